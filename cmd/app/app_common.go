@@ -0,0 +1,287 @@
+//go:build darwin || linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/stefanos/dotward/internal/audit"
+	"github.com/stefanos/dotward/internal/core"
+	"github.com/stefanos/dotward/internal/procwatch"
+)
+
+func initLogFile(cfg core.Config) *os.File {
+	logPath := cfg.Log.Path
+	if err := os.MkdirAll(filepath.Dir(logPath), 0o700); err != nil {
+		return nil
+	}
+
+	if info, err := os.Stat(logPath); err == nil && info.Size() > cfg.Log.MaxSize {
+		_ = os.Rename(logPath, logPath+".old")
+	}
+
+	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, f))
+	return f
+}
+
+// expiryReason reports whether wf must be locked at now and why: its TTL
+// ran out, it reached the maximum lifetime, or its unlock window closed.
+func (a *app) expiryReason(wf core.WatchedFile, now time.Time) (string, bool) {
+	switch {
+	case now.After(wf.ExpiresAt):
+		return "", true
+	case wf.LifetimeExceeded(now, a.cfg.Limits.MaxLifetime):
+		return "max lifetime reached", true
+	case !a.cfg.ScheduleAt(wf.Path, now).Open:
+		return "unlock window closed", true
+	default:
+		return "", false
+	}
+}
+
+// extendFromNotification handles the Extend action of an expiry warning. A
+// refused extension is reported back to the user, since nothing else would
+// tell them the file is still about to expire.
+func (a *app) extendFromNotification(path string) {
+	prev, watched := a.state.Lookup(path)
+	if !watched {
+		return
+	}
+	window := a.cfg.ScheduleAt(path, a.now())
+	var wf core.WatchedFile
+	err := window.Err()
+	if err == nil {
+		wf, err = a.state.ExtendUntil(path, a.cfg.ForProfile(prev.Profile).ResolveTTL(path).TTL, a.cfg.Limits, window.End)
+	}
+	if err != nil {
+		log.Printf("refused extension for %q: %v", path, err)
+		recordAudit(a.audit, audit.EventExtendDenied, path, fmt.Sprintf("%v via notification", err))
+		if nerr := a.notifier.ExtendDenied(path, err.Error()); nerr != nil {
+			log.Printf("failed to send extension refused notification for %q: %v", path, nerr)
+		}
+		return
+	}
+	if err := a.state.Save(a.cfg.StatePath); err != nil {
+		log.Printf("failed to save state after extension for %q: %v", path, err)
+	}
+	recordAudit(a.audit, audit.EventExtend, path, fmt.Sprintf("ttl=%s via notification", wf.ExpiresAt.Sub(prev.ExpiresAt)))
+}
+
+// reloadConfig re-reads the config file and applies it. On error the running
+// config is kept.
+func (a *app) reloadConfig(source string) error {
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		log.Printf("config reload (%s) rejected: %v", source, err)
+		return err
+	}
+	prev := a.cfg
+	if cfg.SockPath != prev.SockPath || cfg.Log != prev.Log {
+		log.Printf("socket_path and log settings take effect after restart")
+		cfg.SockPath = prev.SockPath
+		cfg.SocketPath = prev.SocketPath
+		cfg.Log = prev.Log
+	}
+	a.cfg = cfg
+	a.config.Set(cfg)
+	log.Printf("config reloaded (%s)", source)
+	a.checkFiles(a.now())
+	a.updateStatus()
+	return nil
+}
+
+func (a *app) checkFiles(now time.Time) {
+	changed := false
+	files := a.state.Snapshot()
+
+	for path, wf := range files {
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				if !wf.MissingGraceOver(now) {
+					// A smudged file is registered before git writes it.
+					continue
+				}
+				a.state.StopWatching(path)
+				a.procs.Untrack(path)
+				changed = true
+				continue
+			}
+			log.Printf("stat error for %q: %v", path, err)
+			continue
+		}
+
+		if reason, expired := a.expiryReason(wf, now); expired {
+			if err := a.lockWatchedFile(path, audit.EventExpire, reason); err != nil {
+				log.Printf("failed to delete expired file %q: %v", path, err)
+				continue
+			}
+			changed = true
+			continue
+		}
+
+		if !a.cfg.ForProfile(wf.Profile).Notifications.Warnings {
+			continue
+		}
+		if stage, due := wf.DueWarning(now, a.cfg.WarningWindows); due {
+			if err := a.notifier.Warn(path, wf.ExpiresAt, stage); err != nil {
+				log.Printf("failed to send warning %d/%d notification for %q: %v", stage.Index, stage.Total, path, err)
+			} else if a.state.MarkWarned(path, stage.Offset) {
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		if err := a.state.Save(a.cfg.StatePath); err != nil {
+			log.Printf("failed to save state after checks: %v", err)
+		}
+	}
+}
+
+// lockWatchedFile securely deletes a watched plaintext file, stops watching it,
+// records event in the audit log and notifies the user. The caller is
+// responsible for saving state.
+func (a *app) lockWatchedFile(path, event, detail string) error {
+	if err := core.SecureDelete(path); err != nil {
+		recordAudit(a.audit, audit.EventDeleteFailed, path, fmt.Sprintf("%s: %v", event, err))
+		return err
+	}
+	wf, _ := a.state.Lookup(path)
+	a.state.StopWatching(path)
+	a.procs.Untrack(path)
+	recordAudit(a.audit, event, path, detail)
+	if !a.cfg.ForProfile(wf.Profile).Notifications.Deleted {
+		return nil
+	}
+	if err := a.notifier.FileDeleted(path); err != nil {
+		log.Printf("failed to send delete notification for %q: %v", path, err)
+	}
+	return nil
+}
+
+// trackBoundProcesses resumes waiting on processes bound to files restored
+// from state. Processes that exited while the daemon was down are reported
+// immediately, and so are those whose pid now belongs to a process that
+// started at another time.
+func (a *app) trackBoundProcesses() {
+	for path, wf := range a.state.Snapshot() {
+		if wf.BoundPID <= 0 {
+			continue
+		}
+		if !wf.BoundStart.IsZero() {
+			if start, err := procwatch.StartTime(wf.BoundPID); err == nil && !start.Equal(wf.BoundStart) {
+				log.Printf("process %d bound to %q was replaced while the daemon was down", wf.BoundPID, path)
+				a.handleProcessExit(processExit{Path: path, PID: wf.BoundPID})
+				continue
+			}
+		}
+		a.procs.Track(path, wf.BoundPID)
+	}
+}
+
+func (a *app) handleProcessExit(exit processExit) {
+	wf, ok := a.state.Snapshot()[exit.Path]
+	if !ok || wf.BoundPID != exit.PID {
+		return
+	}
+	log.Printf("process %d bound to %q exited, locking file", exit.PID, exit.Path)
+	if err := a.lockWatchedFile(exit.Path, audit.EventProcessExit, fmt.Sprintf("pid=%d", exit.PID)); err != nil {
+		log.Printf("failed to delete file after bound process exit %q: %v", exit.Path, err)
+		return
+	}
+	if err := a.state.Save(a.cfg.StatePath); err != nil {
+		log.Printf("failed to save state after bound process exit for %q: %v", exit.Path, err)
+	}
+	a.updateStatus()
+}
+
+// lockOnSessionEvent locks every watched file whose sleep policy asks for it
+// when the machine is about to sleep or the session locks.
+func (a *app) lockOnSessionEvent(ev sessionEvent) {
+	defer ev.Done()
+
+	changed := false
+	for path, wf := range a.state.Snapshot() {
+		if !wf.LocksOnSleep(a.cfg.LockOnSleep) {
+			continue
+		}
+		if err := a.lockWatchedFile(path, audit.EventSessionLock, ev.kind.String()); err != nil {
+			log.Printf("failed to delete watched file on %s %q: %v", ev.kind, path, err)
+			continue
+		}
+		changed = true
+	}
+
+	if changed {
+		log.Printf("locked watched files on %s", ev.kind)
+		if err := a.state.Save(a.cfg.StatePath); err != nil {
+			log.Printf("failed to save state after %s lock: %v", ev.kind, err)
+		}
+	}
+	a.updateStatus()
+}
+
+func (a *app) installSignalHandler() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(ch)
+		defer signal.Stop(hupCh)
+		for {
+			select {
+			case <-a.tickerStop:
+				return
+			case <-hupCh:
+				select {
+				case a.reloadCh <- configReload{source: "SIGHUP"}:
+				default:
+				}
+			case <-ch:
+				a.quit()
+				return
+			}
+		}
+	}()
+}
+
+func (a *app) lockAllWatchedFilesOnExit() {
+	if !a.cfg.LockOnExit {
+		log.Printf("lock_on_exit is disabled, leaving %d watched files unlocked", a.state.Count())
+		return
+	}
+	files := a.state.Snapshot()
+	if len(files) == 0 {
+		return
+	}
+
+	changed := false
+	for path := range files {
+		if err := core.SecureDelete(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to delete watched file during shutdown %q: %v", path, err)
+			recordAudit(a.audit, audit.EventDeleteFailed, path, fmt.Sprintf("%s: %v", audit.EventLockAll, err))
+			continue
+		}
+		a.state.StopWatching(path)
+		recordAudit(a.audit, audit.EventLockAll, path, "")
+		changed = true
+	}
+
+	if changed {
+		if err := a.state.Save(a.cfg.StatePath); err != nil {
+			log.Printf("failed to save state during shutdown lock: %v", err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stefanos/dotward/internal/ipc"
+	"github.com/stefanos/dotward/internal/procwatch"
+)
+
+func TestTrackBoundProcessesSkipsReusedPID(t *testing.T) {
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	m, _ := newTestManager(t, `{"default_ttl": "1h"}`, now)
+	a := &app{
+		cfg:      m.cfg.Get(),
+		state:    m.state,
+		notifier: newNotifier(),
+		procs:    m.procs,
+		now:      func() time.Time { return now },
+	}
+
+	dir := t.TempDir()
+	kept, replaced := filepath.Join(dir, "a.env"), filepath.Join(dir, "b.env")
+	for _, p := range []string{kept, replaced} {
+		if err := os.WriteFile(p, []byte("K=v\n"), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		var resp ipc.Response
+		if err := m.Register(ipc.Request{Path: p, PID: os.Getpid()}, &resp); err != nil || !resp.Success {
+			t.Fatalf("Register: %+v %v", resp, err)
+		}
+	}
+	start, err := procwatch.StartTime(os.Getpid())
+	if err != nil {
+		t.Fatalf("start time: %v", err)
+	}
+	wf, _ := m.state.Lookup(kept)
+	if !wf.BoundStart.Equal(start) {
+		t.Fatalf("bound start got=%v want=%v", wf.BoundStart, start)
+	}
+
+	// As if the pid of b.env's process had been reused while the daemon was
+	// down.
+	wf, _ = m.state.Lookup(replaced)
+	wf.BoundStart = wf.BoundStart.Add(-time.Hour)
+	m.state.RegisterWithin(wf, a.cfg.Limits)
+
+	a.trackBoundProcesses()
+	if _, ok := m.state.Lookup(replaced); ok {
+		t.Fatal("file bound to a reused pid is still watched")
+	}
+	if _, err := os.Stat(replaced); !os.IsNotExist(err) {
+		t.Fatalf("file bound to a reused pid not locked: %v", err)
+	}
+	if _, ok := m.state.Lookup(kept); !ok {
+		t.Fatal("file bound to the live process was dropped")
+	}
+}
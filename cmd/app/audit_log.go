@@ -0,0 +1,18 @@
+package main
+
+import (
+	"log"
+
+	"github.com/stefanos/dotward/internal/audit"
+)
+
+// recordAudit appends an audit entry. A failing audit log is reported in the
+// app log but never blocks the operation being audited.
+func recordAudit(l *audit.Logger, event, path, detail string) {
+	if l == nil {
+		return
+	}
+	if err := l.Record(event, path, detail); err != nil {
+		log.Printf("failed to record audit event %q for %q: %v", event, path, err)
+	}
+}
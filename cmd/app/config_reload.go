@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/stefanos/dotward/internal/core"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 2 * time.Second
+
+// configStore shares the live configuration between the app loop, which
+// replaces it on reload, and RPC handlers.
+type configStore struct {
+	mu  sync.RWMutex
+	cfg core.Config
+}
+
+func newConfigStore(cfg core.Config) *configStore {
+	return &configStore{cfg: cfg}
+}
+
+func (s *configStore) Get() core.Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+func (s *configStore) Set(cfg core.Config) {
+	s.mu.Lock()
+	s.cfg = cfg
+	s.mu.Unlock()
+}
+
+// configReload asks the app loop to re-read the config file. When done is
+// set, the outcome is sent on it.
+type configReload struct {
+	source string
+	done   chan error
+}
+
+// watchConfigFile polls path and requests a reload whenever its size or
+// modification time changes, until stop is closed.
+func watchConfigFile(path string, reloadCh chan<- configReload, stop <-chan struct{}) {
+	last := statSignature(path)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sig := statSignature(path)
+			if sig == last {
+				continue
+			}
+			last = sig
+			select {
+			case reloadCh <- configReload{source: "file change"}:
+			default:
+			}
+		}
+	}
+}
+
+type fileSignature struct {
+	size    int64
+	modTime time.Time
+	exists  bool
+}
+
+func statSignature(path string) fileSignature {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileSignature{}
+	}
+	return fileSignature{size: info.Size(), modTime: info.ModTime(), exists: true}
+}
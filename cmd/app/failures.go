@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/stefanos/dotward/internal/audit"
+	"github.com/stefanos/dotward/internal/core"
+	"github.com/stefanos/dotward/internal/ipc"
+)
+
+// failureKey returns the key failed attempts of req are counted under: its
+// sidecar, or the path for clients that do not send one.
+func failureKey(req ipc.Request) string {
+	if req.Sidecar != "" {
+		return req.Sidecar
+	}
+	return req.Path
+}
+
+// ReserveUnlock starts an attempt to unlock req.Path, unless the backoff
+// after its last failed attempt runs, another attempt is in progress, or it
+// is locked out. Checking and reserving happen at once, so that parallel
+// attempts cannot all pass before a failure is recorded. resp.Attempt
+// identifies the attempt for UnlockFailed or UnlockSucceeded; on refusal
+// resp.TTL is how long until the next attempt is allowed.
+func (m *Manager) ReserveUnlock(req ipc.Request, resp *ipc.Response) error {
+	if req.Path == "" {
+		resp.Success = false
+		resp.Error = "path is required"
+		return nil
+	}
+	token, err := newAttemptToken()
+	if err != nil {
+		resp.Success = false
+		resp.Error = err.Error()
+		return nil
+	}
+	cfg := m.cfg.Get()
+	abandoned, err := m.failures.Reserve(failureKey(req), req.Path, token, m.clock(), cfg.Limits.LockoutAfter)
+	if abandoned {
+		log.Printf("counted an abandoned attempt to unlock %q as failed", req.Path)
+		recordAudit(m.audit, audit.EventUnlockFailed, req.Path, "abandoned")
+		if err := m.failures.Save(cfg.FailuresPath); err != nil {
+			log.Printf("failed to save failed unlock attempts: %v", err)
+		}
+	}
+	if err != nil {
+		var backoff *core.BackoffError
+		if errors.As(err, &backoff) {
+			resp.TTL = backoff.Wait
+		}
+		resp.Success = false
+		resp.Error = err.Error()
+		return nil
+	}
+	resp.Success = true
+	resp.Attempt = token
+	return nil
+}
+
+// newAttemptToken returns a random identifier for an unlock attempt.
+func newAttemptToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate attempt token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// UnlockFailed ends the attempt req.Attempt and records it as failed, with a
+// wrong password or TOTP code. resp.TTL is the backoff before the next
+// attempt.
+func (m *Manager) UnlockFailed(req ipc.Request, resp *ipc.Response) error {
+	if req.Path == "" {
+		resp.Success = false
+		resp.Error = "path is required"
+		return nil
+	}
+	cfg := m.cfg.Get()
+	m.failures.Settle(failureKey(req), req.Attempt)
+	fu := m.failures.Fail(failureKey(req), req.Path, m.clock(), cfg.Limits.LockoutAfter)
+	wait := core.FailureDelay(fu.Failures)
+	if err := m.failures.Save(cfg.FailuresPath); err != nil {
+		log.Printf("failed to save failed unlock attempts: %v", err)
+	}
+
+	log.Printf("failed attempt %d to unlock %q", fu.Failures, req.Path)
+	recordAudit(m.audit, audit.EventUnlockFailed, req.Path, fmt.Sprintf("failures=%d retry_in=%s", fu.Failures, wait))
+	if fu.LockedOut {
+		log.Printf("locked out %q after %d failed attempts", req.Path, fu.Failures)
+		recordAudit(m.audit, audit.EventLockedOut, req.Path, fmt.Sprintf("failures=%d", fu.Failures))
+	}
+	if m.notifier != nil && cfg.Notifications.UnlockFailed {
+		if err := m.notifier.UnlockFailed(req.Path, fu.Failures, wait, fu.LockedOut); err != nil {
+			log.Printf("failed to send unlock failed notification for %q: %v", req.Path, err)
+		}
+	}
+	resp.Success = true
+	resp.TTL = wait
+	return nil
+}
+
+// UnlockSucceeded ends the attempt req.Attempt and forgets the failed
+// attempts of req.Path, which it unlocked. Without an attempt in progress it
+// is refused, so the count cannot be reset without unlocking the file. It
+// does not lift a lockout.
+func (m *Manager) UnlockSucceeded(req ipc.Request, resp *ipc.Response) error {
+	if req.Path == "" {
+		resp.Success = false
+		resp.Error = "path is required"
+		return nil
+	}
+	if !m.failures.Settle(failureKey(req), req.Attempt) {
+		resp.Success = false
+		resp.Error = "no unlock attempt in progress"
+		return nil
+	}
+	if m.failures.Succeed(failureKey(req)) {
+		if err := m.failures.Save(m.cfg.Get().FailuresPath); err != nil {
+			log.Printf("failed to save failed unlock attempts: %v", err)
+		}
+	}
+	resp.Success = true
+	return nil
+}
+
+// UseTOTPStep accepts the TOTP code of time step req.TOTPStep for the file
+// with req.Sidecar, unless a code of that step or a later one was already
+// accepted for it, which would make the code a replay.
+func (m *Manager) UseTOTPStep(req ipc.Request, resp *ipc.Response) error {
+	if req.Sidecar == "" {
+		resp.Success = false
+		resp.Error = "sidecar is required"
+		return nil
+	}
+	if !m.failures.UseTOTPStep(req.Sidecar, req.TOTPStep) {
+		log.Printf("refused a reused TOTP code for %q", req.Path)
+		resp.Success = false
+		resp.Error = "this TOTP code was already used; wait for the next one"
+		return nil
+	}
+	resp.Success = true
+	return nil
+}
+
+// ListFailures returns every file with failed unlock attempts.
+func (m *Manager) ListFailures(_ ipc.Request, resp *ipc.Response) error {
+	for _, fu := range m.failures.Snapshot() {
+		resp.Failures = append(resp.Failures, ipc.FailedUnlock{
+			Path:        fu.Path,
+			Failures:    fu.Failures,
+			LastFailure: fu.LastFailure,
+			RetryAt:     fu.RetryAt(),
+			LockedOut:   fu.LockedOut,
+		})
+	}
+	resp.Success = true
+	return nil
+}
+
+// ClearLockout forgets the failed attempts of the file with req.Sidecar or
+// last attempted at req.Path, or of every file when both are empty, and
+// lifts their lockout. resp.Failures lists the files it cleared. The call is
+// not authenticated, since whoever may clear it can read the sidecar anyway,
+// so every clear is recorded in the audit log: lifted lockouts as
+// lockout_cleared, other files as failures_cleared.
+func (m *Manager) ClearLockout(req ipc.Request, resp *ipc.Response) error {
+	cleared := m.failures.Clear(req.Sidecar, req.Path)
+	if len(cleared) > 0 {
+		if err := m.failures.Save(m.cfg.Get().FailuresPath); err != nil {
+			resp.Success = false
+			resp.Error = fmt.Sprintf("failed to save failed unlock attempts: %v", err)
+			return nil
+		}
+	}
+	paths := make([]string, 0, len(cleared))
+	for _, fu := range cleared {
+		event := audit.EventFailuresCleared
+		if fu.LockedOut {
+			event = audit.EventLockoutCleared
+		}
+		recordAudit(m.audit, event, fu.Path, fmt.Sprintf("failures=%d", fu.Failures))
+		resp.Failures = append(resp.Failures, ipc.FailedUnlock{Path: fu.Path, Failures: fu.Failures, LockedOut: fu.LockedOut})
+		paths = append(paths, fu.Path)
+	}
+	if len(cleared) > 0 {
+		log.Printf("cleared failed unlock attempts of %s", strings.Join(paths, ", "))
+	}
+	resp.Success = true
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stefanos/dotward/internal/audit"
+	"github.com/stefanos/dotward/internal/core"
+	"github.com/stefanos/dotward/internal/ipc"
+)
+
+func TestUnlockFailuresBackOffAndLockOut(t *testing.T) {
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	m, clock := newTestManager(t, `{"limits": {"lockout_after": 3}}`, now)
+	req := ipc.Request{Path: "/srv/a/.env", Sidecar: "5f1c"}
+	call := func(method func(ipc.Request, *ipc.Response) error, req ipc.Request) ipc.Response {
+		t.Helper()
+		var resp ipc.Response
+		if err := method(req, &resp); err != nil {
+			t.Fatalf("rpc: %v", err)
+		}
+		return resp
+	}
+
+	first := call(m.ReserveUnlock, req)
+	if !first.Success || first.Attempt == "" {
+		t.Fatalf("first attempt refused: %+v", first)
+	}
+	// A parallel attempt waits for the first to be settled.
+	if resp := call(m.ReserveUnlock, req); resp.Success || resp.TTL != core.PendingAttemptTimeout {
+		t.Fatalf("parallel attempt got %+v", resp)
+	}
+	req.Attempt = first.Attempt
+	if resp := call(m.UnlockFailed, req); !resp.Success || resp.TTL != time.Second {
+		t.Fatalf("first failure got %+v, want a 1s backoff", resp)
+	}
+	if resp := call(m.ReserveUnlock, req); resp.Success || resp.TTL != time.Second {
+		t.Fatalf("attempt during the backoff got %+v", resp)
+	}
+
+	// A copy of the sidecar at another path shares the backoff.
+	copied := ipc.Request{Path: "/tmp/x1.env", Sidecar: req.Sidecar}
+	if resp := call(m.ReserveUnlock, copied); resp.Success {
+		t.Fatalf("copied sidecar allowed during the backoff: %+v", resp)
+	}
+
+	*clock = clock.Add(time.Second)
+	second := call(m.ReserveUnlock, req)
+	if !second.Success {
+		t.Fatalf("attempt after the backoff refused: %+v", second)
+	}
+	if resp := call(m.UnlockSucceeded, req); resp.Success {
+		t.Fatalf("success settled with a stale attempt: %+v", resp)
+	}
+	req.Attempt = second.Attempt
+	if resp := call(m.UnlockFailed, req); resp.TTL != 2*time.Second {
+		t.Fatalf("second failure got %+v, want a 2s backoff", resp)
+	}
+	*clock = clock.Add(2 * time.Second)
+	req.Attempt = call(m.ReserveUnlock, req).Attempt
+	if resp := call(m.UnlockSucceeded, req); !resp.Success {
+		t.Fatalf("UnlockSucceeded: %+v", resp)
+	}
+	req.Attempt = ""
+	if resp := call(m.ReserveUnlock, req); !resp.Success {
+		t.Fatalf("a successful unlock must reset the count: %+v", resp)
+	}
+
+	// An attempt that is never settled counts as failed once it times out.
+	*clock = clock.Add(core.PendingAttemptTimeout)
+	after := call(m.ReserveUnlock, req)
+	if !after.Success {
+		t.Fatalf("attempt after an abandoned one refused: %+v", after)
+	}
+	if list := call(m.ListFailures, ipc.Request{}); len(list.Failures) != 1 || list.Failures[0].Failures != 1 {
+		t.Fatalf("abandoned attempt not counted: %+v", list.Failures)
+	}
+	req.Attempt = after.Attempt
+	call(m.UnlockSucceeded, req)
+	req.Attempt = ""
+
+	for range 3 {
+		call(m.UnlockFailed, copied)
+	}
+	*clock = clock.Add(time.Hour)
+	if resp := call(m.ReserveUnlock, req); resp.Success || resp.TTL != 0 {
+		t.Fatalf("want a lockout after three failures, got %+v", resp)
+	}
+	if resp := call(m.Register, req); resp.Success {
+		t.Fatalf("locked out file registered: %+v", resp)
+	}
+
+	loaded, err := core.LoadFailures(m.cfg.Get().FailuresPath)
+	if err != nil {
+		t.Fatalf("load failures: %v", err)
+	}
+	if err := loaded.Check(req.Sidecar, *clock); !errors.Is(err, core.ErrLockedOut) {
+		t.Fatalf("lockout not saved: %v", err)
+	}
+
+	list := call(m.ListFailures, ipc.Request{})
+	if len(list.Failures) != 1 || list.Failures[0].Path != copied.Path || !list.Failures[0].LockedOut || list.Failures[0].Failures != 3 {
+		t.Fatalf("ListFailures got %+v", list.Failures)
+	}
+	m.audit = audit.NewLogger(filepath.Join(t.TempDir(), "audit.jsonl"))
+	cleared := call(m.ClearLockout, ipc.Request{Path: req.Path, Sidecar: req.Sidecar})
+	if !cleared.Success || len(cleared.Failures) != 1 || cleared.Failures[0].Path != copied.Path {
+		t.Fatalf("ClearLockout got %+v", cleared)
+	}
+	entries, err := audit.Read(m.audit.Path(), audit.Filter{})
+	if err != nil || len(entries) != 1 || entries[0].Event != audit.EventLockoutCleared || entries[0].Detail != "failures=3" {
+		t.Fatalf("clearing the lockout not audited: %+v %v", entries, err)
+	}
+	if resp := call(m.ReserveUnlock, req); !resp.Success {
+		t.Fatalf("attempt after clearing refused: %+v", resp)
+	}
+	if resp := call(m.Register, req); !resp.Success {
+		t.Fatalf("register after clearing refused: %+v", resp)
+	}
+}
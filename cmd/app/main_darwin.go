@@ -5,17 +5,14 @@ package main
 import (
 	"context"
 	"fmt"
-	"io"
 	"log"
-	"os"
-	"os/signal"
 	"path/filepath"
 	"sort"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/getlantern/systray"
+	"github.com/stefanos/dotward/internal/audit"
 	"github.com/stefanos/dotward/internal/core"
 	"github.com/stefanos/dotward/internal/updater"
 	"github.com/stefanos/dotward/internal/version"
@@ -23,6 +20,8 @@ import (
 
 type app struct {
 	cfg          core.Config
+	config       *configStore
+	reloadCh     chan configReload
 	state        *core.State
 	notifier     Notifier
 	extendCh     chan string
@@ -31,6 +30,11 @@ type app struct {
 	filePaths    []string
 	fileClickCh  chan int
 	wakeCh       chan struct{}
+	procExitCh   chan processExit
+	sessionCh    chan sessionEvent
+	procs        *processTracker
+	audit        *audit.Logger
+	now          func() time.Time
 	quitItem     *systray.MenuItem
 	versionItem  *systray.MenuItem
 	stopRPC      func() error
@@ -47,46 +51,30 @@ type app struct {
 
 const maxFileMenuItems = 128
 
-const maxLogSize = 2 * 1024 * 1024 // 2 MiB
-
-func initLogFile() *os.File {
-	configDir, err := os.UserConfigDir()
+func main() {
+	cfg, err := core.ResolveConfig()
 	if err != nil {
-		return nil
-	}
-	logPath := filepath.Join(configDir, "Dotward", "dotward-app.log")
-
-	if info, err := os.Stat(logPath); err == nil && info.Size() > maxLogSize {
-		_ = os.Rename(logPath, logPath+".old")
+		log.Fatalf("failed to resolve config: %v", err)
 	}
-
-	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
-	if err != nil {
-		return nil
+	if err := core.EnsureDirs(cfg); err != nil {
+		log.Fatalf("failed to initialize config dir: %v", err)
 	}
-	log.SetOutput(io.MultiWriter(os.Stderr, f))
-	return f
-}
 
-func main() {
-	logFile := initLogFile()
+	logFile := initLogFile(cfg)
 	if logFile != nil {
 		defer logFile.Close()
 	}
 
 	log.Printf("starting dotward-app %s", version.String())
 
-	cfg, err := core.ResolveConfig()
+	state, err := core.LoadState(cfg.StatePath)
 	if err != nil {
-		log.Fatalf("failed to resolve config: %v", err)
-	}
-	if err := core.EnsureDirs(cfg); err != nil {
-		log.Fatalf("failed to initialize config dir: %v", err)
+		log.Fatalf("failed to load state: %v", err)
 	}
 
-	state, err := core.LoadState(cfg.StatePath)
+	failures, err := core.LoadFailures(cfg.FailuresPath)
 	if err != nil {
-		log.Fatalf("failed to load state: %v", err)
+		log.Fatalf("failed to load failed unlock attempts: %v", err)
 	}
 
 	notifier := newNotifier()
@@ -98,6 +86,8 @@ func main() {
 
 	a := &app{
 		cfg:          cfg,
+		config:       newConfigStore(cfg),
+		reloadCh:     make(chan configReload, 4),
 		state:        state,
 		notifier:     notifier,
 		extendCh:     make(chan string, 32),
@@ -105,15 +95,30 @@ func main() {
 		filePaths:    make([]string, maxFileMenuItems),
 		fileClickCh:  make(chan int, 32),
 		wakeCh:       make(chan struct{}, 8),
+		procExitCh:   make(chan processExit, 32),
+		sessionCh:    make(chan sessionEvent, 8),
 		tickerStop:   make(chan struct{}),
 		tickerDone:   make(chan struct{}),
 		updateCh:     make(chan updateNotification, 8),
 		skipUpdateCh: make(chan string, 8),
 		updateCheck:  updater.NewChecker(),
 		updatePrefs:  updatePrefs,
+		audit:        audit.NewLogger(cfg.AuditPath),
+		now:          time.Now,
 	}
 
-	stopRPC, err := startRPCServer(cfg, state, notifier)
+	a.procs = newProcessTracker(a.procExitCh)
+
+	stopRPC, err := startRPCServer(cfg, &Manager{
+		state:    state,
+		cfg:      a.config,
+		notifier: notifier,
+		procs:    a.procs,
+		audit:    a.audit,
+		failures: failures,
+		reloadCh: a.reloadCh,
+		now:      a.now,
+	})
 	if err != nil {
 		log.Fatalf("failed to start rpc server: %v", err)
 	}
@@ -131,7 +136,11 @@ func (a *app) onReady() {
 	if err := initWakeMonitor(a.wakeCh); err != nil {
 		log.Printf("failed to initialize wake monitor: %v", err)
 	}
+	if err := initSessionMonitor(a.sessionCh); err != nil {
+		log.Printf("failed to initialize session monitor: %v", err)
+	}
 	a.installSignalHandler()
+	go watchConfigFile(a.cfg.SettingsPath, a.reloadCh, a.tickerStop)
 
 	a.statusItem = systray.AddMenuItem("Status: monitoring 0 files", "Current status")
 	a.statusItem.Disable()
@@ -160,7 +169,8 @@ func (a *app) onReady() {
 		}
 	}()
 
-	a.checkFiles(time.Now())
+	a.checkFiles(a.now())
+	a.trackBoundProcesses()
 	a.updateStatus()
 	a.checkForUpdates()
 
@@ -181,6 +191,7 @@ func (a *app) onExit() {
 			log.Printf("rpc shutdown error: %v", err)
 		}
 	}
+	a.procs.Stop()
 	a.lockAllWatchedFilesOnExit()
 	if err := a.notifier.Shutdown(); err != nil {
 		log.Printf("notification shutdown error: %v", err)
@@ -189,7 +200,7 @@ func (a *app) onExit() {
 
 func (a *app) loop() {
 	defer close(a.tickerDone)
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(a.cfg.CheckInterval)
 	defer ticker.Stop()
 	updateTicker := time.NewTicker(24 * time.Hour)
 	defer updateTicker.Stop()
@@ -199,16 +210,12 @@ func (a *app) loop() {
 		case <-a.tickerStop:
 			return
 		case <-ticker.C:
-			a.checkFiles(time.Now())
+			a.checkFiles(a.now())
 			a.updateStatus()
 		case <-updateTicker.C:
 			a.checkForUpdates()
 		case path := <-a.extendCh:
-			if ok := a.state.Extend(path, a.cfg.DefaultTTL); ok {
-				if err := a.state.Save(a.cfg.StatePath); err != nil {
-					log.Printf("failed to save state after extension for %q: %v", path, err)
-				}
-			}
+			a.extendFromNotification(path)
 			a.updateStatus()
 		case tag := <-a.skipUpdateCh:
 			if err := a.updatePrefs.SetSkippedVersion(tag); err != nil {
@@ -219,15 +226,31 @@ func (a *app) loop() {
 		case update := <-a.updateCh:
 			a.startUpdate(update)
 		case <-a.wakeCh:
-			a.checkFiles(time.Now())
+			a.checkFiles(a.now())
 			a.updateStatus()
 		case idx := <-a.fileClickCh:
 			a.removeWatchedFileByIndex(idx)
+		case exit := <-a.procExitCh:
+			a.handleProcessExit(exit)
+		case ev := <-a.sessionCh:
+			a.lockOnSessionEvent(ev)
+		case req := <-a.reloadCh:
+			prevInterval := a.cfg.CheckInterval
+			err := a.reloadConfig(req.source)
+			if err == nil && a.cfg.CheckInterval != prevInterval {
+				ticker.Reset(a.cfg.CheckInterval)
+			}
+			if req.done != nil {
+				req.done <- err
+			}
 		}
 	}
 }
 
 func (a *app) checkForUpdates() {
+	if !a.cfg.Notifications.Updates {
+		return
+	}
 	buildTime := version.BuildTime
 	if buildTime == "" || buildTime == "unknown" {
 		buildTime = version.BuildDate
@@ -294,48 +317,9 @@ func (a *app) startUpdate(update updateNotification) {
 	}()
 }
 
-func (a *app) checkFiles(now time.Time) {
-	changed := false
-	files := a.state.Snapshot()
-
-	for path, wf := range files {
-		if _, err := os.Stat(path); err != nil {
-			if os.IsNotExist(err) {
-				a.state.StopWatching(path)
-				changed = true
-				continue
-			}
-			log.Printf("stat error for %q: %v", path, err)
-			continue
-		}
-
-		if now.After(wf.ExpiresAt) {
-			if err := core.SecureDelete(path); err != nil {
-				log.Printf("failed to delete expired file %q: %v", path, err)
-				continue
-			}
-			a.state.StopWatching(path)
-			changed = true
-			if err := a.notifier.FileDeleted(path); err != nil {
-				log.Printf("failed to send delete notification for %q: %v", path, err)
-			}
-			continue
-		}
-
-		if !wf.Warned && now.After(wf.ExpiresAt.Add(-core.WarningWindow)) {
-			if err := a.notifier.Warn(path, wf.ExpiresAt); err != nil {
-				log.Printf("failed to send warning notification for %q: %v", path, err)
-			} else if a.state.MarkWarned(path) {
-				changed = true
-			}
-		}
-	}
-
-	if changed {
-		if err := a.state.Save(a.cfg.StatePath); err != nil {
-			log.Printf("failed to save state after checks: %v", err)
-		}
-	}
+// quit stops the tray app, which locks the watched files on its way out.
+func (a *app) quit() {
+	systray.Quit()
 }
 
 func (a *app) updateStatus() {
@@ -382,53 +366,12 @@ func (a *app) removeWatchedFileByIndex(idx int) {
 	if path == "" {
 		return
 	}
-	if err := core.SecureDelete(path); err != nil {
+	if err := a.lockWatchedFile(path, audit.EventMenuRemove, ""); err != nil {
 		log.Printf("failed to delete file selected from menu %q: %v", path, err)
 		return
 	}
-	a.state.StopWatching(path)
 	if err := a.state.Save(a.cfg.StatePath); err != nil {
 		log.Printf("failed to save state after menu lock for %q: %v", path, err)
 	}
-	if err := a.notifier.FileDeleted(path); err != nil {
-		log.Printf("failed to send delete notification for %q: %v", path, err)
-	}
 	a.updateStatus()
 }
-
-func (a *app) installSignalHandler() {
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		select {
-		case <-a.tickerStop:
-			signal.Stop(ch)
-		case <-ch:
-			systray.Quit()
-			signal.Stop(ch)
-		}
-	}()
-}
-
-func (a *app) lockAllWatchedFilesOnExit() {
-	files := a.state.Snapshot()
-	if len(files) == 0 {
-		return
-	}
-
-	changed := false
-	for path := range files {
-		if err := core.SecureDelete(path); err != nil && !os.IsNotExist(err) {
-			log.Printf("failed to delete watched file during shutdown %q: %v", path, err)
-			continue
-		}
-		a.state.StopWatching(path)
-		changed = true
-	}
-
-	if changed {
-		if err := a.state.Save(a.cfg.StatePath); err != nil {
-			log.Printf("failed to save state during shutdown lock: %v", err)
-		}
-	}
-}
@@ -0,0 +1,169 @@
+//go:build linux
+
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/stefanos/dotward/internal/audit"
+	"github.com/stefanos/dotward/internal/core"
+	"github.com/stefanos/dotward/internal/version"
+)
+
+// app is the headless Linux daemon. It has no tray menu or
+// self-update; files are watched, expired and locked as on macOS.
+type app struct {
+	cfg        core.Config
+	config     *configStore
+	reloadCh   chan configReload
+	state      *core.State
+	notifier   Notifier
+	extendCh   chan string
+	wakeCh     chan struct{}
+	procExitCh chan processExit
+	sessionCh  chan sessionEvent
+	procs      *processTracker
+	audit      *audit.Logger
+	now        func() time.Time
+	stopRPC    func() error
+	tickerStop chan struct{}
+	quitCh     chan struct{}
+	quitOnce   sync.Once
+}
+
+func main() {
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		log.Fatalf("failed to resolve config: %v", err)
+	}
+	if err := core.EnsureDirs(cfg); err != nil {
+		log.Fatalf("failed to initialize config dir: %v", err)
+	}
+
+	logFile := initLogFile(cfg)
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	log.Printf("starting dotward-app %s", version.String())
+
+	state, err := core.LoadState(cfg.StatePath)
+	if err != nil {
+		log.Fatalf("failed to load state: %v", err)
+	}
+
+	failures, err := core.LoadFailures(cfg.FailuresPath)
+	if err != nil {
+		log.Fatalf("failed to load failed unlock attempts: %v", err)
+	}
+
+	notifier := newNotifier()
+
+	a := &app{
+		cfg:        cfg,
+		config:     newConfigStore(cfg),
+		reloadCh:   make(chan configReload, 4),
+		state:      state,
+		notifier:   notifier,
+		extendCh:   make(chan string, 32),
+		wakeCh:     make(chan struct{}, 8),
+		procExitCh: make(chan processExit, 32),
+		sessionCh:  make(chan sessionEvent, 8),
+		tickerStop: make(chan struct{}),
+		quitCh:     make(chan struct{}),
+		audit:      audit.NewLogger(cfg.AuditPath),
+		now:        time.Now,
+	}
+
+	a.procs = newProcessTracker(a.procExitCh)
+
+	stopRPC, err := startRPCServer(cfg, &Manager{
+		state:    state,
+		cfg:      a.config,
+		notifier: notifier,
+		procs:    a.procs,
+		audit:    a.audit,
+		failures: failures,
+		reloadCh: a.reloadCh,
+		now:      a.now,
+	})
+	if err != nil {
+		log.Fatalf("failed to start rpc server: %v", err)
+	}
+	a.stopRPC = stopRPC
+
+	if err := a.notifier.Init(a.extendCh, nil, nil); err != nil {
+		log.Printf("failed to initialize notifications: %v", err)
+	}
+	if err := initWakeMonitor(a.wakeCh); err != nil {
+		log.Printf("failed to initialize wake monitor: %v", err)
+	}
+	if err := initSessionMonitor(a.sessionCh); err != nil {
+		log.Printf("failed to initialize session monitor: %v", err)
+	}
+	a.installSignalHandler()
+	go watchConfigFile(a.cfg.SettingsPath, a.reloadCh, a.tickerStop)
+
+	a.checkFiles(a.now())
+	a.trackBoundProcesses()
+
+	a.loop()
+	a.shutdown()
+}
+
+// quit stops the loop; main then locks the watched files and exits.
+func (a *app) quit() {
+	a.quitOnce.Do(func() {
+		close(a.quitCh)
+	})
+}
+
+// updateStatus has nothing to show without a tray menu.
+func (a *app) updateStatus() {}
+
+func (a *app) loop() {
+	ticker := time.NewTicker(a.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.quitCh:
+			return
+		case <-ticker.C:
+			a.checkFiles(a.now())
+		case path := <-a.extendCh:
+			a.extendFromNotification(path)
+		case <-a.wakeCh:
+			a.checkFiles(a.now())
+		case exit := <-a.procExitCh:
+			a.handleProcessExit(exit)
+		case ev := <-a.sessionCh:
+			a.lockOnSessionEvent(ev)
+		case req := <-a.reloadCh:
+			prevInterval := a.cfg.CheckInterval
+			err := a.reloadConfig(req.source)
+			if err == nil && a.cfg.CheckInterval != prevInterval {
+				ticker.Reset(a.cfg.CheckInterval)
+			}
+			if req.done != nil {
+				req.done <- err
+			}
+		}
+	}
+}
+
+func (a *app) shutdown() {
+	close(a.tickerStop)
+	if a.stopRPC != nil {
+		if err := a.stopRPC(); err != nil {
+			log.Printf("rpc shutdown error: %v", err)
+		}
+	}
+	a.procs.Stop()
+	a.lockAllWatchedFilesOnExit()
+	if err := a.notifier.Shutdown(); err != nil {
+		log.Printf("notification shutdown error: %v", err)
+	}
+}
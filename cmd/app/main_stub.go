@@ -1,9 +1,9 @@
-//go:build !darwin
+//go:build !darwin && !linux
 
 package main
 
 import "log"
 
 func main() {
-	log.Fatal("Dotward.app is supported on macOS only")
+	log.Fatal("Dotward.app is supported on macOS and Linux only")
 }
@@ -187,6 +187,38 @@ int DotwardSendDeletedNotification(const char *path, const char *title, const ch
     }
 }
 
+int DotwardSendExtendDeniedNotification(const char *path, const char *title, const char *body) {
+    @autoreleasepool {
+        if (path == NULL || title == NULL || body == NULL) {
+            return 0;
+        }
+        UNMutableNotificationContent *content = [UNMutableNotificationContent new];
+        content.title = [NSString stringWithUTF8String:title];
+        content.body = [NSString stringWithUTF8String:body];
+        content.sound = [UNNotificationSound defaultSound];
+
+        NSString *pathStr = [NSString stringWithUTF8String:path];
+        NSString *identifier = DotwardIdentifier(@"extend-denied", pathStr);
+        return DotwardSendNotification(identifier, content);
+    }
+}
+
+int DotwardSendUnlockFailedNotification(const char *path, const char *title, const char *body) {
+    @autoreleasepool {
+        if (path == NULL || title == NULL || body == NULL) {
+            return 0;
+        }
+        UNMutableNotificationContent *content = [UNMutableNotificationContent new];
+        content.title = [NSString stringWithUTF8String:title];
+        content.body = [NSString stringWithUTF8String:body];
+        content.sound = [UNNotificationSound defaultSound];
+
+        NSString *pathStr = [NSString stringWithUTF8String:path];
+        NSString *identifier = DotwardIdentifier(@"unlock-failed", pathStr);
+        return DotwardSendNotification(identifier, content);
+    }
+}
+
 int DotwardSendUpdateNotification(const char *version, const char *publishedAt, const char *appDownloadURL, const char *cliDownloadURL, const char *title, const char *body) {
     @autoreleasepool {
         if (version == NULL || publishedAt == NULL || appDownloadURL == NULL || cliDownloadURL == NULL || title == NULL || body == NULL) {
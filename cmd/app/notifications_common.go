@@ -1,6 +1,10 @@
 package main
 
-import "time"
+import (
+	"time"
+
+	"github.com/stefanos/dotward/internal/core"
+)
 
 type updateNotification struct {
 	Version        string
@@ -12,9 +16,13 @@ type updateNotification struct {
 // Notifier sends warning and lifecycle notifications.
 type Notifier interface {
 	Init(extendCh chan<- string, updateCh chan<- updateNotification, skipVersionCh chan<- string) error
-	Warn(path string, expiresAt time.Time) error
+	Warn(path string, expiresAt time.Time, stage core.WarningStage) error
 	FileUnlocked(path string, ttl time.Duration) error
 	FileDeleted(path string) error
+	ExtendDenied(path string, reason string) error
+	// UnlockFailed reports a failed unlock attempt; the next one is allowed
+	// after retryIn, or not at all once the file is lockedOut.
+	UnlockFailed(path string, failures int, retryIn time.Duration, lockedOut bool) error
 	UpdateAvailable(update updateNotification) error
 	Shutdown() error
 }
@@ -11,6 +11,8 @@ void DotwardInitNotifications(void);
 int DotwardSendExpiryNotification(const char *path, const char *title, const char *body);
 int DotwardSendUnlockedNotification(const char *path, const char *title, const char *body);
 int DotwardSendDeletedNotification(const char *path, const char *title, const char *body);
+int DotwardSendExtendDeniedNotification(const char *path, const char *title, const char *body);
+int DotwardSendUnlockFailedNotification(const char *path, const char *title, const char *body);
 int DotwardSendUpdateNotification(const char *version, const char *publishedAt, const char *appDownloadURL, const char *cliDownloadURL, const char *title, const char *body);
 */
 import "C"
@@ -21,6 +23,8 @@ import (
 	"sync"
 	"time"
 	"unsafe"
+
+	"github.com/stefanos/dotward/internal/core"
 )
 
 var (
@@ -46,11 +50,19 @@ func (n *darwinNotifier) Init(extendCh chan<- string, updateCh chan<- updateNoti
 	return nil
 }
 
-func (n *darwinNotifier) Warn(path string, expiresAt time.Time) error {
-	title := C.CString("Dotward Expiry Warning")
+func (n *darwinNotifier) Warn(path string, expiresAt time.Time, stage core.WarningStage) error {
+	titleText := "Dotward Expiry Warning"
+	if stage.IsFinal() {
+		titleText = "Dotward Final Expiry Warning"
+	}
+	title := C.CString(titleText)
 	defer C.free(unsafe.Pointer(title))
 
-	body := C.CString(fmt.Sprintf("%s will be deleted at %s", filepath.Base(path), expiresAt.Format(time.Kitchen)))
+	bodyText := fmt.Sprintf("%s will be deleted at %s", filepath.Base(path), expiresAt.Format(time.Kitchen))
+	if stage.Total > 1 {
+		bodyText += fmt.Sprintf(" (warning %d of %d)", stage.Index, stage.Total)
+	}
+	body := C.CString(bodyText)
 	defer C.free(unsafe.Pointer(body))
 
 	cpath := C.CString(path)
@@ -94,6 +106,44 @@ func (n *darwinNotifier) FileDeleted(path string) error {
 	return nil
 }
 
+func (n *darwinNotifier) ExtendDenied(path string, reason string) error {
+	title := C.CString("Dotward Extension Refused")
+	defer C.free(unsafe.Pointer(title))
+
+	body := C.CString(fmt.Sprintf("%s cannot be extended: %s", filepath.Base(path), reason))
+	defer C.free(unsafe.Pointer(body))
+
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	if C.DotwardSendExtendDeniedNotification(cpath, title, body) == 0 {
+		return fmt.Errorf("failed to enqueue extension refused notification for %q", path)
+	}
+	return nil
+}
+
+func (n *darwinNotifier) UnlockFailed(path string, failures int, retryIn time.Duration, lockedOut bool) error {
+	titleText := "Dotward Unlock Failed"
+	bodyText := fmt.Sprintf("%d failed attempt(s) to unlock %s; the next is allowed in %s", failures, filepath.Base(path), retryIn.Round(time.Second))
+	if lockedOut {
+		titleText = "Dotward File Locked Out"
+		bodyText = fmt.Sprintf("%s is locked out after %d failed attempts; run dotward lockout clear to allow unlocking it again", filepath.Base(path), failures)
+	}
+	title := C.CString(titleText)
+	defer C.free(unsafe.Pointer(title))
+
+	body := C.CString(bodyText)
+	defer C.free(unsafe.Pointer(body))
+
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	if C.DotwardSendUnlockFailedNotification(cpath, title, body) == 0 {
+		return fmt.Errorf("failed to enqueue unlock failed notification for %q", path)
+	}
+	return nil
+}
+
 func (n *darwinNotifier) Shutdown() error {
 	extendActionMu.Lock()
 	extendActionCh = nil
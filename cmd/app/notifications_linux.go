@@ -0,0 +1,204 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/stefanos/dotward/internal/core"
+)
+
+const (
+	notificationsName      = "org.freedesktop.Notifications"
+	notificationsPath      = dbus.ObjectPath("/org/freedesktop/Notifications")
+	notificationsInterface = "org.freedesktop.Notifications"
+	extendAction           = "extend"
+)
+
+// Urgency levels of the freedesktop notification spec.
+const (
+	urgencyNormal   byte = 1
+	urgencyCritical byte = 2
+)
+
+// linuxNotifier shows notifications through the desktop's notification
+// server on the session bus. Without a session bus, as on a headless
+// machine, Init fails and notifications are dropped.
+type linuxNotifier struct {
+	mu       sync.Mutex
+	conn     *dbus.Conn
+	extendCh chan<- string
+	// warnings maps the ids of expiry warnings to their file, for the
+	// Extend action.
+	warnings map[uint32]string
+	signals  chan *dbus.Signal
+}
+
+func newNotifier() Notifier {
+	return &linuxNotifier{warnings: make(map[uint32]string)}
+}
+
+func (n *linuxNotifier) Init(extendCh chan<- string, _ chan<- updateNotification, _ chan<- string) error {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the session bus: %w", err)
+	}
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(notificationsPath),
+		dbus.WithMatchInterface(notificationsInterface),
+	)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to watch notification actions: %w", err)
+	}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+
+	n.mu.Lock()
+	n.conn = conn
+	n.extendCh = extendCh
+	n.signals = signals
+	n.mu.Unlock()
+	go n.handleSignals(signals)
+	return nil
+}
+
+// handleSignals forwards Extend clicks on expiry warnings to extendCh and
+// forgets warnings once they are closed.
+func (n *linuxNotifier) handleSignals(signals <-chan *dbus.Signal) {
+	for sig := range signals {
+		if len(sig.Body) < 2 {
+			continue
+		}
+		id, ok := sig.Body[0].(uint32)
+		if !ok {
+			continue
+		}
+		switch sig.Name {
+		case notificationsInterface + ".ActionInvoked":
+			if action, _ := sig.Body[1].(string); action != extendAction {
+				continue
+			}
+			n.mu.Lock()
+			path, ch := n.warnings[id], n.extendCh
+			n.mu.Unlock()
+			if path == "" || ch == nil {
+				continue
+			}
+			select {
+			case ch <- path:
+			default:
+			}
+		case notificationsInterface + ".NotificationClosed":
+			n.mu.Lock()
+			delete(n.warnings, id)
+			n.mu.Unlock()
+		}
+	}
+}
+
+// send shows a notification and returns its id.
+func (n *linuxNotifier) send(title, body string, urgency byte, actions []string) (uint32, error) {
+	n.mu.Lock()
+	conn := n.conn
+	n.mu.Unlock()
+	if conn == nil {
+		return 0, nil
+	}
+	if actions == nil {
+		actions = []string{}
+	}
+	hints := map[string]dbus.Variant{"urgency": dbus.MakeVariant(urgency)}
+	var id uint32
+	err := conn.Object(notificationsName, notificationsPath).Call(notificationsInterface+".Notify", 0,
+		"Dotward", uint32(0), "", title, body, actions, hints, int32(-1)).Store(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (n *linuxNotifier) Warn(path string, expiresAt time.Time, stage core.WarningStage) error {
+	title := "Dotward Expiry Warning"
+	urgency := urgencyNormal
+	if stage.IsFinal() {
+		title = "Dotward Final Expiry Warning"
+		urgency = urgencyCritical
+	}
+	body := fmt.Sprintf("%s will be deleted at %s", filepath.Base(path), expiresAt.Format(time.Kitchen))
+	if stage.Total > 1 {
+		body += fmt.Sprintf(" (warning %d of %d)", stage.Index, stage.Total)
+	}
+	id, err := n.send(title, body, urgency, []string{extendAction, "Extend"})
+	if err != nil {
+		return fmt.Errorf("failed to send warning notification for %q: %w", path, err)
+	}
+	if id != 0 {
+		n.mu.Lock()
+		n.warnings[id] = path
+		n.mu.Unlock()
+	}
+	return nil
+}
+
+func (n *linuxNotifier) FileUnlocked(path string, ttl time.Duration) error {
+	body := fmt.Sprintf("%s unlocked. Expires in %s.", filepath.Base(path), ttl)
+	if _, err := n.send("Dotward File Unlocked", body, urgencyNormal, nil); err != nil {
+		return fmt.Errorf("failed to send unlocked notification for %q: %w", path, err)
+	}
+	return nil
+}
+
+func (n *linuxNotifier) FileDeleted(path string) error {
+	body := fmt.Sprintf("Deleted plaintext file %s", filepath.Base(path))
+	if _, err := n.send("Dotward File Deleted", body, urgencyNormal, nil); err != nil {
+		return fmt.Errorf("failed to send deletion notification for %q: %w", path, err)
+	}
+	return nil
+}
+
+func (n *linuxNotifier) ExtendDenied(path string, reason string) error {
+	body := fmt.Sprintf("%s cannot be extended: %s", filepath.Base(path), reason)
+	if _, err := n.send("Dotward Extension Refused", body, urgencyNormal, nil); err != nil {
+		return fmt.Errorf("failed to send extension refused notification for %q: %w", path, err)
+	}
+	return nil
+}
+
+func (n *linuxNotifier) UnlockFailed(path string, failures int, retryIn time.Duration, lockedOut bool) error {
+	title := "Dotward Unlock Failed"
+	body := fmt.Sprintf("%d failed attempt(s) to unlock %s; the next is allowed in %s", failures, filepath.Base(path), retryIn.Round(time.Second))
+	urgency := urgencyNormal
+	if lockedOut {
+		title = "Dotward File Locked Out"
+		body = fmt.Sprintf("%s is locked out after %d failed attempts; run dotward lockout clear to allow unlocking it again", filepath.Base(path), failures)
+		urgency = urgencyCritical
+	}
+	if _, err := n.send(title, body, urgency, nil); err != nil {
+		return fmt.Errorf("failed to send unlock failed notification for %q: %w", path, err)
+	}
+	return nil
+}
+
+// UpdateAvailable is never called; the Linux daemon does not update itself.
+func (n *linuxNotifier) UpdateAvailable(_ updateNotification) error {
+	return nil
+}
+
+func (n *linuxNotifier) Shutdown() error {
+	n.mu.Lock()
+	conn, signals := n.conn, n.signals
+	n.conn, n.signals, n.extendCh = nil, nil, nil
+	n.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	conn.RemoveSignal(signals)
+	close(signals)
+	return conn.Close()
+}
@@ -1,8 +1,12 @@
-//go:build !darwin
+//go:build !darwin && !linux
 
 package main
 
-import "time"
+import (
+	"time"
+
+	"github.com/stefanos/dotward/internal/core"
+)
 
 type noopNotifier struct{}
 
@@ -14,7 +18,7 @@ func (n *noopNotifier) Init(_ chan<- string, _ chan<- updateNotification, _ chan
 	return nil
 }
 
-func (n *noopNotifier) Warn(_ string, _ time.Time) error {
+func (n *noopNotifier) Warn(_ string, _ time.Time, _ core.WarningStage) error {
 	return nil
 }
 
@@ -26,6 +30,14 @@ func (n *noopNotifier) FileDeleted(_ string) error {
 	return nil
 }
 
+func (n *noopNotifier) ExtendDenied(_ string, _ string) error {
+	return nil
+}
+
+func (n *noopNotifier) UnlockFailed(_ string, _ int, _ time.Duration, _ bool) error {
+	return nil
+}
+
 func (n *noopNotifier) UpdateAvailable(_ updateNotification) error {
 	return nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/stefanos/dotward/internal/procwatch"
+)
+
+// processExit reports that the process bound to a watched file has exited.
+type processExit struct {
+	Path string
+	PID  int
+}
+
+// processTracker waits on processes bound to watched files and reports their
+// exit to the app loop, which performs the actual lock.
+type processTracker struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	exitCh  chan<- processExit
+}
+
+func newProcessTracker(exitCh chan<- processExit) *processTracker {
+	return &processTracker{
+		cancels: make(map[string]context.CancelFunc),
+		exitCh:  exitCh,
+	}
+}
+
+// Track starts waiting on pid for path, replacing any previous binding.
+func (t *processTracker) Track(path string, pid int) {
+	if t == nil || pid <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	t.mu.Lock()
+	if prev, ok := t.cancels[path]; ok {
+		prev()
+	}
+	t.cancels[path] = cancel
+	t.mu.Unlock()
+
+	go func() {
+		if err := procwatch.Wait(ctx, pid); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("failed to wait on process %d bound to %q: %v", pid, path, err)
+			}
+			return
+		}
+		select {
+		case t.exitCh <- processExit{Path: path, PID: pid}:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// Untrack stops waiting on the process bound to path, if any.
+func (t *processTracker) Untrack(path string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if cancel, ok := t.cancels[path]; ok {
+		cancel()
+		delete(t.cancels, path)
+	}
+	t.mu.Unlock()
+}
+
+// Stop cancels all pending waits.
+func (t *processTracker) Stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	for path, cancel := range t.cancels {
+		cancel()
+		delete(t.cancels, path)
+	}
+	t.mu.Unlock()
+}
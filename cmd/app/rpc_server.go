@@ -10,42 +10,182 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/stefanos/dotward/internal/audit"
 	"github.com/stefanos/dotward/internal/core"
 	"github.com/stefanos/dotward/internal/ipc"
+	"github.com/stefanos/dotward/internal/procwatch"
 )
 
 // Manager exposes daemon RPC methods.
 type Manager struct {
 	state    *core.State
-	cfg      core.Config
+	cfg      *configStore
 	notifier Notifier
+	procs    *processTracker
+	audit    *audit.Logger
+	// failures tracks failed unlock attempts reported by the CLI.
+	failures *core.Failures
+	// reloadCh hands config reload requests to the app loop.
+	reloadCh chan<- configReload
+	// now is the clock used for expiry and schedule decisions; nil means
+	// time.Now.
+	now func() time.Time
 }
 
-// Register starts watching a plaintext file.
+func (m *Manager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+// Register starts watching a plaintext file. When req.PID is set, the file is
+// also locked as soon as that process exits.
 func (m *Manager) Register(req ipc.Request, resp *ipc.Response) error {
+	cfg := m.cfg.Get()
+	wf, ok := m.register(cfg, req, resp)
+	if !ok {
+		return nil
+	}
+	if err := m.state.Save(cfg.StatePath); err != nil {
+		resp.Success = false
+		resp.Error = fmt.Sprintf("failed to save state: %v", err)
+		return nil
+	}
+	m.registered(cfg, req, wf, resp)
+	return nil
+}
+
+// RegisterMany registers every request in req.Batch and saves the state
+// once. resp.Results holds the outcome of each request; resp.Success is
+// false when any of them failed.
+func (m *Manager) RegisterMany(req ipc.Request, resp *ipc.Response) error {
+	cfg := m.cfg.Get()
+	resp.Results = make([]ipc.Response, len(req.Batch))
+	wfs := make([]core.WatchedFile, len(req.Batch))
+	ok := make([]bool, len(req.Batch))
+	var pending int
+	for i, r := range req.Batch {
+		if wfs[i], ok[i] = m.register(cfg, r, &resp.Results[i]); ok[i] {
+			pending++
+		}
+	}
+	if pending > 0 {
+		if err := m.state.Save(cfg.StatePath); err != nil {
+			for i := range ok {
+				if ok[i] {
+					ok[i] = false
+					resp.Results[i].Error = fmt.Sprintf("failed to save state: %v", err)
+				}
+			}
+		}
+	}
+	var failed int
+	for i, r := range req.Batch {
+		if !ok[i] {
+			failed++
+			continue
+		}
+		m.registered(cfg, r, wfs[i], &resp.Results[i])
+	}
+	resp.Success = failed == 0
+	if failed > 0 {
+		resp.Error = fmt.Sprintf("%d of %d registrations failed", failed, len(req.Batch))
+	}
+	return nil
+}
+
+// register validates req and adds it to the state without saving. It fills
+// in resp and returns false when the request is refused.
+func (m *Manager) register(cfg core.Config, req ipc.Request, resp *ipc.Response) (core.WatchedFile, bool) {
 	if req.Path == "" {
 		resp.Success = false
 		resp.Error = "path is required"
-		return nil
+		return core.WatchedFile{}, false
+	}
+	if errors.Is(m.failures.Check(failureKey(req), m.clock()), core.ErrLockedOut) {
+		msg := fmt.Sprintf("%s is locked out after too many failed unlock attempts; run dotward lockout clear", req.Path)
+		recordAudit(m.audit, audit.EventRegisterDenied, req.Path, msg)
+		resp.Success = false
+		resp.Error = msg
+		return core.WatchedFile{}, false
+	}
+	settings := cfg.ForProfile(req.Profile)
+	if p, ok := cfg.Profiles[req.Profile]; ok && !p.Allows(req.Path) {
+		msg := fmt.Sprintf("%s is outside the roots of profile %q", req.Path, req.Profile)
+		recordAudit(m.audit, audit.EventRegisterDenied, req.Path, msg)
+		resp.Success = false
+		resp.Error = msg
+		return core.WatchedFile{}, false
 	}
 	ttl := req.TTL
 	if ttl <= 0 {
-		ttl = m.cfg.DefaultTTL
+		ttl = settings.ResolveTTL(req.Path).TTL
+	}
+	if req.PID < 0 || (req.PID > 0 && !procwatch.Alive(req.PID)) {
+		resp.Success = false
+		resp.Error = fmt.Sprintf("process %d is not running", req.PID)
+		return core.WatchedFile{}, false
+	}
+	onSleep, err := core.ParseSleepPolicy(req.OnSleep)
+	if err != nil {
+		resp.Success = false
+		resp.Error = err.Error()
+		return core.WatchedFile{}, false
 	}
 
-	m.state.Register(req.Path, time.Now().Add(ttl))
-	if err := m.state.Save(m.cfg.StatePath); err != nil {
+	now := m.clock()
+	window := cfg.ScheduleAt(req.Path, now)
+	if err := window.Err(); err != nil {
+		recordAudit(m.audit, audit.EventRegisterDenied, req.Path, err.Error())
 		resp.Success = false
-		resp.Error = fmt.Sprintf("failed to save state: %v", err)
-		return nil
+		resp.Error = err.Error()
+		return core.WatchedFile{}, false
+	}
+	expiresAt := now.Add(ttl)
+	if !window.End.IsZero() && expiresAt.After(window.End) {
+		expiresAt = window.End
 	}
-	if m.notifier != nil {
-		if err := m.notifier.FileUnlocked(req.Path, ttl); err != nil {
+	var boundStart time.Time
+	if req.PID > 0 {
+		if boundStart, err = procwatch.StartTime(req.PID); err != nil {
+			log.Printf("failed to read the start time of process %d bound to %q: %v", req.PID, req.Path, err)
+		}
+	}
+	wf, err := m.state.RegisterWithin(core.WatchedFile{
+		Path:         req.Path,
+		ExpiresAt:    expiresAt,
+		BoundPID:     req.PID,
+		BoundStart:   boundStart,
+		OnSleep:      onSleep,
+		UnlockedAt:   now,
+		RegisteredAt: now,
+		Profile:      req.Profile,
+	}, cfg.Limits)
+	if err != nil {
+		recordAudit(m.audit, audit.EventRegisterDenied, req.Path, err.Error())
+		resp.Success = false
+		resp.Error = err.Error()
+		return core.WatchedFile{}, false
+	}
+	resp.TTL = wf.ExpiresAt.Sub(now)
+	return wf, true
+}
+
+// registered finishes a registration once the state holding wf is saved.
+func (m *Manager) registered(cfg core.Config, req ipc.Request, wf core.WatchedFile, resp *ipc.Response) {
+	if req.PID > 0 {
+		m.procs.Track(req.Path, req.PID)
+	} else {
+		m.procs.Untrack(req.Path)
+	}
+	recordAudit(m.audit, audit.EventRegister, req.Path, registerDetail(resp.TTL, req.PID, wf.OnSleep, req.Profile))
+	if m.notifier != nil && cfg.ForProfile(req.Profile).Notifications.Unlocked {
+		if err := m.notifier.FileUnlocked(req.Path, resp.TTL); err != nil {
 			log.Printf("failed to send unlocked notification for %q: %v", req.Path, err)
 		}
 	}
 	resp.Success = true
-	return nil
 }
 
 // IsWatching reports whether the daemon is currently watching the plaintext path.
@@ -60,28 +200,44 @@ func (m *Manager) IsWatching(req ipc.Request, resp *ipc.Response) error {
 	return nil
 }
 
-// Extend extends a watched file by TTL (or default TTL if omitted).
+// Extend extends a watched file by TTL, or by the TTL its policy gives it if
+// omitted. Extensions beyond the configured limits are refused, and the TTL
+// in the response is the time actually added.
 func (m *Manager) Extend(req ipc.Request, resp *ipc.Response) error {
 	if req.Path == "" {
 		resp.Success = false
 		resp.Error = "path is required"
 		return nil
 	}
+	cfg := m.cfg.Get()
+	prev, watched := m.state.Lookup(req.Path)
 	ttl := req.TTL
 	if ttl <= 0 {
-		ttl = m.cfg.DefaultTTL
+		ttl = cfg.ForProfile(prev.Profile).ResolveTTL(req.Path).TTL
 	}
-	if ok := m.state.Extend(req.Path, ttl); !ok {
+	window := cfg.ScheduleAt(req.Path, m.clock())
+	var wf core.WatchedFile
+	err := window.Err()
+	if err == nil {
+		wf, err = m.state.ExtendUntil(req.Path, ttl, cfg.Limits, window.End)
+	}
+	if err != nil {
+		if watched {
+			recordAudit(m.audit, audit.EventExtendDenied, req.Path, err.Error())
+		}
 		resp.Success = false
-		resp.Error = "file is not currently watched"
+		resp.Error = err.Error()
 		return nil
 	}
-	if err := m.state.Save(m.cfg.StatePath); err != nil {
+	ttl = wf.ExpiresAt.Sub(prev.ExpiresAt)
+	if err := m.state.Save(cfg.StatePath); err != nil {
 		resp.Success = false
 		resp.Error = fmt.Sprintf("failed to save state: %v", err)
 		return nil
 	}
+	recordAudit(m.audit, audit.EventExtend, req.Path, fmt.Sprintf("ttl=%s", ttl))
 	resp.Success = true
+	resp.TTL = ttl
 	return nil
 }
 
@@ -92,17 +248,66 @@ func (m *Manager) StopWatching(req ipc.Request, resp *ipc.Response) error {
 		resp.Error = "path is required"
 		return nil
 	}
+	cfg := m.cfg.Get()
 	m.state.StopWatching(req.Path)
-	if err := m.state.Save(m.cfg.StatePath); err != nil {
+	m.procs.Untrack(req.Path)
+	if err := m.state.Save(cfg.StatePath); err != nil {
 		resp.Success = false
 		resp.Error = fmt.Sprintf("failed to save state: %v", err)
 		return nil
 	}
+	recordAudit(m.audit, audit.EventLock, req.Path, "")
 	resp.Success = true
 	return nil
 }
 
-func startRPCServer(cfg core.Config, state *core.State, notifier Notifier) (func() error, error) {
+// ReloadConfig re-reads the config file and applies it without a restart.
+// Invalid config is rejected and the running config is kept.
+func (m *Manager) ReloadConfig(_ ipc.Request, resp *ipc.Response) error {
+	if m.reloadCh == nil {
+		resp.Success = false
+		resp.Error = "config reload is not available"
+		return nil
+	}
+	done := make(chan error, 1)
+	select {
+	case m.reloadCh <- configReload{source: "rpc", done: done}:
+	case <-time.After(5 * time.Second):
+		resp.Success = false
+		resp.Error = "timed out waiting to reload config"
+		return nil
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			resp.Success = false
+			resp.Error = err.Error()
+			return nil
+		}
+	case <-time.After(5 * time.Second):
+		resp.Success = false
+		resp.Error = "timed out waiting to reload config"
+		return nil
+	}
+	resp.Success = true
+	return nil
+}
+
+func registerDetail(ttl time.Duration, pid int, onSleep core.SleepPolicy, profile string) string {
+	detail := fmt.Sprintf("ttl=%s", ttl)
+	if pid > 0 {
+		detail += fmt.Sprintf(" pid=%d", pid)
+	}
+	if onSleep != core.SleepPolicyDefault {
+		detail += fmt.Sprintf(" on_sleep=%s", onSleep)
+	}
+	if profile != "" {
+		detail += fmt.Sprintf(" profile=%s", profile)
+	}
+	return detail
+}
+
+func startRPCServer(cfg core.Config, manager *Manager) (func() error, error) {
 	if err := os.Remove(cfg.SockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, fmt.Errorf("failed to remove old socket %q: %w", cfg.SockPath, err)
 	}
@@ -112,7 +317,6 @@ func startRPCServer(cfg core.Config, state *core.State, notifier Notifier) (func
 		return nil, fmt.Errorf("failed to create socket dir %q: %w", dir, err)
 	}
 
-	manager := &Manager{state: state, cfg: cfg, notifier: notifier}
 	server := rpc.NewServer()
 	if err := server.RegisterName("Manager", manager); err != nil {
 		return nil, fmt.Errorf("failed to register rpc manager: %w", err)
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stefanos/dotward/internal/core"
+	"github.com/stefanos/dotward/internal/ipc"
+)
+
+// newTestManager returns a Manager with the given config file contents, its
+// state and failure records in a temporary directory, and a clock stopped
+// at now.
+func newTestManager(t *testing.T, settings string, now time.Time) (*Manager, *time.Time) {
+	t.Helper()
+	s, err := core.ParseSettings([]byte(settings))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	dir := t.TempDir()
+	cfg := core.Config{
+		StatePath:    filepath.Join(dir, "state.json"),
+		FailuresPath: filepath.Join(dir, "failures.json"),
+		Settings:     s,
+	}
+	clock := now
+	m := &Manager{
+		state:    core.NewState(),
+		cfg:      newConfigStore(cfg),
+		procs:    newProcessTracker(make(chan processExit, 1)),
+		failures: core.NewFailures(),
+		now:      func() time.Time { return clock },
+	}
+	t.Cleanup(m.procs.Stop)
+	return m, &clock
+}
+
+func TestRegisterManyReportsEachResult(t *testing.T) {
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	m, _ := newTestManager(t, `{"default_ttl": "1h", "limits": {"max_unlocked_files": 2}}`, now)
+
+	var resp ipc.Response
+	req := ipc.Request{Batch: []ipc.Request{
+		{Path: "/srv/a/.env", TTL: 30 * time.Minute},
+		{},
+		{Path: "/srv/b/.env"},
+		{Path: "/srv/c/.env"},
+	}}
+	if err := m.RegisterMany(req, &resp); err != nil {
+		t.Fatalf("RegisterMany: %v", err)
+	}
+	if resp.Success || resp.Error != "2 of 4 registrations failed" || len(resp.Results) != 4 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	want := []struct {
+		ok  bool
+		ttl time.Duration
+		err string
+	}{
+		{true, 30 * time.Minute, ""},
+		{false, 0, "path is required"},
+		{true, time.Hour, ""},
+		{false, 0, "too many files unlocked"},
+	}
+	for i, w := range want {
+		r := resp.Results[i]
+		if r.Success != w.ok || r.TTL != w.ttl || !strings.Contains(r.Error, w.err) {
+			t.Fatalf("result %d got %+v, want %+v", i, r, w)
+		}
+	}
+
+	loaded, err := core.LoadState(m.cfg.Get().StatePath)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if loaded.Count() != 2 || !loaded.IsWatching("/srv/a/.env") || !loaded.IsWatching("/srv/b/.env") {
+		t.Fatalf("saved state got %v", loaded.Snapshot())
+	}
+}
+
+func TestRegisterManyReportsSaveFailure(t *testing.T) {
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	m, _ := newTestManager(t, `{}`, now)
+	cfg := m.cfg.Get()
+	cfg.StatePath = filepath.Join(t.TempDir(), "missing", "state.json")
+	m.cfg.Set(cfg)
+
+	var resp ipc.Response
+	req := ipc.Request{Batch: []ipc.Request{{Path: "/srv/a/.env"}, {}}}
+	if err := m.RegisterMany(req, &resp); err != nil {
+		t.Fatalf("RegisterMany: %v", err)
+	}
+	if resp.Success || resp.Error != "2 of 2 registrations failed" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if r := resp.Results[0]; r.Success || !strings.HasPrefix(r.Error, "failed to save state") {
+		t.Fatalf("accepted registration got %+v, want a save failure", r)
+	}
+	if r := resp.Results[1]; r.Success || r.Error != "path is required" {
+		t.Fatalf("refused registration got %+v", r)
+	}
+	if _, err := os.Stat(cfg.StatePath); !os.IsNotExist(err) {
+		t.Fatalf("state file written: %v", err)
+	}
+}
+
+func TestRegisterAndExtendClampToSchedule(t *testing.T) {
+	// 2026-03-02 is a Monday.
+	monday := func(h, m int) time.Time { return time.Date(2026, 3, 2, h, m, 0, 0, time.UTC) }
+	m, clock := newTestManager(t, `{"default_ttl": "1h", "schedules": [
+		{"pattern": "**/prod/*.env", "start": "09:00", "end": "18:00", "timezone": "UTC"}
+	]}`, monday(17, 30))
+
+	var resp ipc.Response
+	if err := m.Register(ipc.Request{Path: "/srv/prod/api.env"}, &resp); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if !resp.Success || resp.TTL != 30*time.Minute {
+		t.Fatalf("register near the end of the window got %+v, want a 30m TTL", resp)
+	}
+	if wf, _ := m.state.Lookup("/srv/prod/api.env"); !wf.ExpiresAt.Equal(monday(18, 0)) {
+		t.Fatalf("expiry got %s, want the end of the window", wf.ExpiresAt)
+	}
+
+	resp = ipc.Response{}
+	if err := m.Extend(ipc.Request{Path: "/srv/prod/api.env"}, &resp); err != nil {
+		t.Fatalf("Extend: %v", err)
+	}
+	if resp.Success || !strings.Contains(resp.Error, core.ErrOutsideSchedule.Error()) {
+		t.Fatalf("extend past the window got %+v", resp)
+	}
+
+	resp = ipc.Response{}
+	if err := m.Register(ipc.Request{Path: "/srv/dev/api.env"}, &resp); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if !resp.Success || resp.TTL != time.Hour {
+		t.Fatalf("file without a schedule got %+v", resp)
+	}
+
+	*clock = monday(19, 0)
+	resp = ipc.Response{}
+	if err := m.Register(ipc.Request{Path: "/srv/prod/other.env"}, &resp); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if resp.Success || !strings.Contains(resp.Error, core.ErrOutsideSchedule.Error()) {
+		t.Fatalf("register outside the window got %+v", resp)
+	}
+	if m.state.IsWatching("/srv/prod/other.env") {
+		t.Fatal("file registered outside its window")
+	}
+}
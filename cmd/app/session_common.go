@@ -0,0 +1,33 @@
+package main
+
+// sessionEventKind identifies why watched files should be locked.
+type sessionEventKind int
+
+const (
+	sessionSleep sessionEventKind = iota + 1
+	sessionLock
+)
+
+func (k sessionEventKind) String() string {
+	switch k {
+	case sessionSleep:
+		return "system sleep"
+	case sessionLock:
+		return "session lock"
+	default:
+		return "session event"
+	}
+}
+
+// sessionEvent asks the app loop to lock files on suspend or session lock.
+// done, when set, tells the platform the loop has finished acting on it.
+type sessionEvent struct {
+	kind sessionEventKind
+	done func()
+}
+
+func (e sessionEvent) Done() {
+	if e.done != nil {
+		e.done()
+	}
+}
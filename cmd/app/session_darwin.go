@@ -0,0 +1,74 @@
+//go:build darwin
+
+package main
+
+/*
+#cgo CFLAGS: -fobjc-arc
+#cgo LDFLAGS: -framework Foundation -framework AppKit -framework IOKit
+
+int DotwardRegisterSessionObservers(void);
+void DotwardAllowSleep(long notificationID);
+*/
+import "C"
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	sessionMu       sync.RWMutex
+	sessionSignalCh chan<- sessionEvent
+)
+
+func initSessionMonitor(ch chan<- sessionEvent) error {
+	sessionMu.Lock()
+	sessionSignalCh = ch
+	sessionMu.Unlock()
+	if C.DotwardRegisterSessionObservers() != 0 {
+		return errors.New("failed to register for system power notifications; files are locked on sleep without delaying it")
+	}
+	return nil
+}
+
+// HandleSystemWillSleep is called when the system is about to sleep. Sleep
+// is delayed until the app loop has locked the files and acknowledges the
+// notification, or until macOS stops waiting after 30 seconds.
+//
+//export HandleSystemWillSleep
+func HandleSystemWillSleep(notificationID C.long) {
+	var once sync.Once
+	sendSessionEvent(sessionEvent{kind: sessionSleep, done: func() {
+		once.Do(func() { C.DotwardAllowSleep(notificationID) })
+	}})
+}
+
+// HandleSystemSleep is called for sleep notifications that cannot be
+// delayed.
+//
+//export HandleSystemSleep
+func HandleSystemSleep() {
+	sendSessionEvent(sessionEvent{kind: sessionSleep})
+}
+
+//export HandleSessionLock
+func HandleSessionLock() {
+	sendSessionEvent(sessionEvent{kind: sessionLock})
+}
+
+// sendSessionEvent hands ev to the app loop without blocking the main
+// thread. An event that cannot be delivered is released at once.
+func sendSessionEvent(ev sessionEvent) {
+	sessionMu.RLock()
+	ch := sessionSignalCh
+	sessionMu.RUnlock()
+	if ch == nil {
+		ev.Done()
+		return
+	}
+	select {
+	case ch <- ev:
+	default:
+		ev.Done()
+	}
+}
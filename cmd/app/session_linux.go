@@ -0,0 +1,38 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stefanos/dotward/internal/logind"
+)
+
+func initSessionMonitor(ch chan<- sessionEvent) error {
+	monitor, err := logind.Connect()
+	if err != nil {
+		return err
+	}
+	events, err := monitor.Start(context.Background())
+	if err != nil {
+		_ = monitor.Close()
+		return fmt.Errorf("failed to start logind monitor: %w", err)
+	}
+
+	go func() {
+		for ev := range events {
+			switch ev.Kind {
+			case logind.EventSleep:
+				// Holding the event keeps logind's delay inhibitor until the
+				// app loop has locked the files.
+				ch <- sessionEvent{kind: sessionSleep, done: ev.Done}
+			case logind.EventLock:
+				ch <- sessionEvent{kind: sessionLock}
+			default:
+				ev.Done()
+			}
+		}
+	}()
+	return nil
+}
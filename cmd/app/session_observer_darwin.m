@@ -0,0 +1,88 @@
+#import <AppKit/AppKit.h>
+#import <Foundation/Foundation.h>
+#import <IOKit/IOMessage.h>
+#import <IOKit/pwr_mgt/IOPMLib.h>
+
+extern void HandleSystemWillSleep(long notificationID);
+extern void HandleSystemSleep(void);
+extern void HandleSessionLock(void);
+
+static id dotwardSleepObserver;
+static id dotwardResignObserver;
+static id dotwardScreenLockObserver;
+
+static io_connect_t dotwardRootPort = MACH_PORT_NULL;
+static IONotificationPortRef dotwardPowerPort;
+static io_object_t dotwardPowerNotifier;
+
+static void DotwardPowerCallback(__unused void *refCon, __unused io_service_t service, natural_t messageType, void *messageArgument) {
+    switch (messageType) {
+    case kIOMessageCanSystemSleep:
+        IOAllowPowerChange(dotwardRootPort, (long)messageArgument);
+        break;
+    case kIOMessageSystemWillSleep:
+        // Acknowledged through DotwardAllowSleep once the files are locked.
+        HandleSystemWillSleep((long)messageArgument);
+        break;
+    default:
+        break;
+    }
+}
+
+void DotwardAllowSleep(long notificationID) {
+    if (dotwardRootPort != MACH_PORT_NULL) {
+        IOAllowPowerChange(dotwardRootPort, notificationID);
+    }
+}
+
+// DotwardRegisterSessionObservers returns -1 when sleep cannot be delayed,
+// in which case files are locked on the workspace sleep notification.
+int DotwardRegisterSessionObservers(void) {
+    int result = 0;
+    @autoreleasepool {
+        NSNotificationCenter *workspaceCenter = [[NSWorkspace sharedWorkspace] notificationCenter];
+        NSDistributedNotificationCenter *distributedCenter = [NSDistributedNotificationCenter defaultCenter];
+
+        if (dotwardSleepObserver != nil) {
+            [workspaceCenter removeObserver:dotwardSleepObserver];
+            dotwardSleepObserver = nil;
+        }
+        if (dotwardResignObserver != nil) {
+            [workspaceCenter removeObserver:dotwardResignObserver];
+            dotwardResignObserver = nil;
+        }
+        if (dotwardScreenLockObserver != nil) {
+            [distributedCenter removeObserver:dotwardScreenLockObserver];
+            dotwardScreenLockObserver = nil;
+        }
+
+        if (dotwardRootPort == MACH_PORT_NULL) {
+            dotwardRootPort = IORegisterForSystemPower(NULL, &dotwardPowerPort, DotwardPowerCallback, &dotwardPowerNotifier);
+            if (dotwardRootPort != MACH_PORT_NULL) {
+                CFRunLoopAddSource(CFRunLoopGetMain(), IONotificationPortGetRunLoopSource(dotwardPowerPort), kCFRunLoopCommonModes);
+            }
+        }
+        if (dotwardRootPort == MACH_PORT_NULL) {
+            result = -1;
+            dotwardSleepObserver = [workspaceCenter addObserverForName:NSWorkspaceWillSleepNotification
+                                                                object:nil
+                                                                 queue:[NSOperationQueue mainQueue]
+                                                            usingBlock:^(__unused NSNotification *note) {
+                HandleSystemSleep();
+            }];
+        }
+        dotwardResignObserver = [workspaceCenter addObserverForName:NSWorkspaceSessionDidResignActiveNotification
+                                                             object:nil
+                                                              queue:[NSOperationQueue mainQueue]
+                                                         usingBlock:^(__unused NSNotification *note) {
+            HandleSessionLock();
+        }];
+        dotwardScreenLockObserver = [distributedCenter addObserverForName:@"com.apple.screenIsLocked"
+                                                                   object:nil
+                                                                    queue:[NSOperationQueue mainQueue]
+                                                               usingBlock:^(__unused NSNotification *note) {
+            HandleSessionLock();
+        }];
+    }
+    return result;
+}
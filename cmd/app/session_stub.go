@@ -0,0 +1,7 @@
+//go:build !darwin && !linux
+
+package main
+
+func initSessionMonitor(_ chan<- sessionEvent) error {
+	return nil
+}
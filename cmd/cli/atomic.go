@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/stefanos/dotward/internal/core"
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/ipc"
+)
+
+// lockFilesAtomic locks all of paths or none. Every sidecar is written to a
+// temporary file first; only when all of them succeed are they renamed into
+// place and the plaintexts deleted.
+func lockFilesAtomic(settings core.Settings, paths []string) ([]fileResult, error) {
+	t := &txn{}
+	results, err := withProfileSessions(settings, paths, nil, true, "Encrypting", func(_ int, path, profile string, sess *cryptopkg.Session) (string, error) {
+		if err := checkPlaintextExists(path); err != nil {
+			return "", err
+		}
+		encPath := path + ".enc"
+		meta, err := sidecarMetadata(encPath, profile, sess)
+		if err != nil {
+			return "", err
+		}
+		defer zeroBytes(meta.TOTPSecret)
+		err = t.stage(encPath, false, func(tmp string) error {
+			return sess.EncryptFile(path, tmp, meta)
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt %q: %w", path, err)
+		}
+		t.remove(path, true)
+		return fmt.Sprintf("Locked %s and updated %s", path, encPath), nil
+	})
+	if err != nil {
+		t.rollback()
+		return nil, err
+	}
+	if abortFailed(t, results) {
+		return results, nil
+	}
+	if err := t.commit(); err != nil {
+		markRolledBack(results, withCode(codeRolledBack, fmt.Errorf("rolled back: %w", err)))
+		return results, nil
+	}
+	reportLeftovers(t.finish())
+	return results, nil
+}
+
+// unlockFilesAtomic unlocks all of files or none. Every plaintext is
+// decrypted to a temporary file first and renamed into place only when all
+// of them succeed. When the daemon then refuses any registration, the
+// registrations this batch created are withdrawn and the plaintexts removed
+// again. Files that were already unlocked get their previous plaintext back
+// and stay registered.
+func unlockFilesAtomic(cfg core.Config, files []string, opts unlockOptions) ([]fileResult, error) {
+	t := &txn{}
+	reqs := make([]ipc.Request, len(files))
+	watched := make([]bool, len(files))
+	gate := newUnlockGate(cfg.SockPath)
+	defer gate.close()
+	results, err := withProfileSessions(cfg.Settings, files, opts.FileProfiles, false, "Decrypting", func(i int, file, profile string, sess *cryptopkg.Session) (string, error) {
+		absPath, encPath, err := resolveUnlockPaths(file)
+		if err != nil {
+			return "", err
+		}
+		plaintext, sidecar, err := gate.decrypt(file, encPath, sess)
+		if err != nil {
+			return "", err
+		}
+		defer zeroBytes(plaintext)
+		watched[i] = isWatched(cfg.SockPath, absPath)
+		err = t.stage(absPath, true, func(tmp string) error { return os.WriteFile(tmp, plaintext, 0o600) })
+		if err != nil {
+			return "", fmt.Errorf("failed to write plaintext file %q: %w", absPath, err)
+		}
+		reqs[i] = unlockRequest(file, absPath, profile, sidecar, opts)
+		return "", nil
+	})
+	if err != nil {
+		t.rollback()
+		return nil, err
+	}
+	if abortFailed(t, results) {
+		return results, nil
+	}
+	if err := t.commit(); err != nil {
+		markRolledBack(results, withCode(codeRolledBack, fmt.Errorf("rolled back: %w", err)))
+		return results, nil
+	}
+
+	resps, err := registerMany(cfg.SockPath, reqs)
+	var refused int
+	for i := range results {
+		switch {
+		case err != nil:
+			results[i].err = errDaemonUnreachable
+		case !resps[i].Success:
+			results[i].err = withCode(codeDaemonRejected, fmt.Errorf("daemon rejected register: %s", resps[i].Error))
+		}
+		if results[i].err != nil {
+			refused++
+		}
+	}
+	if refused > 0 {
+		for i, r := range results {
+			if r.err != nil || watched[i] {
+				continue
+			}
+			if err := stopWatching(cfg.SockPath, reqs[i].Path); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to withdraw the registration of %s (%v)\n", reqs[i].Path, err)
+			}
+		}
+		t.rollback()
+		markRolledBack(results, withCode(codeRolledBack, fmt.Errorf("rolled back: %d other file(s) failed", refused)))
+		return results, nil
+	}
+
+	reportLeftovers(t.finish())
+	for i, req := range reqs {
+		results[i].ttl = appliedTTL(resps[i], req.TTL, cfg.ForProfile(req.Profile), req.Path)
+		results[i].msg = fmt.Sprintf("Unlocked %s for %s", results[i].file, results[i].ttl)
+	}
+	return results, nil
+}
+
+// isWatched reports whether the daemon already watches absPath. A daemon
+// that cannot be asked counts as not watching it.
+func isWatched(sockPath, absPath string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	watching, err := ipcIsWatching(ctx, sockPath, absPath)
+	return err == nil && watching
+}
+
+// abortFailed rolls t back when any file of results failed and marks the
+// others as rolled back. It reports whether it did.
+func abortFailed(t *txn, results []fileResult) bool {
+	var failed int
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return false
+	}
+	t.rollback()
+	markRolledBack(results, withCode(codeRolledBack, fmt.Errorf("rolled back: %d other file(s) failed", failed)))
+	return true
+}
+
+// markRolledBack gives err to every file of results that has not failed.
+func markRolledBack(results []fileResult, err error) {
+	for i := range results {
+		if results[i].err == nil {
+			results[i].err, results[i].msg = err, ""
+		}
+	}
+}
+
+func reportLeftovers(failed map[string]error) {
+	for target, err := range failed {
+		fmt.Fprintf(os.Stderr, "warning: failed to clean up after %s (%v)\n", target, err)
+	}
+}
+
+// txn groups the file changes of an atomic batch. Outputs are written to
+// temporary files next to their targets and files to remove are only
+// recorded; commit then moves everything into place with renames, which
+// rollback can undo.
+type txn struct {
+	mu      sync.Mutex
+	changes []*txnChange
+}
+
+// txnChange is one change of a txn: temp renamed over target, or target
+// removed when temp is empty. A target that exists is moved to backup until
+// the txn is finished.
+type txnChange struct {
+	target, temp, backup string
+	// secret changes hold plaintext, which is securely deleted.
+	secret bool
+	// movedAside and placed record how far apply got.
+	movedAside, placed bool
+}
+
+// stage calls write with the path of a new temporary file next to target
+// and records it to replace target on commit.
+func (t *txn) stage(target string, secret bool, write func(tmp string) error) error {
+	tmp, err := reserveTemp(target)
+	if err != nil {
+		return fmt.Errorf("failed to stage %q: %w", target, err)
+	}
+	if err := write(tmp); err != nil {
+		_ = discard(tmp, secret)
+		return err
+	}
+	t.add(&txnChange{target: target, temp: tmp, secret: secret})
+	return nil
+}
+
+// remove records target to be removed on commit.
+func (t *txn) remove(target string, secret bool) {
+	t.add(&txnChange{target: target, secret: secret})
+}
+
+func (t *txn) add(c *txnChange) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.changes = append(t.changes, c)
+}
+
+// commit applies every change. When one fails, all of them are undone and
+// the staged files discarded.
+func (t *txn) commit() error {
+	for _, c := range t.changes {
+		if err := c.apply(); err != nil {
+			t.rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *txnChange) apply() error {
+	if _, err := os.Lstat(c.target); err == nil {
+		backup, err := reserveTemp(c.target)
+		if err != nil {
+			return fmt.Errorf("failed to move %q aside: %w", c.target, err)
+		}
+		if err := os.Rename(c.target, backup); err != nil {
+			_ = os.Remove(backup)
+			return fmt.Errorf("failed to move %q aside: %w", c.target, err)
+		}
+		c.backup, c.movedAside = backup, true
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to stat %q: %w", c.target, err)
+	}
+	if c.temp == "" {
+		return nil
+	}
+	if err := os.Rename(c.temp, c.target); err != nil {
+		return fmt.Errorf("failed to move %q into place: %w", c.target, err)
+	}
+	c.placed = true
+	return nil
+}
+
+// rollback undoes the applied changes, newest first, and discards the
+// staged files.
+func (t *txn) rollback() {
+	for i := len(t.changes) - 1; i >= 0; i-- {
+		c := t.changes[i]
+		switch {
+		case c.placed:
+			_ = discard(c.target, c.secret)
+		case c.temp != "":
+			_ = discard(c.temp, c.secret)
+		}
+		if c.movedAside {
+			if err := os.Rename(c.backup, c.target); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to restore %s from %s: %v\n", c.target, c.backup, err)
+			}
+		}
+		c.placed, c.movedAside = false, false
+	}
+}
+
+// finish deletes the backups of a committed txn and returns the targets
+// whose backup could not be deleted.
+func (t *txn) finish() map[string]error {
+	failed := make(map[string]error)
+	for _, c := range t.changes {
+		if !c.movedAside {
+			continue
+		}
+		if err := discard(c.backup, c.secret); err != nil {
+			failed[c.target] = err
+		}
+	}
+	return failed
+}
+
+// reserveTemp creates an empty, owner-only file next to target and returns
+// its path.
+func reserveTemp(target string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".dotward-*")
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+	if err := f.Close(); err != nil {
+		_ = os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
+
+// discard deletes path, securely when it holds plaintext.
+func discard(path string, secret bool) error {
+	if secret {
+		return core.SecureDelete(path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove file %q: %w", path, err)
+	}
+	return nil
+}
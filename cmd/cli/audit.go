@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stefanos/dotward/internal/audit"
+	"github.com/stefanos/dotward/internal/core"
+)
+
+var (
+	auditPathFlag  string
+	auditSinceFlag string
+	auditUntilFlag string
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the daemon's audit log",
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that the audit log hash chain is intact",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := core.ResolveConfig()
+		if err != nil {
+			return fmt.Errorf("failed to resolve config: %w", err)
+		}
+		n, err := audit.Verify(cfg.AuditPath)
+		if err != nil {
+			return fmt.Errorf("audit log %q failed verification after %d valid entries: %w", cfg.AuditPath, n, err)
+		}
+		fmt.Printf("audit log OK: %d entries\n", n)
+		return nil
+	},
+}
+
+var auditShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print audit log entries, optionally filtered by path and time",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := core.ResolveConfig()
+		if err != nil {
+			return fmt.Errorf("failed to resolve config: %w", err)
+		}
+		flt, err := buildAuditFilter(auditPathFlag, auditSinceFlag, auditUntilFlag, time.Now())
+		if err != nil {
+			return err
+		}
+		entries, err := audit.Read(cfg.AuditPath, flt)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			line := fmt.Sprintf("%s  %-13s  %s", e.Time.Local().Format(time.RFC3339), e.Event, e.Path)
+			if e.User != "" {
+				line += "  user=" + e.User
+			}
+			if e.Detail != "" {
+				line += "  " + e.Detail
+			}
+			fmt.Println(line)
+		}
+		return nil
+	},
+}
+
+func init() {
+	auditShowCmd.Flags().StringVar(&auditPathFlag, "path", "", "only show entries for this file or directory")
+	auditShowCmd.Flags().StringVar(&auditSinceFlag, "since", "", "only show entries at or after this time (duration ago like 24h, RFC3339, or YYYY-MM-DD)")
+	auditShowCmd.Flags().StringVar(&auditUntilFlag, "until", "", "only show entries at or before this time (same formats as --since)")
+	auditCmd.AddCommand(auditVerifyCmd, auditShowCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+// buildAuditFilter turns the audit show flags into a filter relative to now.
+func buildAuditFilter(path, since, until string, now time.Time) (audit.Filter, error) {
+	var flt audit.Filter
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return audit.Filter{}, fmt.Errorf("failed to resolve path %q: %w", path, err)
+		}
+		flt.Path = abs
+	}
+	var err error
+	if flt.Since, err = parseAuditTime(since, now); err != nil {
+		return audit.Filter{}, fmt.Errorf("invalid --since: %w", err)
+	}
+	if flt.Until, err = parseAuditTime(until, now); err != nil {
+		return audit.Filter{}, fmt.Errorf("invalid --until: %w", err)
+	}
+	return flt, nil
+}
+
+// parseAuditTime accepts a duration before now, an RFC3339 timestamp or a
+// local calendar date. An empty value yields the zero time.
+func parseAuditTime(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a duration, RFC3339 timestamp or YYYY-MM-DD date", v)
+}
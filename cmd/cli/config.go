@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stefanos/dotward/internal/core"
+	"github.com/stefanos/dotward/internal/ipc"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Read, change and validate the Dotward config file",
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get [key]",
+	Short: "Print the effective value of one or all settings",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := core.ResolveConfig()
+		if err != nil {
+			return fmt.Errorf("failed to resolve config: %w", err)
+		}
+		if len(args) == 1 {
+			v, err := core.SettingValue(cfg.Settings, args[0])
+			if err != nil {
+				return err
+			}
+			if s, ok := v.(string); ok {
+				fmt.Println(s)
+				return nil
+			}
+			return printSettingJSON(v)
+		}
+		for _, key := range core.SettingKeys() {
+			v, err := core.SettingValue(cfg.Settings, key)
+			if err != nil {
+				return err
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("failed to encode %s: %w", key, err)
+			}
+			fmt.Printf("%s = %s\n", key, b)
+		}
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Validate and store a setting, then ask the daemon to reload",
+	Long:  "Validate and store a setting, then ask the daemon to reload.\n\nList values such as warning_windows are given comma-separated, e.g. \"15m,5m,1m\".",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := core.SettingsFilePath()
+		if err != nil {
+			return err
+		}
+		key, value := args[0], args[1]
+		if err := core.SetSetting(path, key, value); err != nil {
+			return err
+		}
+		fmt.Printf("Set %s = %s\n", key, value)
+		if core.SettingRequiresRestart(key) {
+			fmt.Println("Restart Dotward.app for this setting to take effect.")
+		}
+		return reloadDaemonConfig()
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Check a config file and report every invalid setting",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := ""
+		if len(args) == 1 {
+			path = args[0]
+		} else {
+			var err error
+			if path, err = core.SettingsFilePath(); err != nil {
+				return err
+			}
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read config %q: %w", path, err)
+		}
+		_, err = core.ParseSettings(b)
+		var fieldErrs core.ValidationErrors
+		if errors.As(err, &fieldErrs) {
+			for _, fe := range fieldErrs {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", fe.Key, fe.Err)
+			}
+			return fmt.Errorf("%s has %d invalid setting(s)", path, len(fieldErrs))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s is valid\n", path)
+		return nil
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configGetCmd, configSetCmd, configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func printSettingJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode setting: %w", err)
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// reloadDaemonConfig asks a running daemon to apply the config file. A daemon
+// that is not running picks the file up when it starts.
+func reloadDaemonConfig() error {
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		return fmt.Errorf("failed to resolve config: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := ipc.Call(ctx, cfg.SockPath, "Manager.ReloadConfig", ipc.Request{})
+	if err != nil {
+		return nil
+	}
+	if !resp.Success {
+		return fmt.Errorf("daemon rejected config reload: %s", resp.Error)
+	}
+	fmt.Println("Dotward.app reloaded its config.")
+	return nil
+}
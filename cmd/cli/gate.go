@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/ipc"
+	"github.com/stefanos/dotward/internal/totp"
+)
+
+// errWrongTOTPCode is returned for a code that does not match, and
+// errReusedTOTPCode for one the daemon already accepted for the file.
+var (
+	errWrongTOTPCode  = errors.New("wrong TOTP code")
+	errReusedTOTPCode = errors.New("TOTP code already used")
+)
+
+// unlockGate stands between a sidecar and its plaintext. It has the daemon
+// reserve every attempt to unlock a file, reports how it went so that
+// the daemon backs off and locks files out, and asks for the code of files
+// enrolled for TOTP. It is safe for concurrent use; prompts are asked one
+// at a time, and files sharing a secret, as files enrolled together do,
+// need one code.
+type unlockGate struct {
+	// sockPath is the daemon socket. Without a daemon, attempts are not
+	// limited.
+	sockPath string
+	mu       sync.Mutex
+	verified []verifiedCode
+}
+
+// verifiedCode is a TOTP secret whose code was given, and the time step of
+// that code.
+type verifiedCode struct {
+	secret []byte
+	step   int64
+}
+
+func newUnlockGate(sockPath string) *unlockGate {
+	return &unlockGate{sockPath: sockPath}
+}
+
+// decrypt returns the plaintext of the sidecar encPath of file, and the
+// fingerprint of the sidecar, once the daemon allows the attempt and the
+// code for its TOTP secret, if it has one, is given.
+func (g *unlockGate) decrypt(file, encPath string, sess *cryptopkg.Session) ([]byte, string, error) {
+	absPath, _, err := resolveUnlockPaths(file)
+	if err != nil {
+		return nil, "", err
+	}
+	payload, err := os.ReadFile(encPath)
+	if err != nil {
+		return nil, "", decryptError(encPath, err)
+	}
+	sidecar := sidecarFingerprint(payload)
+	plaintext, err := g.attempt(file, absPath, sidecar, func() ([]byte, error) {
+		return g.open(file, absPath, encPath, sidecar, sess, payload)
+	})
+	return plaintext, sidecar, err
+}
+
+// attempt runs open, which tries the password, and the code, of the sidecar
+// with fingerprint sidecar, once the daemon reserved the attempt. How it
+// went is reported to the daemon, which backs off after a wrong password or
+// code and locks files out.
+func (g *unlockGate) attempt(file, absPath, sidecar string, open func() ([]byte, error)) ([]byte, error) {
+	token, err := g.reserve(file, absPath, sidecar)
+	if err != nil {
+		return nil, err
+	}
+	req := ipc.Request{Path: absPath, Sidecar: sidecar, Attempt: token}
+	plaintext, err := open()
+	if err != nil {
+		if errors.Is(err, cryptopkg.ErrDecrypt) || errors.Is(err, errWrongTOTPCode) || errors.Is(err, errReusedTOTPCode) {
+			g.report("Manager.UnlockFailed", req)
+		}
+		return nil, err
+	}
+	g.report("Manager.UnlockSucceeded", req)
+	return plaintext, nil
+}
+
+func (g *unlockGate) open(file, absPath, encPath, sidecar string, sess *cryptopkg.Session, payload []byte) ([]byte, error) {
+	secret, err := sess.TOTPSecret(payload)
+	if err != nil {
+		return nil, decryptError(encPath, err)
+	}
+	if secret != nil {
+		defer zeroBytes(secret)
+		if err := g.checkTOTP(file, absPath, sidecar, secret); err != nil {
+			return nil, err
+		}
+	}
+	plaintext, err := sess.DecryptBytes(payload)
+	if err != nil {
+		return nil, decryptError(encPath, err)
+	}
+	return plaintext, nil
+}
+
+// reserve asks the daemon to start an attempt to unlock file and returns
+// its token. The daemon refuses while it backs off after failed attempts,
+// while another attempt is in progress, or once the file is locked out.
+func (g *unlockGate) reserve(file, absPath, sidecar string) (string, error) {
+	if g.sockPath == "" {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := ipc.Call(ctx, g.sockPath, "Manager.ReserveUnlock", ipc.Request{Path: absPath, Sidecar: sidecar})
+	if err != nil {
+		// Without a daemon there is nobody to keep count.
+		return "", nil
+	}
+	if resp.Success {
+		return resp.Attempt, nil
+	}
+	if resp.TTL > 0 {
+		return "", withCode(codeBackoff, fmt.Errorf("%s: %s", file, resp.Error))
+	}
+	return "", withCode(codeLockedOut, fmt.Errorf("%s: %s; run dotward lockout clear to allow it again", file, resp.Error))
+}
+
+// report tells the daemon how the attempt req went.
+func (g *unlockGate) report(method string, req ipc.Request) {
+	if g.sockPath == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, _ = ipc.Call(ctx, g.sockPath, method, req)
+}
+
+// sidecarFingerprint identifies an encrypted payload for the daemon's count
+// of failed attempts. It is empty for payloads that are not Dotward files.
+func sidecarFingerprint(payload []byte) string {
+	h, err := cryptopkg.InspectBytes(payload)
+	if err != nil {
+		return ""
+	}
+	return h.Fingerprint()
+}
+
+// checkTOTP asks for the code of the TOTP secret of file unless it was
+// already given, and has the daemon refuse a code already used for the
+// sidecar.
+func (g *unlockGate) checkTOTP(file, absPath, sidecar string, secret []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, v := range g.verified {
+		if subtle.ConstantTimeCompare(v.secret, secret) == 1 {
+			return g.useTOTPStep(file, absPath, sidecar, v.step)
+		}
+	}
+	code, err := readTOTPCode(fmt.Sprintf("TOTP code for %s: ", file))
+	if err != nil {
+		return withCode(codeTOTPFailed, fmt.Errorf("%s needs a TOTP code: %w", file, err))
+	}
+	step, ok := totp.Match(secret, code, totpNow(), totp.DefaultSkew)
+	if !ok {
+		return withCode(codeTOTPFailed, fmt.Errorf("%w for %s", errWrongTOTPCode, file))
+	}
+	if err := g.useTOTPStep(file, absPath, sidecar, step); err != nil {
+		return err
+	}
+	g.verified = append(g.verified, verifiedCode{secret: append([]byte(nil), secret...), step: step})
+	return nil
+}
+
+// useTOTPStep tells the daemon that a code of time step step unlocks
+// sidecar. The daemon refuses a step at or before the last one it accepted
+// for the sidecar, so that a code seen over someone's shoulder cannot be
+// used again while it is still valid. Without a daemon codes are not
+// tracked.
+func (g *unlockGate) useTOTPStep(file, absPath, sidecar string, step int64) error {
+	if g.sockPath == "" || sidecar == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := ipc.Call(ctx, g.sockPath, "Manager.UseTOTPStep", ipc.Request{Path: absPath, Sidecar: sidecar, TOTPStep: step})
+	if err != nil || resp.Success {
+		return nil
+	}
+	return withCode(codeTOTPFailed, fmt.Errorf("%w for %s; wait for the next code", errReusedTOTPCode, file))
+}
+
+// close zeroes the secrets the gate remembers.
+func (g *unlockGate) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, v := range g.verified {
+		zeroBytes(v.secret)
+	}
+	g.verified = nil
+}
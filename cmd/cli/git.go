@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/stefanos/dotward/internal/core"
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/dotenv"
+	"github.com/stefanos/dotward/internal/scan"
+)
+
+// hookMarker identifies hooks written by dotward git setup.
+const hookMarker = "# Installed by dotward git setup."
+
+// gitAttribute routes encrypted files through the dotward diff and merge
+// drivers.
+const gitAttribute = "*.enc diff=dotward merge=dotward"
+
+var gitCmd = &cobra.Command{
+	Use:   "git",
+	Short: "Integrate Dotward with git diffs and commits",
+}
+
+var gitSetupCmd = &cobra.Command{
+	Use:   "setup",
+	Short: "Install the decrypting diff and merge drivers and the pre-commit hook in this repository",
+	Long: `Install the decrypting diff and merge drivers and the pre-commit hook in this
+repository.
+
+setup configures a "dotward" textconv diff driver and a key-by-key merge
+driver in the repository's git config, maps *.enc files to them in
+.gitattributes, and installs a pre-commit hook that runs
+"dotward git pre-commit". Running it again is safe.
+
+With --filter, matching files are instead committed through a transparent
+clean/smudge filter: the work tree holds plaintext watched by Dotward.app and
+the repository stores ciphertext. The flag may be repeated.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mask, err := cmd.Flags().GetBool("mask")
+		if err != nil {
+			return err
+		}
+		force, err := cmd.Flags().GetBool("force")
+		if err != nil {
+			return err
+		}
+		filters, err := cmd.Flags().GetStringArray("filter")
+		if err != nil {
+			return err
+		}
+		return gitSetup(mask, force, filters)
+	},
+}
+
+var gitTextconvCmd = &cobra.Command{
+	Use:    "textconv <file>",
+	Short:  "Print an encrypted .env file as KEY=value lines for git diff",
+	Args:   cobra.ExactArgs(1),
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mask, err := cmd.Flags().GetBool("mask")
+		if err != nil {
+			return err
+		}
+		fmt.Print(textconv(args[0], mask))
+		return nil
+	},
+}
+
+var gitPreCommitCmd = &cobra.Command{
+	Use:   "pre-commit",
+	Short: "Block commits that stage plaintext secrets or leave .env files unprotected",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return gitPreCommit()
+	},
+}
+
+func init() {
+	gitSetupCmd.Flags().Bool("mask", false, "show value hashes instead of values in diffs")
+	gitSetupCmd.Flags().Bool("force", false, "replace an existing pre-commit hook not written by dotward")
+	gitSetupCmd.Flags().StringArray("filter", nil, `store files matching this .gitattributes pattern (e.g. ".env") encrypted through the clean/smudge filter`)
+	gitTextconvCmd.Flags().Bool("mask", false, "show keyed value hashes instead of values")
+	gitCmd.AddCommand(gitSetupCmd, gitTextconvCmd, gitPreCommitCmd)
+	rootCmd.AddCommand(gitCmd)
+}
+
+func gitSetup(mask, force bool, filters []string) error {
+	root, err := gitOutput("", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	self, err := dotwardCommand()
+	if err != nil {
+		return err
+	}
+
+	conv := self + " git textconv"
+	if mask {
+		conv += " --mask"
+	}
+	if _, err := gitOutput(root, "config", "diff.dotward.textconv", conv); err != nil {
+		return err
+	}
+	fmt.Printf("Configured diff driver: %s\n", conv)
+
+	merge := self + " git merge-driver %O %A %B %P"
+	for key, value := range map[string]string{
+		"merge.dotward.name":   "dotward key-by-key merge of encrypted .env files",
+		"merge.dotward.driver": merge,
+	} {
+		if _, err := gitOutput(root, "config", key, value); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Configured merge driver: %s\n", merge)
+
+	added, err := ensureLine(filepath.Join(root, ".gitattributes"), gitAttribute)
+	if err != nil {
+		return err
+	}
+	if added {
+		fmt.Printf("Added %q to .gitattributes\n", gitAttribute)
+	}
+	if len(filters) > 0 {
+		if err := setupFilter(root, self, filters); err != nil {
+			return err
+		}
+	}
+
+	hooksDir, err := gitOutput(root, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(hooksDir) {
+		hooksDir = filepath.Join(root, hooksDir)
+	}
+	hookPath := filepath.Join(hooksDir, "pre-commit")
+	if err := installPreCommitHook(hookPath, self, force); err != nil {
+		return err
+	}
+	fmt.Printf("Installed pre-commit hook %s\n", hookPath)
+	return nil
+}
+
+// installPreCommitHook writes the hook, refusing to replace one that dotward
+// did not write unless force is set.
+func installPreCommitHook(hookPath, self string, force bool) error {
+	existing, err := os.ReadFile(hookPath)
+	switch {
+	case err == nil && !bytes.Contains(existing, []byte(hookMarker)) && !force:
+		return fmt.Errorf("%s already exists; add \"%s git pre-commit\" to it or pass --force to replace it", hookPath, self)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("failed to read hook %q: %w", hookPath, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(hookPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create hooks dir: %w", err)
+	}
+	hook := "#!/bin/sh\n" + hookMarker + "\nexec " + self + " git pre-commit\n"
+	if err := os.WriteFile(hookPath, []byte(hook), 0o755); err != nil {
+		return fmt.Errorf("failed to write hook %q: %w", hookPath, err)
+	}
+	return nil
+}
+
+// dotwardCommand returns how git should invoke this binary: "dotward" when
+// it is on PATH, otherwise the absolute path of the running executable.
+func dotwardCommand() (string, error) {
+	if _, err := exec.LookPath("dotward"); err == nil {
+		return "dotward", nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the dotward executable: %w", err)
+	}
+	if strings.ContainsAny(exe, " '\"\\$") {
+		return "'" + strings.ReplaceAll(exe, "'", `'\''`) + "'", nil
+	}
+	return exe, nil
+}
+
+// ensureLine appends line to the file at path unless it is already there.
+func ensureLine(path, line string) (bool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("failed to read %q: %w", path, err)
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(l) == line {
+			return false, nil
+		}
+	}
+	if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+		b = append(b, '\n')
+	}
+	b = append(b, line+"\n"...)
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		return false, fmt.Errorf("failed to write %q: %w", path, err)
+	}
+	return true, nil
+}
+
+// textconv decrypts an encrypted file for git diff. It never fails: git
+// shows whatever it prints, so problems are reported as a comment line.
+func textconv(encPath string, mask bool) string {
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		return fmt.Sprintf("# dotward: failed to resolve config: %v\n", err)
+	}
+	h, _ := cryptopkg.Inspect(encPath)
+	if len(h.Metadata.TOTP) > 0 {
+		return "# dotward: this file needs a TOTP code; use dotward cat to read it\n"
+	}
+	read := passwordReader(cfg.Settings, fmt.Sprintf("Decrypting %s for git diff", encPath), "", readTTYPassword)
+	pw, err := profilePassword(cfg.Settings, h.Metadata.Profile, "Dotward password (git diff): ", read)
+	if err != nil {
+		return fmt.Sprintf("# dotward: cannot decrypt without a password: %v\n", err)
+	}
+	defer zeroBytes(pw)
+	absPath, _, err := resolveUnlockPaths(encPath)
+	if err != nil {
+		return fmt.Sprintf("# dotward: %v\n", err)
+	}
+	payload, err := os.ReadFile(encPath)
+	if err != nil {
+		return fmt.Sprintf("# dotward: failed to read %s: %v\n", encPath, err)
+	}
+	gate := newUnlockGate(cfg.SockPath)
+	defer gate.close()
+	plaintext, err := gate.attempt(encPath, absPath, sidecarFingerprint(payload), func() ([]byte, error) {
+		return cryptopkg.DecryptBytes(payload, pw)
+	})
+	if err != nil {
+		return fmt.Sprintf("# dotward: %v\n", err)
+	}
+	defer zeroBytes(plaintext)
+	var key []byte
+	if mask {
+		if key, err = loadMaskKey(cfg.MaskKeyPath); err != nil {
+			return fmt.Sprintf("# dotward: %v\n", err)
+		}
+		defer zeroBytes(key)
+	}
+	return renderTextconv(plaintext, key)
+}
+
+// maskKeySize is the length of the key masked diffs hash values with.
+const maskKeySize = 32
+
+// loadMaskKey returns the key masked diffs hash values with, creating it
+// the first time. A value's hash cannot be brute forced from diff output,
+// such as CI logs, without this key.
+func loadMaskKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil && len(key) == maskKeySize {
+		return key, nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read mask key %q: %w", path, err)
+	}
+	if err == nil {
+		return nil, fmt.Errorf("mask key %q is not %d bytes; delete it to create a new one", path, maskKeySize)
+	}
+
+	key = make([]byte, maskKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to create mask key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create mask key %q: %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		// Another diff created it first.
+		return loadMaskKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mask key %q: %w", path, err)
+	}
+	if _, err := f.Write(key); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("failed to write mask key %q: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("failed to write mask key %q: %w", path, err)
+	}
+	return key, nil
+}
+
+// renderTextconv prints one KEY=value line per entry so that diffs are per
+// key. Comments and blank lines are kept. With a mask key, values are
+// replaced by a short HMAC under that key, which still shows which values
+// changed.
+func renderTextconv(plaintext, maskKey []byte) string {
+	lines, err := dotenv.Parse(plaintext)
+	if err != nil {
+		if maskKey != nil {
+			return fmt.Sprintf("# dotward: not a .env file (%v), %d bytes\n", err, len(plaintext))
+		}
+		return string(plaintext)
+	}
+	var b strings.Builder
+	for _, l := range lines {
+		if !l.IsEntry() {
+			b.WriteString(l.Raw + "\n")
+			continue
+		}
+		b.WriteString(l.Key + "=" + diffValue(l.Value, maskKey) + "\n")
+	}
+	return b.String()
+}
+
+func diffValue(v string, maskKey []byte) string {
+	if maskKey != nil {
+		if v == "" {
+			return ""
+		}
+		mac := hmac.New(sha256.New, maskKey)
+		mac.Write([]byte(v))
+		return "<masked hmac:" + hex.EncodeToString(mac.Sum(nil)[:4]) + ">"
+	}
+	if strings.ContainsAny(v, "\n\r\t\"'#") || strings.TrimSpace(v) != v {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// readTTYPassword prompts on the controlling terminal, which works even when
+// git has redirected stdin and stdout.
+func readTTYPassword(prompt string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open terminal: %w", err)
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, prompt)
+	pw, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password: %w", err)
+	}
+	if len(bytes.TrimSpace(pw)) == 0 {
+		return nil, errors.New("password cannot be empty")
+	}
+	return pw, nil
+}
+
+// gitPreCommit blocks the commit when plaintext secrets are staged, a staged
+// sidecar is corrupt, or the repository has .env files that Dotward does not
+// protect. Files stored through the dotward filter are encrypted by git and
+// may be staged.
+func gitPreCommit() error {
+	root, err := gitOutput("", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	staged, err := gitOutput(root, "diff", "--cached", "--name-only", "-z", "--diff-filter=ACMR")
+	if err != nil {
+		return err
+	}
+
+	var stagedPaths []string
+	for _, rel := range strings.Split(staged, "\x00") {
+		if rel != "" {
+			stagedPaths = append(stagedPaths, filepath.Join(root, filepath.FromSlash(rel)))
+		}
+	}
+	filtered := map[string]bool{}
+	if len(stagedPaths) > 0 {
+		if filtered, err = scan.GitFiltered(root, stagedPaths); err != nil {
+			return err
+		}
+	}
+
+	var problems []string
+	for _, absPath := range stagedPaths {
+		rel := filepath.ToSlash(strings.TrimPrefix(absPath, root+string(filepath.Separator)))
+		if filtered[absPath] {
+			// The clean filter stores these encrypted, unless the filter is
+			// not configured in this clone.
+			blob, err := gitRun(root, "show", ":"+rel)
+			if err != nil {
+				return err
+			}
+			if !isEncrypted(blob) {
+				problems = append(problems, fmt.Sprintf("%s: staged without encryption; run dotward git setup --filter and stage it again", rel))
+			}
+			continue
+		}
+		if strings.HasSuffix(rel, ".enc") {
+			blob, err := gitRun(root, "show", ":"+rel)
+			if err != nil {
+				return err
+			}
+			if _, err := cryptopkg.InspectBytes(blob); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: staged sidecar is corrupt: %v", rel, err))
+			}
+			continue
+		}
+		if scan.IsSecretName(path.Base(rel)) || fileExists(filepath.Join(root, filepath.FromSlash(rel)+".enc")) {
+			problems = append(problems, fmt.Sprintf("%s: plaintext secret is staged; run git rm --cached %s", rel, rel))
+		}
+	}
+
+	report, err := scan.Scan(root, scanOptions())
+	if err != nil {
+		return err
+	}
+	for _, f := range report.Findings {
+		switch f.Kind {
+		case scan.KindUnencrypted, scan.KindUnregistered:
+			problems = append(problems, fmt.Sprintf("%s: %s", f.Path, f.Message))
+		default:
+			fmt.Fprintf(os.Stderr, "dotward: warning: %s: %s\n", f.Path, f.Message)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "dotward: %s\n", p)
+	}
+	return &exitError{code: 1, err: fmt.Errorf("commit blocked by %d problem(s); use git commit --no-verify to bypass", len(problems))}
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// gitOutput runs git in dir (or the working directory when dir is empty)
+// and returns its output with the trailing newline removed.
+func gitOutput(dir string, args ...string) (string, error) {
+	out, err := gitRun(dir, args...)
+	return strings.TrimSuffix(string(out), "\n"), err
+}
+
+// gitRun runs git in dir and returns its raw output.
+func gitRun(dir string, args ...string) ([]byte, error) {
+	sub := args[0]
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("git %s failed: %s", sub, msg)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stefanos/dotward/internal/core"
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/ipc"
+)
+
+// filterSaltKey is the git config key holding the repository's filter salt.
+const filterSaltKey = "dotward.filter.salt"
+
+// filterPassword reads the password for the git filters; tests may replace it.
+var filterPassword = readTTYPassword
+
+var gitFilterCleanCmd = &cobra.Command{
+	Use:    "filter-clean <path>",
+	Short:  "Git clean filter: encrypt plaintext from stdin for the repository",
+	Args:   cobra.ExactArgs(1),
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return filterClean(args[0], os.Stdin, os.Stdout)
+	},
+}
+
+var gitFilterSmudgeCmd = &cobra.Command{
+	Use:    "filter-smudge <path>",
+	Short:  "Git smudge filter: decrypt stdin into the working tree",
+	Args:   cobra.ExactArgs(1),
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return filterSmudge(args[0], os.Stdin, os.Stdout)
+	},
+}
+
+func init() {
+	gitCmd.AddCommand(gitFilterCleanCmd, gitFilterSmudgeCmd)
+}
+
+// filterClean encrypts the plaintext git is about to store. The salt and
+// profile of the blob already in the index are reused, so unchanged
+// plaintext gives the same blob in every clone. Input that is already
+// encrypted is passed through, which happens when the smudge filter could
+// not decrypt it.
+func filterClean(path string, in io.Reader, out io.Writer) error {
+	plaintext, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("failed to read %s from git: %w", path, err)
+	}
+	defer zeroBytes(plaintext)
+	if isEncrypted(plaintext) {
+		_, err := out.Write(plaintext)
+		return err
+	}
+
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		return fmt.Errorf("failed to resolve config: %w", err)
+	}
+	salt, meta, err := filterHeader(cfg.Settings, path)
+	if err != nil {
+		return err
+	}
+	read := passwordReader(cfg.Settings, fmt.Sprintf("Encrypting %s for git", path), "", filterPassword)
+	pw, err := profilePassword(cfg.Settings, meta.Profile, fmt.Sprintf("Dotward password (encrypting %s): ", path), read)
+	if err != nil {
+		return fmt.Errorf("refusing to store %s unencrypted: %w", path, err)
+	}
+	defer zeroBytes(pw)
+
+	payload, err := cryptopkg.EncryptDeterministic(plaintext, pw, salt, meta)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", path, err)
+	}
+	_, err = out.Write(payload)
+	return err
+}
+
+// filterSmudge decrypts a blob into the working tree and registers the file
+// with the daemon, which deletes it when its TTL runs out. When decryption
+// is not possible the ciphertext is checked out unchanged so that clones and
+// checkouts without a password still succeed.
+func filterSmudge(path string, in io.Reader, out io.Writer) error {
+	payload, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("failed to read %s from git: %w", path, err)
+	}
+	if !isEncrypted(payload) {
+		_, err := out.Write(payload)
+		return err
+	}
+
+	h, _ := cryptopkg.InspectBytes(payload)
+	cfg, err := core.ResolveConfig()
+	var pw []byte
+	if err == nil {
+		read := passwordReader(cfg.Settings, fmt.Sprintf("Decrypting %s from git", path), "", filterPassword)
+		pw, err = profilePassword(cfg.Settings, h.Metadata.Profile, fmt.Sprintf("Dotward password (decrypting %s): ", path), read)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dotward: leaving %s encrypted: %v\n", path, err)
+		_, err := out.Write(payload)
+		return err
+	}
+	defer zeroBytes(pw)
+	plaintext, err := decryptFiltered(cfg, path, payload, pw)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dotward: leaving %s encrypted: %v\n", path, err)
+		_, err := out.Write(payload)
+		return err
+	}
+	defer zeroBytes(plaintext)
+	if _, err := out.Write(plaintext); err != nil {
+		return err
+	}
+
+	if err := registerFiltered(cfg, path, h.Metadata.Profile); err != nil {
+		fmt.Fprintf(os.Stderr, "dotward: warning: %s is unlocked but not watched: %v\n", path, err)
+	}
+	return nil
+}
+
+// isEncrypted reports whether b is an encrypted file with a header.
+// Headerless legacy files cannot be told apart from plaintext.
+func isEncrypted(b []byte) bool {
+	h, err := cryptopkg.InspectBytes(b)
+	return err == nil && !h.Legacy
+}
+
+// filterHeader returns the salt and metadata of the blob staged for path.
+// A new file gets the repository salt written by dotward git setup --filter
+// and the profile whose roots contain it.
+func filterHeader(settings core.Settings, path string) ([]byte, cryptopkg.Metadata, error) {
+	if blob, err := gitRun("", "cat-file", "blob", ":"+path); err == nil {
+		if h, err := cryptopkg.InspectBytes(blob); err == nil && !h.Legacy && !h.Session {
+			return h.Salt, h.Metadata, nil
+		}
+	}
+	v, err := gitOutput("", "config", "--get", filterSaltKey)
+	if err != nil || v == "" {
+		return nil, cryptopkg.Metadata{}, fmt.Errorf("%s is not set; run dotward git setup --filter", filterSaltKey)
+	}
+	salt, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, cryptopkg.Metadata{}, fmt.Errorf("invalid %s: %w", filterSaltKey, err)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, cryptopkg.Metadata{}, err
+	}
+	return salt, cryptopkg.Metadata{Profile: settings.ProfileForPath(absPath)}, nil
+}
+
+// decryptFiltered decrypts the blob of path, counting a wrong password as a
+// failed unlock.
+func decryptFiltered(cfg core.Config, path string, payload, pw []byte) ([]byte, error) {
+	absPath, _, err := resolveUnlockPaths(path)
+	if err != nil {
+		return nil, err
+	}
+	gate := newUnlockGate(cfg.SockPath)
+	defer gate.close()
+	return gate.attempt(path, absPath, sidecarFingerprint(payload), func() ([]byte, error) {
+		return cryptopkg.DecryptBytes(payload, pw)
+	})
+}
+
+// registerFiltered asks a running daemon to watch a smudged file. Git runs
+// filters from the top of the work tree with path relative to it.
+func registerFiltered(cfg core.Config, path, profile string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	resp, err := ipc.Call(ctx, cfg.SockPath, "Manager.Register", ipc.Request{Path: absPath, Profile: profile})
+	if err != nil {
+		return errors.New("Dotward.app is not running")
+	}
+	if !resp.Success {
+		return fmt.Errorf("daemon rejected register: %s", resp.Error)
+	}
+	return nil
+}
+
+// setupFilter configures the dotward clean/smudge filter in the repository
+// at root, creates its salt, and routes patterns through it.
+func setupFilter(root, self string, patterns []string) error {
+	for key, value := range map[string]string{
+		"filter.dotward.clean":    self + " git filter-clean %f",
+		"filter.dotward.smudge":   self + " git filter-smudge %f",
+		"filter.dotward.required": "true",
+	} {
+		if _, err := gitOutput(root, "config", key, value); err != nil {
+			return err
+		}
+	}
+	if v, _ := gitOutput(root, "config", "--get", filterSaltKey); v == "" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("failed to generate filter salt: %w", err)
+		}
+		if _, err := gitOutput(root, "config", filterSaltKey, hex.EncodeToString(salt)); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Configured clean/smudge filter \"dotward\"")
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		line := pattern + " filter=dotward diff=dotward merge=dotward"
+		added, err := ensureLine(filepath.Join(root, ".gitattributes"), line)
+		if err != nil {
+			return err
+		}
+		if added {
+			fmt.Printf("Added %q to .gitattributes\n", line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stefanos/dotward/internal/core"
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/dotenv"
+)
+
+// mergePassword reads the password for the merge driver; tests may replace it.
+var mergePassword = readTTYPassword
+
+var gitMergeDriverCmd = &cobra.Command{
+	Use:   "merge-driver <base> <ours> <theirs> [path]",
+	Short: "Git merge driver: merge encrypted .env files key by key",
+	Long: `Git merge driver: merge encrypted .env files key by key.
+
+git runs it as "dotward git merge-driver %O %A %B %P". The three versions
+are decrypted in memory and merged per key; only keys changed differently on
+both sides get conflict markers. The result is encrypted again and written
+over <ours>, so plaintext never reaches the disk. Exit status is 1 when
+conflicts remain.`,
+	Args:   cobra.RangeArgs(3, 4),
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		name := args[1]
+		if len(args) == 4 {
+			name = args[3]
+		}
+		conflicts, err := gitMergeDriver(args[0], args[1], args[2], name)
+		if err != nil {
+			return err
+		}
+		if len(conflicts) > 0 {
+			return &exitError{code: 1, err: fmt.Errorf("%s: %d conflicting key(s): %v", name, len(conflicts), conflicts)}
+		}
+		return nil
+	},
+}
+
+func init() {
+	gitCmd.AddCommand(gitMergeDriverCmd)
+}
+
+// gitMergeDriver merges the encrypted files at basePath, oursPath and
+// theirsPath and writes the encrypted result to oursPath. It returns the
+// conflicting keys; a file that is not a .env file conflicts as a whole,
+// reported as the single key "*".
+func gitMergeDriver(basePath, oursPath, theirsPath, name string) ([]string, error) {
+	var payloads [3][]byte
+	for i, p := range []string{basePath, oursPath, theirsPath} {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", p, err)
+		}
+		payloads[i] = b
+	}
+
+	// All three versions share the profile of ours.
+	h, _ := cryptopkg.InspectBytes(payloads[1])
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve config: %w", err)
+	}
+	read := passwordReader(cfg.Settings, fmt.Sprintf("Merging %s", name), "", mergePassword)
+	pw, err := profilePassword(cfg.Settings, h.Metadata.Profile, fmt.Sprintf("Dotward password (merging %s): ", name), read)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(pw)
+	sess := cryptopkg.NewSession(pw)
+	defer sess.Close()
+	absPath, _, err := resolveUnlockPaths(name)
+	if err != nil {
+		return nil, err
+	}
+	gate := newUnlockGate(cfg.SockPath)
+	defer gate.close()
+
+	var plaintexts [3][]byte
+	for i, payload := range payloads {
+		// A file added on both branches has an empty base.
+		if len(payload) == 0 {
+			continue
+		}
+		plaintext, err := gate.attempt(name, absPath, sidecarFingerprint(payload), func() ([]byte, error) {
+			return sess.DecryptBytes(payload)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s (%s): %w", name, []string{"base", "ours", "theirs"}[i], err)
+		}
+		defer zeroBytes(plaintext)
+		plaintexts[i] = plaintext
+	}
+
+	merged, conflicts, err := dotenv.Merge(plaintexts[0], plaintexts[1], plaintexts[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dotward: cannot merge %s key by key: %v\n", name, err)
+		merged, conflicts = dotenv.MergeConflict(plaintexts[1], plaintexts[2]), []string{"*"}
+	}
+	defer zeroBytes(merged)
+
+	// Keep the salt and profile of ours so that, in filter mode, the clean
+	// filter produces the same blob for the merged plaintext, and its TOTP
+	// secret so that the file stays enrolled.
+	if len(h.Metadata.TOTP) > 0 {
+		if h.Metadata.TOTPSecret, err = sess.TOTPSecret(payloads[1]); err != nil {
+			return nil, fmt.Errorf("failed to keep the TOTP secret of %s: %w", name, err)
+		}
+		defer zeroBytes(h.Metadata.TOTPSecret)
+	}
+	var out []byte
+	if h.Version > 0 && !h.Session {
+		out, err = cryptopkg.EncryptDeterministic(merged, pw, h.Salt, h.Metadata)
+	} else {
+		out, err = sess.EncryptBytes(merged, h.Metadata)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt merged %s: %w", name, err)
+	}
+	if err := os.WriteFile(oursPath, out, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write %q: %w", oursPath, err)
+	}
+	return conflicts, nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/stefanos/dotward/internal/core"
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/manifest"
+	"github.com/stefanos/dotward/internal/scan"
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init [dir]",
+	Short: "Encrypt a project's .env files, write its manifest and update .gitignore",
+	Long: `Encrypt a project's .env files, write its manifest and update .gitignore.
+
+init finds .env and .env.* files below dir (default: the working directory),
+skipping templates such as .env.example. Each file without a sidecar is
+encrypted to <file>.enc, and the plaintext is securely deleted once the
+sidecar decrypts back to the same bytes. The files are listed in a new
+.dotward.yaml manifest and their plaintext names are added to .gitignore.
+
+Files that already have a .enc sidecar are left as they are.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+		yes, err := cmd.Flags().GetBool("yes")
+		if err != nil {
+			return err
+		}
+		return initProject(dir, dryRun, yes)
+	},
+}
+
+func init() {
+	initCmd.Flags().Bool("dry-run", false, "show what init would do without changing anything")
+	initCmd.Flags().BoolP("yes", "y", false, "do not ask for confirmation")
+	rootCmd.AddCommand(initCmd)
+}
+
+// initPlan is what init is about to do.
+type initPlan struct {
+	Root  string
+	Files []scan.SecretFile
+	// ManifestPath is the existing manifest in Root, or the one to create.
+	ManifestPath string
+	NewManifest  bool
+	// Uncovered lists files an existing manifest does not select.
+	Uncovered []string
+	// Gitignore lists the entries missing from Root/.gitignore.
+	Gitignore []string
+}
+
+// toEncrypt returns the files that still need a sidecar.
+func (p initPlan) toEncrypt() []string {
+	var out []string
+	for _, f := range p.Files {
+		if f.HasPlain && !f.HasEnc {
+			out = append(out, f.Rel)
+		}
+	}
+	return out
+}
+
+func initProject(dir string, dryRun, yes bool) error {
+	plan, err := planInit(dir)
+	if err != nil {
+		return err
+	}
+	if len(plan.Files) == 0 {
+		fmt.Printf("No .env files found in %s\n", plan.Root)
+		return nil
+	}
+	encrypt := plan.toEncrypt()
+	if len(encrypt) == 0 && !plan.NewManifest && len(plan.Gitignore) == 0 {
+		printUncovered(plan)
+		fmt.Println("Nothing to do: every file is already encrypted and ignored.")
+		return nil
+	}
+
+	printInitPlan(plan, encrypt)
+	if dryRun {
+		return nil
+	}
+	if !yes {
+		ok, err := confirm("Proceed? [y/N] ")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("init cancelled")
+		}
+	}
+
+	failedFiles := make(map[string]bool)
+	var verified []string
+	if len(encrypt) > 0 {
+		cfg, err := core.ResolveConfig()
+		if err != nil {
+			return fmt.Errorf("failed to resolve config: %w", err)
+		}
+		absPaths := make([]string, 0, len(encrypt))
+		rels := make(map[string]string, len(encrypt))
+		for _, rel := range encrypt {
+			absPath := filepath.Join(plan.Root, filepath.FromSlash(rel))
+			absPaths = append(absPaths, absPath)
+			rels[absPath] = rel
+			failedFiles[rel] = true
+		}
+		results, err := withProfileSessions(cfg.Settings, absPaths, nil, true, "Encrypting", func(_ int, absPath, profile string, sess *cryptopkg.Session) (string, error) {
+			if err := encryptAndVerify(absPath, sess, profile); err != nil {
+				return "", err
+			}
+			rel := rels[absPath]
+			return fmt.Sprintf("Encrypted %s -> %s.enc", rel, rel), nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			if r.err == nil {
+				delete(failedFiles, rels[r.file])
+				verified = append(verified, r.file)
+			}
+		}
+		printResults(results)
+	}
+
+	if plan.NewManifest {
+		m := &manifest.Manifest{}
+		for _, f := range plan.Files {
+			if !failedFiles[f.Rel] {
+				m.Files = append(m.Files, manifest.File{Path: f.Rel})
+			}
+		}
+		if len(m.Files) > 0 {
+			if err := manifest.Save(plan.ManifestPath, m); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote manifest %s\n", plan.ManifestPath)
+		}
+	} else {
+		printUncovered(plan)
+	}
+
+	if len(plan.Gitignore) > 0 {
+		if err := appendGitignore(filepath.Join(plan.Root, ".gitignore"), plan.Gitignore); err != nil {
+			return err
+		}
+		fmt.Printf("Added %d entr%s to .gitignore\n", len(plan.Gitignore), pluralY(len(plan.Gitignore)))
+	}
+
+	failed := len(failedFiles)
+	for _, absPath := range verified {
+		if err := core.SecureDelete(absPath); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "FAILED to delete plaintext %s: %v\n", absPath, err)
+			continue
+		}
+		fmt.Printf("Securely deleted %s\n", absPath)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("init completed with %d failure(s)", failed)
+	}
+	return nil
+}
+
+// planInit looks at dir and works out what init needs to do.
+func planInit(dir string) (initPlan, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return initPlan{}, fmt.Errorf("failed to resolve directory %q: %w", dir, err)
+	}
+	files, err := scan.FindSecretFiles(root)
+	if err != nil {
+		return initPlan{}, err
+	}
+	plan := initPlan{Root: root, Files: files}
+
+	plan.ManifestPath, plan.NewManifest, err = rootManifest(root)
+	if err != nil {
+		return initPlan{}, err
+	}
+	if !plan.NewManifest {
+		m, err := manifest.Load(plan.ManifestPath)
+		if err != nil {
+			return initPlan{}, err
+		}
+		entries, err := m.Entries()
+		if err != nil {
+			return initPlan{}, err
+		}
+		selected := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			selected[e.Rel] = true
+		}
+		for _, f := range files {
+			if !selected[f.Rel] {
+				plan.Uncovered = append(plan.Uncovered, f.Rel)
+			}
+		}
+	}
+
+	rels := make([]string, 0, len(files))
+	for _, f := range files {
+		rels = append(rels, f.Rel)
+	}
+	plan.Gitignore, err = missingGitignoreEntries(filepath.Join(root, ".gitignore"), rels)
+	if err != nil {
+		return initPlan{}, err
+	}
+	return plan, nil
+}
+
+// rootManifest returns the manifest in root, or the path of a new
+// .dotward.yaml when there is none.
+func rootManifest(root string) (string, bool, error) {
+	for _, name := range manifest.Names {
+		p := filepath.Join(root, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, false, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", false, fmt.Errorf("failed to stat manifest %q: %w", p, err)
+		}
+	}
+	return filepath.Join(root, ".dotward.yaml"), true, nil
+}
+
+// missingGitignoreEntries returns the anchored .gitignore entries for rels
+// that the file at path does not already contain.
+func missingGitignoreEntries(path string, rels []string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %q: %w", path, err)
+	}
+	present := make(map[string]bool)
+	for _, line := range strings.Split(string(b), "\n") {
+		present[strings.TrimSpace(line)] = true
+	}
+	var out []string
+	for _, rel := range rels {
+		entry := "/" + rel
+		// An unanchored entry for a top-level file already covers it.
+		if present[entry] || (!strings.Contains(rel, "/") && present[rel]) {
+			continue
+		}
+		present[entry] = true
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+const gitignoreHeader = "# Dotward: plaintext secrets (commit the .enc sidecars instead)"
+
+// appendGitignore adds entries to the .gitignore at path, creating it when
+// needed.
+func appendGitignore(path string, entries []string) error {
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read %q: %w", path, err)
+	}
+	var buf bytes.Buffer
+	buf.Write(b)
+	if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	if !bytes.Contains(b, []byte(gitignoreHeader)) {
+		if len(b) > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(gitignoreHeader + "\n")
+	}
+	for _, e := range entries {
+		buf.WriteString(e + "\n")
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %q: %w", path, err)
+	}
+	return nil
+}
+
+// encryptAndVerify writes absPath.enc for profile and checks that it
+// decrypts back to the current plaintext. A sidecar that fails the check is
+// removed.
+func encryptAndVerify(absPath string, sess *cryptopkg.Session, profile string) error {
+	encPath := absPath + ".enc"
+	if err := sess.EncryptFile(absPath, encPath, cryptopkg.Metadata{Profile: profile}); err != nil {
+		return fmt.Errorf("failed to encrypt %q: %w", absPath, err)
+	}
+	plaintext, err := os.ReadFile(absPath)
+	if err != nil {
+		_ = os.Remove(encPath)
+		return fmt.Errorf("failed to read plaintext file %q: %w", absPath, err)
+	}
+	defer zeroBytes(plaintext)
+	decrypted, err := sess.Decrypt(encPath)
+	if err != nil {
+		_ = os.Remove(encPath)
+		return fmt.Errorf("failed to verify %q: %w", encPath, err)
+	}
+	defer zeroBytes(decrypted)
+	if !bytes.Equal(plaintext, decrypted) {
+		_ = os.Remove(encPath)
+		return fmt.Errorf("failed to verify %q: decrypted content does not match the plaintext", encPath)
+	}
+	return nil
+}
+
+func printInitPlan(plan initPlan, encrypt []string) {
+	fmt.Printf("Plan for %s:\n", plan.Root)
+	for _, rel := range encrypt {
+		fmt.Printf("  encrypt %s -> %s.enc, then securely delete the plaintext\n", rel, rel)
+	}
+	for _, f := range plan.Files {
+		if f.HasEnc {
+			fmt.Printf("  keep    %s (already encrypted)\n", f.Rel)
+		}
+	}
+	if plan.NewManifest {
+		fmt.Printf("  write   %s listing %d file(s)\n", plan.ManifestPath, len(plan.Files))
+	}
+	for _, e := range plan.Gitignore {
+		fmt.Printf("  ignore  %s\n", e)
+	}
+}
+
+func printUncovered(plan initPlan) {
+	if len(plan.Uncovered) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "warning: %s does not select these files; add them to it:\n", plan.ManifestPath)
+	for _, rel := range plan.Uncovered {
+		fmt.Fprintf(os.Stderr, "  %s\n", rel)
+	}
+}
+
+func pluralY(n int) string {
+	if n == 1 {
+		return "y"
+	}
+	return "ies"
+}
+
+// confirm asks a yes/no question on the terminal. It reads stdin one byte at
+// a time so that a password prompt that follows still sees its input.
+func confirm(prompt string) (bool, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, errors.New("stdin is not a terminal; pass --yes to run without confirmation")
+	}
+	fmt.Print(prompt)
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read confirmation: %w", err)
+		}
+	}
+	answer := strings.ToLower(strings.TrimSpace(string(line)))
+	return answer == "y" || answer == "yes", nil
+}
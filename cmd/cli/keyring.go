@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stefanos/dotward/internal/core"
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/keyring"
+)
+
+var keyringCmd = &cobra.Command{
+	Use:   "keyring",
+	Short: "Manage the keys cached in the kernel keyring",
+	Long:  "Manage the keys cached in the kernel keyring.\n\nWith keyring_ttl set, derived keys are kept in the Linux session keyring, so that later commands of the login session skip the password prompt and key derivation.",
+}
+
+var keyringClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove every cached key from the kernel keyring",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		if err := keyring.Clear(); err != nil {
+			return fmt.Errorf("failed to clear cached keys: %w", err)
+		}
+		fmt.Println("Cleared cached keys.")
+		return nil
+	},
+}
+
+func init() {
+	keyringCmd.AddCommand(keyringClearCmd)
+	rootCmd.AddCommand(keyringCmd)
+}
+
+// keyringName names the cached master key for a master key salt.
+func keyringName(salt []byte) string {
+	return "master:" + hex.EncodeToString(salt)
+}
+
+// profileSession returns a crypto session for files of profile. It holds the
+// master keys of their sidecars cached in the kernel keyring, and the
+// password is only asked for when a file needs a key that is not cached.
+// A newPassword, which encrypts files, must meet min_password_score.
+func profileSession(settings core.Settings, profile string, files []string, newPassword bool, prompt string, read func(string) ([]byte, error)) (*cryptopkg.Session, error) {
+	keys, complete := cachedKeys(settings, files)
+	defer func() {
+		for _, key := range keys {
+			zeroBytes(key)
+		}
+	}()
+	var pw []byte
+	if !complete {
+		var err error
+		if pw, err = profilePassword(settings, profile, prompt, read); err != nil {
+			return nil, err
+		}
+		if newPassword {
+			if err := checkPasswordStrength(settings, profile, files, pw); err != nil {
+				zeroBytes(pw)
+				return nil, err
+			}
+		}
+	}
+	sess := cryptopkg.NewSession(pw)
+	zeroBytes(pw)
+	for salt, key := range keys {
+		sess.AddMasterKey([]byte(salt), key)
+	}
+	return sess, nil
+}
+
+// cachedKeys returns the cached master keys of the sidecars of files, keyed
+// by salt, and whether every file has one. Files without a sidecar, or with
+// one written before master keys, have none.
+func cachedKeys(settings core.Settings, files []string) (map[string][]byte, bool) {
+	if settings.KeyringTTL <= 0 || len(files) == 0 {
+		return nil, false
+	}
+	keys := make(map[string][]byte)
+	complete := true
+	for _, file := range files {
+		_, encPath, err := resolveUnlockPaths(file)
+		if err != nil {
+			complete = false
+			continue
+		}
+		h, err := cryptopkg.Inspect(encPath)
+		if err != nil || !h.Session {
+			complete = false
+			continue
+		}
+		if _, ok := keys[string(h.Salt)]; ok {
+			continue
+		}
+		key, err := keyring.Get(keyringName(h.Salt))
+		if err != nil {
+			complete = false
+			continue
+		}
+		keys[string(h.Salt)] = key
+	}
+	return keys, complete
+}
+
+// cacheDerivedKeys stores the master keys sess derived from its password in
+// the kernel keyring for keyring_ttl. Where there is no keyring, nothing is
+// cached and the password is asked for again next time.
+func cacheDerivedKeys(settings core.Settings, sess *cryptopkg.Session) {
+	if settings.KeyringTTL <= 0 {
+		return
+	}
+	var failed error
+	sess.DerivedKeys(func(salt, key []byte) {
+		if err := keyring.Put(keyringName(salt), key, settings.KeyringTTL); err != nil && failed == nil {
+			failed = err
+		}
+	})
+	if failed != nil && !errors.Is(failed, keyring.ErrUnavailable) {
+		fmt.Fprintf(os.Stderr, "warning: failed to cache keys in the kernel keyring (%v)\n", failed)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stefanos/dotward/internal/core"
+	"github.com/stefanos/dotward/internal/ipc"
+)
+
+var lockoutCmd = &cobra.Command{
+	Use:   "lockout",
+	Short: "Show and clear failed unlock attempts",
+	Long:  "Show and clear failed unlock attempts.\n\nDotward.app counts wrong passwords and TOTP codes per encrypted file, and copies of a sidecar share its count. After each failure the next attempt has to wait, twice as long each time, and with limits.lockout_after set the file is locked out until the lockout is cleared.",
+}
+
+var lockoutListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List files with failed unlock attempts",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return lockoutList()
+	},
+}
+
+var lockoutClearAll bool
+
+var lockoutClearCmd = &cobra.Command{
+	Use:   "clear [files...]",
+	Short: "Forget the failed unlock attempts of files and lift their lockout",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && !lockoutClearAll {
+			return usageError("name the files to clear, or pass --all")
+		}
+		if len(args) > 0 && lockoutClearAll {
+			return usageError("--all takes no files")
+		}
+		cmd.SilenceUsage = true
+		return lockoutClear(args)
+	},
+}
+
+func init() {
+	lockoutClearCmd.Flags().BoolVar(&lockoutClearAll, "all", false, "clear every file")
+	lockoutCmd.AddCommand(lockoutListCmd, lockoutClearCmd)
+	rootCmd.AddCommand(lockoutCmd)
+}
+
+func lockoutList() error {
+	resp, err := callLockout("Manager.ListFailures", ipc.Request{})
+	if err != nil {
+		return err
+	}
+	if len(resp.Failures) == 0 {
+		fmt.Println("No failed unlock attempts.")
+		return nil
+	}
+	now := time.Now()
+	for _, f := range resp.Failures {
+		status := "unlock allowed"
+		switch {
+		case f.LockedOut:
+			status = "locked out"
+		case now.Before(f.RetryAt):
+			status = fmt.Sprintf("retry in %s", f.RetryAt.Sub(now).Round(time.Second))
+		}
+		fmt.Printf("%s: %d failed attempt(s), last %s, %s\n", f.Path, f.Failures, f.LastFailure.Local().Format(time.DateTime), status)
+	}
+	return nil
+}
+
+// lockoutClear clears the failed attempts of files, or of every file when
+// there are none. A file is matched by its sidecar, wherever the failed
+// attempts were made, and by the path they were last made at.
+func lockoutClear(files []string) error {
+	reqs := []ipc.Request{{}}
+	if len(files) > 0 {
+		reqs = reqs[:0]
+		for _, file := range files {
+			absPath, encPath, err := resolveUnlockPaths(file)
+			if err != nil {
+				return err
+			}
+			req := ipc.Request{Path: absPath}
+			if payload, err := os.ReadFile(encPath); err == nil {
+				req.Sidecar = sidecarFingerprint(payload)
+			}
+			reqs = append(reqs, req)
+		}
+	}
+	var cleared int
+	for _, req := range reqs {
+		resp, err := callLockout("Manager.ClearLockout", req)
+		if err != nil {
+			return err
+		}
+		for _, f := range resp.Failures {
+			fmt.Printf("Cleared %s\n", f.Path)
+		}
+		cleared += len(resp.Failures)
+	}
+	if cleared == 0 {
+		fmt.Println("No failed unlock attempts to clear.")
+	}
+	return nil
+}
+
+func callLockout(method string, req ipc.Request) (ipc.Response, error) {
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		return ipc.Response{}, fmt.Errorf("failed to resolve config: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := ipc.Call(ctx, cfg.SockPath, method, req)
+	if err != nil {
+		return ipc.Response{}, errDaemonUnreachable
+	}
+	if !resp.Success {
+		return ipc.Response{}, withCode(codeDaemonRejected, fmt.Errorf("daemon rejected %s: %s", method, resp.Error))
+	}
+	return resp, nil
+}
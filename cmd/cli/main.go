@@ -22,7 +22,27 @@ import (
 	"github.com/stefanos/dotward/internal/version"
 )
 
-var permanentFlag bool
+var (
+	permanentFlag bool
+	bindPIDFlag   int
+	whileFlag     bool
+	onSleepFlag   string
+	ttlFlag       time.Duration
+)
+
+// unlockOptions controls how unlocked files are registered with the daemon.
+type unlockOptions struct {
+	Permanent bool
+	// TTL overrides the daemon's TTL policies when non-zero.
+	TTL time.Duration
+	// FileTTLs holds per-file TTLs from the project manifest, keyed by file
+	// argument. TTL takes precedence over them.
+	FileTTLs map[string]time.Duration
+	// FileProfiles holds the manifest's profile of each file argument.
+	FileProfiles map[string]string
+	PID          int
+	OnSleep      core.SleepPolicy
+}
 
 // resolveUpdateConfig is the config resolver used by update; tests may replace it.
 var resolveUpdateConfig = core.ResolveConfig
@@ -43,11 +63,46 @@ var rootCmd = &cobra.Command{
 }
 
 var unlockCmd = &cobra.Command{
-	Use:   "unlock <file> [files...]",
+	Use:   "unlock [files...] [--while -- <command> [args...]]",
 	Short: "Decrypt one or more files and register them with the daemon",
-	Args:  cobra.MinimumNArgs(1),
+	Long:  "Decrypt one or more files and register them with the daemon.\n\nWithout file arguments, every file in the project manifest (.dotward.json or .dotward.yaml, found by walking up from the working directory) is unlocked.\n\nWith --while, dotward exits with the status of the command once it ran, even one that matches a dotward exit code; dotward's own exit codes only apply when the files could not be unlocked or the command could not be started.",
+	Args:  cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return unlock(args, permanentFlag)
+		files, command, err := splitWhileArgs(args, cmd.ArgsLenAtDash(), whileFlag)
+		if err != nil {
+			return withCode(codeUsage, err)
+		}
+		onSleep, err := core.ParseSleepPolicy(onSleepFlag)
+		if err != nil {
+			return withCode(codeUsage, err)
+		}
+		if permanentFlag && (whileFlag || bindPIDFlag != 0 || onSleep != core.SleepPolicyDefault || ttlFlag != 0) {
+			return usageError("--permanent cannot be combined with --while, --bind-pid, --on-sleep or --ttl")
+		}
+		if ttlFlag < 0 {
+			return usageError("invalid --ttl %s: must be > 0", ttlFlag)
+		}
+		if whileFlag && jsonOutput() {
+			// The command owns stdout.
+			return usageError("--while cannot be combined with --output json")
+		}
+		opts := unlockOptions{Permanent: permanentFlag, TTL: ttlFlag, OnSleep: onSleep}
+		if len(files) == 0 {
+			if files, err = projectUnlockFiles(&opts); err != nil {
+				return err
+			}
+		}
+		if whileFlag {
+			if bindPIDFlag != 0 {
+				return usageError("--while cannot be combined with --bind-pid")
+			}
+			return unlockWhile(files, command, opts)
+		}
+		if bindPIDFlag < 0 {
+			return usageError("invalid --bind-pid %d", bindPIDFlag)
+		}
+		opts.PID = bindPIDFlag
+		return unlock(files, opts)
 	},
 }
 
@@ -61,24 +116,53 @@ var catCmd = &cobra.Command{
 }
 
 var updateCmd = &cobra.Command{
-	Use:   "update <file> [files...]",
+	Use:   "update [files...]",
 	Short: "Re-encrypt one or more plaintext files",
-	Args:  cobra.MinimumNArgs(1),
+	Long:  "Re-encrypt one or more plaintext files.\n\nWithout file arguments, the unlocked files of the project manifest are re-encrypted.",
+	Args:  cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		allowCreate, err := cmd.Flags().GetBool("create")
 		if err != nil {
 			return err
 		}
-		return update(args, allowCreate)
+		files := args
+		var profiles map[string]string
+		if len(files) == 0 {
+			if files, profiles, err = projectPlaintextFiles(); err != nil {
+				return err
+			}
+			if len(files) == 0 {
+				if !jsonOutput() {
+					fmt.Println("No manifest files are unlocked.")
+				}
+				return nil
+			}
+		}
+		return update(files, profiles, allowCreate)
 	},
 }
 
 var lockCmd = &cobra.Command{
-	Use:   "lock <file> [files...]",
+	Use:   "lock [files...]",
 	Short: "Encrypt one or more files and securely delete the plaintext",
-	Args:  cobra.MinimumNArgs(1),
+	Long:  "Encrypt one or more files and securely delete the plaintext.\n\nWithout file arguments, the unlocked files of the project manifest are locked.",
+	Args:  cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return lock(args)
+		files := args
+		var profiles map[string]string
+		if len(files) == 0 {
+			var err error
+			if files, profiles, err = projectPlaintextFiles(); err != nil {
+				return err
+			}
+			if len(files) == 0 {
+				if !jsonOutput() {
+					fmt.Println("No manifest files are unlocked.")
+				}
+				return nil
+			}
+		}
+		return lock(files, profiles)
 	},
 }
 
@@ -87,7 +171,11 @@ var batchLockCmd = &cobra.Command{
 	Short: "Lock multiple files listed in a paths file",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return batchLock(args[0])
+		atomic, err := cmd.Flags().GetBool("atomic")
+		if err != nil {
+			return err
+		}
+		return batchLock(args[0], atomic)
 	},
 }
 
@@ -96,7 +184,11 @@ var batchUnlockCmd = &cobra.Command{
 	Short: "Unlock multiple files listed in a paths file",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return batchUnlock(args[0])
+		atomic, err := cmd.Flags().GetBool("atomic")
+		if err != nil {
+			return err
+		}
+		return batchUnlock(args[0], atomic)
 	},
 }
 
@@ -105,157 +197,329 @@ var versionCmd = &cobra.Command{
 	Short: "Print version information",
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
+		if jsonOutput() {
+			jsonReport.Version = &versionReport{
+				Component: "dotward-cli",
+				Version:   version.Version,
+				Commit:    version.Commit,
+				Built:     version.Built(),
+				BuiltBy:   version.BuiltBy,
+			}
+			return
+		}
 		fmt.Println(version.Detailed("dotward-cli"))
 	},
 }
 
 func init() {
 	unlockCmd.Flags().BoolVar(&permanentFlag, "permanent", false, "keep file unlocked until manually locked")
+	unlockCmd.Flags().IntVar(&bindPIDFlag, "bind-pid", 0, "lock the files as soon as the process with this PID exits")
+	unlockCmd.Flags().BoolVar(&whileFlag, "while", false, "run the command after -- and lock the files when it exits")
+	unlockCmd.Flags().DurationVar(&ttlFlag, "ttl", 0, "how long the files stay unlocked (default: matching ttl_policies rule or default_ttl)")
+	unlockCmd.Flags().StringVar(&onSleepFlag, "on-sleep", "", `"lock" or "keep" the files on suspend or session lock (default: lock_on_sleep setting)`)
 	updateCmd.Flags().Bool("create", false, "create a new .enc sidecar when none exists (first-time encrypt only)")
+	batchLockCmd.Flags().Bool("atomic", false, "lock every file or none: roll back all files when one fails")
+	batchUnlockCmd.Flags().Bool("atomic", false, "unlock every file or none: roll back all files and registrations when one fails")
 	rootCmd.AddCommand(unlockCmd, catCmd, updateCmd, lockCmd, batchLockCmd, batchUnlockCmd, versionCmd)
+	rootCmd.PersistentPreRunE = checkGlobalFlags
+}
+
+// checkGlobalFlags validates the persistent flags before a command runs.
+func checkGlobalFlags(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFlag(cmd, args); err != nil {
+		return err
+	}
+	return checkPasswordFlags(cmd)
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+	markUsageErrors(rootCmd)
+	cmd, err := rootCmd.ExecuteC()
+	forgetGivenPassword()
+	if jsonOutput() && cmd != nil {
+		if werr := writeReport(os.Stdout, cmd, err); werr != nil {
+			fmt.Fprintf(os.Stderr, "dotward: failed to write report: %v\n", werr)
+		}
+	}
+	if err != nil {
+		os.Exit(exitCode(err))
 	}
 }
 
+// exitError makes the CLI exit with a specific status code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
 func cat(file string) error {
-	_, encPath, err := resolveUnlockPaths(file)
+	absPath, encPath, err := resolveUnlockPaths(file)
+	if err != nil {
+		return err
+	}
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		return fmt.Errorf("failed to resolve config: %w", err)
+	}
+	profile, err := fileProfile(cfg.Settings, absPath, encPath, "")
 	if err != nil {
 		return err
 	}
 
-	pw, err := readPassword("Password: ")
+	read := passwordReader(cfg.Settings, filesDescription("Decrypting", profile, []string{file}), "", readPassword)
+	sess, err := profileSession(cfg.Settings, profile, []string{file}, false, "Password: ", read)
 	if err != nil {
 		return err
 	}
-	defer zeroBytes(pw)
+	defer sess.Close()
 
-	plaintext, err := cryptopkg.Decrypt(encPath, pw)
+	gate := newUnlockGate(cfg.SockPath)
+	defer gate.close()
+	plaintext, _, err := gate.decrypt(file, encPath, sess)
 	if err != nil {
-		return fmt.Errorf("failed to decrypt %q: %w", encPath, err)
+		return err
 	}
 	defer zeroBytes(plaintext)
+	cacheDerivedKeys(cfg.Settings, sess)
 
+	if jsonOutput() {
+		h, err := cryptopkg.Inspect(encPath)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %q: %w", encPath, err)
+		}
+		content := string(plaintext)
+		jsonReport.Files = append(jsonReport.Files, fileReport{
+			File:          file,
+			OK:            true,
+			Path:          absPath,
+			Sidecar:       encPath,
+			Profile:       profile,
+			FormatVersion: int(h.Version),
+			Content:       &content,
+		})
+		return nil
+	}
 	_, err = os.Stdout.Write(plaintext)
 	return err
 }
 
-func unlock(files []string, permanent bool) error {
-	var cfg core.Config
-	if !permanent {
-		var err error
-		cfg, err = core.ResolveConfig()
-		if err != nil {
-			return fmt.Errorf("failed to resolve config: %w", err)
-		}
+func unlock(files []string, opts unlockOptions) error {
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		return fmt.Errorf("failed to resolve config: %w", err)
+	}
+	if !opts.Permanent {
 		if err := ensureDaemonRunning(cfg.SockPath); err != nil {
-			return errors.New("please start Dotward.app")
+			return errDaemonUnreachable
 		}
 	}
 
-	pw, err := readPassword("Password: ")
+	results, err := unlockFiles(cfg, files, opts)
 	if err != nil {
 		return err
 	}
-	defer zeroBytes(pw)
+	return reportResults("unlock", results)
+}
 
-	var failed int
-	for _, file := range files {
-		pwCopy := append([]byte(nil), pw...)
-		if unlockErr := unlockOnePath(file, pwCopy, permanent, cfg); unlockErr != nil {
-			failed++
-			fmt.Fprintf(os.Stderr, "FAILED %s: %v\n", file, unlockErr)
-			continue
+// unlockFiles decrypts files in parallel and registers them with the daemon
+// in one call unless opts.Permanent.
+func unlockFiles(cfg core.Config, files []string, opts unlockOptions) ([]fileResult, error) {
+	reqs := make([]ipc.Request, len(files))
+	gate := newUnlockGate(cfg.SockPath)
+	defer gate.close()
+	results, err := withProfileSessions(cfg.Settings, files, opts.FileProfiles, false, "Decrypting", func(i int, file, profile string, sess *cryptopkg.Session) (string, error) {
+		req, err := decryptForUnlock(file, sess, gate, profile, opts)
+		if err != nil {
+			return "", err
 		}
-		if permanent {
-			fmt.Printf("Permanently unlocked %s\n", file)
-		} else {
-			fmt.Printf("Unlocked %s for %s\n", file, cfg.DefaultTTL)
+		reqs[i] = req
+		if opts.Permanent {
+			return fmt.Sprintf("Permanently unlocked %s", file), nil
 		}
+		return "", nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	if failed > 0 {
-		return fmt.Errorf("unlock completed with %d failure(s)", failed)
+	if !opts.Permanent {
+		registerUnlocked(cfg, results, reqs, opts)
 	}
-	return nil
+	return results, nil
 }
 
-func unlockOnePath(file string, pw []byte, permanent bool, cfg core.Config) error {
-	defer zeroBytes(pw)
-
+// decryptForUnlock decrypts file next to its sidecar, once gate lets it,
+// and returns the registration to send to the daemon for it.
+func decryptForUnlock(file string, sess *cryptopkg.Session, gate *unlockGate, profile string, opts unlockOptions) (ipc.Request, error) {
 	absPath, encPath, err := resolveUnlockPaths(file)
 	if err != nil {
-		return err
+		return ipc.Request{}, err
 	}
 
-	if err := cryptopkg.DecryptFile(encPath, absPath, pw); err != nil {
-		return fmt.Errorf("failed to decrypt %q: %w", encPath, err)
+	plaintext, sidecar, err := gate.decrypt(file, encPath, sess)
+	if err != nil {
+		return ipc.Request{}, err
+	}
+	defer zeroBytes(plaintext)
+	if err := os.WriteFile(absPath, plaintext, 0o600); err != nil {
+		return ipc.Request{}, fmt.Errorf("failed to write plaintext file %q: %w", absPath, err)
 	}
 
-	if permanent {
-		return nil
+	return unlockRequest(file, absPath, profile, sidecar, opts), nil
+}
+
+// unlockRequest returns the registration to send to the daemon for file.
+func unlockRequest(file, absPath, profile, sidecar string, opts unlockOptions) ipc.Request {
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = opts.FileTTLs[file]
 	}
+	return ipc.Request{
+		Path:    absPath,
+		TTL:     ttl,
+		PID:     opts.PID,
+		OnSleep: string(opts.OnSleep),
+		Profile: profile,
+		Sidecar: sidecar,
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// registerUnlocked registers the files of results that were decrypted with
+// the daemon and records the outcome of each. Plaintext the daemon does not
+// accept is deleted again.
+func registerUnlocked(cfg core.Config, results []fileResult, reqs []ipc.Request, opts unlockOptions) {
+	var batch []ipc.Request
+	var index []int
+	for i, r := range results {
+		if r.err == nil {
+			batch = append(batch, reqs[i])
+			index = append(index, i)
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	resps, err := registerMany(cfg.SockPath, batch)
+	for k, i := range index {
+		req := reqs[i]
+		switch {
+		case err != nil:
+			_ = core.SecureDelete(req.Path)
+			results[i].err = errDaemonUnreachable
+		case !resps[k].Success:
+			_ = core.SecureDelete(req.Path)
+			results[i].err = withCode(codeDaemonRejected, fmt.Errorf("daemon rejected register: %s", resps[k].Error))
+		default:
+			ttl := appliedTTL(resps[k], req.TTL, cfg.ForProfile(req.Profile), req.Path)
+			results[i].ttl = ttl
+			if opts.PID > 0 {
+				results[i].msg = fmt.Sprintf("Unlocked %s while process %d runs (at most %s)", results[i].file, opts.PID, ttl)
+			} else {
+				results[i].msg = fmt.Sprintf("Unlocked %s for %s", results[i].file, ttl)
+			}
+		}
+	}
+}
+
+// registerMany registers reqs with the daemon in a single call. Daemons
+// without Manager.RegisterMany get one Register call per file.
+func registerMany(sockPath string, reqs []ipc.Request) ([]ipc.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	resp, err := ipc.Call(ctx, cfg.SockPath, "Manager.Register", ipc.Request{Path: absPath, TTL: cfg.DefaultTTL})
-	if err != nil {
-		_ = core.SecureDelete(absPath)
-		return errors.New("please start Dotward.app")
+	resp, err := ipc.Call(ctx, sockPath, "Manager.RegisterMany", ipc.Request{Batch: reqs})
+	if err == nil {
+		if len(resp.Results) != len(reqs) {
+			return nil, fmt.Errorf("daemon returned %d results for %d registrations", len(resp.Results), len(reqs))
+		}
+		return resp.Results, nil
 	}
-	if !resp.Success {
-		_ = core.SecureDelete(absPath)
-		return fmt.Errorf("daemon rejected register: %s", resp.Error)
+	if !strings.Contains(err.Error(), "can't find method") {
+		return nil, err
 	}
-	return nil
+
+	resps := make([]ipc.Response, len(reqs))
+	for i, req := range reqs {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		resps[i], err = ipc.Call(ctx, sockPath, "Manager.Register", req)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resps, nil
 }
 
-func update(files []string, allowCreateMissingEnc bool) error {
-	pw, err := readPassword("Password: ")
+// appliedTTL returns the TTL reported by the daemon, or the one it would have
+// picked when talking to a daemon that does not report it.
+func appliedTTL(resp ipc.Response, requested time.Duration, settings core.Settings, absPath string) time.Duration {
+	switch {
+	case resp.TTL > 0:
+		return resp.TTL
+	case requested > 0:
+		return requested
+	default:
+		return settings.ResolveTTL(absPath).TTL
+	}
+}
+
+func update(files []string, profiles map[string]string, allowCreateMissingEnc bool) error {
+	cfg, err := resolveUpdateConfig()
+	if err != nil {
+		return fmt.Errorf("failed to resolve config: %w", err)
+	}
+
+	// A file encrypted for the first time sets its password.
+	newPassword := allowCreateMissingEnc && anySidecarMissing(files)
+	gate := newUnlockGate(cfg.SockPath)
+	defer gate.close()
+	results, err := withProfileSessions(cfg.Settings, files, profiles, newPassword, "Encrypting", func(_ int, file, profile string, sess *cryptopkg.Session) (string, error) {
+		encPath, err := updateOneFile(file, sess, gate, profile, allowCreateMissingEnc)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Updated encrypted file %s", encPath), nil
+	})
 	if err != nil {
 		return err
 	}
-	defer zeroBytes(pw)
+	return reportResults("update", results)
+}
 
-	var failed int
+// anySidecarMissing reports whether one of files has no encrypted sidecar.
+func anySidecarMissing(files []string) bool {
 	for _, file := range files {
-		pwCopy := append([]byte(nil), pw...)
-		encPath, updateErr := updateOneFile(file, pwCopy, allowCreateMissingEnc)
-		if updateErr != nil {
-			failed++
-			fmt.Fprintf(os.Stderr, "FAILED %s: %v\n", file, updateErr)
+		_, encPath, err := resolveUnlockPaths(file)
+		if err != nil {
 			continue
 		}
-		fmt.Printf("Updated encrypted file %s\n", encPath)
-	}
-
-	if failed > 0 {
-		return fmt.Errorf("update completed with %d failure(s)", failed)
+		if _, err := os.Stat(encPath); os.IsNotExist(err) {
+			return true
+		}
 	}
-	return nil
+	return false
 }
 
-func updateOneFile(file string, pw []byte, allowCreateMissingEnc bool) (string, error) {
-	defer zeroBytes(pw)
-
+func updateOneFile(file string, sess *cryptopkg.Session, gate *unlockGate, profile string, allowCreateMissingEnc bool) (string, error) {
 	absPath, encPath, err := resolveUnlockPaths(file)
 	if err != nil {
 		return "", err
 	}
-	if _, err := os.Stat(absPath); err != nil {
-		if os.IsNotExist(err) {
-			return "", fmt.Errorf("plaintext file %q does not exist", absPath)
-		}
-		return "", fmt.Errorf("failed to stat plaintext file %q: %w", absPath, err)
+	if err := checkPlaintextExists(absPath); err != nil {
+		return "", err
 	}
 
 	if _, err := os.Stat(encPath); err != nil {
 		if os.IsNotExist(err) {
 			if !allowCreateMissingEnc {
-				return "", fmt.Errorf("encrypted file %q does not exist; pass --create only for first-time encrypt from plaintext", encPath)
+				return "", withCode(codeMissingSidecar, fmt.Errorf("encrypted file %q does not exist; pass --create only for first-time encrypt from plaintext", encPath))
 			}
 			cfg, cfgErr := resolveUpdateConfig()
 			if cfgErr == nil {
@@ -267,11 +531,16 @@ func updateOneFile(file string, pw []byte, allowCreateMissingEnc bool) (string,
 			return "", fmt.Errorf("failed to stat encrypted file %q: %w", encPath, err)
 		}
 	} else {
-		if err := validateExistingEncryptedFilePassword(encPath, pw); err != nil {
+		if err := validateExistingEncryptedFilePassword(file, encPath, sess, gate); err != nil {
 			return "", err
 		}
 	}
-	if err := cryptopkg.EncryptFile(absPath, encPath, pw); err != nil {
+	meta, err := sidecarMetadata(encPath, profile, sess)
+	if err != nil {
+		return "", err
+	}
+	defer zeroBytes(meta.TOTPSecret)
+	if err := sess.EncryptFile(absPath, encPath, meta); err != nil {
 		return "", fmt.Errorf("failed to encrypt %q: %w", absPath, err)
 	}
 	return encPath, nil
@@ -294,57 +563,52 @@ func refuseUpdateWhenEncMissingIfWatched(sockPath, encPath, absPlain string) err
 	return nil
 }
 
-func validateExistingEncryptedFilePassword(encPath string, pw []byte) error {
-	plaintext, err := cryptopkg.Decrypt(encPath, pw)
+// validateExistingEncryptedFilePassword checks the password of sess against
+// the sidecar encPath of file. A wrong password counts as a failed unlock.
+func validateExistingEncryptedFilePassword(file, encPath string, sess *cryptopkg.Session, gate *unlockGate) error {
+	absPath, _, err := resolveUnlockPaths(file)
+	if err != nil {
+		return err
+	}
+	payload, err := os.ReadFile(encPath)
+	if err != nil {
+		return decryptError(encPath, err)
+	}
+	plaintext, err := gate.attempt(file, absPath, sidecarFingerprint(payload), func() ([]byte, error) {
+		return sess.DecryptBytes(payload)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to verify password for existing encrypted file %q: wrong password or corrupted file: %w", encPath, err)
+		return fmt.Errorf("failed to verify password for existing encrypted file %q: %w", encPath, err)
 	}
 	defer zeroBytes(plaintext)
 	return nil
 }
 
-func lock(files []string) error {
+func lock(files []string, profiles map[string]string) error {
 	cfg, err := core.ResolveConfig()
 	if err != nil {
 		return fmt.Errorf("failed to resolve config: %w", err)
 	}
 
-	pw, err := readPasswordWithConfirmation("Password: ", "Confirm password: ")
-	if err != nil {
-		return err
-	}
-	defer zeroBytes(pw)
-
-	var failed int
-	for _, file := range files {
+	results, err := withProfileSessions(cfg.Settings, files, profiles, true, "Encrypting", func(_ int, file, profile string, sess *cryptopkg.Session) (string, error) {
 		absPath, err := filepath.Abs(file)
 		if err != nil {
-			failed++
-			fmt.Fprintf(os.Stderr, "FAILED %s: %v\n", file, err)
-			continue
-		}
-
-		pwCopy := append([]byte(nil), pw...)
-		encPath, lockErr := lockOneFile(absPath, pwCopy)
-		if lockErr != nil {
-			failed++
-			fmt.Fprintf(os.Stderr, "FAILED %s: %v\n", file, lockErr)
-			continue
+			return "", err
 		}
-
-		if err := stopWatching(cfg.SockPath, absPath); err != nil {
-			fmt.Fprintf(os.Stderr, "warning: locked file locally but failed to stop watching %s (%v)\n", absPath, err)
+		encPath, err := lockOneFile(absPath, sess, profile)
+		if err != nil {
+			return "", err
 		}
-		fmt.Printf("Locked %s and updated %s\n", absPath, encPath)
-	}
-
-	if failed > 0 {
-		return fmt.Errorf("lock completed with %d failure(s)", failed)
+		return fmt.Sprintf("Locked %s and updated %s", absPath, encPath), nil
+	})
+	if err != nil {
+		return err
 	}
-	return nil
+	stopWatchingLocked(cfg.SockPath, results)
+	return reportResults("lock", results)
 }
 
-func batchLock(pathsFile string) error {
+func batchLock(pathsFile string, atomic bool) error {
 	cfg, err := core.ResolveConfig()
 	if err != nil {
 		return fmt.Errorf("failed to resolve config: %w", err)
@@ -358,41 +622,25 @@ func batchLock(pathsFile string) error {
 		return fmt.Errorf("no file paths found in %q", pathsFile)
 	}
 
-	pw, err := readPasswordWithConfirmation("Password: ", "Confirm password: ")
+	lockFn := lockFilesNow
+	if atomic {
+		lockFn = lockFilesAtomic
+	}
+	results, err := lockFn(cfg.Settings, paths)
 	if err != nil {
 		return err
 	}
-	defer zeroBytes(pw)
-
-	var failed int
-	for _, path := range paths {
-		pwCopy := append([]byte(nil), pw...)
-		encPath, lockErr := lockOneFile(path, pwCopy)
-		if lockErr != nil {
-			failed++
-			fmt.Fprintf(os.Stderr, "FAILED %s: %v\n", path, lockErr)
-			continue
-		}
-
-		if err := stopWatching(cfg.SockPath, path); err != nil {
-			fmt.Fprintf(os.Stderr, "warning: locked file locally but could not stop watching %s (%v)\n", path, err)
-		}
-		fmt.Printf("Locked %s and updated %s\n", path, encPath)
-	}
-
-	if failed > 0 {
-		return fmt.Errorf("batch-lock completed with %d failure(s)", failed)
-	}
-	return nil
+	stopWatchingLocked(cfg.SockPath, results)
+	return reportResults("batch-lock", results)
 }
 
-func batchUnlock(pathsFile string) error {
+func batchUnlock(pathsFile string, atomic bool) error {
 	cfg, err := core.ResolveConfig()
 	if err != nil {
 		return fmt.Errorf("failed to resolve config: %w", err)
 	}
 	if err := ensureDaemonRunning(cfg.SockPath); err != nil {
-		return errors.New("please start Dotward.app")
+		return errDaemonUnreachable
 	}
 
 	paths, err := readPathsFile(pathsFile)
@@ -403,71 +651,74 @@ func batchUnlock(pathsFile string) error {
 		return fmt.Errorf("no file paths found in %q", pathsFile)
 	}
 
-	pw, err := readPassword("Password: ")
+	unlockFn := unlockFiles
+	if atomic {
+		unlockFn = unlockFilesAtomic
+	}
+	results, err := unlockFn(cfg, paths, unlockOptions{})
 	if err != nil {
 		return err
 	}
-	defer zeroBytes(pw)
+	return reportResults("batch-unlock", results)
+}
 
-	var failed int
-	for _, path := range paths {
-		pwCopy := append([]byte(nil), pw...)
-		unlockErr := unlockOneFile(path, pwCopy, cfg.SockPath, cfg.DefaultTTL)
-		zeroBytes(pwCopy)
-		if unlockErr != nil {
-			failed++
-			fmt.Fprintf(os.Stderr, "FAILED %s: %v\n", path, unlockErr)
-			continue
+// lockFilesNow locks each of paths on its own.
+func lockFilesNow(settings core.Settings, paths []string) ([]fileResult, error) {
+	return withProfileSessions(settings, paths, nil, true, "Encrypting", func(_ int, path, profile string, sess *cryptopkg.Session) (string, error) {
+		encPath, err := lockOneFile(path, sess, profile)
+		if err != nil {
+			return "", err
 		}
-		fmt.Printf("Unlocked %s for %s\n", path, cfg.DefaultTTL)
-	}
+		return fmt.Sprintf("Locked %s and updated %s", path, encPath), nil
+	})
+}
 
-	if failed > 0 {
-		return fmt.Errorf("batch-unlock completed with %d failure(s)", failed)
+func lockOneFile(absPath string, sess *cryptopkg.Session, profile string) (string, error) {
+	if err := checkPlaintextExists(absPath); err != nil {
+		return "", err
 	}
-	return nil
-}
 
-func unlockOneFile(path string, pw []byte, sockPath string, ttl time.Duration) error {
-	absPath, encPath, err := resolveUnlockPaths(path)
+	encPath := absPath + ".enc"
+	meta, err := sidecarMetadata(encPath, profile, sess)
 	if err != nil {
-		return err
+		return "", err
 	}
-	if err := cryptopkg.DecryptFile(encPath, absPath, pw); err != nil {
-		return fmt.Errorf("failed to decrypt %q: %w", encPath, err)
+	defer zeroBytes(meta.TOTPSecret)
+	if err := sess.EncryptFile(absPath, encPath, meta); err != nil {
+		return "", fmt.Errorf("failed to encrypt %q: %w", absPath, err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	resp, err := ipc.Call(ctx, sockPath, "Manager.Register", ipc.Request{Path: absPath, TTL: ttl})
-	if err != nil {
-		_ = core.SecureDelete(absPath)
-		return errors.New("please start Dotward.app")
-	}
-	if !resp.Success {
-		_ = core.SecureDelete(absPath)
-		return fmt.Errorf("daemon rejected register: %s", resp.Error)
+	if err := core.SecureDelete(absPath); err != nil {
+		return "", fmt.Errorf("failed to lock file %q: %w", absPath, err)
 	}
-	return nil
+	return encPath, nil
 }
 
-func lockOneFile(absPath string, pw []byte) (string, error) {
+func checkPlaintextExists(absPath string) error {
 	if _, err := os.Stat(absPath); err != nil {
 		if os.IsNotExist(err) {
-			return "", fmt.Errorf("plaintext file %q does not exist", absPath)
+			return withCode(codeMissingPlaintext, fmt.Errorf("plaintext file %q does not exist", absPath))
 		}
-		return "", fmt.Errorf("failed to stat plaintext file %q: %w", absPath, err)
-	}
-
-	encPath := absPath + ".enc"
-	if err := cryptopkg.EncryptFile(absPath, encPath, pw); err != nil {
-		return "", fmt.Errorf("failed to encrypt %q: %w", absPath, err)
+		return fmt.Errorf("failed to stat plaintext file %q: %w", absPath, err)
 	}
+	return nil
+}
 
-	if err := core.SecureDelete(absPath); err != nil {
-		return "", fmt.Errorf("failed to lock file %q: %w", absPath, err)
+// stopWatchingLocked asks the daemon to stop watching the files of results
+// that were locked.
+func stopWatchingLocked(sockPath string, results []fileResult) {
+	for _, r := range results {
+		if r.err != nil {
+			continue
+		}
+		absPath, err := filepath.Abs(r.file)
+		if err == nil {
+			err = stopWatching(sockPath, absPath)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: locked file locally but failed to stop watching %s (%v)\n", absPath, err)
+		}
 	}
-	return encPath, nil
 }
 
 func stopWatching(sockPath, absPath string) error {
@@ -484,9 +735,9 @@ func stopWatching(sockPath, absPath string) error {
 }
 
 func readPassword(prompt string) ([]byte, error) {
-	fmt.Print(prompt)
+	fmt.Fprint(promptWriter(), prompt)
 	pw, err := term.ReadPassword(int(os.Stdin.Fd()))
-	fmt.Println()
+	fmt.Fprintln(promptWriter())
 	if err == nil {
 		if len(bytes.TrimSpace(pw)) == 0 {
 			return nil, errors.New("password cannot be empty")
@@ -506,13 +757,13 @@ func readPassword(prompt string) ([]byte, error) {
 	return []byte(line), nil
 }
 
-func readPasswordWithConfirmation(prompt, confirmPrompt string) ([]byte, error) {
-	pw, err := readPassword(prompt)
+func readPasswordWithConfirmation(read func(string) ([]byte, error), prompt, confirmPrompt string) ([]byte, error) {
+	pw, err := read(prompt)
 	if err != nil {
 		return nil, err
 	}
 
-	confirm, err := readPassword(confirmPrompt)
+	confirm, err := read(confirmPrompt)
 	if err != nil {
 		zeroBytes(pw)
 		return nil, err
@@ -3,12 +3,23 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/stefanos/dotward/internal/audit"
 	"github.com/stefanos/dotward/internal/core"
 	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/ipc"
+	"github.com/stefanos/dotward/internal/keyring"
+	"github.com/stefanos/dotward/internal/totp"
 )
 
 func TestUpdateOneFileRejectsWrongExistingPassword(t *testing.T) {
@@ -30,7 +41,7 @@ func TestUpdateOneFileRejectsWrongExistingPassword(t *testing.T) {
 	if err := os.WriteFile(plainPath, []byte("TOKEN=new\n"), 0o600); err != nil {
 		t.Fatalf("write updated plaintext: %v", err)
 	}
-	if _, err := updateOneFile(plainPath, []byte("12345"), false); err == nil {
+	if _, err := updateOneFile(plainPath, cryptopkg.NewSession([]byte("12345")), newUnlockGate(""), "", false); err == nil {
 		t.Fatal("expected update to reject the wrong existing password")
 	}
 
@@ -70,7 +81,7 @@ func TestUpdateOneFileWithCorrectPasswordReencryptsPlaintext(t *testing.T) {
 		t.Fatalf("write updated plaintext: %v", err)
 	}
 
-	if _, err := updateOneFile(plainPath, []byte("1234"), false); err != nil {
+	if _, err := updateOneFile(plainPath, cryptopkg.NewSession([]byte("1234")), newUnlockGate(""), "", false); err != nil {
 		t.Fatalf("update with correct password: %v", err)
 	}
 
@@ -106,7 +117,7 @@ func TestUpdateOneFileRefusesWhenEncMissingButDaemonReportsWatching(t *testing.T
 		return true, nil
 	}
 
-	if _, err := updateOneFile(plainPath, []byte("any-password"), true); err == nil {
+	if _, err := updateOneFile(plainPath, cryptopkg.NewSession([]byte("any-password")), newUnlockGate(""), "", true); err == nil {
 		t.Fatal("expected error when .enc is missing but plaintext path is watched")
 	}
 }
@@ -134,7 +145,7 @@ func TestUpdateOneFileAllowsEncryptWhenEncMissingAndNotWatched(t *testing.T) {
 		return false, nil
 	}
 
-	if _, err := updateOneFile(plainPath, []byte("freshpw"), true); err != nil {
+	if _, err := updateOneFile(plainPath, cryptopkg.NewSession([]byte("freshpw")), newUnlockGate(""), "", true); err != nil {
 		t.Fatalf("update: %v", err)
 	}
 	plaintext, err := cryptopkg.Decrypt(encPath, []byte("freshpw"))
@@ -164,7 +175,7 @@ func TestUpdateOneFileAllowsFirstEncryptWhenDaemonUnreachable(t *testing.T) {
 		return core.Config{SockPath: sock}, nil
 	}
 
-	if _, err := updateOneFile(plainPath, []byte("solo"), true); err != nil {
+	if _, err := updateOneFile(plainPath, cryptopkg.NewSession([]byte("solo")), newUnlockGate(""), "", true); err != nil {
 		t.Fatalf("update: %v", err)
 	}
 	if _, err := os.Stat(encPath); err != nil {
@@ -184,10 +195,1209 @@ func TestUpdateOneFileRejectsWhenEncMissingWithoutCreateFlag(t *testing.T) {
 		t.Fatalf("expected no encrypted file yet, stat err=%v", err)
 	}
 
-	if _, err := updateOneFile(plainPath, []byte("any-password"), false); err == nil {
+	if _, err := updateOneFile(plainPath, cryptopkg.NewSession([]byte("any-password")), newUnlockGate(""), "", false); err == nil {
 		t.Fatal("expected error when .enc is missing and --create was not requested")
 	}
 	if _, err := os.Stat(encPath); err == nil {
 		t.Fatal("did not expect encrypted file to be created without --create")
 	}
 }
+
+func TestSplitWhileArgs(t *testing.T) {
+	files, command, err := splitWhileArgs([]string{".env", "api/.env", "npm", "run", "dev"}, 2, true)
+	if err != nil {
+		t.Fatalf("split: %v", err)
+	}
+	if len(files) != 2 || files[0] != ".env" || files[1] != "api/.env" {
+		t.Fatalf("files mismatch got=%q", files)
+	}
+	if len(command) != 3 || command[0] != "npm" {
+		t.Fatalf("command mismatch got=%q", command)
+	}
+
+	if _, _, err := splitWhileArgs([]string{".env"}, -1, true); err == nil {
+		t.Fatal("expected error when --while has no command")
+	}
+	files, command, err = splitWhileArgs([]string{"npm", "test"}, 0, true)
+	if err != nil || len(files) != 0 || len(command) != 2 {
+		t.Fatalf("expected manifest fallback when --while has no files, got files=%q command=%q err=%v", files, command, err)
+	}
+	if _, _, err := splitWhileArgs([]string{".env", "npm"}, 1, false); err == nil {
+		t.Fatal("expected error for -- without --while")
+	}
+}
+
+func TestParseAuditTime(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{in: "", want: time.Time{}},
+		{in: "24h", want: now.Add(-24 * time.Hour)},
+		{in: "2026-03-01T08:30:00Z", want: time.Date(2026, 3, 1, 8, 30, 0, 0, time.UTC)},
+		{in: "2026-03-01", want: time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tc := range tests {
+		got, err := parseAuditTime(tc.in, now)
+		if err != nil {
+			t.Fatalf("parseAuditTime(%q): %v", tc.in, err)
+		}
+		if !got.Equal(tc.want) {
+			t.Fatalf("parseAuditTime(%q) got=%s want=%s", tc.in, got, tc.want)
+		}
+	}
+	if _, err := parseAuditTime("yesterday", now); err == nil {
+		t.Fatal("expected error for unsupported time format")
+	}
+}
+
+func TestGitignoreUpdateIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".gitignore")
+	if err := os.WriteFile(path, []byte("node_modules/\n.env"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rels := []string{".env", "api/.env"}
+
+	missing, err := missingGitignoreEntries(path, rels)
+	if err != nil {
+		t.Fatalf("missing: %v", err)
+	}
+	if len(missing) != 1 || missing[0] != "/api/.env" {
+		t.Fatalf("missing got=%q", missing)
+	}
+	if err := appendGitignore(path, missing); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+
+	missing, err = missingGitignoreEntries(path, rels)
+	if err != nil {
+		t.Fatalf("missing: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Fatalf("expected no missing entries after update, got %q", missing)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if want := "node_modules/\n.env\n\n" + gitignoreHeader + "\n/api/.env\n"; string(b) != want {
+		t.Fatalf(".gitignore got=%q want=%q", b, want)
+	}
+}
+
+func TestEncryptAndVerifyRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("TOKEN=abc\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := encryptAndVerify(path, cryptopkg.NewSession([]byte("pw")), ""); err != nil {
+		t.Fatalf("encrypt and verify: %v", err)
+	}
+	got, err := cryptopkg.Decrypt(path+".enc", []byte("pw"))
+	if err != nil || string(got) != "TOKEN=abc\n" {
+		t.Fatalf("sidecar does not decrypt: %q %v", got, err)
+	}
+}
+
+func TestRenderTextconv(t *testing.T) {
+	in := []byte("# db\nexport DB_USER=admin\nDB_PASS=\"two words\"\nEMPTY=\n")
+	got := renderTextconv(in, nil)
+	want := "# db\nDB_USER=admin\nDB_PASS=two words\nEMPTY=\n"
+	if got != want {
+		t.Fatalf("plain textconv got=%q want=%q", got, want)
+	}
+
+	keyPath := filepath.Join(t.TempDir(), "mask.key")
+	key, err := loadMaskKey(keyPath)
+	if err != nil {
+		t.Fatalf("mask key: %v", err)
+	}
+	if again, err := loadMaskKey(keyPath); err != nil || !bytes.Equal(again, key) {
+		t.Fatalf("mask key not kept: %v", err)
+	}
+	masked := renderTextconv(in, key)
+	if strings.Contains(masked, "admin") || strings.Contains(masked, "two words") {
+		t.Fatalf("masked textconv leaks values: %q", masked)
+	}
+	if !strings.Contains(masked, "DB_USER=<masked hmac:") || !strings.Contains(masked, "EMPTY=\n") {
+		t.Fatalf("masked textconv mismatch: %q", masked)
+	}
+	if renderTextconv([]byte("A=1\n"), key) == renderTextconv([]byte("A=2\n"), key) {
+		t.Fatal("masked values must still show that a value changed")
+	}
+	other := bytes.Repeat([]byte{1}, maskKeySize)
+	if renderTextconv([]byte("A=1\n"), key) == renderTextconv([]byte("A=1\n"), other) {
+		t.Fatal("masked values must depend on the mask key")
+	}
+
+	if got := renderTextconv([]byte("not a dotenv file"), key); !strings.HasPrefix(got, "# dotward: not a .env file") {
+		t.Fatalf("masked non-dotenv got=%q", got)
+	}
+}
+
+func TestInstallPreCommitHook(t *testing.T) {
+	hookPath := filepath.Join(t.TempDir(), "hooks", "pre-commit")
+	if err := installPreCommitHook(hookPath, "dotward", false); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if err := installPreCommitHook(hookPath, "dotward", false); err != nil {
+		t.Fatalf("reinstall over own hook: %v", err)
+	}
+	b, err := os.ReadFile(hookPath)
+	if err != nil || !strings.Contains(string(b), "exec dotward git pre-commit") {
+		t.Fatalf("hook content mismatch: %q %v", b, err)
+	}
+
+	if err := os.WriteFile(hookPath, []byte("#!/bin/sh\nmake lint\n"), 0o755); err != nil {
+		t.Fatalf("write foreign hook: %v", err)
+	}
+	if err := installPreCommitHook(hookPath, "dotward", false); err == nil {
+		t.Fatal("expected refusal to replace a foreign hook")
+	}
+	if err := installPreCommitHook(hookPath, "dotward", true); err != nil {
+		t.Fatalf("forced install: %v", err)
+	}
+}
+
+func TestEnsureLineIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".gitattributes")
+	if err := os.WriteFile(path, []byte("*.png binary"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for i, wantAdded := range []bool{true, false} {
+		added, err := ensureLine(path, gitAttribute)
+		if err != nil {
+			t.Fatalf("ensure line: %v", err)
+		}
+		if added != wantAdded {
+			t.Fatalf("call %d added=%v want=%v", i, added, wantAdded)
+		}
+	}
+	b, _ := os.ReadFile(path)
+	if string(b) != "*.png binary\n"+gitAttribute+"\n" {
+		t.Fatalf(".gitattributes got=%q", b)
+	}
+}
+
+func TestFilterSmudgeAndCleanPassThrough(t *testing.T) {
+	orig := filterPassword
+	t.Cleanup(func() { filterPassword = orig })
+	filterPassword = func(string) ([]byte, error) { return []byte("pw"), nil }
+
+	salt := bytes.Repeat([]byte{7}, 16)
+	payload, err := cryptopkg.EncryptDeterministic([]byte("A=1\n"), []byte("pw"), salt, cryptopkg.Metadata{})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := filterSmudge(".env", bytes.NewReader(payload), &out); err != nil {
+		t.Fatalf("smudge: %v", err)
+	}
+	if out.String() != "A=1\n" {
+		t.Fatalf("smudge got=%q", out.String())
+	}
+
+	// Blobs committed before the filter was enabled are plaintext.
+	out.Reset()
+	if err := filterSmudge(".env", strings.NewReader("B=2\n"), &out); err != nil || out.String() != "B=2\n" {
+		t.Fatalf("smudge of plaintext blob got=%q err=%v", out.String(), err)
+	}
+
+	// A wrong password leaves the work tree file encrypted instead of failing the checkout.
+	filterPassword = func(string) ([]byte, error) { return []byte("wrong"), nil }
+	out.Reset()
+	if err := filterSmudge(".env", bytes.NewReader(payload), &out); err != nil || !bytes.Equal(out.Bytes(), payload) {
+		t.Fatalf("smudge with wrong password err=%v", err)
+	}
+
+	// Cleaning a file that is still encrypted stores it unchanged.
+	out.Reset()
+	if err := filterClean(".env", bytes.NewReader(payload), &out); err != nil || !bytes.Equal(out.Bytes(), payload) {
+		t.Fatalf("clean of ciphertext err=%v", err)
+	}
+}
+
+func TestGitMergeDriverMergesEncryptedFiles(t *testing.T) {
+	orig := mergePassword
+	t.Cleanup(func() { mergePassword = orig })
+	mergePassword = func(string) ([]byte, error) { return []byte("pw"), nil }
+
+	dir := t.TempDir()
+	write := func(name, plaintext string) string {
+		t.Helper()
+		payload, err := cryptopkg.EncryptBytes([]byte(plaintext), []byte("pw"))
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, payload, 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return p
+	}
+	base := write("base", "A=1\nB=2\n")
+	ours := write("ours", "A=10\nB=2\n")
+	theirs := write("theirs", "A=1\nB=20\n")
+
+	conflicts, err := gitMergeDriver(base, ours, theirs, ".env.enc")
+	if err != nil || len(conflicts) != 0 {
+		t.Fatalf("merge conflicts=%v err=%v", conflicts, err)
+	}
+	merged, err := cryptopkg.Decrypt(ours, []byte("pw"))
+	if err != nil {
+		t.Fatalf("decrypt merged: %v", err)
+	}
+	if string(merged) != "A=10\nB=20\n" {
+		t.Fatalf("merged got=%q", merged)
+	}
+
+	theirs = write("theirs", "A=11\nB=2\n")
+	conflicts, err = gitMergeDriver(base, ours, theirs, ".env.enc")
+	if err != nil || strings.Join(conflicts, ",") != "A" {
+		t.Fatalf("expected conflict on A, got conflicts=%v err=%v", conflicts, err)
+	}
+}
+
+func TestFileProfileAndGrouping(t *testing.T) {
+	dir := t.TempDir()
+	settings, err := core.ParseSettings([]byte(`{"profiles": {
+		"prod": {"roots": ["` + filepath.Join(dir, "prod") + `"]},
+		"dev": {"password_command": "printf 'dev-pw\\n'"}
+	}}`))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	for _, sub := range []string{"prod", "dev"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	prodFile := filepath.Join(dir, "prod", ".env")
+	devFile := filepath.Join(dir, "dev", ".env")
+	otherFile := filepath.Join(dir, "other.env")
+
+	// A sidecar's recorded profile wins over the roots.
+	payload, err := cryptopkg.EncryptBytesWithMetadata([]byte("A=1\n"), []byte("dev-pw"), cryptopkg.Metadata{Profile: "dev"})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if err := os.WriteFile(devFile+".enc", payload, 0o600); err != nil {
+		t.Fatalf("write sidecar: %v", err)
+	}
+
+	groups, failed := groupByProfile(settings, []string{prodFile, devFile, otherFile}, map[string]string{otherFile: "dev"})
+	if len(failed) != 0 || len(groups) != 2 {
+		t.Fatalf("groups=%+v failed=%d", groups, failed)
+	}
+	if groups[0].profile != "prod" || len(groups[0].files) != 1 || groups[1].profile != "dev" || len(groups[1].files) != 2 {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+
+	profileFlag = "prod"
+	t.Cleanup(func() { profileFlag = "" })
+	if _, err := fileProfile(settings, devFile, devFile+".enc", ""); err == nil || !strings.Contains(err.Error(), `encrypted for profile "dev"`) {
+		t.Fatalf("expected profile mismatch, got %v", err)
+	}
+	if _, err := fileProfile(settings, otherFile, otherFile+".enc", ""); err == nil || !strings.Contains(err.Error(), "outside the roots") {
+		t.Fatalf("expected roots error, got %v", err)
+	}
+	profileFlag = ""
+
+	pw, err := profilePassword(settings, "dev", "Password: ", func(string) ([]byte, error) {
+		t.Fatal("dev has a password_command and must not prompt")
+		return nil, nil
+	})
+	if err != nil || string(pw) != "dev-pw" {
+		t.Fatalf("password_command got=%q err=%v", pw, err)
+	}
+	if got := profilePrompt("Dotward password (git diff): ", "prod"); got != "Dotward password for profile prod (git diff): " {
+		t.Fatalf("prompt got=%q", got)
+	}
+}
+
+func TestWithProfileSessionsKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+	settings, err := core.ParseSettings([]byte(`{"profiles": {
+		"dev": {"password_command": "printf dev-pw", "roots": ["` + dir + `"]}
+	}}`))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	var files []string
+	for i := range 6 {
+		files = append(files, filepath.Join(dir, fmt.Sprintf("svc%d.env", i)))
+	}
+	outside := filepath.Join(t.TempDir(), ".env")
+	files = append(files[:3], append([]string{outside}, files[3:]...)...)
+
+	sessions := make([]*cryptopkg.Session, len(files))
+	results, err := withProfileSessions(settings, files, map[string]string{outside: "dev"}, false, "Testing", func(i int, file, profile string, sess *cryptopkg.Session) (string, error) {
+		if profile != "dev" {
+			return "", fmt.Errorf("profile %q", profile)
+		}
+		sessions[i] = sess
+		return "done " + filepath.Base(file), nil
+	})
+	if err != nil {
+		t.Fatalf("withProfileSessions: %v", err)
+	}
+	for i, r := range results {
+		if r.file != files[i] {
+			t.Fatalf("result %d is for %s, want %s", i, r.file, files[i])
+		}
+		if files[i] == outside {
+			if r.err == nil || !strings.Contains(r.err.Error(), "outside the roots") {
+				t.Fatalf("expected roots error for %s, got %v", outside, r.err)
+			}
+			continue
+		}
+		if r.err != nil || r.msg != "done "+filepath.Base(files[i]) {
+			t.Fatalf("result %d got=%+v", i, r)
+		}
+		if sessions[i] != sessions[0] {
+			t.Fatal("files of one profile must share a session")
+		}
+	}
+}
+
+// registerOnly is a daemon that predates Manager.RegisterMany.
+type registerOnly struct {
+	calls int
+}
+
+func (d *registerOnly) Register(req ipc.Request, resp *ipc.Response) error {
+	d.calls++
+	resp.Success = req.Path != "/bad"
+	resp.TTL = time.Minute
+	return nil
+}
+
+type registerBatch struct {
+	registerOnly
+	batches int
+}
+
+func (d *registerBatch) RegisterMany(req ipc.Request, resp *ipc.Response) error {
+	d.batches++
+	for _, r := range req.Batch {
+		resp.Results = append(resp.Results, ipc.Response{Success: r.Path != "/bad", TTL: time.Minute})
+	}
+	return nil
+}
+
+func serveManager(t *testing.T, manager any) string {
+	t.Helper()
+	sockPath := filepath.Join(t.TempDir(), "d.sock")
+	server := rpc.NewServer()
+	if err := server.RegisterName("Manager", manager); err != nil {
+		t.Fatalf("register manager: %v", err)
+	}
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go server.Accept(ln)
+	return sockPath
+}
+
+func TestRegisterManyUsesOneCall(t *testing.T) {
+	reqs := []ipc.Request{{Path: "/a"}, {Path: "/bad"}, {Path: "/b"}}
+
+	batch := &registerBatch{}
+	resps, err := registerMany(serveManager(t, batch), reqs)
+	if err != nil {
+		t.Fatalf("registerMany: %v", err)
+	}
+	if batch.batches != 1 || batch.calls != 0 {
+		t.Fatalf("batches=%d calls=%d, want one batch", batch.batches, batch.calls)
+	}
+	if len(resps) != 3 || !resps[0].Success || resps[1].Success || !resps[2].Success {
+		t.Fatalf("unexpected responses: %+v", resps)
+	}
+
+	old := &registerOnly{}
+	resps, err = registerMany(serveManager(t, old), reqs)
+	if err != nil {
+		t.Fatalf("registerMany against an older daemon: %v", err)
+	}
+	if old.calls != 3 || len(resps) != 3 || resps[1].Success {
+		t.Fatalf("calls=%d responses=%+v", old.calls, resps)
+	}
+}
+
+func atomicTestSettings(t *testing.T, dir string) core.Settings {
+	t.Helper()
+	settings, err := core.ParseSettings([]byte(`{"profiles": {
+		"svc": {"password_command": "printf svc-tamarind-oboe", "roots": ["` + dir + `"]}
+	}}`))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	return settings
+}
+
+func TestRelockKeepsMasterSalt(t *testing.T) {
+	dir := t.TempDir()
+	settings := atomicTestSettings(t, dir)
+	files := []string{filepath.Join(dir, "a.env"), filepath.Join(dir, "b.env")}
+	for _, f := range files {
+		if err := os.WriteFile(f, []byte("A=1\n"), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if results, err := lockFilesNow(settings, files); err != nil || results[0].err != nil || results[1].err != nil {
+		t.Fatalf("lockFilesNow: %+v %v", results, err)
+	}
+	before, err := cryptopkg.Inspect(files[1] + ".enc")
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+
+	// Unlocking, editing and locking one file keeps it on the batch's salt.
+	if err := os.WriteFile(files[0], []byte("A=2\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if results, err := lockFilesNow(settings, files[:1]); err != nil || results[0].err != nil {
+		t.Fatalf("lockFilesNow: %+v %v", results, err)
+	}
+	after, err := cryptopkg.Inspect(files[0] + ".enc")
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if !after.Session || !bytes.Equal(after.Salt, before.Salt) {
+		t.Fatalf("re-locked file moved to salt %x, want %x", after.Salt, before.Salt)
+	}
+}
+
+func TestLockFilesAtomicRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	settings := atomicTestSettings(t, dir)
+	a := filepath.Join(dir, "a.env")
+	b := filepath.Join(dir, "b.env")
+	if err := os.WriteFile(a, []byte("A=1\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(a+".enc", []byte("old sidecar"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// b.env does not exist, so nothing may change.
+	results, err := lockFilesAtomic(settings, []string{a, b})
+	if err != nil {
+		t.Fatalf("lockFilesAtomic: %v", err)
+	}
+	if results[0].err == nil || !strings.Contains(results[0].err.Error(), "rolled back") || results[1].err == nil {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if got, err := os.ReadFile(a + ".enc"); err != nil || string(got) != "old sidecar" {
+		t.Fatalf("sidecar changed: %q %v", got, err)
+	}
+	if got, err := os.ReadFile(a); err != nil || string(got) != "A=1\n" {
+		t.Fatalf("plaintext changed: %q %v", got, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("staged files left behind: %v", entries)
+	}
+
+	if err := os.WriteFile(b, []byte("B=2\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	results, err = lockFilesAtomic(settings, []string{a, b})
+	if err != nil || results[0].err != nil || results[1].err != nil {
+		t.Fatalf("lockFilesAtomic: %+v %v", results, err)
+	}
+	for _, p := range []string{a, b} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("plaintext %s not deleted: %v", p, err)
+		}
+		if out, err := cryptopkg.Decrypt(p+".enc", []byte("svc-tamarind-oboe")); err != nil || len(out) == 0 {
+			t.Fatalf("decrypt %s: %v", p, err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("staged files left behind: %v", entries)
+	}
+}
+
+// refusingDaemon accepts every registration except files named refuse.env.
+type refusingDaemon struct {
+	watching map[string]bool
+	stopped  []string
+}
+
+func (d *refusingDaemon) IsWatching(req ipc.Request, resp *ipc.Response) error {
+	resp.Success = d.watching[req.Path]
+	return nil
+}
+
+func (d *refusingDaemon) RegisterMany(req ipc.Request, resp *ipc.Response) error {
+	for _, r := range req.Batch {
+		result := ipc.Response{Success: true, TTL: time.Minute}
+		if filepath.Base(r.Path) == "refuse.env" {
+			result = ipc.Response{Error: "refused"}
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return nil
+}
+
+func (d *refusingDaemon) StopWatching(req ipc.Request, resp *ipc.Response) error {
+	d.stopped = append(d.stopped, req.Path)
+	resp.Success = true
+	return nil
+}
+
+func TestUnlockFilesAtomicRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	settings := atomicTestSettings(t, dir)
+	var files []string
+	for _, name := range []string{"a.env", "refuse.env"} {
+		path := filepath.Join(dir, name)
+		payload, err := cryptopkg.EncryptBytesWithMetadata([]byte("K=v\n"), []byte("svc-tamarind-oboe"), cryptopkg.Metadata{Profile: "svc"})
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		if err := os.WriteFile(path+".enc", payload, 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		files = append(files, path)
+	}
+
+	daemon := &refusingDaemon{}
+	cfg := core.Config{Settings: settings, SockPath: serveManager(t, daemon)}
+	results, err := unlockFilesAtomic(cfg, files, unlockOptions{})
+	if err != nil {
+		t.Fatalf("unlockFilesAtomic: %v", err)
+	}
+	if len(results) != 2 || errorCode(results[0].err) != codeRolledBack || errorCode(results[1].err) != codeDaemonRejected {
+		t.Fatalf("want a rolled back and a rejected file, got %+v", results)
+	}
+	if err := reportResults("batch-unlock", results); exitCode(err) != exitDaemonRejected {
+		t.Fatalf("exit code %d for %v, want %d", exitCode(err), err, exitDaemonRejected)
+	}
+	if len(daemon.stopped) != 1 || daemon.stopped[0] != files[0] {
+		t.Fatalf("accepted registration not withdrawn: %v", daemon.stopped)
+	}
+	for _, p := range files {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("plaintext %s left behind: %v", p, err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("staged files left behind: %v", entries)
+	}
+
+	if err := os.Rename(files[1]+".enc", filepath.Join(dir, "b.env.enc")); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	files[1] = filepath.Join(dir, "b.env")
+	results, err = unlockFilesAtomic(cfg, files, unlockOptions{})
+	if err != nil || results[0].err != nil || results[1].err != nil {
+		t.Fatalf("unlockFilesAtomic: results=%+v err=%v", results, err)
+	}
+	for _, p := range files {
+		if got, err := os.ReadFile(p); err != nil || string(got) != "K=v\n" {
+			t.Fatalf("plaintext %s: %q %v", p, got, err)
+		}
+	}
+}
+
+func TestUnlockFilesAtomicKeepsEarlierUnlock(t *testing.T) {
+	dir := t.TempDir()
+	settings := atomicTestSettings(t, dir)
+	var files []string
+	for _, name := range []string{"a.env", "refuse.env"} {
+		path := filepath.Join(dir, name)
+		payload, err := cryptopkg.EncryptBytesWithMetadata([]byte("K=v\n"), []byte("svc-tamarind-oboe"), cryptopkg.Metadata{Profile: "svc"})
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		if err := os.WriteFile(path+".enc", payload, 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		files = append(files, path)
+	}
+	// a.env was unlocked and edited before the batch.
+	if err := os.WriteFile(files[0], []byte("K=edited\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	daemon := &refusingDaemon{watching: map[string]bool{files[0]: true}}
+	cfg := core.Config{Settings: settings, SockPath: serveManager(t, daemon)}
+	results, err := unlockFilesAtomic(cfg, files, unlockOptions{})
+	if err != nil {
+		t.Fatalf("unlockFilesAtomic: %v", err)
+	}
+	if errorCode(results[0].err) != codeRolledBack || errorCode(results[1].err) != codeDaemonRejected {
+		t.Fatalf("want a rolled back and a rejected file, got %+v", results)
+	}
+	if len(daemon.stopped) != 0 {
+		t.Fatalf("registration of the earlier unlock withdrawn: %v", daemon.stopped)
+	}
+	if got, err := os.ReadFile(files[0]); err != nil || string(got) != "K=edited\n" {
+		t.Fatalf("earlier plaintext not restored: %q %v", got, err)
+	}
+	if _, err := os.Stat(files[1]); !os.IsNotExist(err) {
+		t.Fatalf("plaintext %s left behind: %v", files[1], err)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	missing := decryptError("/tmp/x.enc", fmt.Errorf("failed to read: %w", os.ErrNotExist))
+	tests := []struct {
+		err  error
+		code string
+		exit int
+	}{
+		{nil, "", 0},
+		{errors.New("boom"), codeFailed, exitFailed},
+		{usageError("bad flag"), codeUsage, exitUsage},
+		{errors.New(`unknown command "nope" for "dotward"`), codeUsage, exitUsage},
+		{fmt.Errorf("failed to decrypt: %w", cryptopkg.ErrDecrypt), codeWrongPassword, exitWrongPassword},
+		{missing, codeMissingSidecar, exitMissingSidecar},
+		{withCode(codeMissingPlaintext, errors.New("no plaintext")), codeMissingPlaintext, exitMissingPlaintext},
+		{errDaemonUnreachable, codeDaemonUnreachable, exitDaemonUnreachable},
+		{&exitError{code: 9, err: errors.New("scan")}, codeFailed, 9},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.exit {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.exit)
+		}
+		if tt.err == nil {
+			continue
+		}
+		if got := errorCode(tt.err); got != tt.code {
+			t.Errorf("errorCode(%v) = %q, want %q", tt.err, got, tt.code)
+		}
+	}
+
+	mixed := []fileResult{
+		{file: "a", err: withCode(codeRolledBack, errors.New("rolled back"))},
+		{file: "b", err: missing},
+	}
+	if err := reportResults("lock", mixed); exitCode(err) != exitMissingSidecar {
+		t.Fatalf("exit code %d for %v, want %d", exitCode(err), err, exitMissingSidecar)
+	}
+	mixed = append(mixed, fileResult{file: "c", err: errDaemonUnreachable})
+	if err := reportResults("lock", mixed); exitCode(err) != exitFailed {
+		t.Fatalf("exit code %d for %v, want %d", exitCode(err), err, exitFailed)
+	}
+}
+
+func TestWriteReportJSON(t *testing.T) {
+	outputFlag = "json"
+	jsonReport = report{}
+	t.Cleanup(func() {
+		outputFlag = "text"
+		jsonReport = report{}
+	})
+
+	dir := t.TempDir()
+	results := []fileResult{
+		{file: filepath.Join(dir, ".env"), profile: "work", ttl: 5 * time.Minute},
+		{file: filepath.Join(dir, "b.env"), err: fmt.Errorf("failed: %w", cryptopkg.ErrDecrypt)},
+	}
+	err := reportResults("unlock", results)
+
+	var buf bytes.Buffer
+	if err := writeReport(&buf, unlockCmd, err); err != nil {
+		t.Fatalf("writeReport: %v", err)
+	}
+	var got report
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal %s: %v", buf.Bytes(), err)
+	}
+	if got.Command != "unlock" || got.OK || got.ExitCode != exitWrongPassword || got.Error == nil || got.Error.Code != codeWrongPassword {
+		t.Fatalf("report = %+v", got)
+	}
+	if len(got.Files) != 2 {
+		t.Fatalf("files = %+v", got.Files)
+	}
+	if f := got.Files[0]; !f.OK || f.Profile != "work" || f.TTLSeconds != 300 || f.Sidecar != filepath.Join(dir, ".env.enc") || f.Error != nil {
+		t.Fatalf("files[0] = %+v", f)
+	}
+	if f := got.Files[1]; f.OK || f.Error == nil || f.Error.Code != codeWrongPassword {
+		t.Fatalf("files[1] = %+v", f)
+	}
+}
+
+func TestOutputFlagCommands(t *testing.T) {
+	outputFlag = "json"
+	t.Cleanup(func() { outputFlag = "text" })
+
+	if err := checkOutputFlag(scanCmd, nil); err != nil {
+		t.Fatalf("scan refused --output json: %v", err)
+	}
+	if err := checkOutputFlag(lockoutListCmd, nil); exitCode(err) != exitUsage {
+		t.Fatalf("lockout list accepted --output json: %v", err)
+	}
+}
+
+func TestProfilePasswordPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	writeSecret := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+	flagFile := writeSecret("flag", "from-flag\n")
+	envFile := writeSecret("env", "from-env\r\n")
+	t.Cleanup(func() { passwordFileFlag = "" })
+
+	settings := core.Settings{
+		PasswordCommand: "echo from-global",
+		Profiles:        map[string]core.Profile{"prod": {Name: "prod", PasswordCommand: "echo from-profile"}},
+	}
+	prompt := func(string) ([]byte, error) { return []byte("from-prompt"), nil }
+	check := func(settings core.Settings, profile, want string) {
+		t.Helper()
+		pw, err := profilePassword(settings, profile, "Password: ", prompt)
+		if err != nil || string(pw) != want {
+			t.Fatalf("profilePassword(%q) = %q, %v; want %q", profile, pw, err, want)
+		}
+	}
+
+	passwordFileFlag = flagFile
+	t.Setenv(passwordFileEnv, envFile)
+	check(settings, "prod", "from-flag")
+	passwordFileFlag = ""
+	check(settings, "prod", "from-env")
+	t.Setenv(passwordFileEnv, "")
+	check(settings, "prod", "from-profile")
+	check(settings, "", "from-global")
+	check(core.Settings{}, "", "from-prompt")
+
+	passwordFileFlag = writeSecret("empty", "\n")
+	if _, err := profilePassword(settings, "", "Password: ", prompt); errorCode(err) != codeNoPassword {
+		t.Fatalf("empty password file: %v", err)
+	}
+	passwordFileFlag = writeSecret("long", strings.Repeat("x", maxPasswordSize+1))
+	if _, err := profilePassword(settings, "", "Password: ", prompt); err == nil || strings.Contains(err.Error(), "xxx") {
+		t.Fatalf("long password file: %v", err)
+	}
+}
+
+func TestWithProfileSessionsUsesPinentry(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "pinentry.log")
+	script := fmt.Sprintf(`#!/bin/sh
+echo "OK ready"
+while read -r line; do
+	echo "$line" >> %q
+	case "$line" in
+	GETPIN) echo "S PIN_REPEATED"; echo "D pw"; echo OK ;;
+	BYE) echo OK; exit 0 ;;
+	*) echo OK ;;
+	esac
+done
+`, logPath)
+	program := filepath.Join(dir, "pinentry")
+	if err := os.WriteFile(program, []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake pinentry: %v", err)
+	}
+	t.Setenv("GPG_TTY", "/dev/pts/9")
+	t.Setenv("TERM", "")
+
+	files := []string{filepath.Join(dir, "a.env"), filepath.Join(dir, "b.env")}
+	settings := core.Settings{Pinentry: program}
+	results, err := withProfileSessions(settings, files, nil, true, "Encrypting", func(_ int, file, _ string, sess *cryptopkg.Session) (string, error) {
+		payload, err := sess.EncryptBytes([]byte("K=v\n"), cryptopkg.Metadata{})
+		if err != nil {
+			return "", err
+		}
+		if _, err := cryptopkg.DecryptBytes(payload, []byte("pw")); err != nil {
+			return "", fmt.Errorf("not encrypted with the pinentry password: %w", err)
+		}
+		return file, nil
+	})
+	if err != nil || results[0].err != nil || results[1].err != nil {
+		t.Fatalf("withProfileSessions: results=%+v err=%v", results, err)
+	}
+
+	b, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	want := fmt.Sprintf("OPTION ttyname=/dev/pts/9\nSETTITLE Dotward\nSETDESC Encrypting:%%0A  %s%%0A  %s\nSETPROMPT Password:\nSETREPEAT Confirm password:\nGETPIN\nBYE\n", files[0], files[1])
+	if string(b) != want {
+		t.Fatalf("pinentry commands:\n%s\nwant:\n%s", b, want)
+	}
+}
+
+func TestProfileSessionUsesCachedKeys(t *testing.T) {
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, ".env")
+	if err := os.WriteFile(plainPath, []byte("K=v\n"), 0o600); err != nil {
+		t.Fatalf("write plaintext: %v", err)
+	}
+	settings := core.Settings{KeyringTTL: 10 * time.Second}
+	prompted := 0
+	read := func(string) ([]byte, error) {
+		prompted++
+		return []byte("pw"), nil
+	}
+
+	sess, err := profileSession(settings, "", []string{plainPath}, false, "Password: ", read)
+	if err != nil {
+		t.Fatalf("profileSession: %v", err)
+	}
+	if err := sess.EncryptFile(plainPath, plainPath+".enc", cryptopkg.Metadata{}); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	cacheDerivedKeys(settings, sess)
+	sess.Close()
+	if prompted != 1 {
+		t.Fatalf("prompted %d times for a file without sidecar, want 1", prompted)
+	}
+	h, err := cryptopkg.Inspect(plainPath + ".enc")
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if _, err := keyring.Get(keyringName(h.Salt)); err != nil {
+		t.Skipf("no kernel keyring: %v", err)
+	}
+
+	sess, err = profileSession(settings, "", []string{plainPath}, false, "Password: ", read)
+	if err != nil {
+		t.Fatalf("profileSession: %v", err)
+	}
+	defer sess.Close()
+	if got, err := sess.Decrypt(plainPath + ".enc"); err != nil || string(got) != "K=v\n" {
+		t.Fatalf("decrypt with cached key got=%q err=%v", got, err)
+	}
+	if prompted != 1 {
+		t.Fatal("prompted although the key was cached")
+	}
+
+	if _, complete := cachedKeys(core.Settings{}, []string{plainPath}); complete {
+		t.Fatal("keys used without keyring_ttl")
+	}
+}
+
+func TestCheckPasswordStrength(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Cleanup(func() { allowWeakFlag = false })
+	settings, err := core.ParseSettings([]byte(`{"profiles": {"dev": {"min_password_score": 0}, "prod": {"min_password_score": 4}}}`))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	files := []string{filepath.Join(home, "svc", ".env")}
+
+	err = checkPasswordStrength(settings, "", files, []byte("1234"))
+	if errorCode(err) != codeWeakPassword || exitCode(err) != exitWeakPassword {
+		t.Fatalf("weak password: %v", err)
+	}
+	for _, want := range []string{"scores 0 of 4", "requires 3", "top-10 common password", "--allow-weak"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error %q lacks %q", err, want)
+		}
+	}
+	if err := checkPasswordStrength(settings, "", files, []byte("envsvc")); errorCode(err) != codeWeakPassword {
+		t.Fatalf("password made of file names: %v", err)
+	}
+	if err := checkPasswordStrength(settings, "dev", files, []byte("1234")); err != nil {
+		t.Fatalf("dev accepts any password: %v", err)
+	}
+	if err := checkPasswordStrength(settings, "", files, []byte("glacier-tinsel-omnibus")); err != nil {
+		t.Fatalf("strong password: %v", err)
+	}
+	if err := checkPasswordStrength(settings, "prod", files, []byte("kq8vzjwmp")); errorCode(err) != codeWeakPassword {
+		t.Fatalf("prod requires score 4: %v", err)
+	}
+
+	allowWeakFlag = true
+	if err := checkPasswordStrength(settings, "prod", files, []byte("1234")); err != nil {
+		t.Fatalf("--allow-weak: %v", err)
+	}
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		t.Fatalf("resolve config: %v", err)
+	}
+	entries, err := audit.Read(cfg.AuditPath, audit.Filter{})
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Event != audit.EventWeakPasswordAllowed || entries[0].Path != files[0] || entries[0].Detail != `score=0 min=4 profile="prod"` {
+		t.Fatalf("audit entries: %+v", entries)
+	}
+}
+
+func TestTOTPGatesUnlockAndSurvivesLock(t *testing.T) {
+	dir := t.TempDir()
+	settings := atomicTestSettings(t, dir)
+	secret := []byte("12345678901234567890")
+	var files []string
+	for _, name := range []string{"a.env", "b.env", "plain.env"} {
+		meta := cryptopkg.Metadata{Profile: "svc"}
+		if name != "plain.env" {
+			meta.TOTPSecret = secret
+		}
+		payload, err := cryptopkg.EncryptBytesWithMetadata([]byte("K=v\n"), []byte("svc-tamarind-oboe"), meta)
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path+".enc", payload, 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		files = append(files, path)
+	}
+
+	now := time.Unix(1111111111, 0)
+	origNow, origRead := totpNow, readTOTPCode
+	t.Cleanup(func() { totpNow, readTOTPCode = origNow, origRead })
+	totpNow = func() time.Time { return now }
+	code := "000000"
+	var prompts int
+	readTOTPCode = func(prompt string) (string, error) {
+		prompts++
+		return code, nil
+	}
+
+	cfg := core.Config{Settings: settings}
+	results, err := unlockFiles(cfg, files, unlockOptions{Permanent: true})
+	if err != nil {
+		t.Fatalf("unlockFiles: %v", err)
+	}
+	for i, r := range results[:2] {
+		if errorCode(r.err) != codeTOTPFailed {
+			t.Fatalf("file %d with a wrong code: %v", i, r.err)
+		}
+		if _, err := os.Stat(files[i]); !os.IsNotExist(err) {
+			t.Fatalf("plaintext %s written without a code: %v", files[i], err)
+		}
+	}
+	if results[2].err != nil || prompts != 2 {
+		t.Fatalf("file without TOTP: %v, %d prompts", results[2].err, prompts)
+	}
+	if err := reportResults("unlock", results[:2]); exitCode(err) != exitTOTPFailed {
+		t.Fatalf("exit code %d for %v, want %d", exitCode(err), err, exitTOTPFailed)
+	}
+
+	// A code from the previous step is within the skew window, and files
+	// sharing a secret need it once.
+	code = totp.Code(secret, now.Add(-totp.Period))
+	prompts = 0
+	results, err = unlockFiles(cfg, files[:2], unlockOptions{Permanent: true})
+	if err != nil || results[0].err != nil || results[1].err != nil || prompts != 1 {
+		t.Fatalf("unlockFiles: results=%+v err=%v prompts=%d", results, err, prompts)
+	}
+	for _, p := range files[:2] {
+		if got, err := os.ReadFile(p); err != nil || string(got) != "K=v\n" {
+			t.Fatalf("plaintext %s: %q %v", p, got, err)
+		}
+	}
+
+	// Locking again keeps the file enrolled.
+	if results, err := lockFilesNow(settings, files[:1]); err != nil || results[0].err != nil {
+		t.Fatalf("lockFilesNow: %+v %v", results, err)
+	}
+	payload, err := os.ReadFile(files[0] + ".enc")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	sess := cryptopkg.NewSession([]byte("svc-tamarind-oboe"))
+	defer sess.Close()
+	if got, err := sess.TOTPSecret(payload); err != nil || !bytes.Equal(got, secret) {
+		t.Fatalf("TOTP secret after lock: %q %v", got, err)
+	}
+}
+
+// attemptDaemon keeps count of failed unlock attempts like Dotward.app.
+type attemptDaemon struct {
+	failures     *core.Failures
+	now          time.Time
+	lockoutAfter int
+	attempts     int
+	succeeded    []string
+}
+
+func (d *attemptDaemon) ReserveUnlock(req ipc.Request, resp *ipc.Response) error {
+	d.attempts++
+	resp.Attempt = fmt.Sprint(d.attempts)
+	_, err := d.failures.Reserve(req.Sidecar, req.Path, resp.Attempt, d.now, d.lockoutAfter)
+	var backoff *core.BackoffError
+	if errors.As(err, &backoff) {
+		resp.TTL = backoff.Wait
+	}
+	resp.Success = err == nil
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return nil
+}
+
+func (d *attemptDaemon) UnlockFailed(req ipc.Request, resp *ipc.Response) error {
+	d.failures.Settle(req.Sidecar, req.Attempt)
+	d.failures.Fail(req.Sidecar, req.Path, d.now, d.lockoutAfter)
+	resp.Success = true
+	return nil
+}
+
+func (d *attemptDaemon) UnlockSucceeded(req ipc.Request, resp *ipc.Response) error {
+	if !d.failures.Settle(req.Sidecar, req.Attempt) {
+		return fmt.Errorf("attempt %q not in progress", req.Attempt)
+	}
+	d.failures.Succeed(req.Sidecar)
+	d.succeeded = append(d.succeeded, req.Path)
+	resp.Success = true
+	return nil
+}
+
+func (d *attemptDaemon) UseTOTPStep(req ipc.Request, resp *ipc.Response) error {
+	resp.Success = d.failures.UseTOTPStep(req.Sidecar, req.TOTPStep)
+	return nil
+}
+
+func TestTOTPCodeCannotBeReused(t *testing.T) {
+	dir := t.TempDir()
+	settings := atomicTestSettings(t, dir)
+	secret := []byte("12345678901234567890")
+	path := filepath.Join(dir, "a.env")
+	payload, err := cryptopkg.EncryptBytesWithMetadata([]byte("K=v\n"), []byte("svc-tamarind-oboe"), cryptopkg.Metadata{Profile: "svc", TOTPSecret: secret})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if err := os.WriteFile(path+".enc", payload, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	now := time.Unix(1111111111, 0)
+	origNow, origRead := totpNow, readTOTPCode
+	t.Cleanup(func() { totpNow, readTOTPCode = origNow, origRead })
+	totpNow = func() time.Time { return now }
+	code := totp.Code(secret, now)
+	readTOTPCode = func(string) (string, error) { return code, nil }
+
+	d := &attemptDaemon{failures: core.NewFailures(), now: now}
+	cfg := core.Config{Settings: settings, SockPath: serveManager(t, d)}
+	unlockOnce := func() error {
+		t.Helper()
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			t.Fatalf("remove: %v", err)
+		}
+		results, err := unlockFiles(cfg, []string{path}, unlockOptions{Permanent: true})
+		if err != nil {
+			t.Fatalf("unlockFiles: %v", err)
+		}
+		return results[0].err
+	}
+
+	if err := unlockOnce(); err != nil {
+		t.Fatalf("first use of the code: %v", err)
+	}
+	// The same code, and one of an earlier step, are refused while still
+	// within the skew window.
+	for _, c := range []string{code, totp.Code(secret, now.Add(-totp.Period))} {
+		code = c
+		d.now = d.now.Add(time.Minute)
+		if err := unlockOnce(); !errors.Is(err, errReusedTOTPCode) || errorCode(err) != codeTOTPFailed {
+			t.Fatalf("reused code: %v", err)
+		}
+	}
+	code = totp.Code(secret, now.Add(totp.Period))
+	d.now = d.now.Add(time.Hour)
+	if err := unlockOnce(); err != nil {
+		t.Fatalf("code of the next step: %v", err)
+	}
+}
+
+func TestUnlockReportsFailedAttempts(t *testing.T) {
+	dir := t.TempDir()
+	settings := atomicTestSettings(t, dir)
+	path := filepath.Join(dir, "a.env")
+	encrypt := func(pw string) {
+		t.Helper()
+		payload, err := cryptopkg.EncryptBytesWithMetadata([]byte("K=v\n"), []byte(pw), cryptopkg.Metadata{Profile: "svc"})
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		if err := os.WriteFile(path+".enc", payload, 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	encrypt("another-passphrase")
+
+	d := &attemptDaemon{failures: core.NewFailures(), now: time.Unix(1700000000, 0), lockoutAfter: 2}
+	cfg := core.Config{Settings: settings, SockPath: serveManager(t, d)}
+	unlockOnce := func() error {
+		t.Helper()
+		results, err := unlockFiles(cfg, []string{path}, unlockOptions{Permanent: true})
+		if err != nil {
+			return err
+		}
+		return reportResults("unlock", results)
+	}
+
+	if err := unlockOnce(); exitCode(err) != exitWrongPassword {
+		t.Fatalf("first attempt: exit %d for %v", exitCode(err), err)
+	}
+	if err := unlockOnce(); exitCode(err) != exitBackoff {
+		t.Fatalf("attempt during the backoff: exit %d for %v", exitCode(err), err)
+	}
+	d.now = d.now.Add(time.Second)
+	if err := unlockOnce(); exitCode(err) != exitWrongPassword {
+		t.Fatalf("attempt after the backoff: exit %d for %v", exitCode(err), err)
+	}
+	d.now = d.now.Add(time.Hour)
+	if err := unlockOnce(); exitCode(err) != exitLockedOut {
+		t.Fatalf("attempt after two failures: exit %d for %v", exitCode(err), err)
+	}
+
+	// A copy of the sidecar under another name is still locked out.
+	copied := filepath.Join(dir, "x1.env")
+	payload, err := os.ReadFile(path + ".enc")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := os.WriteFile(copied+".enc", payload, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	results, err := unlockFiles(cfg, []string{copied}, unlockOptions{Permanent: true})
+	if err != nil {
+		t.Fatalf("unlockFiles: %v", err)
+	}
+	if err := reportResults("unlock", results); exitCode(err) != exitLockedOut {
+		t.Fatalf("attempt on a copied sidecar: exit %d for %v", exitCode(err), err)
+	}
+
+	encrypt("svc-tamarind-oboe")
+	d.failures.Clear("", path)
+	if err := unlockOnce(); err != nil {
+		t.Fatalf("unlock after clearing: %v", err)
+	}
+	if len(d.succeeded) != 1 || d.succeeded[0] != path || len(d.failures.Snapshot()) != 0 {
+		t.Fatalf("success not reported: %v %v", d.succeeded, d.failures.Snapshot())
+	}
+}
+
+func TestUpdateReportsFailedAttempts(t *testing.T) {
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, ".env")
+	if err := os.WriteFile(plainPath, []byte("TOKEN=old\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := cryptopkg.EncryptFile(plainPath, plainPath+".enc", []byte("1234")); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	d := &attemptDaemon{failures: core.NewFailures(), now: time.Unix(1700000000, 0)}
+	gate := newUnlockGate(serveManager(t, d))
+	defer gate.close()
+	if _, err := updateOneFile(plainPath, cryptopkg.NewSession([]byte("12345")), gate, "", false); errorCode(err) != codeWrongPassword {
+		t.Fatalf("wrong password: %v", err)
+	}
+	if list := d.failures.Snapshot(); len(list) != 1 || list[0].Path != plainPath {
+		t.Fatalf("failed attempt not reported: %+v", list)
+	}
+	if _, err := updateOneFile(plainPath, cryptopkg.NewSession([]byte("1234")), gate, "", false); errorCode(err) != codeBackoff {
+		t.Fatalf("attempt during the backoff: %v", err)
+	}
+}
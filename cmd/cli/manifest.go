@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/stefanos/dotward/internal/manifest"
+)
+
+// projectFiles returns the files selected by the project manifest found from
+// the working directory, for commands run without file arguments.
+func projectFiles() ([]manifest.Entry, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve working directory: %w", err)
+	}
+	path, err := manifest.Find(wd)
+	if errors.Is(err, manifest.ErrNotFound) {
+		return nil, fmt.Errorf("no files given and %w in %s or its parents", err, wd)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m, err := manifest.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := m.Entries()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("manifest %s selects no files", path)
+	}
+	fmt.Fprintf(os.Stderr, "Using manifest %s\n", path)
+	return entries, nil
+}
+
+// projectUnlockFiles returns every manifest file and records the TTLs and
+// profiles the manifest sets for them in opts, keyed by path.
+func projectUnlockFiles(opts *unlockOptions) ([]string, error) {
+	entries, err := projectFiles()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(entries))
+	opts.FileTTLs = make(map[string]time.Duration)
+	opts.FileProfiles = make(map[string]string)
+	for _, e := range entries {
+		files = append(files, e.Path)
+		if e.TTL > 0 {
+			opts.FileTTLs[e.Path] = e.TTL
+		}
+		if e.Profile != "" {
+			opts.FileProfiles[e.Path] = e.Profile
+		}
+	}
+	return files, nil
+}
+
+// projectPlaintextFiles returns the manifest files that are currently
+// unlocked and the profiles the manifest sets for them. Locked files have
+// nothing to lock or update and are skipped.
+func projectPlaintextFiles() ([]string, map[string]string, error) {
+	entries, err := projectFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []string
+	profiles := make(map[string]string)
+	for _, e := range entries {
+		if _, err := os.Stat(e.Path); err == nil {
+			files = append(files, e.Path)
+			if e.Profile != "" {
+				profiles[e.Path] = e.Profile
+			}
+		}
+	}
+	return files, profiles, nil
+}
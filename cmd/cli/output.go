@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/scan"
+)
+
+// Exit codes. They are stable, so tooling may rely on them. dotward scan,
+// git pre-commit, git merge-driver and unlock --while document their own.
+const (
+	exitFailed            = 1
+	exitUsage             = 2
+	exitWrongPassword     = 3
+	exitNoPassword        = 4
+	exitMissingSidecar    = 5
+	exitProfile           = 6
+	exitDaemonUnreachable = 7
+	exitDaemonRejected    = 8
+	exitWeakPassword      = 9
+	exitTOTPFailed        = 10
+	exitBackoff           = 11
+	exitLockedOut         = 12
+	exitMissingPlaintext  = 13
+)
+
+// Error codes reported by --output json. They are stable, so tooling may
+// rely on them.
+const (
+	codeFailed            = "failed"
+	codeUsage             = "usage"
+	codeWrongPassword     = "wrong_password"
+	codeNoPassword        = "password_unavailable"
+	codeMissingSidecar    = "missing_sidecar"
+	codeMissingPlaintext  = "missing_plaintext"
+	codeProfile           = "profile"
+	codeDaemonUnreachable = "daemon_unreachable"
+	codeDaemonRejected    = "daemon_rejected"
+	codeRolledBack        = "rolled_back"
+	codeWeakPassword      = "weak_password"
+	codeTOTPFailed        = "totp_failed"
+	codeBackoff           = "backoff"
+	codeLockedOut         = "locked_out"
+)
+
+var codeExits = map[string]int{
+	codeUsage:             exitUsage,
+	codeWrongPassword:     exitWrongPassword,
+	codeNoPassword:        exitNoPassword,
+	codeMissingSidecar:    exitMissingSidecar,
+	codeMissingPlaintext:  exitMissingPlaintext,
+	codeProfile:           exitProfile,
+	codeDaemonUnreachable: exitDaemonUnreachable,
+	codeDaemonRejected:    exitDaemonRejected,
+	codeWeakPassword:      exitWeakPassword,
+	codeTOTPFailed:        exitTOTPFailed,
+	codeBackoff:           exitBackoff,
+	codeLockedOut:         exitLockedOut,
+}
+
+// codedError attaches an error code to an error.
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+func withCode(code string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+func usageError(format string, args ...any) error {
+	return withCode(codeUsage, fmt.Errorf(format, args...))
+}
+
+// errDaemonUnreachable is returned when Dotward.app does not answer.
+var errDaemonUnreachable = withCode(codeDaemonUnreachable, errors.New("please start Dotward.app"))
+
+// errorCode returns the code of err.
+func errorCode(err error) string {
+	var ce *codedError
+	switch {
+	case errors.As(err, &ce):
+		return ce.code
+	case errors.Is(err, cryptopkg.ErrDecrypt):
+		return codeWrongPassword
+	case strings.HasPrefix(err.Error(), "unknown command "):
+		return codeUsage
+	}
+	return codeFailed
+}
+
+// exitCode returns the exit status for the error a command returned.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	if code, ok := codeExits[errorCode(err)]; ok {
+		return code
+	}
+	return exitFailed
+}
+
+// decryptError describes a failure to decrypt encPath, noting a missing
+// sidecar.
+func decryptError(encPath string, err error) error {
+	err = fmt.Errorf("failed to decrypt %q: %w", encPath, err)
+	if errors.Is(err, os.ErrNotExist) {
+		return withCode(codeMissingSidecar, err)
+	}
+	return err
+}
+
+// outputFlag is the global --output flag.
+var outputFlag string
+
+// jsonOutputAnnotation marks commands that support --output json: those
+// that report on secret files, whose results scripts act on, and version.
+// Commands that manage Dotward itself print text only.
+const jsonOutputAnnotation = "dotward/output-json"
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&outputFlag, "output", "text", `output format: "text" or "json" (json: unlock, lock, update, batch-lock, batch-unlock, cat, scan, version)`)
+	rootCmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return withCode(codeUsage, err)
+	})
+	for _, cmd := range []*cobra.Command{unlockCmd, catCmd, updateCmd, lockCmd, batchLockCmd, batchUnlockCmd, scanCmd, versionCmd} {
+		if cmd.Annotations == nil {
+			cmd.Annotations = make(map[string]string)
+		}
+		cmd.Annotations[jsonOutputAnnotation] = "true"
+	}
+}
+
+func checkOutputFlag(cmd *cobra.Command, _ []string) error {
+	switch outputFlag {
+	case "text":
+		return nil
+	case "json":
+	default:
+		return usageError("invalid --output %q: want text or json", outputFlag)
+	}
+	// The JSON report carries errors, so cobra must not print them too.
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	if cmd.Annotations[jsonOutputAnnotation] == "" {
+		return usageError("%s does not support --output json; only commands that report on secret files, and version, do", cmd.CommandPath())
+	}
+	return nil
+}
+
+func jsonOutput() bool {
+	return outputFlag == "json"
+}
+
+// markUsageErrors gives argument validation errors of cmd and its
+// subcommands the usage code.
+func markUsageErrors(cmd *cobra.Command) {
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(cmd *cobra.Command, a []string) error {
+			return withCode(codeUsage, args(cmd, a))
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		markUsageErrors(sub)
+	}
+}
+
+// promptWriter is where password prompts go: stdout, unless stdout carries
+// the JSON report.
+func promptWriter() io.Writer {
+	if jsonOutput() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// report is the document printed by --output json.
+type report struct {
+	Command  string         `json:"command"`
+	OK       bool           `json:"ok"`
+	ExitCode int            `json:"exit_code"`
+	Error    *errorReport   `json:"error,omitempty"`
+	Files    []fileReport   `json:"files,omitempty"`
+	Version  *versionReport `json:"version,omitempty"`
+	Scan     *scan.Report   `json:"scan,omitempty"`
+}
+
+type errorReport struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type fileReport struct {
+	// File is the path as given on the command line or in a paths file.
+	File    string `json:"file"`
+	OK      bool   `json:"ok"`
+	Path    string `json:"path,omitempty"`
+	Sidecar string `json:"sidecar,omitempty"`
+	Profile string `json:"profile,omitempty"`
+	// TTLSeconds is how long an unlocked file stays unlocked.
+	TTLSeconds int64        `json:"ttl_seconds,omitempty"`
+	Error      *errorReport `json:"error,omitempty"`
+	// FormatVersion and Content are set by cat.
+	FormatVersion int     `json:"format_version,omitempty"`
+	Content       *string `json:"content,omitempty"`
+}
+
+type versionReport struct {
+	Component string `json:"component"`
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Built     string `json:"built"`
+	BuiltBy   string `json:"built_by"`
+}
+
+// jsonReport collects what the running command reports under --output json.
+var jsonReport report
+
+func newErrorReport(err error) *errorReport {
+	if err == nil {
+		return nil
+	}
+	return &errorReport{Code: errorCode(err), Message: err.Error()}
+}
+
+// writeReport prints the JSON report of cmd, which returned err.
+func writeReport(w io.Writer, cmd *cobra.Command, err error) error {
+	r := jsonReport
+	r.Command = strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" ")
+	r.OK = err == nil
+	r.ExitCode = exitCode(err)
+	r.Error = newErrorReport(err)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// reportResults prints the outcome of each file, or adds it to the JSON
+// report. When files failed, the error names command and exits with the
+// status of their common failure, or exitFailed when they differ.
+func reportResults(command string, results []fileResult) error {
+	var failed int
+	if jsonOutput() {
+		for _, r := range results {
+			if r.err != nil {
+				failed++
+			}
+			jsonReport.Files = append(jsonReport.Files, r.report())
+		}
+	} else {
+		failed = printResults(results)
+	}
+	if failed == 0 {
+		return nil
+	}
+	code := resultsCode(results)
+	err := withCode(code, fmt.Errorf("%s completed with %d failure(s)", command, failed))
+	status, ok := codeExits[code]
+	if !ok {
+		status = exitFailed
+	}
+	return &exitError{code: status, err: err}
+}
+
+// resultsCode returns the code shared by every failure of results, not
+// counting files rolled back because of others.
+func resultsCode(results []fileResult) string {
+	code := ""
+	for _, r := range results {
+		if r.err == nil {
+			continue
+		}
+		c := errorCode(r.err)
+		switch {
+		case c == codeRolledBack:
+		case code == "":
+			code = c
+		case code != c:
+			return codeFailed
+		}
+	}
+	if code == "" {
+		return codeFailed
+	}
+	return code
+}
+
+func (r fileResult) report() fileReport {
+	fr := fileReport{File: r.file, OK: r.err == nil, Profile: r.profile, Error: newErrorReport(r.err)}
+	if absPath, encPath, err := resolveUnlockPaths(r.file); err == nil {
+		fr.Path, fr.Sidecar = absPath, encPath
+	}
+	if r.ttl > 0 {
+		fr.TTLSeconds = int64(r.ttl.Seconds())
+	}
+	return fr
+}
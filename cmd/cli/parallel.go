@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/sysmem"
+)
+
+// fileResult is the outcome of one file of a batch.
+type fileResult struct {
+	file    string
+	profile string
+	// msg is printed when the file succeeded.
+	msg string
+	// ttl is how long an unlocked file stays unlocked.
+	ttl time.Duration
+	err error
+}
+
+// printResults prints the outcome of each file in order, followed by a
+// summary for batches of more than one file, and returns the number of
+// failures.
+func printResults(results []fileResult) int {
+	var failed int
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "FAILED %s: %v\n", r.file, r.err)
+			continue
+		}
+		if r.msg != "" {
+			fmt.Println(r.msg)
+		}
+	}
+	if len(results) > 1 {
+		fmt.Printf("%d of %d file(s) succeeded\n", len(results)-failed, len(results))
+	}
+	return failed
+}
+
+// batchWorkers returns how many of n files to process at once: one per CPU,
+// but no more Argon2id derivations side by side than available memory
+// holds, since every file in an older format derives a key of its own.
+func batchWorkers(n int) int {
+	workers := runtime.NumCPU()
+	if avail, ok := sysmem.Available(); ok {
+		// Leave half of the available memory to everything else.
+		workers = min(workers, int(avail/2/cryptopkg.KeyDerivationMemory()))
+	}
+	return max(1, min(workers, n))
+}
+
+// runParallel calls fn for 0..n-1 on batchWorkers(n) goroutines, showing
+// progress as "<verb> i/n" when stderr is a terminal.
+func runParallel(verb string, n int, fn func(i int)) {
+	p := newProgress(verb, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range batchWorkers(n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+				p.step()
+			}
+		}()
+	}
+	for i := range n {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	p.finish()
+}
+
+// progress redraws a single status line on a terminal stderr.
+type progress struct {
+	mu    sync.Mutex
+	verb  string
+	done  int
+	total int
+	show  bool
+}
+
+func newProgress(verb string, total int) *progress {
+	p := &progress{verb: verb, total: total, show: total > 1 && term.IsTerminal(int(os.Stderr.Fd()))}
+	p.draw()
+	return p
+}
+
+func (p *progress) step() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.draw()
+}
+
+func (p *progress) draw() {
+	if p.show {
+		fmt.Fprintf(os.Stderr, "\r\033[K%s %d/%d", p.verb, p.done, p.total)
+	}
+}
+
+// finish clears the status line.
+func (p *progress) finish() {
+	if p.show {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
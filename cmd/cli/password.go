@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// passwordFileEnv names a file holding the password, like --password-file.
+const passwordFileEnv = "DOTWARD_PASSWORD_FILE"
+
+// maxPasswordSize bounds what --password-stdin and --password-file read, so
+// that the buffer holding the password never grows and leaves copies behind.
+const maxPasswordSize = 4096
+
+var (
+	passwordStdinFlag bool
+	passwordFileFlag  string
+)
+
+// stdinPassword holds the password read by --password-stdin, since stdin can
+// only be read once.
+var stdinPassword []byte
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&passwordStdinFlag, "password-stdin", false, "read the password from stdin")
+	rootCmd.PersistentFlags().StringVar(&passwordFileFlag, "password-file", "", "read the password from a file (default: $"+passwordFileEnv+")")
+}
+
+func checkPasswordFlags(cmd *cobra.Command) error {
+	if !passwordStdinFlag {
+		return nil
+	}
+	if passwordFileFlag != "" {
+		return usageError("--password-stdin cannot be combined with --password-file")
+	}
+	if cmd == gitFilterCleanCmd || cmd == gitFilterSmudgeCmd {
+		return usageError("--password-stdin cannot be used by git filters, which read file contents from stdin")
+	}
+	return nil
+}
+
+// givenPassword returns a copy of the password given by --password-stdin,
+// --password-file or DOTWARD_PASSWORD_FILE, in that order, and whether one
+// of them is set.
+func givenPassword() ([]byte, bool, error) {
+	switch {
+	case passwordStdinFlag:
+		if stdinPassword == nil {
+			pw, err := readPasswordFrom(os.Stdin, "stdin")
+			if err != nil {
+				return nil, true, err
+			}
+			stdinPassword = pw
+		}
+		return append([]byte(nil), stdinPassword...), true, nil
+	case passwordFileFlag != "":
+		pw, err := readPasswordFile(passwordFileFlag)
+		return pw, true, err
+	}
+	if path := os.Getenv(passwordFileEnv); path != "" {
+		pw, err := readPasswordFile(path)
+		return pw, true, err
+	}
+	return nil, false, nil
+}
+
+// forgetGivenPassword zeroes the password read from stdin.
+func forgetGivenPassword() {
+	zeroBytes(stdinPassword)
+	stdinPassword = nil
+}
+
+func readPasswordFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open password file: %w", err)
+	}
+	defer f.Close()
+	return readPasswordFrom(f, fmt.Sprintf("password file %q", path))
+}
+
+// readPasswordFrom reads a password from r, which is all of its content
+// without the trailing newline. name describes r in errors, which never
+// include the content.
+func readPasswordFrom(r io.Reader, name string) ([]byte, error) {
+	buf := make([]byte, maxPasswordSize+1)
+	defer zeroBytes(buf)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("failed to read password from %s: %w", name, err)
+	}
+	if n > maxPasswordSize {
+		return nil, fmt.Errorf("password from %s is longer than %d bytes", name, maxPasswordSize)
+	}
+	pw := bytes.TrimRight(buf[:n], "\r\n")
+	if len(bytes.TrimSpace(pw)) == 0 {
+		return nil, fmt.Errorf("password from %s is empty", name)
+	}
+	return append([]byte(nil), pw...), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/stefanos/dotward/internal/core"
+	"github.com/stefanos/dotward/internal/pinentry"
+)
+
+// maxDescribedFiles is how many files a pinentry description lists by name.
+const maxDescribedFiles = 5
+
+// passwordReader returns how to prompt for a password: through the program
+// of the pinentry setting, which shows desc, or else with read. With a
+// non-empty confirmPrompt the password is asked for twice.
+func passwordReader(settings core.Settings, desc, confirmPrompt string, read func(string) ([]byte, error)) func(string) ([]byte, error) {
+	if settings.Pinentry != "" {
+		return func(prompt string) ([]byte, error) {
+			return pinentryPassword(settings.Pinentry, desc, prompt, confirmPrompt)
+		}
+	}
+	if confirmPrompt != "" {
+		return func(prompt string) ([]byte, error) {
+			return readPasswordWithConfirmation(read, prompt, confirmPrompt)
+		}
+	}
+	return read
+}
+
+func pinentryPassword(program, desc, prompt, confirmPrompt string) ([]byte, error) {
+	pw, err := pinentry.GetPIN(program, pinentry.Prompt{
+		Title:       "Dotward",
+		Description: desc,
+		Prompt:      strings.TrimSpace(prompt),
+		Repeat:      strings.TrimSpace(confirmPrompt),
+		TTYName:     ttyName(),
+		TTYType:     os.Getenv("TERM"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password: %w", err)
+	}
+	if len(bytes.TrimSpace(pw)) == 0 {
+		zeroBytes(pw)
+		return nil, errors.New("password cannot be empty")
+	}
+	return pw, nil
+}
+
+// ttyName returns the terminal a terminal pinentry should draw on: $GPG_TTY,
+// as for gpg, or the terminal of stdin or stderr where it can be found.
+func ttyName() string {
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		return tty
+	}
+	for _, f := range []*os.File{os.Stdin, os.Stderr} {
+		if !term.IsTerminal(int(f.Fd())) {
+			continue
+		}
+		if name, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", f.Fd())); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// filesDescription describes what a password is asked for, such as
+// "Decrypting for profile prod:" followed by the files, one per line.
+func filesDescription(verb, profile string, files []string) string {
+	var b strings.Builder
+	b.WriteString(verb)
+	if profile != "" {
+		fmt.Fprintf(&b, " for profile %s", profile)
+	}
+	b.WriteString(":")
+	for i, file := range files {
+		if i == maxDescribedFiles {
+			fmt.Fprintf(&b, "\n  and %d more", len(files)-i)
+			break
+		}
+		b.WriteString("\n  " + file)
+	}
+	return b.String()
+}
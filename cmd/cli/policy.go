@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stefanos/dotward/internal/core"
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Inspect per-path TTL policies",
+}
+
+var policyExplainCmd = &cobra.Command{
+	Use:   "explain <file>",
+	Short: "Show which TTL policy applies to a file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := core.ResolveConfig()
+		if err != nil {
+			return fmt.Errorf("failed to resolve config: %w", err)
+		}
+		absPath, encPath, err := resolveUnlockPaths(args[0])
+		if err != nil {
+			return err
+		}
+		profile, err := fileProfile(cfg.Settings, absPath, encPath, "")
+		if err != nil {
+			return err
+		}
+		fmt.Print(explainTTL(cfg.Settings, absPath, profile))
+		return nil
+	},
+}
+
+func init() {
+	policyCmd.AddCommand(policyExplainCmd)
+	rootCmd.AddCommand(policyCmd)
+}
+
+// explainTTL describes how the TTL for absPath is chosen. A profile's
+// policies come before the global ones it keeps.
+func explainTTL(s core.Settings, absPath, profile string) string {
+	s = s.ForProfile(profile)
+	res := s.ResolveTTL(absPath)
+	out := fmt.Sprintf("File: %s\n", absPath)
+	if profile != "" {
+		out += fmt.Sprintf("Profile: %s\n", profile)
+	}
+	if res.Rule < 0 {
+		if len(s.TTLPolicies) == 0 {
+			out += "No ttl_policies are configured.\n"
+		} else {
+			out += fmt.Sprintf("None of the %d ttl_policies rules match.\n", len(s.TTLPolicies))
+		}
+		return out + fmt.Sprintf("TTL: %s (default_ttl)\n", res.TTL)
+	}
+	out += fmt.Sprintf("Matched rule %d of %d: %q\n", res.Rule+1, len(s.TTLPolicies), res.Pattern)
+	return out + fmt.Sprintf("TTL: %s\n", res.TTL)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/stefanos/dotward/internal/core"
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+)
+
+// profileFlag is the global --profile flag.
+var profileFlag string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&profileFlag, "profile", "", "config profile for files encrypted for the first time; must match the profile of existing files")
+}
+
+// fileProfile returns the profile of a file: the one recorded in its
+// encrypted sidecar or, for a file that has none yet, the --profile flag,
+// the manifest's profile (hint), or the profile whose roots contain it.
+// The file must lie within the roots of its profile.
+func fileProfile(settings core.Settings, absPath, encPath, hint string) (string, error) {
+	if profileFlag != "" {
+		if _, ok := settings.Profiles[profileFlag]; !ok {
+			return "", withCode(codeProfile, fmt.Errorf("unknown profile %q; define it under \"profiles\" in the config file", profileFlag))
+		}
+	}
+
+	var profile string
+	h, err := cryptopkg.Inspect(encPath)
+	switch {
+	case err == nil:
+		profile = h.Metadata.Profile
+		if profileFlag != "" && profileFlag != profile {
+			return "", withCode(codeProfile, fmt.Errorf("%s is encrypted for profile %s, not %q", encPath, profileLabel(profile), profileFlag))
+		}
+	case profileFlag != "":
+		profile = profileFlag
+	case hint != "":
+		profile = hint
+	default:
+		profile = settings.ProfileForPath(absPath)
+	}
+	if p, ok := settings.Profiles[profile]; ok && !p.Allows(absPath) {
+		return "", withCode(codeProfile, fmt.Errorf("%s is outside the roots of profile %q", absPath, profile))
+	}
+	return profile, nil
+}
+
+func profileLabel(profile string) string {
+	if profile == "" {
+		return "default"
+	}
+	return fmt.Sprintf("%q", profile)
+}
+
+// profileGroup lists files that share a profile and so a password.
+type profileGroup struct {
+	profile string
+	files   []string
+	// indexes holds the position of each file in the batch.
+	indexes []int
+}
+
+// groupByProfile sorts files into profile groups in order of first
+// appearance. hints holds manifest profiles keyed by file argument. Files
+// whose profile cannot be determined are returned as failures, keyed by
+// their position.
+func groupByProfile(settings core.Settings, files []string, hints map[string]string) ([]profileGroup, map[int]error) {
+	var groups []profileGroup
+	index := make(map[string]int)
+	failures := make(map[int]error)
+	for n, file := range files {
+		absPath, encPath, err := resolveUnlockPaths(file)
+		if err == nil {
+			var profile string
+			if profile, err = fileProfile(settings, absPath, encPath, hints[file]); err == nil {
+				i, ok := index[profile]
+				if !ok {
+					i = len(groups)
+					index[profile] = i
+					groups = append(groups, profileGroup{profile: profile})
+				}
+				groups[i].files = append(groups[i].files, file)
+				groups[i].indexes = append(groups[i].indexes, n)
+				continue
+			}
+		}
+		failures[n] = err
+	}
+	return groups, failures
+}
+
+// withProfileSessions reads one password per profile among files, then calls
+// fn for every file, in parallel, with a crypto session for its profile, so
+// that each profile costs a single key derivation however many files it
+// has. Profiles whose keys are all cached in the kernel keyring need no
+// password. fn gets the position of the file and returns the message to print
+// for it. The results are in the order of files. When the password of the
+// only profile cannot be read, that error is returned instead. A
+// newPassword encrypts files: it is asked for twice and must meet
+// min_password_score.
+func withProfileSessions(settings core.Settings, files []string, hints map[string]string, newPassword bool, verb string, fn func(i int, file, profile string, sess *cryptopkg.Session) (string, error)) ([]fileResult, error) {
+	results := make([]fileResult, len(files))
+	for i, file := range files {
+		results[i].file = file
+	}
+	groups, failures := groupByProfile(settings, files, hints)
+	for i, err := range failures {
+		results[i].err = err
+	}
+
+	type job struct {
+		index   int
+		profile string
+		sess    *cryptopkg.Session
+	}
+	var jobs []job
+	var sessions []*cryptopkg.Session
+	for _, g := range groups {
+		confirmPrompt := ""
+		if newPassword {
+			confirmPrompt = profilePrompt("Confirm password: ", g.profile)
+		}
+		read := passwordReader(settings, filesDescription(verb, g.profile, g.files), confirmPrompt, readPassword)
+		sess, err := profileSession(settings, g.profile, g.files, newPassword, "Password: ", read)
+		if err != nil {
+			if len(groups) == 1 && len(failures) == 0 {
+				return nil, err
+			}
+			for _, i := range g.indexes {
+				results[i].err = err
+			}
+			continue
+		}
+		sessions = append(sessions, sess)
+		defer sess.Close()
+		for _, i := range g.indexes {
+			jobs = append(jobs, job{index: i, profile: g.profile, sess: sess})
+		}
+	}
+
+	runParallel(verb, len(jobs), func(k int) {
+		j := jobs[k]
+		results[j.index].profile = j.profile
+		results[j.index].msg, results[j.index].err = fn(j.index, files[j.index], j.profile, j.sess)
+	})
+	for _, sess := range sessions {
+		cacheDerivedKeys(settings, sess)
+	}
+	return results, nil
+}
+
+// profilePassword returns the password for files of profile, from the first
+// source that is set: --password-stdin, --password-file,
+// DOTWARD_PASSWORD_FILE, the profile's password_command, the global
+// password_command, or else what read returns for a prompt naming the
+// profile.
+func profilePassword(settings core.Settings, profile, prompt string, read func(string) ([]byte, error)) ([]byte, error) {
+	pw, given, err := givenPassword()
+	if !given {
+		switch command := settings.Profiles[profile].PasswordCommand; {
+		case command != "":
+			pw, err = runPasswordCommand(command)
+		case settings.PasswordCommand != "":
+			pw, err = runPasswordCommand(settings.PasswordCommand)
+		default:
+			pw, err = read(profilePrompt(prompt, profile))
+		}
+	}
+	return pw, withCode(codeNoPassword, err)
+}
+
+// profilePrompt inserts the profile name into a password prompt, so that
+// "Password: " becomes "Password for profile prod: ".
+func profilePrompt(prompt, profile string) string {
+	if profile == "" {
+		return prompt
+	}
+	i := strings.Index(prompt, " (")
+	if i < 0 {
+		i = strings.LastIndex(prompt, ":")
+	}
+	if i < 0 {
+		return prompt
+	}
+	return prompt[:i] + " for profile " + profile + prompt[i:]
+}
+
+// runPasswordCommand runs command through the shell and returns its output
+// without the trailing newline. The command may prompt on the terminal.
+func runPasswordCommand(command string) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		zeroBytes(out)
+		return nil, fmt.Errorf("password command %q failed: %w", command, err)
+	}
+	pw := append([]byte(nil), bytes.TrimRight(out, "\r\n")...)
+	zeroBytes(out)
+	if len(bytes.TrimSpace(pw)) == 0 {
+		return nil, errors.New("password command printed an empty password")
+	}
+	return pw, nil
+}
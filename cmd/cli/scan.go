@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stefanos/dotward/internal/core"
+	"github.com/stefanos/dotward/internal/scan"
+)
+
+// Exit codes of dotward scan.
+const (
+	scanExitFindings = 1
+	scanExitFailed   = 2
+)
+
+var scanCmd = &cobra.Command{
+	Use:   "scan [dir]",
+	Short: "Report unprotected plaintext secrets below a directory",
+	Long: `Report unprotected plaintext secrets below a directory (default: the
+working directory).
+
+scan reports .env files without a .enc sidecar, plaintext that git does not
+ignore, plaintext next to its sidecar that Dotward.app is not tracking, and
+sidecars that are missing or corrupt. Checks that need git or a running
+Dotward.app are skipped when those are unavailable.
+
+With --output json the report is printed under "scan" in the JSON document.
+Exit status is 0 when nothing is found, 1 when there are findings and 2 when
+the scan itself fails, so scan can run as a pre-commit check.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return err
+		}
+		if format != "text" && format != "json" {
+			return usageError("invalid --format %q: want text or json", format)
+		}
+
+		report, err := scan.Scan(dir, scanOptions())
+		if err != nil {
+			return &exitError{code: scanExitFailed, err: err}
+		}
+		switch {
+		case jsonOutput():
+			jsonReport.Scan = &report
+		case format == "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return &exitError{code: scanExitFailed, err: fmt.Errorf("failed to encode report: %w", err)}
+			}
+		default:
+			printScanReport(report)
+		}
+		if n := len(report.Findings); n > 0 {
+			return &exitError{code: scanExitFindings, err: fmt.Errorf("found %d problem(s)", n)}
+		}
+		return nil
+	},
+}
+
+func init() {
+	scanCmd.Flags().String("format", "text", `output format: "text" or "json"`)
+	_ = scanCmd.Flags().MarkDeprecated("format", "use --output json instead")
+	rootCmd.AddCommand(scanCmd)
+}
+
+// scanOptions asks the daemon which files it tracks when it is running.
+func scanOptions() scan.Options {
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		return scan.Options{SkipReason: fmt.Sprintf("failed to resolve config: %v", err)}
+	}
+	if err := ensureDaemonRunning(cfg.SockPath); err != nil {
+		return scan.Options{SkipReason: "Dotward.app is not running"}
+	}
+	return scan.Options{
+		IsWatched: func(absPath string) (bool, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			return ipcIsWatching(ctx, cfg.SockPath, absPath)
+		},
+	}
+}
+
+func printScanReport(report scan.Report) {
+	for _, f := range report.Findings {
+		fmt.Printf("%-16s %s: %s\n", f.Kind, f.Path, f.Message)
+	}
+	for _, s := range report.Skipped {
+		fmt.Fprintf(os.Stderr, "skipped %s\n", s)
+	}
+	if len(report.Findings) == 0 {
+		fmt.Printf("No problems found in %s\n", report.Root)
+	}
+}
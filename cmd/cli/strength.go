@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stefanos/dotward/internal/audit"
+	"github.com/stefanos/dotward/internal/core"
+	"github.com/stefanos/dotward/internal/strength"
+)
+
+// allowWeakFlag lets a password below min_password_score encrypt files.
+var allowWeakFlag bool
+
+func init() {
+	for _, cmd := range []*cobra.Command{lockCmd, batchLockCmd, updateCmd, initCmd} {
+		cmd.Flags().BoolVar(&allowWeakFlag, "allow-weak", false, "encrypt even with a password below min_password_score; recorded in the audit log")
+	}
+}
+
+// checkPasswordStrength refuses a password for encrypting files of profile
+// that scores below the profile's min_password_score, unless --allow-weak
+// is given, in which case the override is recorded in the audit log.
+func checkPasswordStrength(settings core.Settings, profile string, files []string, pw []byte) error {
+	minScore := settings.ForProfile(profile).MinPasswordScore
+	if minScore <= 0 {
+		return nil
+	}
+	r := strength.Estimate(pw, strengthInputs(profile, files)...)
+	if r.Score >= minScore {
+		return nil
+	}
+	if allowWeakFlag {
+		return recordWeakPassword(profile, files, r.Score, minScore)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "password is too weak: it scores %d of %d and profile %s requires %d", r.Score, strength.MaxScore, profileLabel(profile), minScore)
+	if r.Warning != "" {
+		b.WriteString(". " + r.Warning)
+	}
+	for _, s := range r.Suggestions {
+		b.WriteString(". " + strings.TrimSuffix(s, "."))
+	}
+	b.WriteString(". Pass --allow-weak to use it anyway")
+	return withCode(codeWeakPassword, errors.New(b.String()))
+}
+
+// strengthInputs are the words an attacker would try first for files: the
+// names of the tool, the profile and the files.
+func strengthInputs(profile string, files []string) []string {
+	inputs := []string{"dotward", profile}
+	notWord := func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+		base := filepath.Base(file)
+		inputs = append(inputs, base, filepath.Base(filepath.Dir(file)))
+		inputs = append(inputs, strings.FieldsFunc(base, notWord)...)
+	}
+	return inputs
+}
+
+// recordWeakPassword records in the audit log that files of profile are
+// encrypted with a password scoring below minScore. Without the record the
+// override is refused.
+func recordWeakPassword(profile string, files []string, score, minScore int) error {
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		return fmt.Errorf("failed to record --allow-weak in the audit log: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.AuditPath), 0o700); err != nil {
+		return fmt.Errorf("failed to record --allow-weak in the audit log: %w", err)
+	}
+	logger := audit.NewLogger(cfg.AuditPath)
+	detail := fmt.Sprintf("score=%d min=%d profile=%s", score, minScore, profileLabel(profile))
+	for _, file := range files {
+		absPath, err := filepath.Abs(file)
+		if err != nil {
+			absPath = file
+		}
+		if err := logger.Record(audit.EventWeakPasswordAllowed, absPath, detail); err != nil {
+			return fmt.Errorf("failed to record --allow-weak in the audit log: %w", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "warning: encrypting with a weak password (score %d of %d, profile %s requires %d); recorded in the audit log\n", score, strength.MaxScore, profileLabel(profile), minScore)
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stefanos/dotward/internal/core"
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/totp"
+)
+
+// totpIssuer names Dotward in authenticator apps.
+const totpIssuer = "Dotward"
+
+// totpNow is the clock codes are checked against. Tests fix it.
+var totpNow = time.Now
+
+// readTOTPCode asks for a one-time code. Tests replace it.
+var readTOTPCode = readTerminalCode
+
+var totpCmd = &cobra.Command{
+	Use:   "totp",
+	Short: "Require a one-time code to unlock files",
+	Long:  "Require a one-time code to unlock files.\n\nEnrolled files carry a TOTP secret sealed inside their encrypted header. Unlocking them, or printing them with cat, asks for the current code of an authenticator app on top of the password.",
+}
+
+var totpEnrollCmd = &cobra.Command{
+	Use:   "enroll <files...>",
+	Short: "Seal a new TOTP secret into encrypted files and print its otpauth URI",
+	Long:  "Seal a new TOTP secret into encrypted files and print its otpauth URI.\n\nThe files given together share one secret, so that one code unlocks them all. Enrolling a file that already has a secret asks for its current code first.",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return totpEnroll(args)
+	},
+}
+
+func init() {
+	totpCmd.AddCommand(totpEnrollCmd)
+	rootCmd.AddCommand(totpCmd)
+}
+
+// totpEnroll re-encrypts the sidecars of files with one new TOTP secret and
+// prints the URI to add it to an authenticator app.
+func totpEnroll(files []string) error {
+	cfg, err := core.ResolveConfig()
+	if err != nil {
+		return fmt.Errorf("failed to resolve config: %w", err)
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return err
+	}
+	defer zeroBytes(secret)
+
+	gate := newUnlockGate(cfg.SockPath)
+	defer gate.close()
+	t := &txn{}
+	results, err := withProfileSessions(cfg.Settings, files, nil, false, "Enrolling", func(_ int, file, profile string, sess *cryptopkg.Session) (string, error) {
+		_, encPath, err := resolveUnlockPaths(file)
+		if err != nil {
+			return "", err
+		}
+		if h, err := cryptopkg.Inspect(encPath); err == nil && !h.Session {
+			return "", fmt.Errorf("%s is in an older format without room for a TOTP secret; run dotward update on it first", encPath)
+		}
+		plaintext, _, err := gate.decrypt(file, encPath, sess)
+		if err != nil {
+			return "", err
+		}
+		defer zeroBytes(plaintext)
+		err = t.stage(encPath, false, func(tmp string) error {
+			payload, err := sess.EncryptBytes(plaintext, cryptopkg.Metadata{Profile: profile, TOTPSecret: secret})
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(tmp, payload, 0o600)
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt %q: %w", encPath, err)
+		}
+		return fmt.Sprintf("Enrolled %s", file), nil
+	})
+	if err != nil {
+		t.rollback()
+		return err
+	}
+	// The secret is only shown once, so either every file gets it or none.
+	if abortFailed(t, results) {
+		return reportResults("totp enroll", results)
+	}
+	if err := t.commit(); err != nil {
+		markRolledBack(results, withCode(codeRolledBack, fmt.Errorf("rolled back: %w", err)))
+		return reportResults("totp enroll", results)
+	}
+	reportLeftovers(t.finish())
+	if err := reportResults("totp enroll", results); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println("Add this secret to an authenticator app, for example by turning the URI into a QR code:")
+	fmt.Println()
+	fmt.Println("  " + totp.URI(secret, totpIssuer, totpAccount(files)))
+	fmt.Println()
+	fmt.Println("Secret for manual entry: " + totp.EncodeSecret(secret))
+	fmt.Println("It is not shown again. Unlocking these files now asks for a code.")
+	return nil
+}
+
+// totpAccount names a secret in authenticator apps after the directory and
+// name of the first file it protects.
+func totpAccount(files []string) string {
+	path := files[0]
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	account := filepath.Base(filepath.Dir(path)) + "/" + filepath.Base(path)
+	if len(files) > 1 {
+		account += fmt.Sprintf(" (+%d)", len(files)-1)
+	}
+	return account
+}
+
+// sidecarMetadata returns the metadata to encrypt absPath into encPath with:
+// the profile, and the TOTP secret of the sidecar being replaced, so that
+// locking and updating keep a file enrolled. A sidecar whose secret sess
+// cannot open, because it has another password, is not replaced. sess also
+// keeps the master key salt of the sidecar, so that re-locking one file of
+// a batch does not move it onto a salt of its own. The caller zeroes the
+// TOTPSecret.
+func sidecarMetadata(encPath, profile string, sess *cryptopkg.Session) (cryptopkg.Metadata, error) {
+	meta := cryptopkg.Metadata{Profile: profile}
+	payload, err := os.ReadFile(encPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, fmt.Errorf("failed to read encrypted file %q: %w", encPath, err)
+	}
+	h, err := cryptopkg.InspectBytes(payload)
+	if err != nil {
+		return meta, nil
+	}
+	if h.Session {
+		sess.KeepSalt(h.Salt)
+	}
+	if len(h.Metadata.TOTP) == 0 {
+		return meta, nil
+	}
+	if meta.TOTPSecret, err = sess.TOTPSecret(payload); err != nil {
+		return meta, fmt.Errorf("failed to keep the TOTP secret of %q: %w", encPath, err)
+	}
+	return meta, nil
+}
+
+// readTerminalCode reads a code from the controlling terminal, so that it
+// works with --password-stdin, or else from stdin.
+func readTerminalCode(prompt string) (string, error) {
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		fmt.Fprint(tty, prompt)
+		return readCodeLine(tty)
+	}
+	if passwordStdinFlag {
+		return "", errors.New("no terminal to ask for it")
+	}
+	fmt.Fprint(promptWriter(), prompt)
+	return readCodeLine(os.Stdin)
+}
+
+func readCodeLine(f *os.File) (string, error) {
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read code: %w", err)
+	}
+	code := strings.TrimSpace(line)
+	if code == "" {
+		return "", errors.New("code cannot be empty")
+	}
+	return code, nil
+}
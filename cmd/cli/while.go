@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// splitWhileArgs separates the files to unlock from the command given after
+// "--" when --while is set. No files before "--" means the project manifest.
+func splitWhileArgs(args []string, dashAt int, while bool) ([]string, []string, error) {
+	if !while {
+		if dashAt >= 0 {
+			return nil, nil, errors.New("arguments after -- are only accepted with --while")
+		}
+		return args, nil, nil
+	}
+	if dashAt < 0 || dashAt >= len(args) {
+		return nil, nil, errors.New("--while requires a command after --")
+	}
+	return args[:dashAt], args[dashAt:], nil
+}
+
+// unlockWhile unlocks files bound to the lifetime of this process, then runs
+// command and waits for it. The daemon locks the files as soon as this process
+// exits, so plaintext never outlives the command.
+func unlockWhile(files []string, command []string, opts unlockOptions) error {
+	opts.PID = os.Getpid()
+	if err := unlock(files, opts); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %q: %w", command[0], err)
+	}
+
+	// Forward termination signals so the command can shut down on its own
+	// terms; this process keeps running until it does.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-sigCh:
+				_ = cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		if code <= 0 {
+			code = 1
+		}
+		return &exitError{code: code, err: fmt.Errorf("%s exited with status %d", command[0], code)}
+	}
+	return fmt.Errorf("failed to run %q: %w", command[0], err)
+}
@@ -0,0 +1,300 @@
+// Package audit maintains an append-only, hash-chained JSONL record of secret
+// access.
+//
+// Every entry stores the hash of the entry before it, and its own hash covers
+// all of its fields including that link. Editing, reordering or removing an
+// entry therefore breaks the chain at that point, which Verify reports.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event names recorded by Dotward.
+const (
+	EventRegister       = "register"
+	EventRegisterDenied = "register_denied"
+	EventExtend         = "extend"
+	EventExtendDenied   = "extend_denied"
+	EventLock           = "lock"
+	EventExpire         = "expire"
+	EventDeleteFailed   = "delete_failed"
+	EventMenuRemove     = "menu_remove"
+	EventLockAll        = "lock_all"
+	EventProcessExit    = "process_exit"
+	EventSessionLock    = "session_lock"
+	// EventUnlockFailed is recorded by the daemon when the CLI reports a
+	// wrong password or TOTP code, EventLockedOut when that locks the file
+	// out, EventLockoutCleared when dotward lockout clear lifts the lockout
+	// and EventFailuresCleared when it forgets failures of a file that was
+	// not locked out.
+	EventUnlockFailed    = "unlock_failed"
+	EventLockedOut       = "locked_out"
+	EventLockoutCleared  = "lockout_cleared"
+	EventFailuresCleared = "failures_cleared"
+	// EventWeakPasswordAllowed is recorded by the CLI when --allow-weak
+	// encrypts a file with a password below min_password_score.
+	EventWeakPasswordAllowed = "weak_password_allowed"
+)
+
+// Entry is a single audit record.
+type Entry struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Path     string    `json:"path,omitempty"`
+	User     string    `json:"user,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+// computeHash returns the hex SHA-256 of the entry's JSON encoding with the
+// hash field left empty.
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Logger appends entries to an audit file. It is safe for concurrent use, and
+// an advisory file lock keeps separate processes from interleaving writes.
+type Logger struct {
+	mu   sync.Mutex
+	path string
+	user string
+	now  func() time.Time
+}
+
+// NewLogger returns a logger that appends to path, creating it on first use.
+func NewLogger(path string) *Logger {
+	name := ""
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return &Logger{path: path, user: name, now: time.Now}
+}
+
+// Path returns the audit file location.
+func (l *Logger) Path() string {
+	return l.path
+}
+
+// Record appends an entry linked to the current end of the chain.
+func (l *Logger) Record(event, path, detail string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %q: %w", l.path, err)
+	}
+	defer f.Close()
+
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("failed to lock audit log %q: %w", l.path, err)
+	}
+	defer unlockFile(f)
+
+	last, err := readLastEntry(f)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log tail %q: %w", l.path, err)
+	}
+
+	entry := Entry{
+		Seq:      last.Seq + 1,
+		Time:     l.now().UTC(),
+		Event:    event,
+		Path:     path,
+		User:     l.user,
+		Detail:   detail,
+		PrevHash: last.Hash,
+	}
+	entry.Hash, err = entry.computeHash()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("failed to seek audit log %q: %w", l.path, err)
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to append audit entry to %q: %w", l.path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log %q: %w", l.path, err)
+	}
+	return nil
+}
+
+// readLastEntry returns the final entry in f, or a zero Entry when f is empty.
+func readLastEntry(f *os.File) (Entry, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return Entry{}, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return Entry{}, nil
+	}
+
+	const chunk = 64 * 1024
+	start := size - chunk
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, size-start)
+	if _, err := f.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return Entry{}, err
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		buf = buf[i+1:]
+	} else if start > 0 {
+		return Entry{}, errors.New("last audit entry is too large")
+	}
+
+	var last Entry
+	if err := json.Unmarshal(buf, &last); err != nil {
+		return Entry{}, fmt.Errorf("failed to decode last audit entry: %w", err)
+	}
+	return last, nil
+}
+
+// ChainError describes the first point at which an audit file stops being a
+// valid chain.
+type ChainError struct {
+	Line   int
+	Seq    uint64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	if e.Seq == 0 {
+		return fmt.Sprintf("audit log line %d: %s", e.Line, e.Reason)
+	}
+	return fmt.Sprintf("audit log line %d (seq %d): %s", e.Line, e.Seq, e.Reason)
+}
+
+// Verify walks the whole file and checks sequence numbers, hash links and
+// entry hashes. It returns the number of valid entries. A missing file is an
+// empty, valid log.
+func Verify(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to open audit log %q: %w", path, err)
+	}
+	defer f.Close()
+
+	var prev Entry
+	count := 0
+	err = scanEntries(f, func(line int, e Entry) error {
+		if e.Seq != prev.Seq+1 {
+			return &ChainError{Line: line, Seq: e.Seq, Reason: fmt.Sprintf("expected seq %d", prev.Seq+1)}
+		}
+		if e.PrevHash != prev.Hash {
+			return &ChainError{Line: line, Seq: e.Seq, Reason: "previous hash does not match the preceding entry"}
+		}
+		want, err := e.computeHash()
+		if err != nil {
+			return err
+		}
+		if e.Hash != want {
+			return &ChainError{Line: line, Seq: e.Seq, Reason: "entry hash does not match its contents"}
+		}
+		prev = e
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// Filter selects entries in Read. Zero fields match everything.
+type Filter struct {
+	// Path matches the exact path or anything below it when it is a directory.
+	Path  string
+	Since time.Time
+	Until time.Time
+}
+
+func (flt Filter) matches(e Entry) bool {
+	if flt.Path != "" && e.Path != flt.Path && !strings.HasPrefix(e.Path, strings.TrimSuffix(flt.Path, "/")+"/") {
+		return false
+	}
+	if !flt.Since.IsZero() && e.Time.Before(flt.Since) {
+		return false
+	}
+	if !flt.Until.IsZero() && e.Time.After(flt.Until) {
+		return false
+	}
+	return true
+}
+
+// Read returns the entries matching flt in file order. It does not verify the
+// chain; use Verify for that.
+func Read(path string, flt Filter) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open audit log %q: %w", path, err)
+	}
+	defer f.Close()
+
+	var out []Entry
+	err = scanEntries(f, func(_ int, e Entry) error {
+		if flt.matches(e) {
+			out = append(out, e)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func scanEntries(r io.Reader, fn func(line int, e Entry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			return &ChainError{Line: line, Reason: "empty line"}
+		}
+		var e Entry
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return &ChainError{Line: line, Reason: fmt.Sprintf("invalid entry: %v", err)}
+		}
+		if err := fn(line, e); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed reading audit log: %w", err)
+	}
+	return nil
+}
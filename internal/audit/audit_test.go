@@ -0,0 +1,183 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogger(t *testing.T) *Logger {
+	t.Helper()
+	clock := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	l := NewLogger(filepath.Join(t.TempDir(), "audit.jsonl"))
+	l.now = func() time.Time {
+		clock = clock.Add(time.Minute)
+		return clock
+	}
+	return l
+}
+
+func recordAll(t *testing.T, l *Logger) {
+	t.Helper()
+	events := []struct{ event, path, detail string }{
+		{EventRegister, "/work/api/.env", "ttl=1h0m0s"},
+		{EventExtend, "/work/api/.env", "ttl=1h0m0s"},
+		{EventRegister, "/work/web/.env", "ttl=10m0s"},
+		{EventExpire, "/work/api/.env", ""},
+	}
+	for _, e := range events {
+		if err := l.Record(e.event, e.path, e.detail); err != nil {
+			t.Fatalf("record %s: %v", e.event, err)
+		}
+	}
+}
+
+func TestRecordBuildsVerifiableChain(t *testing.T) {
+	l := newTestLogger(t)
+	recordAll(t, l)
+
+	n, err := Verify(l.Path())
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if n != 4 {
+		t.Fatalf("entry count got=%d want=4", n)
+	}
+
+	entries, err := Read(l.Path(), Filter{})
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if entries[0].PrevHash != "" {
+		t.Fatalf("first entry should have an empty previous hash, got %q", entries[0].PrevHash)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].PrevHash != entries[i-1].Hash {
+			t.Fatalf("entry %d is not linked to entry %d", i+1, i)
+		}
+	}
+
+	info, err := os.Stat(l.Path())
+	if err != nil {
+		t.Fatalf("stat audit log: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("audit log permissions got=%o want=600", perm)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(lines []string) []string
+		line   int
+	}{
+		{
+			name: "edited field",
+			mutate: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], "ttl=1h0m0s", "ttl=9h0m0s", 1)
+				return lines
+			},
+			line: 2,
+		},
+		{
+			name: "removed entry",
+			mutate: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			line: 2,
+		},
+		{
+			name: "swapped entries",
+			mutate: func(lines []string) []string {
+				lines[2], lines[3] = lines[3], lines[2]
+				return lines
+			},
+			line: 3,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newTestLogger(t)
+			recordAll(t, l)
+
+			b, err := os.ReadFile(l.Path())
+			if err != nil {
+				t.Fatalf("read audit log: %v", err)
+			}
+			lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+			lines = tc.mutate(lines)
+			if err := os.WriteFile(l.Path(), []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+				t.Fatalf("write tampered log: %v", err)
+			}
+
+			_, err = Verify(l.Path())
+			var chainErr *ChainError
+			if !errors.As(err, &chainErr) {
+				t.Fatalf("expected chain error, got %v", err)
+			}
+			if chainErr.Line != tc.line {
+				t.Fatalf("chain error line got=%d want=%d (%v)", chainErr.Line, tc.line, err)
+			}
+		})
+	}
+}
+
+func TestRecordContinuesExistingChain(t *testing.T) {
+	l := newTestLogger(t)
+	recordAll(t, l)
+
+	reopened := NewLogger(l.Path())
+	if err := reopened.Record(EventLockAll, "/work/web/.env", ""); err != nil {
+		t.Fatalf("record after reopen: %v", err)
+	}
+	n, err := Verify(l.Path())
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("entry count got=%d want=5", n)
+	}
+}
+
+func TestReadFiltersByPathAndTime(t *testing.T) {
+	l := newTestLogger(t)
+	recordAll(t, l)
+
+	entries, err := Read(l.Path(), Filter{Path: "/work/api"})
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("path filter got %d entries want 3", len(entries))
+	}
+
+	entries, err = Read(l.Path(), Filter{Path: "/work/web/.env"})
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Event != EventRegister {
+		t.Fatalf("exact path filter got %+v", entries)
+	}
+
+	since := time.Date(2026, 3, 1, 9, 2, 0, 0, time.UTC)
+	until := time.Date(2026, 3, 1, 9, 3, 0, 0, time.UTC)
+	entries, err = Read(l.Path(), Filter{Since: since, Until: until})
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Seq != 2 || entries[1].Seq != 3 {
+		t.Fatalf("time filter got %+v", entries)
+	}
+}
+
+func TestVerifyMissingFileIsEmpty(t *testing.T) {
+	n, err := Verify(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if err != nil || n != 0 {
+		t.Fatalf("verify missing file got n=%d err=%v", n, err)
+	}
+}
@@ -6,53 +6,112 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 const (
 	// DefaultTTL is the default plaintext lifetime after unlock.
 	DefaultTTL = time.Hour
-	// WarningWindow is when warning notifications should fire.
+	// WarningWindow is the expiry warning offset used when none is configured.
 	WarningWindow = 5 * time.Minute
+	// DefaultCheckInterval is how often the daemon checks watched files.
+	DefaultCheckInterval = 10 * time.Second
+	// DefaultLogMaxSize is the app log size that triggers rotation at startup.
+	DefaultLogMaxSize = 2 * 1024 * 1024
+	// DefaultMinPasswordScore is the strength, from 0 to 4, that new
+	// passwords need when none is configured.
+	DefaultMinPasswordScore = 3
 )
 
+// DefaultWarningWindows returns the expiry warning offsets used when the
+// config file does not set any.
+func DefaultWarningWindows() []time.Duration {
+	return []time.Duration{WarningWindow}
+}
+
 // Config contains all filesystem paths used by Dotward.
 type Config struct {
 	AppDir       string
 	StatePath    string
 	SockPath     string
 	SettingsPath string
-	DefaultTTL   time.Duration
+	AuditPath    string
+	// FailuresPath holds the daemon's record of failed unlock attempts.
+	FailuresPath string
+	// MaskKeyPath holds the random key that masked git diffs hash values
+	// with. It never leaves the machine.
+	MaskKeyPath string
+	// Settings holds the validated config file contents. Its socket and log
+	// paths are resolved to absolute paths.
+	Settings
 }
 
 // ResolveConfig resolves application paths for the current user.
 func ResolveConfig() (Config, error) {
-	configDir, err := os.UserConfigDir()
+	appDir, err := resolveAppDir()
 	if err != nil {
-		return Config{}, fmt.Errorf("failed to resolve user config dir: %w", err)
+		return Config{}, err
 	}
-
-	appDir := filepath.Join(configDir, "Dotward")
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return Config{}, fmt.Errorf("failed to resolve home dir: %w", err)
 	}
 
 	settingsPath := filepath.Join(appDir, "config.json")
-	defaultTTL, err := loadDefaultTTL(settingsPath)
+	settings, err := LoadSettings(settingsPath)
 	if err != nil {
 		return Config{}, fmt.Errorf("failed to load config file %q: %w", settingsPath, err)
 	}
 
+	settings.SocketPath = resolveSettingPath(settings.SocketPath, homeDir, filepath.Join(homeDir, ".dotward.sock"))
+	settings.Log.Path = resolveSettingPath(settings.Log.Path, homeDir, filepath.Join(appDir, "dotward-app.log"))
+	settings.expandPatternHome(homeDir)
+	settings.expandProfileHome(homeDir)
+
 	return Config{
 		AppDir:       appDir,
 		StatePath:    filepath.Join(appDir, "state.json"),
-		SockPath:     filepath.Join(homeDir, ".dotward.sock"),
+		SockPath:     settings.SocketPath,
 		SettingsPath: settingsPath,
-		DefaultTTL:   defaultTTL,
+		AuditPath:    filepath.Join(appDir, "audit.jsonl"),
+		FailuresPath: filepath.Join(appDir, "failures.json"),
+		MaskKeyPath:  filepath.Join(appDir, "mask.key"),
+		Settings:     settings,
 	}, nil
 }
 
+// SettingsFilePath returns the location of the user config file without
+// reading it, so that an invalid file can still be inspected and fixed.
+func SettingsFilePath() (string, error) {
+	appDir, err := resolveAppDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(appDir, "config.json"), nil
+}
+
+func resolveAppDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve user config dir: %w", err)
+	}
+	return filepath.Join(configDir, "Dotward"), nil
+}
+
+// resolveSettingPath expands a leading "~/" in a configured path, or returns
+// def when the path is not set.
+func resolveSettingPath(p, homeDir, def string) string {
+	switch {
+	case p == "":
+		return def
+	case strings.HasPrefix(p, "~/"):
+		return filepath.Join(homeDir, p[2:])
+	default:
+		return filepath.Clean(p)
+	}
+}
+
 // EnsureDirs creates directories needed by Dotward.
 func EnsureDirs(cfg Config) error {
 	if err := os.MkdirAll(cfg.AppDir, 0o700); err != nil {
@@ -64,13 +123,21 @@ func EnsureDirs(cfg Config) error {
 	return nil
 }
 
+// fileConfig is the content written to a freshly created config file.
 type fileConfig struct {
-	DefaultTTL string `json:"default_ttl"`
+	DefaultTTL     string   `json:"default_ttl"`
+	WarningWindows []string `json:"warning_windows,omitempty"`
+	LockOnSleep    bool     `json:"lock_on_sleep"`
 }
 
 func defaultFileConfig() fileConfig {
+	windows := make([]string, 0, len(DefaultWarningWindows()))
+	for _, w := range DefaultWarningWindows() {
+		windows = append(windows, w.String())
+	}
 	return fileConfig{
-		DefaultTTL: DefaultTTL.String(),
+		DefaultTTL:     DefaultTTL.String(),
+		WarningWindows: windows,
 	}
 }
 
@@ -102,28 +169,9 @@ func ensureDefaultConfigFile(path string) error {
 }
 
 func loadDefaultTTL(path string) (time.Duration, error) {
-	b, err := os.ReadFile(path)
+	s, err := LoadSettings(path)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return DefaultTTL, nil
-		}
-		return 0, fmt.Errorf("failed to read config: %w", err)
-	}
-
-	var cfg fileConfig
-	if err := json.Unmarshal(b, &cfg); err != nil {
-		return 0, fmt.Errorf("failed to decode config json: %w", err)
-	}
-	if cfg.DefaultTTL == "" {
-		return DefaultTTL, nil
-	}
-
-	ttl, err := time.ParseDuration(cfg.DefaultTTL)
-	if err != nil {
-		return 0, fmt.Errorf("invalid default_ttl %q: %w", cfg.DefaultTTL, err)
-	}
-	if ttl <= 0 {
-		return 0, fmt.Errorf("invalid default_ttl %q: must be > 0", cfg.DefaultTTL)
+		return 0, err
 	}
-	return ttl, nil
+	return s.DefaultTTL, nil
 }
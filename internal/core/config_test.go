@@ -106,3 +106,72 @@ func TestEnsureDirsDoesNotOverwriteExistingConfig(t *testing.T) {
 		t.Fatalf("settings file was overwritten")
 	}
 }
+
+func TestLoadSettingsReadsLockOnSleep(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(cfgPath, []byte(`{"lock_on_sleep":true}`), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	got, err := LoadSettings(cfgPath)
+	if err != nil {
+		t.Fatalf("load settings: %v", err)
+	}
+	if !got.LockOnSleep {
+		t.Fatal("expected lock_on_sleep to be enabled")
+	}
+	if got.DefaultTTL != DefaultTTL {
+		t.Fatalf("ttl mismatch got=%s want=%s", got.DefaultTTL, DefaultTTL)
+	}
+}
+
+func TestLoadSettingsWarningWindows(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []time.Duration
+	}{
+		{name: "default", raw: `{}`, want: []time.Duration{WarningWindow}},
+		{name: "sorted and deduplicated", raw: `{"warning_windows":["1m","15m","5m","1m"]}`, want: []time.Duration{15 * time.Minute, 5 * time.Minute, time.Minute}},
+		{name: "legacy single window", raw: `{"warning_window":"10m"}`, want: []time.Duration{10 * time.Minute}},
+		{name: "list wins over legacy", raw: `{"warning_window":"10m","warning_windows":["2m"]}`, want: []time.Duration{2 * time.Minute}},
+		{name: "empty list disables", raw: `{"warning_windows":[]}`, want: []time.Duration{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfgPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(cfgPath, []byte(tc.raw), 0o600); err != nil {
+				t.Fatalf("write config: %v", err)
+			}
+			got, err := LoadSettings(cfgPath)
+			if err != nil {
+				t.Fatalf("load settings: %v", err)
+			}
+			if len(got.WarningWindows) != len(tc.want) {
+				t.Fatalf("warning windows got=%v want=%v", got.WarningWindows, tc.want)
+			}
+			for i := range tc.want {
+				if got.WarningWindows[i] != tc.want[i] {
+					t.Fatalf("warning windows got=%v want=%v", got.WarningWindows, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadSettingsRejectsInvalidWarningWindows(t *testing.T) {
+	tests := []string{
+		`{"warning_windows":["soon"]}`,
+		`{"warning_windows":["0s"]}`,
+		`{"warning_window":"-1m"}`,
+	}
+	for _, raw := range tests {
+		cfgPath := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(cfgPath, []byte(raw), 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+		if _, err := LoadSettings(cfgPath); err == nil {
+			t.Fatalf("expected error for config %s", raw)
+		}
+	}
+}
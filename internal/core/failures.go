@@ -0,0 +1,289 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// FailureBackoff is how long to wait after the first failed unlock
+	// attempt of a file; every further failure doubles it.
+	FailureBackoff = time.Second
+	// MaxFailureBackoff caps the wait between failed unlock attempts.
+	MaxFailureBackoff = 15 * time.Minute
+	// PendingAttemptTimeout is how long a reserved unlock attempt may run,
+	// including the TOTP prompt, before it counts as failed.
+	PendingAttemptTimeout = 2 * time.Minute
+)
+
+// FailedUnlock records the failed attempts to unlock one file since it was
+// last unlocked.
+type FailedUnlock struct {
+	// Key identifies the encrypted file, normally by the fingerprint of its
+	// sidecar, so that copies of a sidecar share one record.
+	Key string `json:"key"`
+	// Path is where the last failed attempt was made. It is only shown.
+	Path string `json:"path"`
+	// Failures counts the failed attempts in a row.
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	// LockedOut files are refused until the lockout is cleared.
+	LockedOut bool `json:"locked_out,omitempty"`
+}
+
+// RetryAt returns when the next attempt is allowed.
+func (f FailedUnlock) RetryAt() time.Time {
+	return f.LastFailure.Add(FailureDelay(f.Failures))
+}
+
+// FailureDelay returns the wait after failures failed attempts in a row:
+// FailureBackoff doubled for every failure after the first, up to
+// MaxFailureBackoff.
+func FailureDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := FailureBackoff
+	for i := 1; i < failures && delay < MaxFailureBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, MaxFailureBackoff)
+}
+
+// ErrLockedOut is returned for a file locked out after too many failed
+// unlock attempts.
+var ErrLockedOut = errors.New("locked out after too many failed unlock attempts")
+
+// ErrAttemptPending is returned while another attempt to unlock a file is in
+// progress.
+var ErrAttemptPending = errors.New("another unlock attempt is in progress")
+
+// BackoffError is returned for an attempt made before the wait after the
+// last failure is over, or while another attempt is in progress.
+type BackoffError struct {
+	Failures int
+	// Wait is how long until the next attempt is allowed.
+	Wait time.Duration
+	// Pending is set when another attempt is in progress; the error then
+	// wraps ErrAttemptPending.
+	Pending bool
+}
+
+func (e *BackoffError) Error() string {
+	if e.Pending {
+		return fmt.Sprintf("%v; retry in %s", ErrAttemptPending, e.Wait.Round(time.Second))
+	}
+	return fmt.Sprintf("%d failed unlock attempt(s); retry in %s", e.Failures, e.Wait.Round(time.Second))
+}
+
+func (e *BackoffError) Unwrap() error {
+	if e.Pending {
+		return ErrAttemptPending
+	}
+	return nil
+}
+
+// Failures tracks failed unlock attempts per encrypted file and persists
+// them, so that restarting the daemon resets neither the backoff nor a
+// lockout. Attempts in progress and the TOTP steps used are only kept in
+// memory.
+type Failures struct {
+	mu      sync.Mutex
+	files   map[string]FailedUnlock
+	pending map[string]pendingAttempt
+	// totpSteps holds the last TOTP time step accepted per encrypted file.
+	totpSteps map[string]int64
+}
+
+// pendingAttempt is an unlock attempt reserved and not settled yet.
+type pendingAttempt struct {
+	token string
+	path  string
+	since time.Time
+}
+
+// NewFailures creates an empty record.
+func NewFailures() *Failures {
+	return &Failures{
+		files:     make(map[string]FailedUnlock),
+		pending:   make(map[string]pendingAttempt),
+		totpSteps: make(map[string]int64),
+	}
+}
+
+// LoadFailures loads failed attempts from disk. A missing file is not an
+// error.
+func LoadFailures(path string) (*Failures, error) {
+	f := NewFailures()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return f, nil
+		}
+		return nil, fmt.Errorf("failed to read failures file %q: %w", path, err)
+	}
+	var list []FailedUnlock
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode failures file %q: %w", path, err)
+	}
+	for _, fu := range list {
+		if fu.Key == "" {
+			// Written before records were keyed by sidecar.
+			fu.Key = fu.Path
+		}
+		f.files[fu.Key] = fu
+	}
+	return f, nil
+}
+
+// Save writes the failed attempts atomically as JSON.
+func (f *Failures) Save(path string) error {
+	b, err := json.MarshalIndent(f.Snapshot(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode failures: %w", err)
+	}
+	return writeFileAtomic(path, b)
+}
+
+// Check returns ErrLockedOut or a *BackoffError when the file identified by
+// key may not be unlocked at now.
+func (f *Failures) Check(key string, now time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.check(key, now)
+}
+
+func (f *Failures) check(key string, now time.Time) error {
+	fu, ok := f.files[key]
+	switch {
+	case !ok:
+		return nil
+	case fu.LockedOut:
+		return ErrLockedOut
+	case now.Before(fu.RetryAt()):
+		return &BackoffError{Failures: fu.Failures, Wait: fu.RetryAt().Sub(now)}
+	}
+	return nil
+}
+
+// Reserve checks the attempt at now to unlock the file identified by key
+// like Check and, when it is allowed, holds it in progress under token until
+// Settle. Meanwhile further attempts are refused with ErrAttemptPending, so
+// that attempts made in parallel cannot all pass before the first failure is
+// recorded. An attempt not settled within PendingAttemptTimeout counts as
+// failed at the path it was made at; abandoned reports whether Reserve
+// recorded such a failure.
+func (f *Failures) Reserve(key, path, token string, now time.Time, lockoutAfter int) (abandoned bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p, ok := f.pending[key]; ok {
+		if expires := p.since.Add(PendingAttemptTimeout); now.Before(expires) {
+			return false, &BackoffError{Failures: f.files[key].Failures, Wait: expires.Sub(now), Pending: true}
+		}
+		delete(f.pending, key)
+		f.fail(key, p.path, p.since, lockoutAfter)
+		abandoned = true
+	}
+	if err := f.check(key, now); err != nil {
+		return abandoned, err
+	}
+	f.pending[key] = pendingAttempt{token: token, path: path, since: now}
+	return abandoned, nil
+}
+
+// Settle ends the attempt reserved under token for the file identified by
+// key and reports whether it was still in progress.
+func (f *Failures) Settle(key, token string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.pending[key]
+	if !ok || p.token != token {
+		return false
+	}
+	delete(f.pending, key)
+	return true
+}
+
+// UseTOTPStep records that a TOTP code of time step step unlocked the file
+// identified by key. It reports false, recording nothing, for a step at or
+// before the last one accepted, so that a code cannot be used twice within
+// the window of steps it is valid in.
+func (f *Failures) UseTOTPStep(key string, step int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if last, ok := f.totpSteps[key]; ok && step <= last {
+		return false
+	}
+	f.totpSteps[key] = step
+	return true
+}
+
+// Fail records a failed attempt at now to unlock the file identified by
+// key, made at path. The file is locked out once it fails lockoutAfter times
+// in a row; 0 never locks it out.
+func (f *Failures) Fail(key, path string, now time.Time, lockoutAfter int) FailedUnlock {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fail(key, path, now, lockoutAfter)
+}
+
+func (f *Failures) fail(key, path string, now time.Time, lockoutAfter int) FailedUnlock {
+	fu := f.files[key]
+	fu.Key = key
+	fu.Path = path
+	fu.Failures++
+	fu.LastFailure = now
+	if lockoutAfter > 0 && fu.Failures >= lockoutAfter {
+		fu.LockedOut = true
+	}
+	f.files[key] = fu
+	return fu
+}
+
+// Succeed forgets the failed attempts of the file identified by key after it
+// was unlocked, and reports whether there were any. A lockout is only lifted
+// by Clear.
+func (f *Failures) Succeed(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fu, ok := f.files[key]
+	if !ok || fu.LockedOut {
+		return false
+	}
+	delete(f.files, key)
+	return true
+}
+
+// Clear forgets the failed attempts and lifts the lockout of the files
+// identified by key or last attempted at path, or of every file when both
+// are empty, and returns the records it cleared ordered by path.
+func (f *Failures) Clear(key, path string) []FailedUnlock {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var cleared []FailedUnlock
+	for k, fu := range f.files {
+		if (key == "" && path == "") || (key != "" && k == key) || (path != "" && fu.Path == path) {
+			cleared = append(cleared, fu)
+			delete(f.files, k)
+		}
+	}
+	sort.Slice(cleared, func(i, j int) bool { return cleared[i].Path < cleared[j].Path })
+	return cleared
+}
+
+// Snapshot returns the failed attempts ordered by path.
+func (f *Failures) Snapshot() []FailedUnlock {
+	f.mu.Lock()
+	list := make([]FailedUnlock, 0, len(f.files))
+	for _, fu := range f.files {
+		list = append(list, fu)
+	}
+	f.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
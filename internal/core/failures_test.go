@@ -0,0 +1,156 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFailureDelayDoubles(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{10, 512 * time.Second},
+		{11, MaxFailureBackoff},
+		{1000, MaxFailureBackoff},
+	}
+	for _, tc := range tests {
+		if got := FailureDelay(tc.failures); got != tc.want {
+			t.Fatalf("FailureDelay(%d) = %s, want %s", tc.failures, got, tc.want)
+		}
+	}
+}
+
+func TestFailuresFollowCopiedSidecar(t *testing.T) {
+	f := NewFailures()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	const key = "5f1c"
+
+	for range 2 {
+		f.Fail(key, "/tmp/project/.env", now, 2)
+	}
+	// The same sidecar copied elsewhere stays locked out.
+	if err := f.Check(key, now.Add(time.Hour)); !errors.Is(err, ErrLockedOut) {
+		t.Fatalf("want a lockout for the copied sidecar, got %v", err)
+	}
+	f.Fail(key, "/tmp/x1.env", now, 2)
+	list := f.Snapshot()
+	if len(list) != 1 || list[0].Path != "/tmp/x1.env" || list[0].Failures != 3 {
+		t.Fatalf("want one record at the last path, got %+v", list)
+	}
+	if got := f.Clear(key, ""); len(got) != 1 || got[0].Path != "/tmp/x1.env" {
+		t.Fatalf("clear by key got=%v", got)
+	}
+
+	legacyPath := filepath.Join(t.TempDir(), "failures.json")
+	if err := os.WriteFile(legacyPath, []byte(`[{"path": "/tmp/old/.env", "failures": 2, "locked_out": true}]`), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	loaded, err := LoadFailures(legacyPath)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := loaded.Check("/tmp/old/.env", now); !errors.Is(err, ErrLockedOut) {
+		t.Fatalf("record without a key not keyed by path: %v", err)
+	}
+}
+
+func TestFailuresBackoffAndLockout(t *testing.T) {
+	f := NewFailures()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	const key, path = "5f1c", "/tmp/project/.env"
+
+	for i := range 2 {
+		at := now.Add(time.Duration(i) * time.Second)
+		if err := f.Check(key, at); err != nil {
+			t.Fatalf("attempt %d refused: %v", i+1, err)
+		}
+		f.Fail(key, path, at, 3)
+	}
+	var backoff *BackoffError
+	if err := f.Check(key, now.Add(2*time.Second)); !errors.As(err, &backoff) || backoff.Wait != time.Second || backoff.Failures != 2 {
+		t.Fatalf("want a 2s backoff after two failures, got %v", err)
+	}
+	if err := f.Check(key, now.Add(3*time.Second)); err != nil {
+		t.Fatalf("attempt after the backoff refused: %v", err)
+	}
+	if !f.Succeed(key) || f.Check(key, now) != nil {
+		t.Fatal("a successful unlock must forget the failures")
+	}
+
+	for range 3 {
+		f.Fail(key, path, now, 3)
+	}
+	if err := f.Check(key, now.Add(time.Hour)); !errors.Is(err, ErrLockedOut) {
+		t.Fatalf("want a lockout after three failures, got %v", err)
+	}
+	if f.Succeed(key) {
+		t.Fatal("a successful unlock must not lift a lockout")
+	}
+
+	statePath := filepath.Join(t.TempDir(), "failures.json")
+	if err := f.Save(statePath); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	loaded, err := LoadFailures(statePath)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := loaded.Check(key, now.Add(time.Hour)); !errors.Is(err, ErrLockedOut) {
+		t.Fatalf("lockout lost across a restart: %v", err)
+	}
+	f.Fail("9a07", "/tmp/other/.env", now, 0)
+	if got := loaded.Clear("", path); len(got) != 1 || got[0].Path != path || !got[0].LockedOut || loaded.Check(key, now) != nil {
+		t.Fatalf("clear got=%v", got)
+	}
+	if got := f.Clear("", ""); len(got) != 2 || len(f.Snapshot()) != 0 {
+		t.Fatalf("clear all got=%v", got)
+	}
+}
+
+func TestFailuresReserveOneAttemptAtATime(t *testing.T) {
+	f := NewFailures()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	const key, path = "5f1c", "/tmp/project/.env"
+
+	if _, err := f.Reserve(key, path, "a", now, 2); err != nil {
+		t.Fatalf("first reservation refused: %v", err)
+	}
+	if _, err := f.Reserve(key, path, "b", now, 2); !errors.Is(err, ErrAttemptPending) {
+		t.Fatalf("want a parallel attempt refused, got %v", err)
+	}
+	if f.Settle(key, "b") || !f.Settle(key, "a") || f.Settle(key, "a") {
+		t.Fatal("only the reserved token may settle the attempt, once")
+	}
+
+	if _, err := f.Reserve(key, path, "c", now, 2); err != nil {
+		t.Fatalf("reservation after settling refused: %v", err)
+	}
+	abandoned, err := f.Reserve(key, path, "d", now.Add(PendingAttemptTimeout), 2)
+	if !abandoned || err != nil {
+		t.Fatalf("want the abandoned attempt counted, got %t %v", abandoned, err)
+	}
+	if list := f.Snapshot(); len(list) != 1 || list[0].Failures != 1 || list[0].LastFailure != now {
+		t.Fatalf("abandoned attempt recorded as %+v", list)
+	}
+}
+
+func TestFailuresRefuseReusedTOTPSteps(t *testing.T) {
+	f := NewFailures()
+	if !f.UseTOTPStep("5f1c", 100) || !f.UseTOTPStep("9a07", 100) {
+		t.Fatal("first use of a step refused")
+	}
+	if f.UseTOTPStep("5f1c", 100) || f.UseTOTPStep("5f1c", 99) {
+		t.Fatal("reused or earlier step accepted")
+	}
+	if !f.UseTOTPStep("5f1c", 101) {
+		t.Fatal("later step refused")
+	}
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TTLPolicy gives files matching a glob pattern their own unlock lifetime.
+//
+// Patterns are matched against absolute paths one path segment at a time
+// with path.Match syntax, plus "**" for any number of segments. A leading
+// "~/" refers to the home directory, and a pattern that is not absolute
+// matches at any depth, as if it started with "**/".
+type TTLPolicy struct {
+	Pattern string
+	TTL     time.Duration
+	// expanded is Pattern with "~/" replaced by the home directory.
+	expanded string
+}
+
+// TTLResolution explains which TTL applies to a path.
+type TTLResolution struct {
+	TTL time.Duration
+	// Rule is the index of the matching policy in Settings.TTLPolicies, or
+	// -1 when no policy matched and DefaultTTL applies.
+	Rule int
+	// Pattern is the matching policy's pattern as written in the config.
+	Pattern string
+}
+
+// ResolveTTL returns the TTL for path from the first matching policy, falling
+// back to DefaultTTL.
+func (s Settings) ResolveTTL(p string) TTLResolution {
+	p = cleanSlashPath(p)
+	for i, policy := range s.TTLPolicies {
+		pattern := policy.expanded
+		if pattern == "" {
+			pattern = policy.Pattern
+		}
+		if matchGlob(pattern, p) {
+			return TTLResolution{TTL: policy.TTL, Rule: i, Pattern: policy.Pattern}
+		}
+	}
+	return TTLResolution{TTL: s.DefaultTTL, Rule: -1}
+}
+
+// expandPatternHome resolves "~/" in every policy and schedule pattern
+// against homeDir.
+func (s *Settings) expandPatternHome(homeDir string) {
+	for i := range s.TTLPolicies {
+		s.TTLPolicies[i].expanded = expandHomePattern(s.TTLPolicies[i].Pattern, homeDir)
+	}
+	for i := range s.Schedules {
+		s.Schedules[i].expanded = expandHomePattern(s.Schedules[i].Pattern, homeDir)
+	}
+}
+
+func expandHomePattern(p, homeDir string) string {
+	if strings.HasPrefix(p, "~/") {
+		return filepath.ToSlash(homeDir) + "/" + p[2:]
+	}
+	return p
+}
+
+func cleanSlashPath(p string) string {
+	return filepath.ToSlash(filepath.Clean(p))
+}
+
+// ValidatePattern checks every segment of a glob pattern for syntax errors.
+func ValidatePattern(pattern string) error {
+	for _, seg := range splitSegments(pattern) {
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchGlob(pattern, name string) bool {
+	if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "~/") {
+		pattern = "**/" + pattern
+	}
+	return MatchPattern(pattern, name)
+}
+
+// MatchPattern reports whether the slash-separated name matches pattern one
+// segment at a time with path.Match syntax, where a "**" segment matches any
+// number of segments. Unlike policy patterns it is anchored at both ends.
+func MatchPattern(pattern, name string) bool {
+	return matchSegments(splitSegments(pattern), splitSegments(name))
+}
+
+func splitSegments(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ttlPoliciesValue parses the ttl_policies list, keeping its order.
+func ttlPoliciesValue(v any) ([]TTLPolicy, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, errors.New(`must be a list of {"pattern": ..., "ttl": ...} objects`)
+	}
+	out := make([]TTLPolicy, 0, len(list))
+	for i, item := range list {
+		obj, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("rule %d: must be an object with pattern and ttl", i+1)
+		}
+		pattern, _ := obj["pattern"].(string)
+		if pattern == "" {
+			return nil, fmt.Errorf("rule %d: pattern is required", i+1)
+		}
+		if err := ValidatePattern(pattern); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		ttl, err := durationValue(obj["ttl"], 0)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: ttl: %w", i+1, err)
+		}
+		for key := range obj {
+			if key != "pattern" && key != "ttl" {
+				return nil, fmt.Errorf("rule %d: unknown field %q", i+1, key)
+			}
+		}
+		out = append(out, TTLPolicy{Pattern: pattern, TTL: ttl})
+	}
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"**/prod/*.env", "/srv/app/prod/api.env", true},
+		{"**/prod/*.env", "/srv/app/prod/nested/api.env", false},
+		{"**/prod/*.env", "/prod/.env", true},
+		{"prod/*.env", "/srv/prod/db.env", true},
+		{"/home/me/work/**", "/home/me/work/api/.env", true},
+		{"/home/me/work/**", "/home/me/personal/.env", false},
+		{"/home/me/work/**/.env", "/home/me/work/.env", true},
+		{"*.env", "/anywhere/deep/down/.env", true},
+		{"/a/?/.env", "/a/b/.env", true},
+		{"/a/?/.env", "/a/bc/.env", false},
+	}
+	for _, tc := range tests {
+		if got := matchGlob(tc.pattern, tc.path); got != tc.want {
+			t.Fatalf("matchGlob(%q, %q) got=%v want=%v", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestResolveTTLFirstMatchWins(t *testing.T) {
+	s, err := ParseSettings([]byte(`{
+		"default_ttl": "1h",
+		"ttl_policies": [
+			{"pattern": "**/prod/*.env", "ttl": "10m"},
+			{"pattern": "~/work/**", "ttl": "4h"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	s.expandPatternHome("/home/me")
+
+	tests := []struct {
+		path string
+		ttl  time.Duration
+		rule int
+	}{
+		{"/home/me/work/prod/api.env", 10 * time.Minute, 0},
+		{"/home/me/work/docker/.env", 4 * time.Hour, 1},
+		{"/home/me/personal/.env", time.Hour, -1},
+	}
+	for _, tc := range tests {
+		got := s.ResolveTTL(tc.path)
+		if got.TTL != tc.ttl || got.Rule != tc.rule {
+			t.Fatalf("ResolveTTL(%q) got=%+v want ttl=%s rule=%d", tc.path, got, tc.ttl, tc.rule)
+		}
+	}
+}
+
+func TestParseSettingsRejectsInvalidTTLPolicies(t *testing.T) {
+	tests := []string{
+		`{"ttl_policies": {"pattern": "*.env"}}`,
+		`{"ttl_policies": [{"ttl": "10m"}]}`,
+		`{"ttl_policies": [{"pattern": "[.env", "ttl": "10m"}]}`,
+		`{"ttl_policies": [{"pattern": "*.env", "ttl": "0s"}]}`,
+		`{"ttl_policies": [{"pattern": "*.env", "ttl": "10m", "max": "1h"}]}`,
+	}
+	for _, raw := range tests {
+		if _, err := ParseSettings([]byte(raw)); err == nil {
+			t.Fatalf("expected error for %s", raw)
+		}
+	}
+}
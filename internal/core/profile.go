@@ -0,0 +1,287 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profile groups secret files, such as those of one environment, that share
+// a key source and their own unlock policy. Encrypted files record the
+// profile they belong to. Zero fields inherit the global settings.
+type Profile struct {
+	Name string
+	// PasswordCommand is run through the shell to obtain the profile's
+	// password instead of prompting for it.
+	PasswordCommand string
+	// DefaultTTL replaces the global default_ttl and ttl_policies for the
+	// profile's files.
+	DefaultTTL time.Duration
+	// TTLPolicies are tried before DefaultTTL or the global policies.
+	TTLPolicies []TTLPolicy
+	// Roots restrict the profile to files below these directories. Empty
+	// means anywhere.
+	Roots []string
+	// Notifications override individual global notification switches.
+	Notifications ProfileNotifications
+	// MinPasswordScore, when set, replaces the global min_password_score.
+	MinPasswordScore *int
+}
+
+// ProfileNotifications overrides notification switches for a profile; nil
+// fields follow the global setting.
+type ProfileNotifications struct {
+	Unlocked *bool
+	Warnings *bool
+	Deleted  *bool
+}
+
+// ForProfile returns the settings that apply to files of the named profile.
+// An empty or unknown name returns s unchanged.
+func (s Settings) ForProfile(name string) Settings {
+	p, ok := s.Profiles[name]
+	if !ok {
+		return s
+	}
+	out := s
+	if p.DefaultTTL > 0 {
+		out.DefaultTTL = p.DefaultTTL
+		out.TTLPolicies = p.TTLPolicies
+	} else if len(p.TTLPolicies) > 0 {
+		out.TTLPolicies = append(append([]TTLPolicy(nil), p.TTLPolicies...), s.TTLPolicies...)
+	}
+	for _, o := range []struct {
+		override *bool
+		dst      *bool
+	}{
+		{p.Notifications.Unlocked, &out.Notifications.Unlocked},
+		{p.Notifications.Warnings, &out.Notifications.Warnings},
+		{p.Notifications.Deleted, &out.Notifications.Deleted},
+	} {
+		if o.override != nil {
+			*o.dst = *o.override
+		}
+	}
+	if p.MinPasswordScore != nil {
+		out.MinPasswordScore = *p.MinPasswordScore
+	}
+	return out
+}
+
+// Allows reports whether the absolute path lies below one of the profile's
+// roots.
+func (p Profile) Allows(absPath string) bool {
+	if len(p.Roots) == 0 {
+		return true
+	}
+	_, ok := p.rootOf(absPath)
+	return ok
+}
+
+// rootOf returns the longest root containing absPath.
+func (p Profile) rootOf(absPath string) (string, bool) {
+	absPath = filepath.Clean(absPath)
+	best, found := "", false
+	for _, root := range p.Roots {
+		rel, err := filepath.Rel(root, absPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if !found || len(root) > len(best) {
+			best, found = root, true
+		}
+	}
+	return best, found
+}
+
+// ProfileForPath returns the profile whose roots most closely contain
+// absPath, or "" when no profile claims it.
+func (s Settings) ProfileForPath(absPath string) string {
+	name, best := "", ""
+	for _, n := range s.ProfileNames() {
+		root, ok := s.Profiles[n].rootOf(absPath)
+		if ok && len(root) > len(best) {
+			name, best = n, root
+		}
+	}
+	return name
+}
+
+// ProfileNames returns the configured profile names in sorted order.
+func (s Settings) ProfileNames() []string {
+	names := make([]string, 0, len(s.Profiles))
+	for n := range s.Profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profilesValue parses the profiles object.
+func profilesValue(v any) (map[string]Profile, error) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New(`must be an object such as {"prod": {"default_ttl": "5m"}}`)
+	}
+	out := make(map[string]Profile, len(obj))
+	for name, item := range obj {
+		if !validProfileName(name) {
+			return nil, fmt.Errorf("invalid profile name %q: use letters, digits, '-' and '_'", name)
+		}
+		fields, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("profile %q: must be an object", name)
+		}
+		p, err := profileValue(name, fields)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", name, err)
+		}
+		out[name] = p
+	}
+	return out, nil
+}
+
+func profileValue(name string, fields map[string]any) (Profile, error) {
+	p := Profile{Name: name}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := fields[key]
+		var err error
+		switch key {
+		case "password_command":
+			p.PasswordCommand, err = commandValue(v)
+		case "default_ttl":
+			p.DefaultTTL, err = durationValue(v, 0)
+		case "ttl_policies":
+			p.TTLPolicies, err = ttlPoliciesValue(v)
+		case "roots":
+			p.Roots, err = rootsValue(v)
+		case "notifications":
+			p.Notifications, err = profileNotificationsValue(v)
+		case "min_password_score":
+			var score int
+			if score, err = scoreValue(v); err == nil {
+				p.MinPasswordScore = &score
+			}
+		default:
+			return Profile{}, fmt.Errorf("unknown field %q", key)
+		}
+		if err != nil {
+			return Profile{}, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return p, nil
+}
+
+func rootsValue(v any) ([]string, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, errors.New("must be a list of directories")
+	}
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		root, err := pathValue(item)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, root)
+	}
+	return out, nil
+}
+
+func profileNotificationsValue(v any) (ProfileNotifications, error) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return ProfileNotifications{}, errors.New(`must be an object such as {"unlocked": false}`)
+	}
+	var out ProfileNotifications
+	for key, raw := range obj {
+		var dst **bool
+		switch key {
+		case "unlocked":
+			dst = &out.Unlocked
+		case "warnings":
+			dst = &out.Warnings
+		case "deleted":
+			dst = &out.Deleted
+		default:
+			return ProfileNotifications{}, fmt.Errorf("unknown notification %q", key)
+		}
+		b := new(bool)
+		if err := boolValue(raw, b); err != nil {
+			return ProfileNotifications{}, fmt.Errorf("%s: %w", key, err)
+		}
+		*dst = b
+	}
+	return out, nil
+}
+
+// profilesJSON returns profiles in their config file form.
+func profilesJSON(profiles map[string]Profile) map[string]any {
+	out := make(map[string]any, len(profiles))
+	for name, p := range profiles {
+		obj := make(map[string]any)
+		if p.PasswordCommand != "" {
+			obj["password_command"] = p.PasswordCommand
+		}
+		if p.DefaultTTL > 0 {
+			obj["default_ttl"] = p.DefaultTTL.String()
+		}
+		if len(p.TTLPolicies) > 0 {
+			policies := make([]map[string]string, 0, len(p.TTLPolicies))
+			for _, pol := range p.TTLPolicies {
+				policies = append(policies, map[string]string{"pattern": pol.Pattern, "ttl": pol.TTL.String()})
+			}
+			obj["ttl_policies"] = policies
+		}
+		if len(p.Roots) > 0 {
+			obj["roots"] = p.Roots
+		}
+		notifications := make(map[string]bool)
+		for key, b := range map[string]*bool{"unlocked": p.Notifications.Unlocked, "warnings": p.Notifications.Warnings, "deleted": p.Notifications.Deleted} {
+			if b != nil {
+				notifications[key] = *b
+			}
+		}
+		if len(notifications) > 0 {
+			obj["notifications"] = notifications
+		}
+		if p.MinPasswordScore != nil {
+			obj["min_password_score"] = *p.MinPasswordScore
+		}
+		out[name] = obj
+	}
+	return out
+}
+
+// validProfileName reports whether name can be used as a profile name.
+func validProfileName(name string) bool {
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// expandProfileHome resolves "~/" in profile roots and policy patterns.
+func (s *Settings) expandProfileHome(homeDir string) {
+	for name, p := range s.Profiles {
+		for i := range p.TTLPolicies {
+			p.TTLPolicies[i].expanded = expandHomePattern(p.TTLPolicies[i].Pattern, homeDir)
+		}
+		for i, root := range p.Roots {
+			p.Roots[i] = resolveSettingPath(root, homeDir, root)
+		}
+		s.Profiles[name] = p
+	}
+}
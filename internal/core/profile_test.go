@@ -0,0 +1,105 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSettingsReadsProfiles(t *testing.T) {
+	raw := `{
+		"default_ttl": "1h",
+		"ttl_policies": [{"pattern": "*.pem", "ttl": "2h"}],
+		"profiles": {
+			"prod": {
+				"password_command": "pass show prod",
+				"default_ttl": "5m",
+				"roots": ["/srv/prod"],
+				"notifications": {"unlocked": false}
+			},
+			"staging": {
+				"ttl_policies": [{"pattern": "**/.env", "ttl": "30m"}]
+			}
+		}
+	}`
+	s, err := ParseSettings([]byte(raw))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	prod := s.Profiles["prod"]
+	if prod.PasswordCommand != "pass show prod" || prod.DefaultTTL != 5*time.Minute || len(prod.Roots) != 1 {
+		t.Fatalf("unexpected prod profile: %+v", prod)
+	}
+
+	p := s.ForProfile("prod")
+	if got := p.ResolveTTL("/srv/prod/app/cert.pem").TTL; got != 5*time.Minute {
+		t.Fatalf("prod default_ttl must replace global policies, got=%s", got)
+	}
+	if p.Notifications.Unlocked || !p.Notifications.Warnings {
+		t.Fatalf("unexpected prod notifications: %+v", p.Notifications)
+	}
+
+	st := s.ForProfile("staging")
+	if got := st.ResolveTTL("/srv/app/.env").TTL; got != 30*time.Minute {
+		t.Fatalf("staging policy got=%s", got)
+	}
+	if got := st.ResolveTTL("/srv/app/cert.pem").TTL; got != 2*time.Hour {
+		t.Fatalf("staging must fall back to global policies, got=%s", got)
+	}
+	if got := s.ForProfile("").ResolveTTL("/srv/app/.env").TTL; got != time.Hour {
+		t.Fatalf("default profile got=%s", got)
+	}
+}
+
+func TestProfileRoots(t *testing.T) {
+	s, err := ParseSettings([]byte(`{"profiles": {
+		"prod": {"roots": ["/srv"]},
+		"payments": {"roots": ["/srv/payments"]}
+	}}`))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	if !s.Profiles["prod"].Allows("/srv/app/.env") || s.Profiles["prod"].Allows("/srvx/.env") {
+		t.Fatal("prod roots mismatch")
+	}
+	if got := s.ProfileForPath("/srv/payments/.env"); got != "payments" {
+		t.Fatalf("most specific root must win, got=%q", got)
+	}
+	if got := s.ProfileForPath("/home/me/.env"); got != "" {
+		t.Fatalf("unclaimed path got=%q", got)
+	}
+}
+
+func TestParseSettingsRejectsInvalidProfiles(t *testing.T) {
+	for raw, want := range map[string]string{
+		`{"profiles": {"bad name": {}}}`:                         "invalid profile name",
+		`{"profiles": {"prod": {"colour": "red"}}}`:              `unknown field "colour"`,
+		`{"profiles": {"prod": {"roots": ["relative"]}}}`:        "must be absolute",
+		`{"profiles": {"prod": {"notifications": {"x": true}}}}`: "unknown notification",
+		`{"profiles": {"prod": {"min_password_score": 5}}}`:      "from 0 to 4",
+	} {
+		_, err := ParseSettings([]byte(raw))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: got err=%v want %q", raw, err, want)
+		}
+	}
+}
+
+func TestProfileMinPasswordScore(t *testing.T) {
+	s, err := ParseSettings([]byte(`{"min_password_score": 2, "profiles": {
+		"prod": {"min_password_score": 4},
+		"dev": {"min_password_score": 0},
+		"staging": {}
+	}}`))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	for profile, want := range map[string]int{"prod": 4, "dev": 0, "staging": 2, "": 2} {
+		if got := s.ForProfile(profile).MinPasswordScore; got != want {
+			t.Errorf("profile %q min_password_score got=%d want=%d", profile, got, want)
+		}
+	}
+	if got := profilesJSON(s.Profiles)["dev"].(map[string]any)["min_password_score"]; got != 0 {
+		t.Fatalf("dev override lost in JSON form: %v", got)
+	}
+}
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrOutsideSchedule is returned when a file may not be unlocked or extended
+// at the current time.
+var ErrOutsideSchedule = errors.New("outside the allowed unlock schedule")
+
+// ScheduleRule restricts when matching files may be unlocked. Files may only
+// be unlocked on Days between Start and End in Location, and expire no later
+// than the end of the window. When End is not after Start the window runs
+// overnight into the next day.
+type ScheduleRule struct {
+	// Pattern selects the files the rule applies to, with the same syntax as
+	// TTLPolicy. An empty pattern matches every file.
+	Pattern string
+	// Days lists the weekdays on which a window opens. Empty means every day.
+	Days []time.Weekday
+	// Start and End are minutes after midnight.
+	Start, End int
+	Location   *time.Location
+	expanded   string
+}
+
+// ScheduleWindow describes the unlock window in effect at a point in time.
+type ScheduleWindow struct {
+	// Rule is the index of the governing rule in Settings.Schedules, or -1
+	// when no rule applies to the file.
+	Rule int
+	// Open reports whether unlocking is allowed now.
+	Open bool
+	// End is when the current window closes. It is zero when no rule applies
+	// or the window is closed.
+	End time.Time
+	// NextStart is when the next window opens while the window is closed.
+	NextStart time.Time
+}
+
+// ScheduleAt returns the unlock window that governs path at now. The first
+// rule whose pattern matches applies.
+func (s Settings) ScheduleAt(p string, now time.Time) ScheduleWindow {
+	p = cleanSlashPath(p)
+	for i, rule := range s.Schedules {
+		if !rule.matches(p) {
+			continue
+		}
+		if end, ok := rule.windowEnd(now); ok {
+			return ScheduleWindow{Rule: i, Open: true, End: end}
+		}
+		return ScheduleWindow{Rule: i, NextStart: rule.nextStart(now)}
+	}
+	return ScheduleWindow{Rule: -1, Open: true}
+}
+
+// Err describes a closed window as an error wrapping ErrOutsideSchedule.
+func (w ScheduleWindow) Err() error {
+	if w.Open {
+		return nil
+	}
+	if w.NextStart.IsZero() {
+		return ErrOutsideSchedule
+	}
+	return fmt.Errorf("%w: next window opens %s", ErrOutsideSchedule, w.NextStart.Format("Mon Jan 2 15:04 MST"))
+}
+
+func (r ScheduleRule) matches(p string) bool {
+	if r.Pattern == "" {
+		return true
+	}
+	pattern := r.expanded
+	if pattern == "" {
+		pattern = r.Pattern
+	}
+	return matchGlob(pattern, p)
+}
+
+func (r ScheduleRule) location() *time.Location {
+	if r.Location == nil {
+		return time.Local
+	}
+	return r.Location
+}
+
+func (r ScheduleRule) allows(day time.Weekday) bool {
+	if len(r.Days) == 0 {
+		return true
+	}
+	for _, d := range r.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// window returns the window opening on the calendar day offset days from t.
+func (r ScheduleRule) window(t time.Time, offset int) (time.Time, time.Time) {
+	loc := r.location()
+	y, m, d := t.Date()
+	start := time.Date(y, m, d+offset, r.Start/60, r.Start%60, 0, 0, loc)
+	endDay := d + offset
+	if r.End <= r.Start {
+		endDay++
+	}
+	end := time.Date(y, m, endDay, r.End/60, r.End%60, 0, 0, loc)
+	return start, end
+}
+
+// windowEnd returns the end of the window containing now, if any. Overnight
+// windows that opened the day before are considered too.
+func (r ScheduleRule) windowEnd(now time.Time) (time.Time, bool) {
+	t := now.In(r.location())
+	for _, offset := range []int{0, -1} {
+		start, end := r.window(t, offset)
+		if !r.allows(start.Weekday()) {
+			continue
+		}
+		if !t.Before(start) && t.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nextStart returns when the next window opens after now.
+func (r ScheduleRule) nextStart(now time.Time) time.Time {
+	t := now.In(r.location())
+	for offset := 0; offset <= 7; offset++ {
+		start, _ := r.window(t, offset)
+		if r.allows(start.Weekday()) && start.After(t) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(v any) (int, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, errors.New(`must be a time of day such as "09:00"`)
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: want HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// schedulesValue parses the schedules list, keeping its order.
+func schedulesValue(v any) ([]ScheduleRule, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, errors.New(`must be a list of {"days": ..., "start": ..., "end": ...} objects`)
+	}
+	out := make([]ScheduleRule, 0, len(list))
+	for i, item := range list {
+		obj, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("rule %d: must be an object", i+1)
+		}
+		rule, err := scheduleRuleValue(obj)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		out = append(out, rule)
+	}
+	return out, nil
+}
+
+func scheduleRuleValue(obj map[string]any) (ScheduleRule, error) {
+	var rule ScheduleRule
+	for key, v := range obj {
+		switch key {
+		case "pattern":
+			p, ok := v.(string)
+			if !ok || p == "" {
+				return ScheduleRule{}, errors.New("pattern must be a non-empty string")
+			}
+			if err := ValidatePattern(p); err != nil {
+				return ScheduleRule{}, err
+			}
+			rule.Pattern = p
+		case "days":
+			days, ok := v.([]any)
+			if !ok {
+				return ScheduleRule{}, errors.New(`days must be a list such as ["mon", "tue"]`)
+			}
+			for _, d := range days {
+				name, _ := d.(string)
+				wd, ok := weekdayNames[strings.ToLower(name)]
+				if !ok {
+					return ScheduleRule{}, fmt.Errorf("unknown day %v: use mon, tue, wed, thu, fri, sat or sun", d)
+				}
+				rule.Days = append(rule.Days, wd)
+			}
+		case "timezone":
+			name, ok := v.(string)
+			if !ok || name == "" {
+				return ScheduleRule{}, errors.New("timezone must be an IANA zone name such as \"Europe/Athens\"")
+			}
+			loc, err := time.LoadLocation(name)
+			if err != nil {
+				return ScheduleRule{}, fmt.Errorf("unknown timezone %q: %w", name, err)
+			}
+			rule.Location = loc
+		case "start", "end":
+		default:
+			return ScheduleRule{}, fmt.Errorf("unknown field %q", key)
+		}
+	}
+
+	var err error
+	if rule.Start, err = parseClock(obj["start"]); err != nil {
+		return ScheduleRule{}, fmt.Errorf("start: %w", err)
+	}
+	if rule.End, err = parseClock(obj["end"]); err != nil {
+		return ScheduleRule{}, fmt.Errorf("end: %w", err)
+	}
+	return rule, nil
+}
+
+// formatClock renders minutes after midnight as "HH:MM".
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func mustParseSettings(t *testing.T, raw string) Settings {
+	t.Helper()
+	s, err := ParseSettings([]byte(raw))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	return s
+}
+
+func TestScheduleAtWorkingHours(t *testing.T) {
+	s := mustParseSettings(t, `{"schedules": [
+		{"pattern": "**/prod/*.env", "days": ["mon", "tue", "wed", "thu", "fri"], "start": "09:00", "end": "18:00", "timezone": "UTC"}
+	]}`)
+
+	// 2026-03-02 is a Monday.
+	monday := func(h, m int) time.Time { return time.Date(2026, 3, 2, h, m, 0, 0, time.UTC) }
+
+	w := s.ScheduleAt("/srv/prod/api.env", monday(10, 30))
+	if !w.Open || !w.End.Equal(monday(18, 0)) || w.Rule != 0 {
+		t.Fatalf("inside window got %+v", w)
+	}
+
+	w = s.ScheduleAt("/srv/prod/api.env", monday(18, 0))
+	if w.Open {
+		t.Fatal("window should be closed at its end time")
+	}
+	if !w.NextStart.Equal(monday(9, 0).AddDate(0, 0, 1)) {
+		t.Fatalf("next start got %s", w.NextStart)
+	}
+	if err := w.Err(); !errors.Is(err, ErrOutsideSchedule) {
+		t.Fatalf("closed window error got %v", err)
+	}
+
+	saturday := time.Date(2026, 3, 7, 12, 0, 0, 0, time.UTC)
+	w = s.ScheduleAt("/srv/prod/api.env", saturday)
+	if w.Open || !w.NextStart.Equal(time.Date(2026, 3, 9, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("weekend got %+v", w)
+	}
+
+	if w := s.ScheduleAt("/home/me/docker/.env", saturday); !w.Open || w.Rule != -1 || !w.End.IsZero() {
+		t.Fatalf("unmatched file should be unrestricted, got %+v", w)
+	}
+}
+
+func TestScheduleAtOvernightWindow(t *testing.T) {
+	s := mustParseSettings(t, `{"schedules": [
+		{"days": ["fri"], "start": "22:00", "end": "02:00", "timezone": "UTC"}
+	]}`)
+
+	friday := time.Date(2026, 3, 6, 23, 0, 0, 0, time.UTC)
+	w := s.ScheduleAt("/any/.env", friday)
+	if !w.Open || !w.End.Equal(time.Date(2026, 3, 7, 2, 0, 0, 0, time.UTC)) {
+		t.Fatalf("friday night got %+v", w)
+	}
+
+	// Saturday 01:00 is still inside the window that opened on Friday.
+	w = s.ScheduleAt("/any/.env", time.Date(2026, 3, 7, 1, 0, 0, 0, time.UTC))
+	if !w.Open {
+		t.Fatalf("early saturday got %+v", w)
+	}
+
+	// Saturday 23:00 is not: no window opens on Saturday.
+	if w := s.ScheduleAt("/any/.env", time.Date(2026, 3, 7, 23, 0, 0, 0, time.UTC)); w.Open {
+		t.Fatalf("saturday night got %+v", w)
+	}
+}
+
+func TestScheduleAtUsesRuleTimezone(t *testing.T) {
+	s := mustParseSettings(t, `{"schedules": [
+		{"start": "09:00", "end": "17:00", "timezone": "Europe/Athens"}
+	]}`)
+	// 07:30 UTC is 09:30 in Athens in winter.
+	now := time.Date(2026, 1, 15, 7, 30, 0, 0, time.UTC)
+	w := s.ScheduleAt("/any/.env", now)
+	if !w.Open || !w.End.Equal(time.Date(2026, 1, 15, 15, 0, 0, 0, time.UTC)) {
+		t.Fatalf("athens window got %+v", w)
+	}
+}
+
+func TestExtendUntilClampsToWindowEnd(t *testing.T) {
+	now := time.Date(2026, 3, 2, 17, 0, 0, 0, time.UTC)
+	windowEnd := time.Date(2026, 3, 2, 18, 0, 0, 0, time.UTC)
+
+	s := NewState()
+	s.Register("/srv/prod/api.env", now.Add(30*time.Minute))
+	wf, err := s.ExtendUntil("/srv/prod/api.env", time.Hour, Limits{}, windowEnd)
+	if err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	if !wf.ExpiresAt.Equal(windowEnd) {
+		t.Fatalf("expiry got %s want %s", wf.ExpiresAt, windowEnd)
+	}
+	if _, err := s.ExtendUntil("/srv/prod/api.env", time.Hour, Limits{}, windowEnd); !errors.Is(err, ErrOutsideSchedule) {
+		t.Fatalf("extend at window end got err=%v want ErrOutsideSchedule", err)
+	}
+}
+
+func TestParseSettingsRejectsInvalidSchedules(t *testing.T) {
+	tests := []string{
+		`{"schedules": [{"start": "9am", "end": "17:00"}]}`,
+		`{"schedules": [{"start": "09:00"}]}`,
+		`{"schedules": [{"start": "09:00", "end": "17:00", "days": ["funday"]}]}`,
+		`{"schedules": [{"start": "09:00", "end": "17:00", "timezone": "Mars/Olympus"}]}`,
+		`{"schedules": [{"start": "09:00", "end": "17:00", "weekends": true}]}`,
+		`{"schedules": [{"start": "09:00", "end": "17:00", "pattern": "[prod"}]}`,
+	}
+	for _, raw := range tests {
+		if _, err := ParseSettings([]byte(raw)); err == nil {
+			t.Fatalf("expected error for %s", raw)
+		}
+	}
+}
@@ -0,0 +1,741 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings is the validated content of the user config file.
+type Settings struct {
+	DefaultTTL time.Duration
+	// TTLPolicies override DefaultTTL for matching paths; the first match
+	// wins.
+	TTLPolicies []TTLPolicy
+	// CheckInterval is how often the daemon checks watched files for expiry.
+	CheckInterval time.Duration
+	// WarningWindows are the offsets before expiry at which warning
+	// notifications fire, longest first.
+	WarningWindows []time.Duration
+	// SocketPath is where the daemon listens for CLI requests.
+	SocketPath string
+	Log        LogSettings
+	// LockOnExit locks every watched file when the daemon quits.
+	LockOnExit bool
+	// LockOnSleep locks every watched file when the machine suspends or the
+	// session locks, unless a file overrides it.
+	LockOnSleep   bool
+	Notifications NotificationSettings
+	Limits        Limits
+	// Schedules restrict when matching files may be unlocked; the first
+	// matching rule applies.
+	Schedules []ScheduleRule
+	// PasswordCommand is run through the shell to obtain the password of
+	// files whose profile has no password command of its own.
+	PasswordCommand string
+	// Pinentry names the pinentry program that password prompts go through
+	// instead of the terminal.
+	Pinentry string
+	// KeyringTTL is how long the CLI caches derived keys in the kernel
+	// keyring; 0 disables the cache.
+	KeyringTTL time.Duration
+	// MinPasswordScore is the strength, from 0 to 4, a password must reach
+	// to encrypt files; 0 accepts any password.
+	MinPasswordScore int
+	// Profiles are keyed by name.
+	Profiles map[string]Profile
+}
+
+// LogSettings configures the daemon's app log.
+type LogSettings struct {
+	Path string
+	// MaxSize is the size in bytes above which the log is rotated at startup.
+	MaxSize int64
+}
+
+// NotificationSettings switches individual notification kinds on or off.
+type NotificationSettings struct {
+	Unlocked     bool
+	Warnings     bool
+	Deleted      bool
+	Updates      bool
+	UnlockFailed bool
+}
+
+// DefaultSettings returns the settings used for keys absent from the config
+// file.
+func DefaultSettings() Settings {
+	return Settings{
+		DefaultTTL:       DefaultTTL,
+		CheckInterval:    DefaultCheckInterval,
+		WarningWindows:   DefaultWarningWindows(),
+		Log:              LogSettings{MaxSize: DefaultLogMaxSize},
+		LockOnExit:       true,
+		MinPasswordScore: DefaultMinPasswordScore,
+		Notifications: NotificationSettings{
+			Unlocked:     true,
+			Warnings:     true,
+			Deleted:      true,
+			Updates:      true,
+			UnlockFailed: true,
+		},
+	}
+}
+
+// FieldError reports an invalid value for a single config key.
+type FieldError struct {
+	Key string
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors collects every invalid key found in a config file.
+type ValidationErrors []*FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+type settingKind int
+
+const (
+	kindDuration settingKind = iota
+	kindDurationList
+	kindBool
+	kindString
+	kindSize
+	kindCount
+	// kindJSON values are given as JSON on the command line.
+	kindJSON
+)
+
+// settingField describes one config key. Nested JSON objects are addressed
+// with dotted keys such as "log.path".
+type settingField struct {
+	key  string
+	kind settingKind
+	// restart marks settings the daemon only reads at startup.
+	restart bool
+	// deprecated keys are still read but are not listed or settable.
+	deprecated bool
+	get        func(Settings) any
+	set        func(*Settings, any) error
+}
+
+var settingFields = []settingField{
+	{
+		key:  "default_ttl",
+		kind: kindDuration,
+		get:  func(s Settings) any { return s.DefaultTTL.String() },
+		set: func(s *Settings, v any) (err error) {
+			s.DefaultTTL, err = durationValue(v, 0)
+			return err
+		},
+	},
+	{
+		key:  "ttl_policies",
+		kind: kindJSON,
+		get: func(s Settings) any {
+			out := make([]map[string]string, 0, len(s.TTLPolicies))
+			for _, p := range s.TTLPolicies {
+				out = append(out, map[string]string{"pattern": p.Pattern, "ttl": p.TTL.String()})
+			}
+			return out
+		},
+		set: func(s *Settings, v any) (err error) {
+			s.TTLPolicies, err = ttlPoliciesValue(v)
+			return err
+		},
+	},
+	{
+		key:  "check_interval",
+		kind: kindDuration,
+		get:  func(s Settings) any { return s.CheckInterval.String() },
+		set: func(s *Settings, v any) (err error) {
+			s.CheckInterval, err = durationValue(v, time.Second)
+			return err
+		},
+	},
+	{
+		key:        "warning_window",
+		kind:       kindDuration,
+		deprecated: true,
+		set: func(s *Settings, v any) error {
+			d, err := durationValue(v, 0)
+			if err != nil {
+				return err
+			}
+			s.WarningWindows = []time.Duration{d}
+			return nil
+		},
+	},
+	{
+		key:  "warning_windows",
+		kind: kindDurationList,
+		get: func(s Settings) any {
+			out := make([]string, 0, len(s.WarningWindows))
+			for _, w := range s.WarningWindows {
+				out = append(out, w.String())
+			}
+			return out
+		},
+		set: func(s *Settings, v any) (err error) {
+			s.WarningWindows, err = warningWindowsValue(v)
+			return err
+		},
+	},
+	{
+		key:     "socket_path",
+		kind:    kindString,
+		restart: true,
+		get:     func(s Settings) any { return s.SocketPath },
+		set: func(s *Settings, v any) (err error) {
+			s.SocketPath, err = pathValue(v)
+			return err
+		},
+	},
+	{
+		key:     "log.path",
+		kind:    kindString,
+		restart: true,
+		get:     func(s Settings) any { return s.Log.Path },
+		set: func(s *Settings, v any) (err error) {
+			s.Log.Path, err = pathValue(v)
+			return err
+		},
+	},
+	{
+		key:     "log.max_size",
+		kind:    kindSize,
+		restart: true,
+		get:     func(s Settings) any { return s.Log.MaxSize },
+		set: func(s *Settings, v any) (err error) {
+			s.Log.MaxSize, err = sizeValue(v)
+			return err
+		},
+	},
+	{
+		key:  "lock_on_exit",
+		kind: kindBool,
+		get:  func(s Settings) any { return s.LockOnExit },
+		set:  func(s *Settings, v any) error { return boolValue(v, &s.LockOnExit) },
+	},
+	{
+		key:  "lock_on_sleep",
+		kind: kindBool,
+		get:  func(s Settings) any { return s.LockOnSleep },
+		set:  func(s *Settings, v any) error { return boolValue(v, &s.LockOnSleep) },
+	},
+	{
+		key:  "limits.max_extensions",
+		kind: kindCount,
+		get:  func(s Settings) any { return s.Limits.MaxExtensions },
+		set: func(s *Settings, v any) (err error) {
+			s.Limits.MaxExtensions, err = countValue(v)
+			return err
+		},
+	},
+	{
+		key:  "limits.max_lifetime",
+		kind: kindDuration,
+		get:  func(s Settings) any { return s.Limits.MaxLifetime.String() },
+		set: func(s *Settings, v any) (err error) {
+			s.Limits.MaxLifetime, err = optionalDurationValue(v)
+			return err
+		},
+	},
+	{
+		key:  "limits.max_unlocked_files",
+		kind: kindCount,
+		get:  func(s Settings) any { return s.Limits.MaxUnlockedFiles },
+		set: func(s *Settings, v any) (err error) {
+			s.Limits.MaxUnlockedFiles, err = countValue(v)
+			return err
+		},
+	},
+	{
+		key:  "limits.lockout_after",
+		kind: kindCount,
+		get:  func(s Settings) any { return s.Limits.LockoutAfter },
+		set: func(s *Settings, v any) (err error) {
+			s.Limits.LockoutAfter, err = countValue(v)
+			return err
+		},
+	},
+	{
+		key:  "schedules",
+		kind: kindJSON,
+		get: func(s Settings) any {
+			out := make([]map[string]any, 0, len(s.Schedules))
+			for _, r := range s.Schedules {
+				rule := map[string]any{"start": formatClock(r.Start), "end": formatClock(r.End)}
+				if r.Pattern != "" {
+					rule["pattern"] = r.Pattern
+				}
+				if len(r.Days) > 0 {
+					days := make([]string, 0, len(r.Days))
+					for _, d := range r.Days {
+						days = append(days, strings.ToLower(d.String()[:3]))
+					}
+					rule["days"] = days
+				}
+				if r.Location != nil {
+					rule["timezone"] = r.Location.String()
+				}
+				out = append(out, rule)
+			}
+			return out
+		},
+		set: func(s *Settings, v any) (err error) {
+			s.Schedules, err = schedulesValue(v)
+			return err
+		},
+	},
+	{
+		key:  "notifications.unlocked",
+		kind: kindBool,
+		get:  func(s Settings) any { return s.Notifications.Unlocked },
+		set:  func(s *Settings, v any) error { return boolValue(v, &s.Notifications.Unlocked) },
+	},
+	{
+		key:  "notifications.warnings",
+		kind: kindBool,
+		get:  func(s Settings) any { return s.Notifications.Warnings },
+		set:  func(s *Settings, v any) error { return boolValue(v, &s.Notifications.Warnings) },
+	},
+	{
+		key:  "notifications.deleted",
+		kind: kindBool,
+		get:  func(s Settings) any { return s.Notifications.Deleted },
+		set:  func(s *Settings, v any) error { return boolValue(v, &s.Notifications.Deleted) },
+	},
+	{
+		key:  "notifications.updates",
+		kind: kindBool,
+		get:  func(s Settings) any { return s.Notifications.Updates },
+		set:  func(s *Settings, v any) error { return boolValue(v, &s.Notifications.Updates) },
+	},
+	{
+		key:  "notifications.unlock_failed",
+		kind: kindBool,
+		get:  func(s Settings) any { return s.Notifications.UnlockFailed },
+		set:  func(s *Settings, v any) error { return boolValue(v, &s.Notifications.UnlockFailed) },
+	},
+	{
+		key:  "password_command",
+		kind: kindString,
+		get:  func(s Settings) any { return s.PasswordCommand },
+		set: func(s *Settings, v any) (err error) {
+			s.PasswordCommand, err = commandValue(v)
+			return err
+		},
+	},
+	{
+		key:  "pinentry",
+		kind: kindString,
+		get:  func(s Settings) any { return s.Pinentry },
+		set: func(s *Settings, v any) (err error) {
+			s.Pinentry, err = commandValue(v)
+			return err
+		},
+	},
+	{
+		key:  "keyring_ttl",
+		kind: kindDuration,
+		get:  func(s Settings) any { return s.KeyringTTL.String() },
+		set: func(s *Settings, v any) (err error) {
+			s.KeyringTTL, err = optionalDurationValue(v)
+			return err
+		},
+	},
+	{
+		key:  "min_password_score",
+		kind: kindCount,
+		get:  func(s Settings) any { return s.MinPasswordScore },
+		set: func(s *Settings, v any) (err error) {
+			s.MinPasswordScore, err = scoreValue(v)
+			return err
+		},
+	},
+	{
+		key:  "profiles",
+		kind: kindJSON,
+		get:  func(s Settings) any { return profilesJSON(s.Profiles) },
+		set: func(s *Settings, v any) (err error) {
+			s.Profiles, err = profilesValue(v)
+			return err
+		},
+	},
+}
+
+func lookupSettingField(key string) *settingField {
+	for i := range settingFields {
+		if settingFields[i].key == key {
+			return &settingFields[i]
+		}
+	}
+	return nil
+}
+
+// SettingKeys lists every settable config key in schema order.
+func SettingKeys() []string {
+	keys := make([]string, 0, len(settingFields))
+	for _, f := range settingFields {
+		if !f.deprecated {
+			keys = append(keys, f.key)
+		}
+	}
+	return keys
+}
+
+// SettingValue returns the value of key in s in its JSON form.
+func SettingValue(s Settings, key string) (any, error) {
+	f := lookupSettingField(key)
+	if f == nil || f.deprecated {
+		return nil, fmt.Errorf("unknown setting %q", key)
+	}
+	return f.get(s), nil
+}
+
+// SettingRequiresRestart reports whether the daemon only applies key at
+// startup.
+func SettingRequiresRestart(key string) bool {
+	f := lookupSettingField(key)
+	return f != nil && f.restart
+}
+
+// LoadSettings reads and validates the config file at path. A missing file
+// yields the defaults.
+func LoadSettings(path string) (Settings, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return DefaultSettings(), nil
+		}
+		return Settings{}, fmt.Errorf("failed to read config: %w", err)
+	}
+	return ParseSettings(b)
+}
+
+// ParseSettings validates config file content. Every invalid or unknown key
+// is reported in the returned ValidationErrors.
+func ParseSettings(b []byte) (Settings, error) {
+	values, err := decodeSettingValues(b)
+	if err != nil {
+		return Settings{}, err
+	}
+	if _, ok := values["warning_windows"]; ok {
+		delete(values, "warning_window")
+	}
+
+	var errs ValidationErrors
+	unknown := make([]string, 0)
+	for key := range values {
+		if lookupSettingField(key) == nil {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+
+	out := DefaultSettings()
+	for _, f := range settingFields {
+		v, ok := values[f.key]
+		if !ok {
+			continue
+		}
+		if err := f.set(&out, v); err != nil {
+			errs = append(errs, &FieldError{Key: f.key, Err: err})
+		}
+	}
+	for _, key := range unknown {
+		errs = append(errs, &FieldError{Key: key, Err: errors.New("unknown setting")})
+	}
+	if len(errs) > 0 {
+		return Settings{}, errs
+	}
+	return out, nil
+}
+
+// SetSetting parses value for key, validates the resulting file and writes it
+// back to path. Other keys in the file are preserved.
+func SetSetting(path, key, value string) error {
+	f := lookupSettingField(key)
+	if f == nil || f.deprecated {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	v, err := f.parseString(value)
+	if err != nil {
+		return &FieldError{Key: key, Err: err}
+	}
+
+	raw := make(map[string]any)
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := decodeJSON(b, &raw); err != nil {
+			return fmt.Errorf("failed to decode config json: %w", err)
+		}
+		if raw == nil {
+			raw = make(map[string]any)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if key == "warning_windows" {
+		delete(raw, "warning_window")
+	}
+	setNestedValue(raw, strings.Split(key, "."), v)
+
+	out, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	if _, err := ParseSettings(out); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(out, '\n'))
+}
+
+// parseString converts a command-line value into its JSON form.
+func (f settingField) parseString(value string) (any, error) {
+	switch f.kind {
+	case kindDurationList:
+		items := make([]any, 0)
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				items = append(items, part)
+			}
+		}
+		return items, nil
+	case kindBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not true or false", value)
+		}
+		return b, nil
+	case kindSize:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a whole number of bytes", value)
+		}
+		return n, nil
+	case kindCount:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a whole number", value)
+		}
+		return n, nil
+	case kindJSON:
+		var v any
+		if err := decodeJSON([]byte(value), &v); err != nil {
+			return nil, fmt.Errorf("invalid JSON value: %w", err)
+		}
+		return v, nil
+	default:
+		return value, nil
+	}
+}
+
+func decodeJSON(b []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// decodeSettingValues decodes a config file into a flat map keyed by dotted
+// setting names.
+func decodeSettingValues(b []byte) (map[string]any, error) {
+	var raw map[string]any
+	if err := decodeJSON(b, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode config json: %w", err)
+	}
+	values := make(map[string]any)
+	flattenSettingValues("", raw, values)
+	return values, nil
+}
+
+func flattenSettingValues(prefix string, in map[string]any, out map[string]any) {
+	for k, v := range in {
+		key := prefix + k
+		// Object-valued settings such as profiles are kept whole.
+		if nested, ok := v.(map[string]any); ok && lookupSettingField(key) == nil {
+			flattenSettingValues(key+".", nested, out)
+			continue
+		}
+		out[key] = v
+	}
+}
+
+func setNestedValue(m map[string]any, parts []string, v any) {
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := m[p].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			m[p] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = v
+}
+
+func durationValue(v any, min time.Duration) (time.Duration, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, errors.New("must be a duration string such as \"30m\"")
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q: must be > 0", s)
+	}
+	if d < min {
+		return 0, fmt.Errorf("invalid duration %q: must be at least %s", s, min)
+	}
+	return d, nil
+}
+
+// optionalDurationValue parses a duration where "0s" means no limit.
+func optionalDurationValue(v any) (time.Duration, error) {
+	if s, ok := v.(string); ok {
+		if d, err := time.ParseDuration(s); err == nil && d == 0 {
+			return 0, nil
+		}
+	}
+	return durationValue(v, 0)
+}
+
+// countValue parses a non-negative whole number where 0 means no limit.
+func countValue(v any) (int, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, errors.New("must be a whole number")
+	}
+	count, err := strconv.Atoi(n.String())
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("invalid count %s: must be a whole number >= 0 (0 means no limit)", n)
+	}
+	return count, nil
+}
+
+// scoreValue parses a password strength score from 0 to 4.
+func scoreValue(v any) (int, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, errors.New("must be a whole number from 0 to 4")
+	}
+	score, err := strconv.Atoi(n.String())
+	if err != nil || score < 0 || score > 4 {
+		return 0, fmt.Errorf("invalid score %s: must be a whole number from 0 to 4 (0 accepts any password)", n)
+	}
+	return score, nil
+}
+
+// warningWindowsValue parses warning offsets and returns them longest first
+// without duplicates. An empty list disables expiry warnings.
+func warningWindowsValue(v any) ([]time.Duration, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, errors.New("must be a list of duration strings")
+	}
+	seen := make(map[time.Duration]bool, len(list))
+	out := make([]time.Duration, 0, len(list))
+	for _, item := range list {
+		d, err := durationValue(item, 0)
+		if err != nil {
+			return nil, err
+		}
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] > out[j] })
+	return out, nil
+}
+
+func pathValue(v any) (string, error) {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return "", errors.New("must be a non-empty path")
+	}
+	if !filepath.IsAbs(s) && !strings.HasPrefix(s, "~/") {
+		return "", fmt.Errorf("path %q must be absolute or start with ~/", s)
+	}
+	return s, nil
+}
+
+func commandValue(v any) (string, error) {
+	s, ok := v.(string)
+	if !ok || strings.TrimSpace(s) == "" {
+		return "", errors.New("must be a non-empty command")
+	}
+	return s, nil
+}
+
+func sizeValue(v any) (int64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, errors.New("must be a number of bytes")
+	}
+	size, err := n.Int64()
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size %s: must be a positive whole number of bytes", n)
+	}
+	return size, nil
+}
+
+func boolValue(v any, dst *bool) error {
+	b, ok := v.(bool)
+	if !ok {
+		return errors.New("must be true or false")
+	}
+	*dst = b
+	return nil
+}
+
+// writeFileAtomic replaces path with data via a temporary file so readers
+// never observe a partial write.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open temp file %q: %w", tmpPath, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write temp file %q: %w", tmpPath, err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to sync temp file %q: %w", tmpPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file %q: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSettingsDefaults(t *testing.T) {
+	got, err := ParseSettings([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	want := DefaultSettings()
+	if got.DefaultTTL != want.DefaultTTL || got.CheckInterval != DefaultCheckInterval || got.Log.MaxSize != DefaultLogMaxSize {
+		t.Fatalf("unexpected defaults: %+v", got)
+	}
+	if got.MinPasswordScore != DefaultMinPasswordScore {
+		t.Fatalf("min_password_score got=%d want=%d", got.MinPasswordScore, DefaultMinPasswordScore)
+	}
+	if !got.LockOnExit || !got.Notifications.Unlocked || !got.Notifications.Warnings || !got.Notifications.Deleted || !got.Notifications.Updates || !got.Notifications.UnlockFailed {
+		t.Fatalf("expected lock_on_exit and all notifications to default on: %+v", got)
+	}
+}
+
+func TestParseSettingsReadsNestedKeys(t *testing.T) {
+	raw := `{
+		"check_interval": "30s",
+		"socket_path": "~/run/dotward.sock",
+		"log": {"path": "/var/tmp/dotward.log", "max_size": 1048576},
+		"lock_on_exit": false,
+		"notifications": {"unlocked": false, "updates": false},
+		"password_command": "pass show dotward"
+	}`
+	got, err := ParseSettings([]byte(raw))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	if got.PasswordCommand != "pass show dotward" {
+		t.Fatalf("password_command got=%q", got.PasswordCommand)
+	}
+	if got.CheckInterval != 30*time.Second {
+		t.Fatalf("check_interval got=%s", got.CheckInterval)
+	}
+	if got.SocketPath != "~/run/dotward.sock" || got.Log.Path != "/var/tmp/dotward.log" || got.Log.MaxSize != 1<<20 {
+		t.Fatalf("unexpected paths: %+v", got)
+	}
+	if got.LockOnExit {
+		t.Fatal("expected lock_on_exit to be disabled")
+	}
+	if got.Notifications.Unlocked || got.Notifications.Updates || !got.Notifications.Warnings || !got.Notifications.Deleted {
+		t.Fatalf("unexpected notifications: %+v", got.Notifications)
+	}
+}
+
+func TestParseSettingsReportsEveryFieldError(t *testing.T) {
+	raw := `{
+		"default_ttl": "forever",
+		"check_interval": "100ms",
+		"socket_path": "relative.sock",
+		"log": {"max_size": -1},
+		"lock_on_exit": "yes",
+		"colour": "blue"
+	}`
+	_, err := ParseSettings([]byte(raw))
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+	want := []string{"default_ttl", "check_interval", "socket_path", "log.max_size", "lock_on_exit", "colour"}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d field errors want %d: %v", len(errs), len(want), err)
+	}
+	for i, key := range want {
+		if errs[i].Key != key {
+			t.Fatalf("field error %d key got=%q want=%q", i, errs[i].Key, key)
+		}
+	}
+}
+
+func TestSetSettingPreservesOtherKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"default_ttl":"2h","warning_window":"10m"}`), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	if err := SetSetting(path, "notifications.updates", "false"); err != nil {
+		t.Fatalf("set notifications.updates: %v", err)
+	}
+	if err := SetSetting(path, "warning_windows", "15m, 1m"); err != nil {
+		t.Fatalf("set warning_windows: %v", err)
+	}
+	if err := SetSetting(path, "log.max_size", "4096"); err != nil {
+		t.Fatalf("set log.max_size: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatalf("decode config: %v", err)
+	}
+	if _, ok := raw["warning_window"]; ok {
+		t.Fatal("legacy warning_window should be dropped once warning_windows is set")
+	}
+
+	got, err := LoadSettings(path)
+	if err != nil {
+		t.Fatalf("load settings: %v", err)
+	}
+	if got.DefaultTTL != 2*time.Hour || got.Notifications.Updates || got.Log.MaxSize != 4096 {
+		t.Fatalf("unexpected settings after set: %+v", got)
+	}
+	if len(got.WarningWindows) != 2 || got.WarningWindows[0] != 15*time.Minute || got.WarningWindows[1] != time.Minute {
+		t.Fatalf("warning windows got=%v", got.WarningWindows)
+	}
+}
+
+func TestSetSettingRejectsInvalidValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	existing := []byte(`{"default_ttl":"2h"}`)
+	if err := os.WriteFile(path, existing, 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	tests := []struct{ key, value string }{
+		{"default_ttl", "-1m"},
+		{"lock_on_exit", "maybe"},
+		{"check_interval", "10ms"},
+		{"no_such_key", "1"},
+		{"warning_window", "5m"},
+		{"min_password_score", "5"},
+		{"min_password_score", "-1"},
+	}
+	for _, tc := range tests {
+		if err := SetSetting(path, tc.key, tc.value); err == nil {
+			t.Fatalf("expected error setting %s=%s", tc.key, tc.value)
+		}
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if string(got) != string(existing) {
+		t.Fatalf("config was modified by rejected set: %s", got)
+	}
+}
+
+func TestParseSettingsReadsLimits(t *testing.T) {
+	got, err := ParseSettings([]byte(`{"limits": {"max_extensions": 3, "max_lifetime": "8h", "max_unlocked_files": 10, "lockout_after": 5}}`))
+	if err != nil {
+		t.Fatalf("parse settings: %v", err)
+	}
+	want := Limits{MaxExtensions: 3, MaxLifetime: 8 * time.Hour, MaxUnlockedFiles: 10, LockoutAfter: 5}
+	if got.Limits != want {
+		t.Fatalf("limits got=%+v want=%+v", got.Limits, want)
+	}
+
+	if _, err := ParseSettings([]byte(`{"limits": {"max_lifetime": "0s", "max_extensions": 0}}`)); err != nil {
+		t.Fatalf("zero limits should disable them: %v", err)
+	}
+	for _, raw := range []string{
+		`{"limits": {"max_extensions": -1}}`,
+		`{"limits": {"max_extensions": 1.5}}`,
+		`{"limits": {"max_lifetime": "-1h"}}`,
+		`{"limits": {"lockout_after": -3}}`,
+	} {
+		if _, err := ParseSettings([]byte(raw)); err == nil {
+			t.Fatalf("expected error for %s", raw)
+		}
+	}
+}
@@ -10,11 +10,168 @@ import (
 	"time"
 )
 
+// SleepPolicy controls whether a watched file is locked when the machine
+// suspends or the user session locks.
+type SleepPolicy string
+
+const (
+	// SleepPolicyDefault follows the global lock_on_sleep setting.
+	SleepPolicyDefault SleepPolicy = ""
+	// SleepPolicyLock always locks the file on suspend or session lock.
+	SleepPolicyLock SleepPolicy = "lock"
+	// SleepPolicyKeep keeps the file unlocked across suspend and session lock.
+	SleepPolicyKeep SleepPolicy = "keep"
+)
+
+// ParseSleepPolicy validates a sleep policy name.
+func ParseSleepPolicy(raw string) (SleepPolicy, error) {
+	switch p := SleepPolicy(raw); p {
+	case SleepPolicyDefault, SleepPolicyLock, SleepPolicyKeep:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid sleep policy %q: must be %q or %q", raw, SleepPolicyLock, SleepPolicyKeep)
+	}
+}
+
 // WatchedFile describes a plaintext file managed by the daemon.
 type WatchedFile struct {
 	Path      string    `json:"path"`
 	ExpiresAt time.Time `json:"expires_at"`
-	Warned    bool      `json:"warned"`
+	// WarnedStages holds the warning offsets that have already fired since
+	// the file was registered or last extended.
+	WarnedStages []time.Duration `json:"warned_stages,omitempty"`
+	// BoundPID, when set, ties the plaintext lifetime to a process: the file
+	// is locked as soon as that process exits, regardless of ExpiresAt.
+	BoundPID int `json:"bound_pid,omitempty"`
+	// BoundStart is when the process BoundPID started. After a restart the
+	// daemon only waits on BoundPID again if it still started then, since
+	// the pid may have been reused by another process.
+	BoundStart time.Time `json:"bound_start,omitempty"`
+	// OnSleep overrides the global lock_on_sleep setting for this file.
+	OnSleep SleepPolicy `json:"on_sleep,omitempty"`
+	// UnlockedAt is when the file was first unlocked. Re-registering a file
+	// that is still watched keeps it, so the maximum lifetime cannot be
+	// reset by unlocking again.
+	UnlockedAt time.Time `json:"unlocked_at,omitempty"`
+	// RegisteredAt is when the file was last registered.
+	RegisteredAt time.Time `json:"registered_at,omitempty"`
+	// Extensions counts how often the expiry has been pushed back.
+	Extensions int `json:"extensions,omitempty"`
+	// Profile is the config profile the file was encrypted for.
+	Profile string `json:"profile,omitempty"`
+}
+
+// Limits bounds how long and how widely files may stay unlocked. A zero
+// field disables that limit.
+type Limits struct {
+	// MaxExtensions is how often a single unlock may be extended.
+	MaxExtensions int
+	// MaxLifetime caps the time from first unlock to expiry.
+	MaxLifetime time.Duration
+	// MaxUnlockedFiles caps how many files may be unlocked at once.
+	MaxUnlockedFiles int
+	// LockoutAfter is how many failed unlock attempts in a row lock a file
+	// out until the lockout is cleared.
+	LockoutAfter int
+}
+
+var (
+	// ErrNotWatched is returned for a path the daemon is not watching.
+	ErrNotWatched = errors.New("file is not currently watched")
+	// ErrExtensionLimit is returned once a file has used all its extensions.
+	ErrExtensionLimit = errors.New("extension limit reached")
+	// ErrLifetimeLimit is returned when a file already expires at its
+	// maximum lifetime.
+	ErrLifetimeLimit = errors.New("maximum unlock lifetime reached")
+	// ErrTooManyUnlocked is returned when registering another file would
+	// exceed the unlocked file limit.
+	ErrTooManyUnlocked = errors.New("too many files unlocked")
+)
+
+// MissingFileGrace is how long after registration a watched file may be
+// missing before the daemon stops watching it. The git smudge filter
+// registers a file before git writes it to the work tree.
+const MissingFileGrace = time.Minute
+
+// MissingGraceOver reports whether a watched file that does not exist at
+// now has been missing for longer than the registration could explain.
+func (wf WatchedFile) MissingGraceOver(now time.Time) bool {
+	registered := wf.RegisteredAt
+	if registered.IsZero() {
+		registered = wf.UnlockedAt
+	}
+	return now.Sub(registered) >= MissingFileGrace
+}
+
+// lifetimeDeadline returns the latest allowed expiry, or the zero time when
+// the lifetime is unbounded.
+func (wf WatchedFile) lifetimeDeadline(maxLifetime time.Duration) time.Time {
+	if maxLifetime <= 0 || wf.UnlockedAt.IsZero() {
+		return time.Time{}
+	}
+	return wf.UnlockedAt.Add(maxLifetime)
+}
+
+// LifetimeExceeded reports whether the file has been unlocked for longer
+// than maxLifetime.
+func (wf WatchedFile) LifetimeExceeded(now time.Time, maxLifetime time.Duration) bool {
+	deadline := wf.lifetimeDeadline(maxLifetime)
+	return !deadline.IsZero() && now.After(deadline)
+}
+
+// LocksOnSleep reports whether the file must be locked on suspend or session
+// lock, given the global lock_on_sleep setting.
+func (wf WatchedFile) LocksOnSleep(global bool) bool {
+	switch wf.OnSleep {
+	case SleepPolicyLock:
+		return true
+	case SleepPolicyKeep:
+		return false
+	default:
+		return global
+	}
+}
+
+// WarningStage identifies one expiry warning out of the configured list.
+type WarningStage struct {
+	// Index is the 1-based position of the stage in firing order.
+	Index int
+	// Total is the number of configured stages.
+	Total int
+	// Offset is how long before expiry the stage fires.
+	Offset time.Duration
+}
+
+// IsFinal reports whether no further warning follows this one.
+func (s WarningStage) IsFinal() bool {
+	return s.Index == s.Total
+}
+
+// DueWarning returns the warning stage that should fire at now, given the
+// configured offsets ordered longest first. When several stages are due at
+// once only the most urgent one is returned, and a stage is never due once
+// it or a later stage has fired.
+func (wf WatchedFile) DueWarning(now time.Time, windows []time.Duration) (WarningStage, bool) {
+	for i := len(windows) - 1; i >= 0; i-- {
+		offset := windows[i]
+		if wf.hasWarned(offset) {
+			return WarningStage{}, false
+		}
+		if !now.Before(wf.ExpiresAt.Add(-offset)) {
+			return WarningStage{Index: i + 1, Total: len(windows), Offset: offset}, true
+		}
+	}
+	return WarningStage{}, false
+}
+
+// hasWarned reports whether a stage at offset, or any later stage, has fired.
+func (wf WatchedFile) hasWarned(offset time.Duration) bool {
+	for _, w := range wf.WarnedStages {
+		if w <= offset {
+			return true
+		}
+	}
+	return false
 }
 
 // State holds all watched files and persists them to disk.
@@ -98,11 +255,49 @@ func (s *State) Save(path string) error {
 
 // Register adds or updates a watched file.
 func (s *State) Register(path string, expiresAt time.Time) {
+	s.RegisterWatched(WatchedFile{Path: path, ExpiresAt: expiresAt})
+}
+
+// RegisterWatched adds or replaces a watched file with all of its attributes.
+func (s *State) RegisterWatched(wf WatchedFile) {
 	s.mu.Lock()
-	s.files[path] = WatchedFile{Path: path, ExpiresAt: expiresAt, Warned: false}
+	s.files[wf.Path] = wf
 	s.mu.Unlock()
 }
 
+// RegisterWithin adds or replaces a watched file subject to limits. A file
+// that is already watched keeps its first unlock time, and the expiry is
+// clamped to the maximum lifetime. Re-registering a watched file with a later
+// expiry counts as an extension; once the extension limit is reached the
+// previous expiry is kept instead. The stored entry is returned.
+func (s *State) RegisterWithin(wf WatchedFile, limits Limits) (WatchedFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, watched := s.files[wf.Path]
+	if watched {
+		if !prev.UnlockedAt.IsZero() {
+			wf.UnlockedAt = prev.UnlockedAt
+		}
+		wf.Extensions = prev.Extensions
+	} else if limits.MaxUnlockedFiles > 0 && len(s.files) >= limits.MaxUnlockedFiles {
+		return WatchedFile{}, fmt.Errorf("%w: at most %d may be unlocked at once", ErrTooManyUnlocked, limits.MaxUnlockedFiles)
+	}
+	if deadline := wf.lifetimeDeadline(limits.MaxLifetime); !deadline.IsZero() && wf.ExpiresAt.After(deadline) {
+		wf.ExpiresAt = deadline
+	}
+	if watched && wf.ExpiresAt.After(prev.ExpiresAt) {
+		if limits.MaxExtensions > 0 && prev.Extensions >= limits.MaxExtensions {
+			wf.ExpiresAt = prev.ExpiresAt
+			wf.WarnedStages = prev.WarnedStages
+		} else {
+			wf.Extensions++
+		}
+	}
+	s.files[wf.Path] = wf
+	return wf, nil
+}
+
 // StopWatching removes a file from state.
 func (s *State) StopWatching(path string) {
 	s.mu.Lock()
@@ -118,18 +313,52 @@ func (s *State) IsWatching(path string) bool {
 	return ok
 }
 
-// Extend extends the TTL for a watched file.
-func (s *State) Extend(path string, delta time.Duration) bool {
+// Extend pushes back the expiry of a watched file by delta, subject to limits.
+// The new expiry is clamped to the maximum lifetime. The updated entry is
+// returned.
+func (s *State) Extend(path string, delta time.Duration, limits Limits) (WatchedFile, error) {
+	return s.ExtendUntil(path, delta, limits, time.Time{})
+}
+
+// ExtendUntil is Extend with an additional deadline, such as the end of an
+// unlock schedule window, that the new expiry may not pass. A zero notAfter
+// means no deadline.
+func (s *State) ExtendUntil(path string, delta time.Duration, limits Limits, notAfter time.Time) (WatchedFile, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	wf, ok := s.files[path]
 	if !ok {
-		return false
+		return WatchedFile{}, ErrNotWatched
 	}
-	wf.ExpiresAt = wf.ExpiresAt.Add(delta)
-	wf.Warned = false
+	if limits.MaxExtensions > 0 && wf.Extensions >= limits.MaxExtensions {
+		return WatchedFile{}, fmt.Errorf("%w: already extended %d times", ErrExtensionLimit, wf.Extensions)
+	}
+	expiresAt := wf.ExpiresAt.Add(delta)
+	if deadline := wf.lifetimeDeadline(limits.MaxLifetime); !deadline.IsZero() && expiresAt.After(deadline) {
+		if !wf.ExpiresAt.Before(deadline) {
+			return WatchedFile{}, fmt.Errorf("%w: unlocked since %s", ErrLifetimeLimit, wf.UnlockedAt.Format(time.RFC3339))
+		}
+		expiresAt = deadline
+	}
+	if !notAfter.IsZero() && expiresAt.After(notAfter) {
+		if !wf.ExpiresAt.Before(notAfter) {
+			return WatchedFile{}, fmt.Errorf("%w: already expires when the window closes at %s", ErrOutsideSchedule, notAfter.Format(time.Kitchen))
+		}
+		expiresAt = notAfter
+	}
+	wf.ExpiresAt = expiresAt
+	wf.Extensions++
+	wf.WarnedStages = nil
 	s.files[path] = wf
-	return true
+	return wf, nil
+}
+
+// Lookup returns the watched file at path.
+func (s *State) Lookup(path string) (WatchedFile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wf, ok := s.files[path]
+	return wf, ok
 }
 
 // Snapshot returns a copy of the current state map.
@@ -143,15 +372,15 @@ func (s *State) Snapshot() map[string]WatchedFile {
 	return out
 }
 
-// MarkWarned marks a file as warned.
-func (s *State) MarkWarned(path string) bool {
+// MarkWarned records that the warning stage at offset fired for a file.
+func (s *State) MarkWarned(path string, offset time.Duration) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	wf, ok := s.files[path]
 	if !ok {
 		return false
 	}
-	wf.Warned = true
+	wf.WarnedStages = append(wf.WarnedStages, offset)
 	s.files[path] = wf
 	return true
 }
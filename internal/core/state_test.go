@@ -0,0 +1,206 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWatchedFileLocksOnSleep(t *testing.T) {
+	tests := []struct {
+		policy SleepPolicy
+		global bool
+		want   bool
+	}{
+		{SleepPolicyDefault, false, false},
+		{SleepPolicyDefault, true, true},
+		{SleepPolicyLock, false, true},
+		{SleepPolicyKeep, true, false},
+	}
+	for _, tc := range tests {
+		wf := WatchedFile{OnSleep: tc.policy}
+		if got := wf.LocksOnSleep(tc.global); got != tc.want {
+			t.Fatalf("policy=%q global=%v got=%v want=%v", tc.policy, tc.global, got, tc.want)
+		}
+	}
+}
+
+func TestWatchedFileDueWarning(t *testing.T) {
+	expires := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	windows := []time.Duration{15 * time.Minute, 5 * time.Minute, time.Minute}
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		warned    []time.Duration
+		wantDue   bool
+		wantIndex int
+	}{
+		{name: "before first stage", now: expires.Add(-20 * time.Minute)},
+		{name: "first stage", now: expires.Add(-15 * time.Minute), wantDue: true, wantIndex: 1},
+		{name: "first stage already fired", now: expires.Add(-10 * time.Minute), warned: []time.Duration{15 * time.Minute}},
+		{name: "second stage", now: expires.Add(-4 * time.Minute), warned: []time.Duration{15 * time.Minute}, wantDue: true, wantIndex: 2},
+		{name: "skips to most urgent stage", now: expires.Add(-30 * time.Second), wantDue: true, wantIndex: 3},
+		{name: "earlier stages covered by later one", now: expires.Add(-30 * time.Second), warned: []time.Duration{time.Minute}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			wf := WatchedFile{ExpiresAt: expires, WarnedStages: tc.warned}
+			stage, due := wf.DueWarning(tc.now, windows)
+			if due != tc.wantDue {
+				t.Fatalf("due got=%v want=%v", due, tc.wantDue)
+			}
+			if due && (stage.Index != tc.wantIndex || stage.Total != len(windows) || stage.Offset != windows[tc.wantIndex-1]) {
+				t.Fatalf("unexpected stage %+v", stage)
+			}
+		})
+	}
+}
+
+func TestExtendResetsWarningStages(t *testing.T) {
+	s := NewState()
+	expires := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.Register("/tmp/.env", expires)
+	if !s.MarkWarned("/tmp/.env", 5*time.Minute) {
+		t.Fatal("mark warned on watched file failed")
+	}
+	if wf := s.Snapshot()["/tmp/.env"]; len(wf.WarnedStages) != 1 {
+		t.Fatalf("warned stages got=%v", wf.WarnedStages)
+	}
+
+	if _, err := s.Extend("/tmp/.env", time.Hour, Limits{}); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	wf := s.Snapshot()["/tmp/.env"]
+	if len(wf.WarnedStages) != 0 {
+		t.Fatalf("warned stages not reset after extend: %v", wf.WarnedStages)
+	}
+	if _, due := wf.DueWarning(expires.Add(56*time.Minute), []time.Duration{5 * time.Minute}); !due {
+		t.Fatal("expected warning to fire again after extension")
+	}
+}
+
+func TestExtendEnforcesLimits(t *testing.T) {
+	unlocked := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	limits := Limits{MaxExtensions: 2, MaxLifetime: 150 * time.Minute}
+
+	s := NewState()
+	if _, err := s.RegisterWithin(WatchedFile{Path: "/tmp/.env", ExpiresAt: unlocked.Add(time.Hour), UnlockedAt: unlocked}, limits); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	wf, err := s.Extend("/tmp/.env", time.Hour, limits)
+	if err != nil {
+		t.Fatalf("first extend: %v", err)
+	}
+	if !wf.ExpiresAt.Equal(unlocked.Add(2*time.Hour)) || wf.Extensions != 1 {
+		t.Fatalf("after first extend got expires=%s extensions=%d", wf.ExpiresAt, wf.Extensions)
+	}
+
+	wf, err = s.Extend("/tmp/.env", time.Hour, limits)
+	if err != nil {
+		t.Fatalf("second extend: %v", err)
+	}
+	if !wf.ExpiresAt.Equal(unlocked.Add(150 * time.Minute)) {
+		t.Fatalf("expiry not clamped to max lifetime: %s", wf.ExpiresAt)
+	}
+
+	if _, err := s.Extend("/tmp/.env", time.Hour, limits); !errors.Is(err, ErrExtensionLimit) {
+		t.Fatalf("third extend got err=%v want ErrExtensionLimit", err)
+	}
+	if _, err := s.Extend("/tmp/.env", time.Hour, Limits{MaxLifetime: limits.MaxLifetime}); !errors.Is(err, ErrLifetimeLimit) {
+		t.Fatalf("extend at max lifetime got err=%v want ErrLifetimeLimit", err)
+	}
+	if _, err := s.Extend("/tmp/missing", time.Hour, limits); !errors.Is(err, ErrNotWatched) {
+		t.Fatalf("extend unknown file got err=%v want ErrNotWatched", err)
+	}
+}
+
+func TestRegisterWithinCountsReRegistrationAsExtension(t *testing.T) {
+	first := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	limits := Limits{MaxExtensions: 1}
+
+	s := NewState()
+	if _, err := s.RegisterWithin(WatchedFile{Path: "/a/.env", ExpiresAt: first.Add(time.Hour), UnlockedAt: first}, limits); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	later := first.Add(10 * time.Minute)
+	wf, err := s.RegisterWithin(WatchedFile{Path: "/a/.env", ExpiresAt: later.Add(time.Hour), UnlockedAt: later}, limits)
+	if err != nil {
+		t.Fatalf("second register: %v", err)
+	}
+	if !wf.ExpiresAt.Equal(later.Add(time.Hour)) || wf.Extensions != 1 {
+		t.Fatalf("second register got expires=%s extensions=%d", wf.ExpiresAt, wf.Extensions)
+	}
+
+	for i := 2; i <= 3; i++ {
+		at := first.Add(time.Duration(i) * 10 * time.Minute)
+		wf, err = s.RegisterWithin(WatchedFile{Path: "/a/.env", ExpiresAt: at.Add(time.Hour), UnlockedAt: at}, limits)
+		if err != nil {
+			t.Fatalf("register %d: %v", i+1, err)
+		}
+		if !wf.ExpiresAt.Equal(later.Add(time.Hour)) || wf.Extensions != 1 {
+			t.Fatalf("register %d past the limit got expires=%s extensions=%d", i+1, wf.ExpiresAt, wf.Extensions)
+		}
+	}
+	if _, err := s.Extend("/a/.env", time.Hour, limits); !errors.Is(err, ErrExtensionLimit) {
+		t.Fatalf("extend after re-registrations got err=%v want ErrExtensionLimit", err)
+	}
+
+	wf, err = s.RegisterWithin(WatchedFile{Path: "/a/.env", ExpiresAt: first.Add(30 * time.Minute), UnlockedAt: first}, limits)
+	if err != nil {
+		t.Fatalf("shortening register: %v", err)
+	}
+	if !wf.ExpiresAt.Equal(first.Add(30 * time.Minute)) {
+		t.Fatalf("shortening register got expires=%s", wf.ExpiresAt)
+	}
+}
+
+func TestRegisterWithinKeepsFirstUnlockAndCapsFiles(t *testing.T) {
+	first := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	limits := Limits{MaxLifetime: 2 * time.Hour, MaxUnlockedFiles: 1}
+
+	s := NewState()
+	if _, err := s.RegisterWithin(WatchedFile{Path: "/a/.env", ExpiresAt: first.Add(time.Hour), UnlockedAt: first}, limits); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := s.Extend("/a/.env", 30*time.Minute, limits); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+
+	later := first.Add(90 * time.Minute)
+	wf, err := s.RegisterWithin(WatchedFile{Path: "/a/.env", ExpiresAt: later.Add(time.Hour), UnlockedAt: later}, limits)
+	if err != nil {
+		t.Fatalf("re-register: %v", err)
+	}
+	if !wf.UnlockedAt.Equal(first) || wf.Extensions != 2 {
+		t.Fatalf("re-register reset limits: unlocked=%s extensions=%d", wf.UnlockedAt, wf.Extensions)
+	}
+	if !wf.ExpiresAt.Equal(first.Add(2 * time.Hour)) {
+		t.Fatalf("re-register expiry not clamped: %s", wf.ExpiresAt)
+	}
+
+	if _, err := s.RegisterWithin(WatchedFile{Path: "/b/.env", ExpiresAt: later.Add(time.Hour), UnlockedAt: later}, limits); !errors.Is(err, ErrTooManyUnlocked) {
+		t.Fatalf("second file got err=%v want ErrTooManyUnlocked", err)
+	}
+	if !wf.LifetimeExceeded(first.Add(2*time.Hour+time.Second), limits.MaxLifetime) {
+		t.Fatal("expected lifetime to be exceeded after max lifetime")
+	}
+}
+
+func TestMissingGraceOver(t *testing.T) {
+	first := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	registered := first.Add(time.Hour)
+	wf := WatchedFile{Path: "/a/.env", UnlockedAt: first, RegisteredAt: registered}
+	if wf.MissingGraceOver(registered.Add(MissingFileGrace - time.Second)) {
+		t.Fatal("a file registered moments ago may still be missing")
+	}
+	if !wf.MissingGraceOver(registered.Add(MissingFileGrace)) {
+		t.Fatal("grace must be over after MissingFileGrace")
+	}
+	old := WatchedFile{Path: "/a/.env", UnlockedAt: first}
+	if !old.MissingGraceOver(registered) {
+		t.Fatal("entries without a registration time fall back to the unlock time")
+	}
+}
@@ -3,10 +3,13 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 
 	"golang.org/x/crypto/argon2"
@@ -15,8 +18,19 @@ import (
 const (
 	magicHeader = "DOT1"
 	versionByte = byte(1)
-	saltSize    = 16
-	keySize     = 32
+	// metadataVersion files carry authenticated metadata after the version
+	// byte. Files without metadata are still written as versionByte.
+	metadataVersion = byte(2)
+	// sessionVersion files are encrypted with a key derived by HKDF from a
+	// master key and a per-file salt. The master key is derived once from
+	// the password with Argon2id and its salt is shared by the files of a
+	// Session. The header always carries a metadata length and is always
+	// authenticated.
+	sessionVersion  = byte(3)
+	maxMetadataSize = 4096
+	saltSize        = 16
+	nonceSize       = 12
+	keySize         = 32
 )
 
 var (
@@ -38,48 +52,151 @@ type argon2Params struct {
 	threads uint8
 }
 
+// KeyDerivationMemory returns the memory in bytes that one Argon2id key
+// derivation uses.
+func KeyDerivationMemory() uint64 {
+	return uint64(currentArgon2.memory) * 1024
+}
+
+// Metadata is stored in the header of an encrypted file. It is readable
+// without the password and authenticated by the cipher, so it cannot be
+// changed without making the file fail to decrypt.
+type Metadata struct {
+	// Profile names the config profile whose key source encrypts the file.
+	// Empty means the default profile.
+	Profile string `json:"profile,omitempty"`
+	// TOTP is the sealed TOTP secret of a file that asks for a one-time
+	// code before it is unlocked. Session.TOTPSecret opens it.
+	TOTP []byte `json:"totp,omitempty"`
+	// TOTPSecret is the secret a Session seals into TOTP when it encrypts
+	// a file. It is never written in the clear.
+	TOTPSecret []byte `json:"-"`
+}
+
+// IsZero reports whether m carries no metadata.
+func (m Metadata) IsZero() bool {
+	return m.Profile == "" && len(m.TOTP) == 0 && len(m.TOTPSecret) == 0
+}
+
 // EncryptFile encrypts src into dst using an Argon2id-derived AES-256-GCM key.
 func EncryptFile(src, dst string, password []byte) error {
+	return EncryptFileWithMetadata(src, dst, password, Metadata{})
+}
+
+// EncryptFileWithMetadata is EncryptFile that records meta in the header.
+func EncryptFileWithMetadata(src, dst string, password []byte, meta Metadata) error {
 	plaintext, err := os.ReadFile(src)
 	if err != nil {
 		return fmt.Errorf("failed to read plaintext file %q: %w", src, err)
 	}
 	defer zeroBytes(plaintext)
 
-	salt := make([]byte, saltSize)
-	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
-		return fmt.Errorf("failed to generate salt: %w", err)
+	payload, err := EncryptBytesWithMetadata(plaintext, password, meta)
+	if err != nil {
+		return err
 	}
+	if err := os.WriteFile(dst, payload, 0o600); err != nil {
+		return fmt.Errorf("failed to write encrypted file %q: %w", dst, err)
+	}
+	return nil
+}
+
+// EncryptBytes encrypts plaintext in memory with random salts and nonce and
+// returns the encrypted file contents. Use a Session to encrypt several
+// files with one key derivation.
+func EncryptBytes(plaintext, password []byte) ([]byte, error) {
+	return EncryptBytesWithMetadata(plaintext, password, Metadata{})
+}
+
+// EncryptBytesWithMetadata is EncryptBytes that records meta in the header.
+func EncryptBytesWithMetadata(plaintext, password []byte, meta Metadata) ([]byte, error) {
+	s := NewSession(password)
+	defer s.Close()
+	return s.EncryptBytes(plaintext, meta)
+}
 
+// EncryptDeterministic encrypts plaintext with the given salt, used directly
+// as the Argon2id salt of the file key, and a nonce
+// derived from the key and the plaintext, so the same plaintext, password and
+// salt always give the same output. This keeps content-addressed stores such
+// as git from seeing a change when nothing changed, at the cost of revealing
+// whether two plaintexts are equal. The output is a regular encrypted file
+// that Decrypt reads.
+func EncryptDeterministic(plaintext, password, salt []byte, meta Metadata) ([]byte, error) {
+	if len(salt) != saltSize {
+		return nil, fmt.Errorf("invalid salt size %d: want %d", len(salt), saltSize)
+	}
+	if len(meta.TOTP) > 0 || len(meta.TOTPSecret) > 0 {
+		return nil, errors.New("deterministic encryption does not support a TOTP secret")
+	}
+	header, err := encodeHeader(meta)
+	if err != nil {
+		return nil, err
+	}
 	key := deriveKey(password, salt, currentArgon2)
 	defer zeroBytes(key)
 
+	// Metadata is part of the nonce input: reusing a nonce for the same
+	// plaintext with different metadata would reveal the GCM hash key.
+	nonceKey := hmac.New(sha256.New, key)
+	nonceKey.Write([]byte("dotward deterministic nonce"))
+	mac := hmac.New(sha256.New, nonceKey.Sum(nil))
+	if !meta.IsZero() {
+		mac.Write(header)
+	}
+	mac.Write(plaintext)
+	return seal(plaintext, key, header, salt, mac.Sum(nil)[:nonceSize])
+}
+
+// encodeHeader returns the bytes before the salt: [magic|version] for files
+// without metadata, [magic|version|length|metadata JSON] otherwise.
+func encodeHeader(meta Metadata) ([]byte, error) {
+	header := append([]byte(magicHeader), versionByte)
+	if meta.IsZero() {
+		return header, nil
+	}
+	return encodeMetadataHeader(metadataVersion, meta)
+}
+
+// encodeMetadataHeader returns [magic|version|length|metadata JSON]. Zero
+// metadata is written with length 0.
+func encodeMetadataHeader(version byte, meta Metadata) ([]byte, error) {
+	header := append([]byte(magicHeader), version)
+	var b []byte
+	if !meta.IsZero() {
+		var err error
+		if b, err = json.Marshal(meta); err != nil {
+			return nil, fmt.Errorf("failed to encode metadata: %w", err)
+		}
+	}
+	if len(b) > maxMetadataSize {
+		return nil, fmt.Errorf("metadata is %d bytes, more than %d", len(b), maxMetadataSize)
+	}
+	header = binary.BigEndian.AppendUint16(header, uint16(len(b)))
+	return append(header, b...), nil
+}
+
+// seal encrypts plaintext and lays out the encrypted file format:
+// [header|salt|nonce|ciphertext], where salt holds both salts of a
+// sessionVersion file. Headers with a metadata length are authenticated as
+// additional data.
+func seal(plaintext, key, header, salt, nonce []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return fmt.Errorf("failed to initialize aes cipher: %w", err)
+		return nil, fmt.Errorf("failed to initialize aes cipher: %w", err)
 	}
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return fmt.Errorf("failed to initialize aes-gcm: %w", err)
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return fmt.Errorf("failed to generate nonce: %w", err)
+		return nil, fmt.Errorf("failed to initialize aes-gcm: %w", err)
 	}
 
-	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
-	payload := make([]byte, 0, len(magicHeader)+1+len(salt)+len(nonce)+len(ciphertext))
-	payload = append(payload, []byte(magicHeader)...)
-	payload = append(payload, versionByte)
+	ciphertext := gcm.Seal(nil, nonce, plaintext, additionalData(header))
+	payload := make([]byte, 0, len(header)+len(salt)+len(nonce)+len(ciphertext))
+	payload = append(payload, header...)
 	payload = append(payload, salt...)
 	payload = append(payload, nonce...)
 	payload = append(payload, ciphertext...)
-
-	if err := os.WriteFile(dst, payload, 0o600); err != nil {
-		return fmt.Errorf("failed to write encrypted file %q: %w", dst, err)
-	}
-	return nil
+	return payload, nil
 }
 
 // Decrypt decrypts src and returns the plaintext bytes without writing to disk.
@@ -89,35 +206,26 @@ func Decrypt(src string, password []byte) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read encrypted file %q: %w", src, err)
 	}
+	return DecryptBytes(payload, password)
+}
 
-	if len(payload) < saltSize+12+16 {
-		return nil, errors.New("encrypted payload is too short")
+// DecryptBytes decrypts encrypted file contents held in memory. The caller is
+// responsible for zeroing the returned slice when done.
+func DecryptBytes(payload, password []byte) ([]byte, error) {
+	p, err := parsePayload(payload)
+	if err != nil {
+		return nil, err
 	}
-
-	var salt, nonce, ciphertext []byte
-	if len(payload) >= len(magicHeader)+1 && string(payload[:len(magicHeader)]) == magicHeader {
-		if payload[len(magicHeader)] != versionByte {
-			return nil, fmt.Errorf("unsupported encrypted file version: %d", payload[len(magicHeader)])
-		}
-		offset := len(magicHeader) + 1
-		if len(payload) < offset+saltSize+12+16 {
-			return nil, errors.New("encrypted payload is too short")
-		}
-		salt = payload[offset : offset+saltSize]
-		offset += saltSize
-		nonce = payload[offset : offset+12]
-		offset += 12
-		ciphertext = payload[offset:]
-	} else {
-		// Legacy layout support: [salt|nonce|ciphertext] without header/version.
-		salt = payload[:saltSize]
-		nonce = payload[saltSize : saltSize+12]
-		ciphertext = payload[saltSize+12:]
+	if p.version == sessionVersion {
+		s := NewSession(password)
+		defer s.Close()
+		return s.open(p)
 	}
+	salt, nonce, ciphertext := p.salt, p.nonce, p.ciphertext
 
 	var lastErr error
 	for _, params := range legacyArgon2Profiles {
-		plaintext, err := decryptPayload(password, params, salt, nonce, ciphertext)
+		plaintext, err := decryptPayload(password, params, salt, nonce, ciphertext, additionalData(p.header))
 		if err != nil {
 			lastErr = err
 			continue
@@ -131,6 +239,148 @@ func Decrypt(src string, password []byte) ([]byte, error) {
 	return nil, errors.New("failed to decrypt payload")
 }
 
+// Header describes an encrypted file without decrypting it.
+type Header struct {
+	// Version is the format version byte, or 0 for legacy files written
+	// without a header.
+	Version byte
+	Legacy  bool
+	// Salt is the Argon2id salt: of the file key or, for sessionVersion
+	// files, of the master key shared by the files of a batch.
+	Salt []byte
+	// FileSalt is the HKDF salt of the file key of sessionVersion files.
+	FileSalt []byte
+	// Session reports a file keyed through a master key, as written by a
+	// Session. Such files cannot be written deterministically.
+	Session bool
+	// Metadata is not authenticated until the file is decrypted.
+	Metadata Metadata
+	// Size is the length of the ciphertext including the GCM tag.
+	Size int
+}
+
+// ErrDecrypt is returned when a file fails authentication: the password is
+// wrong or the file was corrupted or tampered with.
+var ErrDecrypt = errors.New("wrong password or corrupted file")
+
+// ErrMalformed is returned by Inspect for files that cannot be Dotward
+// encrypted files.
+var ErrMalformed = errors.New("not a valid Dotward encrypted file")
+
+// Inspect checks that src is structurally a Dotward encrypted file. It does
+// not need the password, so it cannot detect tampered ciphertext. Legacy
+// files have no header and are only checked for length.
+func Inspect(src string) (Header, error) {
+	payload, err := os.ReadFile(src)
+	if err != nil {
+		return Header{}, fmt.Errorf("failed to read encrypted file %q: %w", src, err)
+	}
+	return InspectBytes(payload)
+}
+
+// InspectBytes is Inspect for an encrypted payload already in memory.
+func InspectBytes(b []byte) (Header, error) {
+	p, err := parsePayload(b)
+	if err != nil {
+		return Header{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return Header{Version: p.version, Legacy: p.version == 0, Salt: p.salt, FileSalt: p.fileSalt, Session: p.version == sessionVersion, Metadata: p.meta, Size: len(p.ciphertext)}, nil
+}
+
+// Fingerprint identifies the encrypted file independently of its path. It
+// is derived from the random salts drawn when the file was first encrypted,
+// so copies of a file share it.
+func (h Header) Fingerprint() string {
+	sum := sha256.Sum256(append(append([]byte(nil), h.Salt...), h.FileSalt...))
+	return hex.EncodeToString(sum[:])
+}
+
+type payload struct {
+	version byte
+	// header is everything before the salt.
+	header                  []byte
+	meta                    Metadata
+	salt, nonce, ciphertext []byte
+	// fileSalt is the HKDF salt of sessionVersion files.
+	fileSalt []byte
+}
+
+// additionalData returns the data authenticated alongside the ciphertext.
+// Only headers with a metadata length are authenticated, which keeps version
+// 1 files unchanged.
+func additionalData(header []byte) []byte {
+	if len(header) > len(magicHeader) {
+		switch header[len(magicHeader)] {
+		case metadataVersion, sessionVersion:
+			return header
+		}
+	}
+	return nil
+}
+
+// parsePayload splits an encrypted file into its parts.
+func parsePayload(b []byte) (payload, error) {
+	if len(b) < saltSize+nonceSize+16 {
+		return payload{}, errors.New("encrypted payload is too short")
+	}
+	if len(b) >= len(magicHeader)+1 && string(b[:len(magicHeader)]) == magicHeader {
+		version := b[len(magicHeader)]
+		offset := len(magicHeader) + 1
+		var meta Metadata
+		switch version {
+		case versionByte:
+		case metadataVersion, sessionVersion:
+			if len(b) < offset+2 {
+				return payload{}, errors.New("encrypted payload is too short")
+			}
+			n := int(binary.BigEndian.Uint16(b[offset:]))
+			offset += 2
+			if n > maxMetadataSize || len(b) < offset+n {
+				return payload{}, errors.New("invalid metadata length")
+			}
+			if n > 0 {
+				if err := json.Unmarshal(b[offset:offset+n], &meta); err != nil {
+					return payload{}, fmt.Errorf("invalid metadata: %w", err)
+				}
+			}
+			offset += n
+		default:
+			return payload{}, fmt.Errorf("unsupported encrypted file version: %d", version)
+		}
+		if version == sessionVersion {
+			if len(b) < offset+2*saltSize+nonceSize+16 {
+				return payload{}, errors.New("encrypted payload is too short")
+			}
+			return payload{
+				version:    version,
+				header:     b[:offset],
+				meta:       meta,
+				salt:       b[offset : offset+saltSize],
+				fileSalt:   b[offset+saltSize : offset+2*saltSize],
+				nonce:      b[offset+2*saltSize : offset+2*saltSize+nonceSize],
+				ciphertext: b[offset+2*saltSize+nonceSize:],
+			}, nil
+		}
+		if len(b) < offset+saltSize+nonceSize+16 {
+			return payload{}, errors.New("encrypted payload is too short")
+		}
+		return payload{
+			version:    version,
+			header:     b[:offset],
+			meta:       meta,
+			salt:       b[offset : offset+saltSize],
+			nonce:      b[offset+saltSize : offset+saltSize+nonceSize],
+			ciphertext: b[offset+saltSize+nonceSize:],
+		}, nil
+	}
+	// Legacy layout support: [salt|nonce|ciphertext] without header/version.
+	return payload{
+		salt:       b[:saltSize],
+		nonce:      b[saltSize : saltSize+nonceSize],
+		ciphertext: b[saltSize+nonceSize:],
+	}, nil
+}
+
 // DecryptFile decrypts src into dst using an Argon2id-derived AES-256-GCM key.
 func DecryptFile(src, dst string, password []byte) error {
 	plaintext, err := Decrypt(src, password)
@@ -145,9 +395,14 @@ func DecryptFile(src, dst string, password []byte) error {
 	return nil
 }
 
-func decryptPayload(password []byte, params argon2Params, salt, nonce, ciphertext []byte) ([]byte, error) {
+func decryptPayload(password []byte, params argon2Params, salt, nonce, ciphertext, additional []byte) ([]byte, error) {
 	key := deriveKey(password, salt, params)
 	defer zeroBytes(key)
+	return openPayload(key, nonce, ciphertext, additional)
+}
+
+// openPayload decrypts and authenticates ciphertext with key.
+func openPayload(key, nonce, ciphertext, additional []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize aes cipher: %w", err)
@@ -159,9 +414,9 @@ func decryptPayload(password []byte, params argon2Params, salt, nonce, ciphertex
 	if len(nonce) != gcm.NonceSize() {
 		return nil, errors.New("invalid nonce size")
 	}
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additional)
 	if err != nil {
-		return nil, err
+		return nil, ErrDecrypt
 	}
 	return plaintext, nil
 }
@@ -1,9 +1,11 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -58,8 +60,8 @@ func TestDecryptBadPasswordFails(t *testing.T) {
 	}
 
 	pwDec := []byte("wrong")
-	if err := DecryptFile(encPath, decPath, pwDec); err == nil {
-		t.Fatal("expected decrypt to fail with wrong password")
+	if err := DecryptFile(encPath, decPath, pwDec); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt for wrong password, got %v", err)
 	}
 }
 
@@ -129,3 +131,139 @@ func encryptLegacyNoHeader(src, dst string, password []byte) error {
 	payload = append(payload, ciphertext...)
 	return os.WriteFile(dst, payload, 0o600)
 }
+
+func TestInspectChecksStructure(t *testing.T) {
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "a.env")
+	if err := os.WriteFile(plainPath, []byte("X=1\n"), 0o600); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	encPath := plainPath + ".enc"
+	if err := EncryptFile(plainPath, encPath, []byte("pw")); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	h, err := Inspect(encPath)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if h.Version != sessionVersion || h.Legacy || h.Size != len("X=1\n")+16 {
+		t.Fatalf("header mismatch: %+v", h)
+	}
+	copyPath := filepath.Join(dir, "copy.enc")
+	if b, err := os.ReadFile(encPath); err != nil || os.WriteFile(copyPath, b, 0o600) != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if c, err := Inspect(copyPath); err != nil || c.Fingerprint() != h.Fingerprint() {
+		t.Fatalf("copy fingerprint differs: %+v %v", c, err)
+	}
+	if err := EncryptFile(plainPath, encPath, []byte("pw")); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if again, err := Inspect(encPath); err != nil || again.Fingerprint() == h.Fingerprint() {
+		t.Fatalf("re-encrypted file kept its fingerprint: %+v %v", again, err)
+	}
+
+	legacyPath := filepath.Join(dir, "legacy.enc")
+	if err := encryptLegacyNoHeader(plainPath, legacyPath, []byte("pw")); err != nil {
+		t.Fatalf("encrypt legacy: %v", err)
+	}
+	if h, err := Inspect(legacyPath); err != nil || !h.Legacy {
+		t.Fatalf("legacy inspect got=%+v err=%v", h, err)
+	}
+
+	bad := map[string][]byte{
+		"short.enc":   []byte("DOT1"),
+		"version.enc": append([]byte("DOT1\x09"), make([]byte, 64)...),
+	}
+	for name, content := range bad {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, content, 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		if _, err := Inspect(p); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("%s: expected ErrMalformed, got %v", name, err)
+		}
+	}
+}
+
+func TestEncryptDeterministic(t *testing.T) {
+	salt := make([]byte, saltSize)
+	for i := range salt {
+		salt[i] = byte(i)
+	}
+	pw := []byte("pw")
+	a, err := EncryptDeterministic([]byte("A=1\n"), pw, salt, Metadata{})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	b, err := EncryptDeterministic([]byte("A=1\n"), pw, salt, Metadata{})
+	if err != nil {
+		t.Fatalf("encrypt again: %v", err)
+	}
+	if string(a) != string(b) {
+		t.Fatal("same plaintext, password and salt must give the same output")
+	}
+	c, err := EncryptDeterministic([]byte("A=2\n"), pw, salt, Metadata{})
+	if err != nil {
+		t.Fatalf("encrypt changed: %v", err)
+	}
+	if string(a[:len(magicHeader)+1+saltSize+nonceSize]) == string(c[:len(magicHeader)+1+saltSize+nonceSize]) {
+		t.Fatal("different plaintexts must use different nonces")
+	}
+
+	out, err := DecryptBytes(a, pw)
+	if err != nil || string(out) != "A=1\n" {
+		t.Fatalf("decrypt got=%q err=%v", out, err)
+	}
+	h, err := InspectBytes(a)
+	if err != nil || string(h.Salt) != string(salt) {
+		t.Fatalf("header salt mismatch: %+v %v", h, err)
+	}
+	if _, err := EncryptDeterministic([]byte("x"), pw, salt[:4], Metadata{}); err == nil {
+		t.Fatal("expected error for short salt")
+	}
+}
+
+func TestMetadataIsAuthenticated(t *testing.T) {
+	pw := []byte("pw")
+	payload, err := EncryptBytesWithMetadata([]byte("A=1\n"), pw, Metadata{Profile: "prod"})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	h, err := InspectBytes(payload)
+	if err != nil || h.Version != sessionVersion || h.Metadata.Profile != "prod" {
+		t.Fatalf("inspect got=%+v err=%v", h, err)
+	}
+	if out, err := DecryptBytes(payload, pw); err != nil || string(out) != "A=1\n" {
+		t.Fatalf("decrypt got=%q err=%v", out, err)
+	}
+
+	// Relabelling the file for another profile of the same length must break
+	// decryption.
+	tampered := bytes.Replace(payload, []byte(`"prod"`), []byte(`"dev1"`), 1)
+	if h, err := InspectBytes(tampered); err != nil || h.Metadata.Profile != "dev1" {
+		t.Fatalf("tampered inspect got=%+v err=%v", h, err)
+	}
+	if _, err := DecryptBytes(tampered, pw); err == nil {
+		t.Fatal("expected tampered metadata to fail decryption")
+	}
+
+	salt := make([]byte, saltSize)
+	plain, err := EncryptDeterministic([]byte("A=1\n"), pw, salt, Metadata{})
+	if err != nil {
+		t.Fatalf("encrypt without metadata: %v", err)
+	}
+	if h, err := InspectBytes(plain); err != nil || h.Version != versionByte || !h.Metadata.IsZero() {
+		t.Fatalf("deterministic files without metadata must keep version 1, got=%+v err=%v", h, err)
+	}
+	labelled, err := EncryptDeterministic([]byte("A=1\n"), pw, salt, Metadata{Profile: "prod"})
+	if err != nil {
+		t.Fatalf("encrypt deterministic with metadata: %v", err)
+	}
+	if h, err := InspectBytes(labelled); err != nil || h.Version != metadataVersion {
+		t.Fatalf("deterministic files with metadata must use version 2, got=%+v err=%v", h, err)
+	}
+	if out, err := DecryptBytes(labelled, pw); err != nil || string(out) != "A=1\n" {
+		t.Fatalf("decrypt version 2 got=%q err=%v", out, err)
+	}
+}
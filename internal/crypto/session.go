@@ -0,0 +1,399 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// fileKeyInfo binds HKDF output to its use as a sessionVersion file key.
+const fileKeyInfo = "dotward file key v3"
+
+// totpKeyInfo binds HKDF output to its use as the key sealing the TOTP
+// secret in a sessionVersion header.
+const totpKeyInfo = "dotward totp key v3"
+
+// Session encrypts and decrypts files with one password while running
+// Argon2id once per master key salt rather than once per file. Files it
+// encrypts share one master key salt and get their own key from HKDF with a
+// random per-file salt, so a batch written by one Session is read back by
+// another with a single derivation. Files in older formats derive their own
+// key as before.
+//
+// A Session is safe for concurrent use. Close zeroes its password and keys.
+type Session struct {
+	mu       sync.Mutex
+	password []byte
+	closed   bool
+	// masters caches master keys by their Argon2id salt.
+	masters map[string]*masterKey
+	// salt is the master key salt for new files: the first salt the session
+	// derived a key for, so that updating a batch keeps its salt.
+	salt []byte
+}
+
+// masterKey is a master key that is ready once its channel is closed.
+type masterKey struct {
+	ready chan struct{}
+	key   []byte
+	// derived keys come from the password rather than AddMasterKey.
+	derived bool
+	// verified keys have opened or written a file.
+	verified bool
+}
+
+// ErrNoKey is returned by a Session without a password for files whose
+// master key it was not given.
+var ErrNoKey = errors.New("no key for this file and no password")
+
+// NewSession returns a Session for password. It keeps a copy, so the caller
+// may zero password. A Session with a nil password only has the master keys
+// given to AddMasterKey.
+func NewSession(password []byte) *Session {
+	return &Session{
+		password: append([]byte(nil), password...),
+		masters:  make(map[string]*masterKey),
+	}
+}
+
+// Close zeroes the password and the cached keys, waiting for derivations in
+// progress. The Session cannot be used afterwards.
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	zeroBytes(s.password)
+	s.password, s.closed = nil, true
+	for salt, mk := range s.masters {
+		<-mk.ready
+		zeroBytes(mk.key)
+		delete(s.masters, salt)
+	}
+}
+
+// AddMasterKey gives the session the master key for salt, such as one cached
+// from an earlier session, so that it need not be derived. It keeps a copy,
+// so the caller may zero key. The first key added or derived also becomes
+// the master key of new files.
+func (s *Session) AddMasterKey(salt, key []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if _, ok := s.masters[string(salt)]; ok {
+		return
+	}
+	mk := &masterKey{ready: make(chan struct{}), key: append([]byte(nil), key...)}
+	close(mk.ready)
+	s.masters[string(salt)] = mk
+	if s.salt == nil {
+		s.salt = append([]byte(nil), salt...)
+	}
+}
+
+// KeepSalt makes salt the master key salt of new files unless the session
+// already has one. Re-encrypting a file with the salt of the sidecar it
+// replaces keeps the files of a batch on one salt, so that they still open
+// with one derivation and one cached key.
+func (s *Session) KeepSalt(salt []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.salt == nil && len(salt) == saltSize {
+		s.salt = append([]byte(nil), salt...)
+	}
+}
+
+// DerivedKeys calls fn with every master key the session derived from its
+// password and then used to open or write a file, so that a wrong password
+// never yields a key. fn must not keep key.
+func (s *Session) DerivedKeys(fn func(salt, key []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for salt, mk := range s.masters {
+		if mk.derived && mk.verified {
+			fn([]byte(salt), mk.key)
+		}
+	}
+}
+
+// verified records that the master key for salt opened or wrote a file.
+func (s *Session) verified(salt []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mk, ok := s.masters[string(salt)]; ok {
+		mk.verified = true
+	}
+}
+
+// EncryptFile encrypts src into dst with a key of the session.
+func (s *Session) EncryptFile(src, dst string, meta Metadata) error {
+	plaintext, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read plaintext file %q: %w", src, err)
+	}
+	defer zeroBytes(plaintext)
+
+	payload, err := s.EncryptBytes(plaintext, meta)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, payload, 0o600); err != nil {
+		return fmt.Errorf("failed to write encrypted file %q: %w", dst, err)
+	}
+	return nil
+}
+
+// EncryptBytes encrypts plaintext in memory and returns the encrypted file
+// contents. A meta.TOTPSecret is sealed into the header with a key of the
+// file.
+func (s *Session) EncryptBytes(plaintext []byte, meta Metadata) ([]byte, error) {
+	salt, master, err := s.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	fileSalt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, fileSalt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	meta.TOTP = nil
+	if len(meta.TOTPSecret) > 0 {
+		if meta.TOTP, err = sealTOTP(master, fileSalt, meta.TOTPSecret); err != nil {
+			return nil, err
+		}
+		meta.TOTPSecret = nil
+	}
+	header, err := encodeMetadataHeader(sessionVersion, meta)
+	if err != nil {
+		return nil, err
+	}
+	key, err := fileKey(master, fileSalt)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(key)
+
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	payload, err := seal(plaintext, key, header, append(append([]byte(nil), salt...), fileSalt...), nonce)
+	if err != nil {
+		return nil, err
+	}
+	s.verified(salt)
+	return payload, nil
+}
+
+// TOTPSecret returns the TOTP secret sealed in the header of encrypted file
+// contents, or nil if the file has none. Only a key of the file opens it,
+// and since the header is authenticated, it cannot be removed without
+// breaking decryption. The caller is responsible for zeroing the secret.
+func (s *Session) TOTPSecret(payload []byte) ([]byte, error) {
+	p, err := parsePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.meta.TOTP) == 0 {
+		return nil, nil
+	}
+	if p.version != sessionVersion {
+		return nil, errors.New("TOTP secret in a file of an older format")
+	}
+	master, err := s.master(p.salt)
+	if err != nil {
+		return nil, err
+	}
+	key, err := totpKey(master, p.fileSalt)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(key)
+
+	sealed := p.meta.TOTP
+	if len(sealed) < nonceSize {
+		return nil, errors.New("invalid TOTP secret")
+	}
+	secret, err := openPayload(key, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TOTP secret: %w", err)
+	}
+	s.verified(p.salt)
+	return secret, nil
+}
+
+// sealTOTP seals secret as [nonce|ciphertext] for a file with fileSalt.
+func sealTOTP(master, fileSalt, secret []byte) ([]byte, error) {
+	key, err := totpKey(master, fileSalt)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(key)
+
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize aes cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize aes-gcm: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, secret, nil), nil
+}
+
+// Decrypt decrypts src and returns the plaintext bytes without writing to
+// disk. The caller is responsible for zeroing the returned slice when done.
+func (s *Session) Decrypt(src string) ([]byte, error) {
+	payload, err := os.ReadFile(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encrypted file %q: %w", src, err)
+	}
+	return s.DecryptBytes(payload)
+}
+
+// DecryptFile decrypts src into dst.
+func (s *Session) DecryptFile(src, dst string) error {
+	plaintext, err := s.Decrypt(src)
+	if err != nil {
+		return err
+	}
+	defer zeroBytes(plaintext)
+
+	if err := os.WriteFile(dst, plaintext, 0o600); err != nil {
+		return fmt.Errorf("failed to write plaintext file %q: %w", dst, err)
+	}
+	return nil
+}
+
+// DecryptBytes decrypts encrypted file contents held in memory. The caller is
+// responsible for zeroing the returned slice when done.
+func (s *Session) DecryptBytes(payload []byte) ([]byte, error) {
+	p, err := parsePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	if p.version == sessionVersion {
+		return s.open(p)
+	}
+	pw, err := s.passwordCopy()
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(pw)
+	return DecryptBytes(payload, pw)
+}
+
+// open decrypts a sessionVersion payload.
+func (s *Session) open(p payload) ([]byte, error) {
+	master, err := s.master(p.salt)
+	if err != nil {
+		return nil, err
+	}
+	key, err := fileKey(master, p.fileSalt)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(key)
+
+	plaintext, err := openPayload(key, p.nonce, p.ciphertext, additionalData(p.header))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt payload: %w", err)
+	}
+	s.verified(p.salt)
+	return plaintext, nil
+}
+
+// encryptionKey returns the master key salt and key for new files. The salt
+// is fixed before deriving, so that concurrent callers share it.
+func (s *Session) encryptionKey() (salt, master []byte, err error) {
+	s.mu.Lock()
+	if s.salt == nil {
+		salt = make([]byte, saltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			s.mu.Unlock()
+			return nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		s.salt = salt
+	}
+	salt = s.salt
+	s.mu.Unlock()
+
+	master, err = s.master(salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return salt, master, nil
+}
+
+// master returns the master key for salt, deriving it on first use.
+// Concurrent callers wait for the derivation of a salt already in progress
+// instead of running their own, while different salts derive in parallel.
+func (s *Session) master(salt []byte) ([]byte, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errors.New("session is closed")
+	}
+	if mk, ok := s.masters[string(salt)]; ok {
+		s.mu.Unlock()
+		<-mk.ready
+		return mk.key, nil
+	}
+	if s.password == nil {
+		s.mu.Unlock()
+		return nil, ErrNoKey
+	}
+	mk := &masterKey{ready: make(chan struct{}), derived: true}
+	s.masters[string(salt)] = mk
+	if s.salt == nil {
+		s.salt = append([]byte(nil), salt...)
+	}
+	pw := append([]byte(nil), s.password...)
+	s.mu.Unlock()
+
+	mk.key = deriveKey(pw, salt, currentArgon2)
+	zeroBytes(pw)
+	close(mk.ready)
+	return mk.key, nil
+}
+
+func (s *Session) passwordCopy() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.closed:
+		return nil, errors.New("session is closed")
+	case s.password == nil:
+		return nil, ErrNoKey
+	}
+	return append([]byte(nil), s.password...), nil
+}
+
+// fileKey derives the key of one file from the master key and its salt.
+func fileKey(master, fileSalt []byte) ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, master, fileSalt, []byte(fileKeyInfo)), key); err != nil {
+		return nil, fmt.Errorf("failed to derive file key: %w", err)
+	}
+	return key, nil
+}
+
+// totpKey derives the key sealing the TOTP secret of one file.
+func totpKey(master, fileSalt []byte) ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, master, fileSalt, []byte(totpKeyInfo)), key); err != nil {
+		return nil, fmt.Errorf("failed to derive TOTP key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,228 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionSharesOneKeyDerivation(t *testing.T) {
+	pw := []byte("pw")
+	s := NewSession(pw)
+	var payloads [][]byte
+	for _, plain := range []string{"A=1\n", "B=2\n", "C=3\n"} {
+		payload, err := s.EncryptBytes([]byte(plain), Metadata{})
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		payloads = append(payloads, payload)
+	}
+	if len(s.masters) != 1 {
+		t.Fatalf("encrypting a batch derived %d master keys, want 1", len(s.masters))
+	}
+	s.Close()
+
+	first, err := InspectBytes(payloads[0])
+	if err != nil || first.Version != sessionVersion || !first.Session {
+		t.Fatalf("inspect got=%+v err=%v", first, err)
+	}
+	for _, payload := range payloads[1:] {
+		h, err := InspectBytes(payload)
+		if err != nil || !bytes.Equal(h.Salt, first.Salt) {
+			t.Fatalf("files of a session must share the master salt, got=%+v err=%v", h, err)
+		}
+		if bytes.Equal(payload[len(payload)-32:], payloads[0][len(payloads[0])-32:]) {
+			t.Fatal("files of a session must not share ciphertext")
+		}
+	}
+
+	reader := NewSession(pw)
+	defer reader.Close()
+	for i, want := range []string{"A=1\n", "B=2\n", "C=3\n"} {
+		out, err := reader.DecryptBytes(payloads[i])
+		if err != nil || string(out) != want {
+			t.Fatalf("decrypt %d got=%q err=%v", i, out, err)
+		}
+	}
+	if len(reader.masters) != 1 {
+		t.Fatalf("decrypting a batch derived %d master keys, want 1", len(reader.masters))
+	}
+
+	// New files written after reading a batch keep its master salt.
+	payload, err := reader.EncryptBytes([]byte("D=4\n"), Metadata{})
+	if err != nil {
+		t.Fatalf("encrypt after decrypt: %v", err)
+	}
+	if h, err := InspectBytes(payload); err != nil || !bytes.Equal(h.Salt, first.Salt) {
+		t.Fatalf("expected the batch salt to be reused, got=%+v err=%v", h, err)
+	}
+
+	wrong := NewSession([]byte("wrong"))
+	defer wrong.Close()
+	if _, err := wrong.DecryptBytes(payloads[0]); err == nil {
+		t.Fatal("expected decrypt to fail with wrong password")
+	}
+
+	// The per-file salt is bound to the key, so swapping it breaks the file.
+	tampered := append([]byte(nil), payloads[0]...)
+	tampered[len(magicHeader)+3+saltSize] ^= 1
+	if _, err := DecryptBytes(tampered, pw); err == nil {
+		t.Fatal("expected tampered file salt to fail decryption")
+	}
+}
+
+func TestSessionReadsPerFileSaltFiles(t *testing.T) {
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "a.env")
+	if err := os.WriteFile(plainPath, []byte("OLD=1\n"), 0o600); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	legacyPath := filepath.Join(dir, "legacy.enc")
+	if err := encryptLegacyNoHeader(plainPath, legacyPath, []byte("pw")); err != nil {
+		t.Fatalf("encrypt legacy: %v", err)
+	}
+	v1, err := EncryptDeterministic([]byte("V1=1\n"), []byte("pw"), make([]byte, saltSize), Metadata{})
+	if err != nil {
+		t.Fatalf("encrypt version 1: %v", err)
+	}
+	v1Path := filepath.Join(dir, "v1.enc")
+	if err := os.WriteFile(v1Path, v1, 0o600); err != nil {
+		t.Fatalf("write version 1: %v", err)
+	}
+
+	s := NewSession([]byte("pw"))
+	defer s.Close()
+	for path, want := range map[string]string{legacyPath: "OLD=1\n", v1Path: "V1=1\n"} {
+		out, err := s.Decrypt(path)
+		if err != nil || string(out) != want {
+			t.Fatalf("decrypt %s got=%q err=%v", filepath.Base(path), out, err)
+		}
+	}
+
+	s.Close()
+	if _, err := s.Decrypt(v1Path); err == nil {
+		t.Fatal("expected a closed session to fail")
+	}
+}
+
+func TestSessionConcurrentEncryptSharesSalt(t *testing.T) {
+	s := NewSession([]byte("pw"))
+	defer s.Close()
+	payloads := make([][]byte, 8)
+	errs := make(chan error, len(payloads))
+	for i := range payloads {
+		go func() {
+			var err error
+			payloads[i], err = s.EncryptBytes([]byte("A=1\n"), Metadata{})
+			errs <- err
+		}()
+	}
+	for range payloads {
+		if err := <-errs; err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+	}
+	if len(s.masters) != 1 {
+		t.Fatalf("concurrent encryption derived %d master keys, want 1", len(s.masters))
+	}
+	for _, payload := range payloads {
+		if out, err := s.DecryptBytes(payload); err != nil || string(out) != "A=1\n" {
+			t.Fatalf("decrypt got=%q err=%v", out, err)
+		}
+	}
+}
+
+func TestSessionWithAddedMasterKey(t *testing.T) {
+	writer := NewSession([]byte("pw"))
+	payload, err := writer.EncryptBytes([]byte("A=1\n"), Metadata{})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	var salt, key []byte
+	writer.DerivedKeys(func(s, k []byte) {
+		salt, key = append([]byte(nil), s...), append([]byte(nil), k...)
+	})
+	writer.Close()
+	if salt == nil {
+		t.Fatal("the key that wrote a file was not reported")
+	}
+
+	s := NewSession(nil)
+	defer s.Close()
+	s.AddMasterKey(salt, key)
+	got, err := s.DecryptBytes(payload)
+	if err != nil || string(got) != "A=1\n" {
+		t.Fatalf("decrypt with added key got=%q err=%v", got, err)
+	}
+	again, err := s.EncryptBytes([]byte("A=2\n"), Metadata{})
+	if err != nil {
+		t.Fatalf("encrypt with added key: %v", err)
+	}
+	if got, err := DecryptBytes(again, []byte("pw")); err != nil || string(got) != "A=2\n" {
+		t.Fatalf("file written with added key got=%q err=%v", got, err)
+	}
+	s.DerivedKeys(func([]byte, []byte) { t.Fatal("added keys must not be reported as derived") })
+
+	other, err := EncryptBytes([]byte("B=1\n"), []byte("pw"))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if _, err := s.DecryptBytes(other); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("decrypt without key err=%v, want ErrNoKey", err)
+	}
+
+	wrong := NewSession([]byte("wrong"))
+	defer wrong.Close()
+	if _, err := wrong.DecryptBytes(payload); err == nil {
+		t.Fatal("wrong password decrypted")
+	}
+	wrong.DerivedKeys(func([]byte, []byte) { t.Fatal("a key that opened nothing must not be reported") })
+}
+
+func TestSessionSealsTOTPSecret(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	s := NewSession([]byte("pw"))
+	defer s.Close()
+	payload, err := s.EncryptBytes([]byte("A=1\n"), Metadata{Profile: "prod", TOTPSecret: secret})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if bytes.Contains(payload, secret) {
+		t.Fatal("TOTP secret written in the clear")
+	}
+	h, err := InspectBytes(payload)
+	if err != nil || h.Metadata.Profile != "prod" || len(h.Metadata.TOTP) == 0 || h.Metadata.TOTPSecret != nil {
+		t.Fatalf("inspect got=%+v err=%v", h, err)
+	}
+
+	other := NewSession([]byte("pw"))
+	defer other.Close()
+	got, err := other.TOTPSecret(payload)
+	if err != nil || !bytes.Equal(got, secret) {
+		t.Fatalf("TOTPSecret got=%q err=%v", got, err)
+	}
+	wrong := NewSession([]byte("other"))
+	defer wrong.Close()
+	if _, err := wrong.TOTPSecret(payload); err == nil {
+		t.Fatal("wrong password opened the TOTP secret")
+	}
+
+	plain, err := s.EncryptBytes([]byte("A=1\n"), Metadata{})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if got, err := s.TOTPSecret(plain); err != nil || got != nil {
+		t.Fatalf("file without TOTP got=%q err=%v", got, err)
+	}
+
+	// The header is authenticated: dropping the secret breaks decryption.
+	stripped := bytes.Replace(payload, []byte(`,"totp":"`), []byte(`,"xxxx":"`), 1)
+	if bytes.Equal(stripped, payload) {
+		t.Fatal("totp field not found in header")
+	}
+	if _, err := other.DecryptBytes(stripped); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("tampered header err=%v", err)
+	}
+}
@@ -0,0 +1,162 @@
+// Package dotenv parses .env files while keeping their original text, so
+// that tools can compare files key by key and still write them back
+// unchanged.
+package dotenv
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Line is one logical line of a .env file. A quoted value may span several
+// physical lines.
+type Line struct {
+	// Key is empty for blank and comment lines.
+	Key   string
+	Value string
+	// Raw is the original text without the trailing newline.
+	Raw string
+	// Number is the 1-based physical line the entry starts on.
+	Number int
+}
+
+// IsEntry reports whether the line assigns a value.
+func (l Line) IsEntry() bool {
+	return l.Key != ""
+}
+
+// Parse splits a .env file into lines. It understands "export KEY=value",
+// single and double quoted values, escapes in double quotes, quoted values
+// spanning lines, and " #" comments after unquoted values.
+func Parse(b []byte) ([]Line, error) {
+	text := strings.ReplaceAll(string(b), "\r\n", "\n")
+	physical := strings.Split(text, "\n")
+	if len(physical) > 0 && physical[len(physical)-1] == "" {
+		physical = physical[:len(physical)-1]
+	}
+
+	var out []Line
+	for i := 0; i < len(physical); i++ {
+		raw := physical[i]
+		start := i + 1
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			out = append(out, Line{Raw: raw, Number: start})
+			continue
+		}
+
+		body := strings.TrimPrefix(trimmed, "export ")
+		eq := strings.IndexByte(body, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=value", start)
+		}
+		key := strings.TrimSpace(body[:eq])
+		if !validKey(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", start, key)
+		}
+		rest := strings.TrimLeft(body[eq+1:], " \t")
+
+		if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+			quote := rest[0]
+			// Keep appending physical lines until the closing quote.
+			for closingQuote(rest[1:], quote) < 0 {
+				i++
+				if i >= len(physical) {
+					return nil, fmt.Errorf("line %d: unterminated %c quote", start, quote)
+				}
+				raw += "\n" + physical[i]
+				rest += "\n" + physical[i]
+			}
+			end := closingQuote(rest[1:], quote) + 1
+			value := rest[1:end]
+			if quote == '"' {
+				value = unescape(value)
+			}
+			if tail := strings.TrimSpace(rest[end+1:]); tail != "" && !strings.HasPrefix(tail, "#") {
+				return nil, fmt.Errorf("line %d: unexpected text after quoted value", start)
+			}
+			out = append(out, Line{Key: key, Value: value, Raw: raw, Number: start})
+			continue
+		}
+
+		if idx := strings.Index(rest, " #"); idx >= 0 {
+			rest = rest[:idx]
+		}
+		out = append(out, Line{Key: key, Value: strings.TrimSpace(rest), Raw: raw, Number: start})
+	}
+	return out, nil
+}
+
+// Values returns the value of every key. A later assignment of a key wins,
+// as it does when a shell sources the file.
+func Values(lines []Line) map[string]string {
+	out := make(map[string]string)
+	for _, l := range lines {
+		if l.IsEntry() {
+			out[l.Key] = l.Value
+		}
+	}
+	return out
+}
+
+// Keys returns the keys in order of first appearance.
+func Keys(lines []Line) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, l := range lines {
+		if l.IsEntry() && !seen[l.Key] {
+			seen[l.Key] = true
+			out = append(out, l.Key)
+		}
+	}
+	return out
+}
+
+func validKey(key string) bool {
+	for i, r := range key {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		case r == '.' || r == '-':
+		default:
+			return false
+		}
+	}
+	return key != ""
+}
+
+// closingQuote returns the index of the unescaped quote ending s, or -1.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		if quote == '"' && s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package dotenv
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	in := "# database\n" +
+		"DB_HOST=localhost\n" +
+		"export DB_USER = admin # inline comment\n" +
+		"\n" +
+		"DB_PASS='p#ss word'\n" +
+		"GREETING=\"hello\\n\\\"world\\\"\"\n" +
+		"CERT=\"-----BEGIN-----\n" +
+		"abc\n" +
+		"-----END-----\"\n" +
+		"EMPTY=\n" +
+		"DB_HOST=override\n"
+
+	lines, err := Parse([]byte(in))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(lines) != 9 {
+		t.Fatalf("expected 9 logical lines, got %d: %+v", len(lines), lines)
+	}
+	if lines[0].IsEntry() || lines[3].IsEntry() {
+		t.Fatal("comment and blank lines must not be entries")
+	}
+	if lines[6].Number != 7 || lines[7].Number != 10 {
+		t.Fatalf("line numbers mismatch: cert=%d empty=%d", lines[6].Number, lines[7].Number)
+	}
+	if lines[6].Raw != "CERT=\"-----BEGIN-----\nabc\n-----END-----\"" {
+		t.Fatalf("multi-line raw mismatch: %q", lines[6].Raw)
+	}
+
+	values := Values(lines)
+	want := map[string]string{
+		"DB_HOST":  "override",
+		"DB_USER":  "admin",
+		"DB_PASS":  "p#ss word",
+		"GREETING": "hello\n\"world\"",
+		"CERT":     "-----BEGIN-----\nabc\n-----END-----",
+		"EMPTY":    "",
+	}
+	for k, v := range want {
+		if values[k] != v {
+			t.Fatalf("%s got=%q want=%q", k, values[k], v)
+		}
+	}
+	if got := strings.Join(Keys(lines), ","); got != "DB_HOST,DB_USER,DB_PASS,GREETING,CERT,EMPTY" {
+		t.Fatalf("keys got=%q", got)
+	}
+}
+
+func TestParseRejectsMalformedInput(t *testing.T) {
+	for _, in := range []string{
+		"NO_EQUALS\n",
+		"=value\n",
+		"1KEY=x\n",
+		"KEY=\"unterminated\n",
+		"KEY='a' trailing\n",
+	} {
+		if _, err := Parse([]byte(in)); err == nil {
+			t.Fatalf("expected error for %q", in)
+		}
+	}
+}
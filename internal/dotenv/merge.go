@@ -0,0 +1,187 @@
+package dotenv
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Conflict markers written by Merge.
+const (
+	MarkerOurs   = "<<<<<<< ours"
+	MarkerSep    = "======="
+	MarkerTheirs = ">>>>>>> theirs"
+)
+
+// Merge performs a three-way merge of .env files key by key. A key changed,
+// added or removed on one side only takes that side's line. A key changed
+// differently on both sides is a conflict: both versions are written between
+// conflict markers and the key is returned in conflicts. The layout,
+// comments and blank lines of ours are kept, and keys added by theirs are
+// placed after the key that precedes them in theirs.
+func Merge(base, ours, theirs []byte) (merged []byte, conflicts []string, err error) {
+	switch {
+	case bytes.Equal(ours, theirs), bytes.Equal(base, theirs):
+		return ours, nil, nil
+	case bytes.Equal(base, ours):
+		return theirs, nil, nil
+	}
+
+	baseLines, err := Parse(base)
+	if err != nil {
+		return nil, nil, fmt.Errorf("base: %w", err)
+	}
+	ourLines, err := Parse(ours)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ours: %w", err)
+	}
+	theirLines, err := Parse(theirs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("theirs: %w", err)
+	}
+	baseSide := newSide(baseLines)
+	ourSide := newSide(ourLines)
+	theirSide := newSide(theirLines)
+
+	// resolve decides what the merge keeps of key. It returns the raw text
+	// to write, or ok=false when the key is left out.
+	resolve := func(key string) (raw string, ok bool) {
+		o, t, b := ourSide.value(key), theirSide.value(key), baseSide.value(key)
+		switch {
+		case o == t, t == b:
+			return ourSide.raw(key), o.present
+		case o == b:
+			return theirSide.raw(key), t.present
+		}
+		conflicts = append(conflicts, key)
+		return conflictBlock(ourSide.raw(key), theirSide.raw(key)), true
+	}
+
+	var out []string
+	done := make(map[string]bool)
+	// emitNew writes the keys that follow key in theirs but are not in ours.
+	var emitNew func(key string)
+	emitNew = func(key string) {
+		for _, next := range theirSide.after[key] {
+			if ourSide.has(next) || done[next] {
+				continue
+			}
+			done[next] = true
+			if raw, ok := resolve(next); ok {
+				out = append(out, raw)
+			}
+			emitNew(next)
+		}
+	}
+
+	emitNew("")
+	for _, l := range ourLines {
+		if !l.IsEntry() {
+			out = append(out, l.Raw)
+			continue
+		}
+		if done[l.Key] {
+			continue
+		}
+		o, t, b := ourSide.value(l.Key), theirSide.value(l.Key), baseSide.value(l.Key)
+		if o == t || t == b {
+			// Ours wins, so every line of the key stays as it is.
+			out = append(out, l.Raw)
+			if ourSide.last[l.Key] == l.Number {
+				done[l.Key] = true
+				emitNew(l.Key)
+			}
+			continue
+		}
+		done[l.Key] = true
+		if raw, ok := resolve(l.Key); ok {
+			out = append(out, raw)
+		}
+		emitNew(l.Key)
+	}
+	// Keys whose anchor was removed from ours go last.
+	for _, key := range Keys(theirLines) {
+		if !done[key] && !ourSide.has(key) {
+			done[key] = true
+			if raw, ok := resolve(key); ok {
+				out = append(out, raw)
+			}
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, conflicts, nil
+	}
+	return []byte(strings.Join(out, "\n") + "\n"), conflicts, nil
+}
+
+// MergeConflict wraps two whole files in conflict markers, for files that
+// cannot be merged key by key.
+func MergeConflict(ours, theirs []byte) []byte {
+	return []byte(conflictBlock(strings.TrimSuffix(string(ours), "\n"), strings.TrimSuffix(string(theirs), "\n")) + "\n")
+}
+
+func conflictBlock(ours, theirs string) string {
+	parts := []string{MarkerOurs}
+	if ours != "" {
+		parts = append(parts, ours)
+	}
+	parts = append(parts, MarkerSep)
+	if theirs != "" {
+		parts = append(parts, theirs)
+	}
+	return strings.Join(append(parts, MarkerTheirs), "\n")
+}
+
+// value is the effective value of a key in one version of a file.
+type value struct {
+	present bool
+	value   string
+}
+
+// side indexes one version of a file for Merge.
+type side struct {
+	values map[string]string
+	// raws holds the last line assigning each key.
+	raws map[string]string
+	// last is the line number of the last assignment of each key.
+	last map[string]int
+	// after lists, for each key, the keys first assigned right after it.
+	// The empty key lists the keys before any other.
+	after map[string][]string
+}
+
+func newSide(lines []Line) side {
+	s := side{
+		values: Values(lines),
+		raws:   make(map[string]string),
+		last:   make(map[string]int),
+		after:  make(map[string][]string),
+	}
+	for _, l := range lines {
+		if l.IsEntry() {
+			s.raws[l.Key] = l.Raw
+			s.last[l.Key] = l.Number
+		}
+	}
+	prev := ""
+	for _, key := range Keys(lines) {
+		s.after[prev] = append(s.after[prev], key)
+		prev = key
+	}
+	return s
+}
+
+func (s side) has(key string) bool {
+	_, ok := s.values[key]
+	return ok
+}
+
+func (s side) value(key string) value {
+	v, ok := s.values[key]
+	return value{present: ok, value: v}
+}
+
+func (s side) raw(key string) string {
+	return s.raws[key]
+}
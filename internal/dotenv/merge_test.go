@@ -0,0 +1,66 @@
+package dotenv
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeCombinesIndependentChanges(t *testing.T) {
+	base := "# app\nA=1\nB=2\nC=3\n"
+	ours := "# app\nA=10\nB=2\nC=3\nD=4\n"
+	theirs := "# app\nA=1\nB=2\nB2=new\nE=5\n"
+
+	merged, conflicts, err := Merge([]byte(base), []byte(ours), []byte(theirs))
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts: %v", conflicts)
+	}
+	want := "# app\nA=10\nB=2\nB2=new\nE=5\nD=4\n"
+	if string(merged) != want {
+		t.Fatalf("merged got=%q want=%q", merged, want)
+	}
+}
+
+func TestMergeMarksOnlyConflictingKeys(t *testing.T) {
+	base := "A=1\nB=2\nC=3\n"
+	ours := "A=ours\nB=2\nC=3\n"
+	theirs := "A=theirs\nB=20\n"
+
+	merged, conflicts, err := Merge([]byte(base), []byte(ours), []byte(theirs))
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if strings.Join(conflicts, ",") != "A" {
+		t.Fatalf("conflicts got=%v want=[A]", conflicts)
+	}
+	want := MarkerOurs + "\nA=ours\n" + MarkerSep + "\nA=theirs\n" + MarkerTheirs + "\nB=20\n"
+	if string(merged) != want {
+		t.Fatalf("merged got=%q want=%q", merged, want)
+	}
+}
+
+func TestMergeConflictsOnDeleteAgainstChange(t *testing.T) {
+	base := "A=1\nB=2\n"
+	ours := "B=2\n"
+	theirs := "A=changed\nB=2\n"
+
+	merged, conflicts, err := Merge([]byte(base), []byte(ours), []byte(theirs))
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if strings.Join(conflicts, ",") != "A" {
+		t.Fatalf("conflicts got=%v want=[A]", conflicts)
+	}
+	want := MarkerOurs + "\n" + MarkerSep + "\nA=changed\n" + MarkerTheirs + "\nB=2\n"
+	if string(merged) != want {
+		t.Fatalf("merged got=%q want=%q", merged, want)
+	}
+}
+
+func TestMergeRejectsUnparsableInput(t *testing.T) {
+	if _, _, err := Merge([]byte("A=1\n"), []byte("A=2\n"), []byte("not a dotenv file\n")); err == nil {
+		t.Fatal("expected error for malformed theirs")
+	}
+}
@@ -5,11 +5,56 @@ import "time"
 // Request is the RPC request payload for file watch operations.
 type Request struct {
 	Path string
-	TTL  time.Duration
+	// TTL is the requested lifetime. Zero lets the daemon pick one from its
+	// TTL policies or default_ttl.
+	TTL time.Duration
+	// PID binds the registration to a process; the daemon locks the file
+	// when that process exits. Zero means no binding.
+	PID int
+	// OnSleep overrides the daemon's lock_on_sleep setting for this file:
+	// "lock", "keep" or empty to inherit it.
+	OnSleep string
+	// Profile names the config profile of the file, whose TTL and
+	// notification settings apply. Empty means the default profile.
+	Profile string
+	// Sidecar is the fingerprint of the encrypted file of Path. Failed
+	// unlock attempts are counted per sidecar, so copying or renaming it
+	// does not reset them; Path is only shown.
+	Sidecar string
+	// Attempt identifies the unlock attempt that Manager.ReserveUnlock
+	// started, for Manager.UnlockFailed and Manager.UnlockSucceeded.
+	Attempt string
+	// TOTPStep is the time step of the TOTP code given for Sidecar, for
+	// Manager.UseTOTPStep.
+	TOTPStep int64
+	// Batch holds the registrations of a Manager.RegisterMany call.
+	Batch []Request
 }
 
 // Response is the RPC response payload.
 type Response struct {
 	Success bool
 	Error   string
+	// TTL is the lifetime the daemon applied on Register and Extend. On
+	// ReserveUnlock and UnlockFailed it is how long to wait before the next
+	// attempt.
+	TTL time.Duration
+	// Attempt identifies the unlock attempt ReserveUnlock started.
+	Attempt string
+	// Results holds one response per request of a Manager.RegisterMany
+	// call, in order.
+	Results []Response
+	// Failures holds the files with failed unlock attempts of a
+	// Manager.ListFailures call, or the files Manager.ClearLockout cleared.
+	Failures []FailedUnlock
+}
+
+// FailedUnlock describes the failed unlock attempts of one file.
+type FailedUnlock struct {
+	Path        string
+	Failures    int
+	LastFailure time.Time
+	// RetryAt is when the next attempt is allowed.
+	RetryAt   time.Time
+	LockedOut bool
 }
@@ -0,0 +1,38 @@
+// Package keyring caches keys in the Linux kernel keyring of the login
+// session, where the kernel discards them once their timeout passes.
+//
+// Keys live in a keyring of their own, linked into the session keyring, so
+// that Clear removes them all without touching other keys. Only processes
+// of the session can read them.
+package keyring
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUnavailable is returned where there is no kernel keyring.
+var ErrUnavailable = errors.New("kernel keyring is not available")
+
+// ErrNotFound is returned by Get for a name that has no key, or whose key
+// timed out.
+var ErrNotFound = errors.New("key not found in keyring")
+
+// ringName is the description of the keyring holding the keys.
+var ringName = "dotward"
+
+// Get returns the key stored under name. The caller is responsible for
+// zeroing it when done.
+func Get(name string) ([]byte, error) {
+	return getKey(name)
+}
+
+// Put stores key under name, replacing any key of that name, for ttl.
+func Put(name string, key []byte, ttl time.Duration) error {
+	return putKey(name, key, ttl)
+}
+
+// Clear removes every key stored by Put.
+func Clear() error {
+	return clearKeys()
+}
@@ -0,0 +1,124 @@
+//go:build linux
+
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxKeySize bounds the keys Get reads.
+const maxKeySize = 256
+
+func getKey(name string) ([]byte, error) {
+	ring, err := findRing()
+	if err != nil {
+		return nil, err
+	}
+	id, err := unix.KeyctlSearch(ring, "user", name, 0)
+	if err != nil {
+		return nil, keyError(err)
+	}
+	buf := make([]byte, maxKeySize)
+	defer zeroBytes(buf)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if err != nil {
+		return nil, keyError(err)
+	}
+	if n > len(buf) {
+		return nil, fmt.Errorf("key %q is longer than %d bytes", name, maxKeySize)
+	}
+	return append([]byte(nil), buf[:n]...), nil
+}
+
+func putKey(name string, key []byte, ttl time.Duration) error {
+	secs := int(ttl / time.Second)
+	if secs <= 0 {
+		return fmt.Errorf("invalid key timeout %s", ttl)
+	}
+	ring, err := findRing()
+	if errors.Is(err, ErrNotFound) {
+		var session int
+		if session, err = sessionRing(); err != nil {
+			return err
+		}
+		ring, err = unix.AddKey("keyring", ringName, nil, session)
+		if err != nil {
+			return fmt.Errorf("failed to create keyring: %w", keyError(err))
+		}
+	} else if err != nil {
+		return err
+	}
+	id, err := unix.AddKey("user", name, key, ring)
+	if err != nil {
+		return fmt.Errorf("failed to add key: %w", keyError(err))
+	}
+	if _, err := unix.KeyctlInt(unix.KEYCTL_SET_TIMEOUT, id, secs, 0, 0); err != nil {
+		// A key without a timeout must not stay behind.
+		_, _ = unix.KeyctlInt(unix.KEYCTL_INVALIDATE, id, 0, 0, 0)
+		return fmt.Errorf("failed to set key timeout: %w", keyError(err))
+	}
+	return nil
+}
+
+func clearKeys() error {
+	ring, err := findRing()
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := unix.KeyctlInt(unix.KEYCTL_CLEAR, ring, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to clear keyring: %w", keyError(err))
+	}
+	return nil
+}
+
+// sessionRing returns the ID of the session keyring or, for processes
+// started outside a login session, which have none, of the user session
+// keyring. The ID is used rather than KEY_SPEC_SESSION_KEYRING because the
+// kernel resolves that for each thread, and a thread without a session
+// keyring would create one of its own when a key is linked into it.
+func sessionRing() (int, error) {
+	id, err := unix.KeyctlGetKeyringID(unix.KEY_SPEC_SESSION_KEYRING, false)
+	if err != nil {
+		return 0, keyError(err)
+	}
+	return id, nil
+}
+
+// findRing returns the ID of the keyring holding the keys.
+func findRing() (int, error) {
+	session, err := sessionRing()
+	if err != nil {
+		return 0, err
+	}
+	id, err := unix.KeyctlSearch(session, "keyring", ringName, 0)
+	if err != nil {
+		return 0, keyError(err)
+	}
+	return id, nil
+}
+
+// keyError maps the errors of missing, expired and revoked keys to
+// ErrNotFound and those of kernels or sandboxes without keyrings to
+// ErrUnavailable.
+func keyError(err error) error {
+	switch {
+	case errors.Is(err, unix.ENOKEY), errors.Is(err, unix.EKEYEXPIRED), errors.Is(err, unix.EKEYREVOKED):
+		return ErrNotFound
+	case errors.Is(err, unix.ENOSYS), errors.Is(err, unix.EPERM), errors.Is(err, unix.EACCES):
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return err
+}
+
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,55 @@
+//go:build linux
+
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPutGetClear(t *testing.T) {
+	ringName = fmt.Sprintf("dotward-test-%d", os.Getpid())
+	t.Cleanup(func() {
+		ring, err := findRing()
+		session, err2 := sessionRing()
+		if err == nil && err2 == nil {
+			_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, ring, session, 0, 0)
+		}
+		ringName = "dotward"
+	})
+
+	if _, err := Get("a"); errors.Is(err, ErrUnavailable) {
+		t.Skipf("no kernel keyring: %v", err)
+	} else if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get before Put err=%v, want ErrNotFound", err)
+	}
+	if err := Put("a", []byte("key-1"), time.Minute); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := Put("a", []byte("key-2"), time.Minute); err != nil {
+		t.Fatalf("Put again: %v", err)
+	}
+	if err := Put("b", []byte("other"), time.Minute); err != nil {
+		t.Fatalf("Put b: %v", err)
+	}
+	if got, err := Get("a"); err != nil || string(got) != "key-2" {
+		t.Fatalf("Get got=%q err=%v", got, err)
+	}
+
+	if err := Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := Get(name); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get %s after Clear err=%v, want ErrNotFound", name, err)
+		}
+	}
+	if err := Put("a", []byte("key"), 0); err == nil {
+		t.Fatal("Put accepted a key without timeout")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package keyring
+
+import "time"
+
+func getKey(string) ([]byte, error) {
+	return nil, ErrUnavailable
+}
+
+func putKey(string, []byte, time.Duration) error {
+	return ErrUnavailable
+}
+
+func clearKeys() error {
+	return ErrUnavailable
+}
@@ -0,0 +1,225 @@
+// Package logind listens for systemd-logind suspend and session lock signals.
+//
+// The monitor holds a "delay" sleep inhibitor so that consumers get a chance
+// to act on EventSleep before the machine actually suspends. Calling
+// Event.Done releases the inhibitor; a fresh one is taken on resume.
+package logind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	busName          = "org.freedesktop.login1"
+	managerPath      = dbus.ObjectPath("/org/freedesktop/login1")
+	managerInterface = "org.freedesktop.login1.Manager"
+	sessionInterface = "org.freedesktop.login1.Session"
+	sessionNamespace = dbus.ObjectPath("/org/freedesktop/login1/session")
+)
+
+// EventKind identifies a logind lifecycle event.
+type EventKind int
+
+const (
+	// EventSleep fires when the system is about to suspend or hibernate.
+	EventSleep EventKind = iota + 1
+	// EventResume fires after the system resumed from sleep.
+	EventResume
+	// EventLock fires when the monitored session is locked.
+	EventLock
+	// EventUnlock fires when the monitored session is unlocked.
+	EventUnlock
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventSleep:
+		return "sleep"
+	case EventResume:
+		return "resume"
+	case EventLock:
+		return "lock"
+	case EventUnlock:
+		return "unlock"
+	default:
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+}
+
+// Event is a single logind notification.
+type Event struct {
+	Kind    EventKind
+	release func()
+}
+
+// Done releases the sleep delay inhibitor held for an EventSleep. It is safe
+// to call on any event and more than once.
+func (e Event) Done() {
+	if e.release != nil {
+		e.release()
+	}
+}
+
+// Monitor translates logind D-Bus signals into Events.
+type Monitor struct {
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+	signals chan *dbus.Signal
+
+	mu        sync.Mutex
+	inhibitor *os.File
+}
+
+// Connect opens the system bus and monitors the session the current process
+// belongs to. When the session cannot be resolved, lock signals from every
+// session are reported.
+func Connect() (*Monitor, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+	var session dbus.ObjectPath
+	obj := conn.Object(busName, managerPath)
+	if err := obj.Call(managerInterface+".GetSessionByPID", 0, uint32(os.Getpid())).Store(&session); err != nil {
+		session = ""
+	}
+	return NewMonitor(conn, session), nil
+}
+
+// NewMonitor creates a monitor on an existing bus connection. An empty
+// session path reports lock signals from every session.
+func NewMonitor(conn *dbus.Conn, session dbus.ObjectPath) *Monitor {
+	return &Monitor{conn: conn, session: session}
+}
+
+// Start subscribes to logind signals and delivers events until ctx is done or
+// the connection closes.
+func (m *Monitor) Start(ctx context.Context) (<-chan Event, error) {
+	if m.signals != nil {
+		return nil, errors.New("monitor already started")
+	}
+	if err := m.conn.AddMatchSignal(
+		dbus.WithMatchSender(busName),
+		dbus.WithMatchObjectPath(managerPath),
+		dbus.WithMatchInterface(managerInterface),
+		dbus.WithMatchMember("PrepareForSleep"),
+	); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to PrepareForSleep: %w", err)
+	}
+	sessionMatch := []dbus.MatchOption{
+		dbus.WithMatchSender(busName),
+		dbus.WithMatchInterface(sessionInterface),
+	}
+	if m.session != "" {
+		sessionMatch = append(sessionMatch, dbus.WithMatchObjectPath(m.session))
+	} else {
+		sessionMatch = append(sessionMatch, dbus.WithMatchPathNamespace(sessionNamespace))
+	}
+	if err := m.conn.AddMatchSignal(sessionMatch...); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to session signals: %w", err)
+	}
+
+	m.signals = make(chan *dbus.Signal, 16)
+	m.conn.Signal(m.signals)
+	m.inhibit()
+
+	events := make(chan Event, 4)
+	go m.run(ctx, events)
+	return events, nil
+}
+
+// Close releases the inhibitor and closes the bus connection.
+func (m *Monitor) Close() error {
+	m.releaseInhibitor()
+	return m.conn.Close()
+}
+
+func (m *Monitor) run(ctx context.Context, events chan<- Event) {
+	defer close(events)
+	defer m.conn.RemoveSignal(m.signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig, ok := <-m.signals:
+			if !ok {
+				return
+			}
+			ev, ok := m.translate(sig)
+			if !ok {
+				continue
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				ev.Done()
+				return
+			}
+		}
+	}
+}
+
+func (m *Monitor) translate(sig *dbus.Signal) (Event, bool) {
+	switch sig.Name {
+	case managerInterface + ".PrepareForSleep":
+		if len(sig.Body) != 1 {
+			return Event{}, false
+		}
+		start, ok := sig.Body[0].(bool)
+		if !ok {
+			return Event{}, false
+		}
+		if start {
+			return Event{Kind: EventSleep, release: m.releaseInhibitor}, true
+		}
+		m.inhibit()
+		return Event{Kind: EventResume}, true
+	case sessionInterface + ".Lock":
+		if !m.ownSession(sig.Path) {
+			return Event{}, false
+		}
+		return Event{Kind: EventLock}, true
+	case sessionInterface + ".Unlock":
+		if !m.ownSession(sig.Path) {
+			return Event{}, false
+		}
+		return Event{Kind: EventUnlock}, true
+	}
+	return Event{}, false
+}
+
+func (m *Monitor) ownSession(path dbus.ObjectPath) bool {
+	return m.session == "" || path == m.session
+}
+
+// inhibit takes a delay inhibitor for sleep. Failure only means events may
+// arrive too late to act on before suspend, so it is not reported.
+func (m *Monitor) inhibit() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.inhibitor != nil {
+		return
+	}
+	var fd dbus.UnixFD
+	obj := m.conn.Object(busName, managerPath)
+	call := obj.Call(managerInterface+".Inhibit", 0, "sleep", "Dotward", "Lock unlocked secret files before sleep", "delay")
+	if err := call.Store(&fd); err != nil {
+		return
+	}
+	m.inhibitor = os.NewFile(uintptr(fd), "logind-inhibitor")
+}
+
+func (m *Monitor) releaseInhibitor() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.inhibitor != nil {
+		_ = m.inhibitor.Close()
+		m.inhibitor = nil
+	}
+}
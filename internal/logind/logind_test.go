@@ -0,0 +1,212 @@
+package logind
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const testSession = dbus.ObjectPath("/org/freedesktop/login1/session/_31")
+
+// fakeLogind owns the logind bus name on a private bus and hands out pipe
+// file descriptors as sleep inhibitors.
+type fakeLogind struct {
+	conn      *dbus.Conn
+	inhibited chan inhibitorPipe
+}
+
+// inhibitorPipe is the fake's view of an inhibitor: the read end reports EOF
+// once every copy of the write end, including the one sent over the bus, is
+// closed.
+type inhibitorPipe struct {
+	r, w *os.File
+}
+
+func (p inhibitorPipe) Close() {
+	_ = p.r.Close()
+	_ = p.w.Close()
+}
+
+func (f *fakeLogind) Inhibit(what, who, why, mode string) (dbus.UnixFD, *dbus.Error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, dbus.MakeFailedError(err)
+	}
+	f.inhibited <- inhibitorPipe{r: r, w: w}
+	return dbus.UnixFD(w.Fd()), nil
+}
+
+func startPrivateBus(t *testing.T) string {
+	t.Helper()
+	bin, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not available")
+	}
+	cmd := exec.Command(bin, "--session", "--nofork", "--nopidfile", "--print-address=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("stdout pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read bus address: %v", err)
+	}
+	return strings.TrimSpace(line)
+}
+
+func connect(t *testing.T, addr string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatalf("connect to private bus: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func startFakeLogind(t *testing.T, addr string) *fakeLogind {
+	t.Helper()
+	f := &fakeLogind{conn: connect(t, addr), inhibited: make(chan inhibitorPipe, 4)}
+	if err := f.conn.Export(f, managerPath, managerInterface); err != nil {
+		t.Fatalf("export fake logind: %v", err)
+	}
+	reply, err := f.conn.RequestName(busName, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("request logind name: reply=%v err=%v", reply, err)
+	}
+	return f
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestMonitorReportsSleepAndReleasesInhibitor(t *testing.T) {
+	addr := startPrivateBus(t)
+	logind := startFakeLogind(t, addr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := NewMonitor(connect(t, addr), testSession).Start(ctx)
+	if err != nil {
+		t.Fatalf("start monitor: %v", err)
+	}
+
+	var inhibitor inhibitorPipe
+	select {
+	case inhibitor = <-logind.inhibited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitor did not take a sleep inhibitor")
+	}
+	defer inhibitor.r.Close()
+	// Start returned, so the reply carrying the descriptor has been delivered.
+	_ = inhibitor.w.Close()
+
+	if err := logind.conn.Emit(managerPath, managerInterface+".PrepareForSleep", true); err != nil {
+		t.Fatalf("emit PrepareForSleep: %v", err)
+	}
+	ev := nextEvent(t, events)
+	if ev.Kind != EventSleep {
+		t.Fatalf("event kind got=%s want=%s", ev.Kind, EventSleep)
+	}
+
+	released := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(inhibitor.r)
+		released <- err
+	}()
+	ev.Done()
+	select {
+	case err := <-released:
+		if err != nil {
+			t.Fatalf("read inhibitor: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("inhibitor was not released by Done")
+	}
+
+	if err := logind.conn.Emit(managerPath, managerInterface+".PrepareForSleep", false); err != nil {
+		t.Fatalf("emit PrepareForSleep: %v", err)
+	}
+	if ev := nextEvent(t, events); ev.Kind != EventResume {
+		t.Fatalf("event kind got=%s want=%s", ev.Kind, EventResume)
+	}
+	select {
+	case p := <-logind.inhibited:
+		p.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitor did not re-acquire the inhibitor after resume")
+	}
+}
+
+func TestMonitorFiltersLockSignalsBySession(t *testing.T) {
+	addr := startPrivateBus(t)
+	logind := startFakeLogind(t, addr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := NewMonitor(connect(t, addr), testSession).Start(ctx)
+	if err != nil {
+		t.Fatalf("start monitor: %v", err)
+	}
+
+	other := dbus.ObjectPath("/org/freedesktop/login1/session/_99")
+	if err := logind.conn.Emit(other, sessionInterface+".Lock"); err != nil {
+		t.Fatalf("emit Lock: %v", err)
+	}
+	if err := logind.conn.Emit(testSession, sessionInterface+".Lock"); err != nil {
+		t.Fatalf("emit Lock: %v", err)
+	}
+	if ev := nextEvent(t, events); ev.Kind != EventLock {
+		t.Fatalf("event kind got=%s want=%s", ev.Kind, EventLock)
+	}
+	if err := logind.conn.Emit(testSession, sessionInterface+".Unlock"); err != nil {
+		t.Fatalf("emit Unlock: %v", err)
+	}
+	if ev := nextEvent(t, events); ev.Kind != EventUnlock {
+		t.Fatalf("event kind got=%s want=%s", ev.Kind, EventUnlock)
+	}
+}
+
+func TestMonitorIgnoresSignalsFromOtherSenders(t *testing.T) {
+	addr := startPrivateBus(t)
+	impostor := connect(t, addr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := NewMonitor(connect(t, addr), testSession).Start(ctx)
+	if err != nil {
+		t.Fatalf("start monitor: %v", err)
+	}
+
+	if err := impostor.Emit(managerPath, managerInterface+".PrepareForSleep", true); err != nil {
+		t.Fatalf("emit PrepareForSleep: %v", err)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event from impostor: %s", ev.Kind)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
@@ -0,0 +1,393 @@
+// Package manifest reads the per-project .dotward.json or .dotward.yaml file
+// that lists the secret files of a repository.
+//
+// Paths and globs in a manifest are relative to the directory that contains
+// it, so the file can be committed and shared. Globs are matched against
+// plaintext paths, and a locked file counts through its .enc sidecar, so
+// include patterns find secrets whether or not they are currently unlocked.
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/stefanos/dotward/internal/core"
+)
+
+// Names lists the manifest file names looked up in each directory.
+var Names = []string{".dotward.json", ".dotward.yaml", ".dotward.yml"}
+
+// ErrNotFound is returned by Find when no manifest exists in the directory or
+// any of its parents.
+var ErrNotFound = errors.New("no .dotward.json or .dotward.yaml manifest found")
+
+// File is a secret file listed explicitly in a manifest. In the manifest it
+// is either a plain path string or an object with the fields below.
+type File struct {
+	Path string `json:"path" yaml:"path"`
+	// TTL overrides the unlock lifetime from the user's config.
+	TTL string `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	// Profile names the key profile the file is encrypted with.
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty"`
+	// Recipients is reserved for sharing files with other people's keys and
+	// is currently only validated.
+	Recipients []string `json:"recipients,omitempty" yaml:"recipients,omitempty"`
+}
+
+// fileObject has the same fields as File without its custom decoding.
+type fileObject File
+
+// UnmarshalJSON accepts a path string or a file object.
+func (f *File) UnmarshalJSON(b []byte) error {
+	var p string
+	if err := json.Unmarshal(b, &p); err == nil {
+		*f = File{Path: p}
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var obj fileObject
+	if err := dec.Decode(&obj); err != nil {
+		return err
+	}
+	*f = File(obj)
+	return nil
+}
+
+// UnmarshalYAML accepts a path string or a file object.
+func (f *File) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*f = File{Path: node.Value}
+		return nil
+	}
+	var obj fileObject
+	if err := node.Decode(&obj); err != nil {
+		return err
+	}
+	*f = File(obj)
+	return nil
+}
+
+// MarshalJSON writes a file with only a path as a plain string.
+func (f File) MarshalJSON() ([]byte, error) {
+	if f.onlyPath() {
+		return json.Marshal(f.Path)
+	}
+	return json.Marshal(fileObject(f))
+}
+
+// MarshalYAML writes a file with only a path as a plain string.
+func (f File) MarshalYAML() (any, error) {
+	if f.onlyPath() {
+		return f.Path, nil
+	}
+	return fileObject(f), nil
+}
+
+func (f File) onlyPath() bool {
+	return f.TTL == "" && f.Profile == "" && len(f.Recipients) == 0
+}
+
+// Manifest is a parsed project manifest.
+type Manifest struct {
+	// Path is the manifest file and Root the directory containing it.
+	Path string `json:"-" yaml:"-"`
+	Root string `json:"-" yaml:"-"`
+
+	Files []File `json:"files,omitempty" yaml:"files,omitempty"`
+	// Include adds every file matching one of these globs.
+	Include []string `json:"include,omitempty" yaml:"include,omitempty"`
+	// Exclude removes matching files, including ones listed in Files.
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+}
+
+// Entry is a secret file selected by a manifest.
+type Entry struct {
+	// Path is the absolute plaintext path.
+	Path string
+	// Rel is Path relative to the manifest directory, with forward slashes.
+	Rel        string
+	TTL        time.Duration
+	Profile    string
+	Recipients []string
+}
+
+// Find returns the path of the manifest in dir or its closest parent.
+func Find(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve directory %q: %w", dir, err)
+	}
+	for {
+		var found []string
+		for _, name := range Names {
+			p := filepath.Join(dir, name)
+			if _, err := os.Stat(p); err == nil {
+				found = append(found, p)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return "", fmt.Errorf("failed to stat manifest %q: %w", p, err)
+			}
+		}
+		switch len(found) {
+		case 0:
+		case 1:
+			return found[0], nil
+		default:
+			return "", fmt.Errorf("found more than one manifest in %s: %s", dir, strings.Join(found, ", "))
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ErrNotFound
+		}
+		dir = parent
+	}
+}
+
+// Load reads and validates the manifest at path.
+func Load(p string) (*Manifest, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %q: %w", p, err)
+	}
+	m, err := Parse(b, filepath.Ext(p))
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %q: %w", p, err)
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve manifest path %q: %w", p, err)
+	}
+	m.Path = abs
+	m.Root = filepath.Dir(abs)
+	return m, nil
+}
+
+// Save validates m and writes it to path, as JSON when path ends in ".json"
+// and as YAML otherwise. An existing file is replaced.
+func Save(p string, m *Manifest) error {
+	if err := m.validate(); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	var (
+		b   []byte
+		err error
+	)
+	if filepath.Ext(p) == ".json" {
+		if b, err = json.MarshalIndent(m, "", "  "); err == nil {
+			b = append(b, '\n')
+		}
+	} else {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err = enc.Encode(m); err == nil {
+			err = enc.Close()
+		}
+		b = buf.Bytes()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(p, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest %q: %w", p, err)
+	}
+	return nil
+}
+
+// Parse decodes and validates manifest contents. ext selects the format:
+// ".json" for JSON, anything else for YAML.
+func Parse(b []byte, ext string) (*Manifest, error) {
+	var m Manifest
+	if ext == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&m); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON: %w", err)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to decode YAML: %w", err)
+		}
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *Manifest) validate() error {
+	if len(m.Files) == 0 && len(m.Include) == 0 {
+		return errors.New("lists no files: add files or include patterns")
+	}
+	for i, f := range m.Files {
+		if _, err := relPath(f.Path); err != nil {
+			return fmt.Errorf("files[%d]: %w", i, err)
+		}
+		if _, err := f.ttl(); err != nil {
+			return fmt.Errorf("files[%d]: %w", i, err)
+		}
+		for _, r := range f.Recipients {
+			if strings.TrimSpace(r) == "" {
+				return fmt.Errorf("files[%d]: recipients must not be empty", i)
+			}
+		}
+	}
+	for _, list := range []struct {
+		name     string
+		patterns []string
+	}{{"include", m.Include}, {"exclude", m.Exclude}} {
+		for i, pattern := range list.patterns {
+			if _, err := relPath(pattern); err != nil {
+				return fmt.Errorf("%s[%d]: %w", list.name, i, err)
+			}
+			if err := core.ValidatePattern(pattern); err != nil {
+				return fmt.Errorf("%s[%d]: %w", list.name, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (f File) ttl() (time.Duration, error) {
+	if f.TTL == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(f.TTL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl %q: %w", f.TTL, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid ttl %q: must be > 0", f.TTL)
+	}
+	return d, nil
+}
+
+// relPath cleans a manifest path and rejects ones that leave the project.
+func relPath(p string) (string, error) {
+	if p == "" {
+		return "", errors.New("path is required")
+	}
+	p = filepath.ToSlash(p)
+	if path.IsAbs(p) || filepath.IsAbs(p) {
+		return "", fmt.Errorf("path %q must be relative to the manifest", p)
+	}
+	clean := path.Clean(p)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("path %q is outside the manifest directory", p)
+	}
+	return strings.TrimSuffix(clean, ".enc"), nil
+}
+
+// Entries returns the files selected by the manifest: the listed files in
+// order, then files matching include patterns in lexical order, minus any
+// that match an exclude pattern. Each file appears once.
+func (m *Manifest) Entries() ([]Entry, error) {
+	seen := make(map[string]bool)
+	var out []Entry
+	add := func(e Entry) {
+		if seen[e.Rel] || m.excluded(e.Rel) {
+			return
+		}
+		seen[e.Rel] = true
+		out = append(out, e)
+	}
+
+	for _, f := range m.Files {
+		rel, err := relPath(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := f.ttl()
+		if err != nil {
+			return nil, err
+		}
+		add(Entry{
+			Path:       filepath.Join(m.Root, filepath.FromSlash(rel)),
+			Rel:        rel,
+			TTL:        ttl,
+			Profile:    f.Profile,
+			Recipients: f.Recipients,
+		})
+	}
+
+	if len(m.Include) == 0 {
+		return out, nil
+	}
+	matched, err := m.walkIncluded()
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range matched {
+		add(Entry{Path: filepath.Join(m.Root, filepath.FromSlash(rel)), Rel: rel})
+	}
+	return out, nil
+}
+
+// walkIncluded returns the plaintext paths below Root, relative to it, that
+// match an include pattern. A file and its sidecar yield the same path twice;
+// Entries drops the duplicate. Directories matched by SkipDir are skipped.
+func (m *Manifest) walkIncluded() ([]string, error) {
+	var out []string
+	err := filepath.WalkDir(m.Root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != m.Root && SkipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(m.Root, p)
+		if err != nil {
+			return err
+		}
+		rel = strings.TrimSuffix(filepath.ToSlash(rel), ".enc")
+		for _, pattern := range m.Include {
+			if core.MatchPattern(pattern, rel) {
+				out = append(out, rel)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %q for manifest includes: %w", m.Root, err)
+	}
+	return out, nil
+}
+
+// SkipDir reports whether a directory is never searched for secret files:
+// version control metadata and dependency trees.
+func SkipDir(name string) bool {
+	switch name {
+	case ".git", ".hg", ".svn", "node_modules", "vendor":
+		return true
+	}
+	return false
+}
+
+func (m *Manifest) excluded(rel string) bool {
+	for _, pattern := range m.Exclude {
+		if core.MatchPattern(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
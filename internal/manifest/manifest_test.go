@@ -0,0 +1,196 @@
+package manifest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, p, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", p, err)
+	}
+}
+
+func TestFindWalksUpToClosestManifest(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".dotward.yaml"), "files: [.env]\n")
+	nested := filepath.Join(root, "services", "api")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	got, err := Find(nested)
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if got != filepath.Join(root, ".dotward.yaml") {
+		t.Fatalf("find got=%q", got)
+	}
+
+	writeFile(t, filepath.Join(root, "services", ".dotward.json"), `{"files": [".env"]}`)
+	got, err = Find(nested)
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if got != filepath.Join(root, "services", ".dotward.json") {
+		t.Fatalf("closest manifest not preferred, got=%q", got)
+	}
+
+	writeFile(t, filepath.Join(root, "services", ".dotward.yml"), "files: [.env]\n")
+	if _, err := Find(nested); err == nil || !strings.Contains(err.Error(), "more than one manifest") {
+		t.Fatalf("expected ambiguity error, got %v", err)
+	}
+}
+
+func TestFindReportsMissingManifest(t *testing.T) {
+	if _, err := Find(t.TempDir()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestParseJSONAndYAML(t *testing.T) {
+	inputs := map[string]string{
+		".json": `{
+			"files": [".env", {"path": "api/.env", "ttl": "15m", "profile": "work", "recipients": ["ops@example.com"]}],
+			"include": ["services/*/.env"],
+			"exclude": ["**/.env.example"]
+		}`,
+		".yaml": `
+files:
+  - .env
+  - path: api/.env
+    ttl: 15m
+    profile: work
+    recipients: [ops@example.com]
+include: ["services/*/.env"]
+exclude: ["**/.env.example"]
+`,
+	}
+	for ext, in := range inputs {
+		m, err := Parse([]byte(in), ext)
+		if err != nil {
+			t.Fatalf("parse %s: %v", ext, err)
+		}
+		if len(m.Files) != 2 || m.Files[0].Path != ".env" {
+			t.Fatalf("%s files mismatch: %+v", ext, m.Files)
+		}
+		api := m.Files[1]
+		if api.Path != "api/.env" || api.TTL != "15m" || api.Profile != "work" || len(api.Recipients) != 1 {
+			t.Fatalf("%s file object mismatch: %+v", ext, api)
+		}
+		if len(m.Include) != 1 || len(m.Exclude) != 1 {
+			t.Fatalf("%s globs mismatch: %+v", ext, m)
+		}
+	}
+}
+
+func TestParseRejectsInvalidManifests(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", `{}`, "lists no files"},
+		{"absolute path", `{"files": ["/etc/.env"]}`, "must be relative"},
+		{"escapes root", `{"files": ["../other/.env"]}`, "outside the manifest directory"},
+		{"bad ttl", `{"files": [{"path": ".env", "ttl": "soon"}]}`, "invalid ttl"},
+		{"negative ttl", `{"files": [{"path": ".env", "ttl": "-1m"}]}`, "must be > 0"},
+		{"unknown field", `{"files": [{"path": ".env", "mode": "x"}]}`, "unknown field"},
+		{"bad glob", `{"include": ["[.env"]}`, "invalid pattern"},
+	}
+	for _, tc := range tests {
+		_, err := Parse([]byte(tc.in), ".json")
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected error containing %q, got %v", tc.name, tc.want, err)
+		}
+	}
+	if _, err := Parse([]byte("files: [.env]\nttl: 1h\n"), ".yaml"); err == nil {
+		t.Fatal("expected error for unknown top-level YAML field")
+	}
+}
+
+func TestEntriesCombinesFilesIncludesAndExcludes(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".env"), "A=1\n")
+	writeFile(t, filepath.Join(root, "services", "api", ".env.enc"), "locked")
+	writeFile(t, filepath.Join(root, "services", "web", ".env"), "B=2\n")
+	writeFile(t, filepath.Join(root, "services", "web", ".env.enc"), "locked")
+	writeFile(t, filepath.Join(root, "services", "legacy", ".env"), "C=3\n")
+	writeFile(t, filepath.Join(root, ".git", "services", "x", ".env"), "ignored")
+	writeFile(t, filepath.Join(root, "node_modules", "pkg", ".env"), "ignored")
+	writeFile(t, filepath.Join(root, "services", "web", "vendor", "lib", ".env"), "ignored")
+	writeFile(t, filepath.Join(root, ".dotward.yaml"), `
+files:
+  - path: services/web/.env
+    ttl: 10m
+  - .env
+include: ["services/*/.env", "**/.env"]
+exclude: ["services/legacy/**"]
+`)
+
+	m, err := Load(filepath.Join(root, ".dotward.yaml"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if m.Root != root {
+		t.Fatalf("root got=%q want=%q", m.Root, root)
+	}
+	entries, err := m.Entries()
+	if err != nil {
+		t.Fatalf("entries: %v", err)
+	}
+
+	var rels []string
+	for _, e := range entries {
+		rels = append(rels, e.Rel)
+		if e.Path != filepath.Join(root, filepath.FromSlash(e.Rel)) {
+			t.Fatalf("entry path mismatch: %+v", e)
+		}
+	}
+	want := []string{"services/web/.env", ".env", "services/api/.env"}
+	if strings.Join(rels, ",") != strings.Join(want, ",") {
+		t.Fatalf("entries got=%q want=%q", rels, want)
+	}
+	if entries[0].TTL != 10*time.Minute || entries[1].TTL != 0 {
+		t.Fatalf("entry TTLs mismatch: %+v", entries)
+	}
+}
+
+func TestSaveRoundTrips(t *testing.T) {
+	dir := t.TempDir()
+	want := &Manifest{
+		Files:   []File{{Path: ".env"}, {Path: "api/.env", TTL: "15m"}},
+		Exclude: []string{"**/.env.example"},
+	}
+	for _, name := range []string{".dotward.yaml", ".dotward.json"} {
+		p := filepath.Join(dir, name)
+		if err := Save(p, want); err != nil {
+			t.Fatalf("save %s: %v", name, err)
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if !strings.Contains(string(b), ".env") || strings.Contains(string(b), `"path": ".env"`) {
+			t.Fatalf("%s should list path-only files as strings:\n%s", name, b)
+		}
+		got, err := Load(p)
+		if err != nil {
+			t.Fatalf("load %s: %v", name, err)
+		}
+		if len(got.Files) != 2 || got.Files[1].TTL != "15m" || got.Exclude[0] != "**/.env.example" {
+			t.Fatalf("%s round trip mismatch: %+v", name, got)
+		}
+	}
+	if err := Save(filepath.Join(dir, "empty.yaml"), &Manifest{}); err == nil {
+		t.Fatal("expected error saving a manifest without files")
+	}
+}
@@ -0,0 +1,271 @@
+// Package pinentry asks for passwords through a pinentry program, such as
+// pinentry-gtk, pinentry-curses or pinentry-mac, speaking the Assuan
+// protocol over its stdin and stdout.
+//
+// The password is decoded into buffers the package owns and zeroes, never
+// into strings.
+package pinentry
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ErrCancelled is returned when the user closes the pinentry dialog.
+var ErrCancelled = errors.New("pinentry: cancelled")
+
+// errCodeCancelled is GPG_ERR_CANCELED, the low 16 bits of the error code
+// pinentry answers with when the dialog is cancelled.
+const errCodeCancelled = 99
+
+// maxLine is the longest line the Assuan protocol allows.
+const maxLine = 1000
+
+// Prompt describes one password request.
+type Prompt struct {
+	// Title is the window title.
+	Title string
+	// Description says what the password is for. It may span lines.
+	Description string
+	// Prompt labels the input field, such as "Password:".
+	Prompt string
+	// Repeat, when set, labels a second field that must match the first.
+	Repeat string
+	// TTYName and TTYType tell terminal pinentries where to draw.
+	TTYName string
+	TTYType string
+}
+
+// GetPIN runs program and asks it for a password. The caller is responsible
+// for zeroing the returned slice when done. When program does not support
+// confirmation fields, a password with Repeat set is asked for twice.
+func GetPIN(program string, p Prompt) ([]byte, error) {
+	cmd := exec.Command(program)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start pinentry: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start pinentry: %w", err)
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start pinentry %q: %w", program, err)
+	}
+
+	c := &conn{w: stdin, r: stdout, buf: make([]byte, 2*maxLine)}
+	pin, err := c.getPIN(p)
+	zeroBytes(c.buf)
+	if err != nil {
+		_ = stdin.Close()
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, err
+	}
+	_, _, _ = c.call("BYE")
+	_ = stdin.Close()
+	_ = cmd.Wait()
+	return pin, nil
+}
+
+func (c *conn) getPIN(p Prompt) ([]byte, error) {
+	if _, _, err := c.response(); err != nil {
+		return nil, fmt.Errorf("pinentry did not start: %w", err)
+	}
+	// Options are hints; pinentries that do not know one answer ERR.
+	if p.TTYName != "" {
+		_, _, _ = c.call("OPTION ttyname=" + p.TTYName)
+	}
+	if p.TTYType != "" {
+		_, _, _ = c.call("OPTION ttytype=" + p.TTYType)
+	}
+	for _, set := range []struct{ command, value string }{
+		{"SETTITLE", p.Title},
+		{"SETDESC", p.Description},
+		{"SETPROMPT", p.Prompt},
+	} {
+		if set.value == "" {
+			continue
+		}
+		if _, _, err := c.call(set.command + " " + escape(set.value)); err != nil {
+			return nil, err
+		}
+	}
+	if p.Repeat == "" {
+		return c.pin()
+	}
+
+	if _, _, err := c.call("SETREPEAT " + escape(p.Repeat)); err == nil {
+		pin, status, err := c.call("GETPIN")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Contains(status, []byte("PIN_REPEATED")) {
+			zeroBytes(pin)
+			return nil, errors.New("pinentry did not confirm the password")
+		}
+		return pin, nil
+	}
+
+	// Older pinentries have no confirmation field: ask twice.
+	pin, err := c.pin()
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := c.call("SETPROMPT " + escape(p.Repeat)); err != nil {
+		zeroBytes(pin)
+		return nil, err
+	}
+	again, err := c.pin()
+	if err != nil {
+		zeroBytes(pin)
+		return nil, err
+	}
+	defer zeroBytes(again)
+	if len(pin) != len(again) || subtle.ConstantTimeCompare(pin, again) != 1 {
+		zeroBytes(pin)
+		return nil, errors.New("passwords do not match")
+	}
+	return pin, nil
+}
+
+func (c *conn) pin() ([]byte, error) {
+	pin, _, err := c.call("GETPIN")
+	return pin, err
+}
+
+// conn is the client side of an Assuan connection.
+type conn struct {
+	w io.Writer
+	r io.Reader
+	// buf holds received bytes from start to end.
+	buf        []byte
+	start, end int
+}
+
+// call sends command and returns the data and status lines of the response.
+func (c *conn) call(command string) (data, status []byte, err error) {
+	if _, err := io.WriteString(c.w, command+"\n"); err != nil {
+		return nil, nil, fmt.Errorf("failed to write to pinentry: %w", err)
+	}
+	return c.response()
+}
+
+// response reads lines up to OK or ERR, collecting the decoded data lines
+// and the status lines.
+func (c *conn) response() (data, status []byte, err error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			zeroBytes(data)
+			return nil, nil, fmt.Errorf("failed to read from pinentry: %w", err)
+		}
+		switch {
+		case bytes.Equal(line, []byte("OK")) || bytes.HasPrefix(line, []byte("OK ")):
+			return data, status, nil
+		case bytes.HasPrefix(line, []byte("ERR ")):
+			zeroBytes(data)
+			return nil, nil, responseError(string(line[len("ERR "):]))
+		case bytes.HasPrefix(line, []byte("D ")):
+			data = unescapeAppend(data, line[len("D "):])
+		case bytes.HasPrefix(line, []byte("S ")):
+			status = append(append(status, line[len("S "):]...), '\n')
+		case bytes.HasPrefix(line, []byte("INQUIRE ")):
+			// Nothing is ever inquired of; cancel so that pinentry moves on.
+			if _, err := io.WriteString(c.w, "CAN\n"); err != nil {
+				zeroBytes(data)
+				return nil, nil, fmt.Errorf("failed to write to pinentry: %w", err)
+			}
+		}
+		// Comments and unknown lines are skipped.
+	}
+}
+
+func responseError(msg string) error {
+	code, desc, _ := strings.Cut(msg, " ")
+	if n, err := strconv.ParseUint(code, 10, 32); err == nil && n&0xffff == errCodeCancelled {
+		return ErrCancelled
+	}
+	return fmt.Errorf("pinentry: %s", desc)
+}
+
+// readLine returns the next line without its newline. The line is only valid
+// until the next call.
+func (c *conn) readLine() ([]byte, error) {
+	for {
+		if i := bytes.IndexByte(c.buf[c.start:c.end], '\n'); i >= 0 {
+			line := c.buf[c.start : c.start+i]
+			c.start += i + 1
+			return line, nil
+		}
+		n := copy(c.buf, c.buf[c.start:c.end])
+		zeroBytes(c.buf[n:c.end])
+		c.start, c.end = 0, n
+		if c.end == len(c.buf) {
+			return nil, errors.New("line too long")
+		}
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if n == 0 && err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+// escape percent-encodes the characters Assuan lines cannot carry.
+func escape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// unescapeAppend appends the percent-decoded b to dst, growing dst only by
+// copying into a buffer it then zeroes.
+func unescapeAppend(dst, b []byte) []byte {
+	out := dst
+	if cap(dst)-len(dst) < len(b) {
+		out = make([]byte, len(dst), len(dst)+len(b)+maxLine)
+		copy(out, dst)
+		zeroBytes(dst)
+	}
+	for i := 0; i < len(b); i++ {
+		if b[i] == '%' && i+2 < len(b) {
+			hi, ok1 := unhex(b[i+1])
+			lo, ok2 := unhex(b[i+2])
+			if ok1 && ok2 {
+				out = append(out, hi<<4|lo)
+				i += 2
+				continue
+			}
+		}
+		out = append(out, b[i])
+	}
+	return out
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,156 @@
+package pinentry
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The test binary stands in for a pinentry program when fakeModeEnv is set.
+const (
+	fakeModeEnv = "DOTWARD_FAKE_PINENTRY"
+	// fakePINsEnv lists the PINs to answer with, separated by commas and
+	// escaped as on the wire.
+	fakePINsEnv = "DOTWARD_FAKE_PINENTRY_PINS"
+	// fakeLogEnv names a file that receives every command.
+	fakeLogEnv = "DOTWARD_FAKE_PINENTRY_LOG"
+)
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(fakeModeEnv); mode != "" {
+		fakePinentry(mode)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakePinentry speaks the pinentry side of the protocol. In mode "repeat" it
+// supports SETREPEAT, in mode "old" it does not and in mode "cancel" every
+// GETPIN is cancelled.
+func fakePinentry(mode string) {
+	log, err := os.OpenFile(os.Getenv(fakeLogEnv), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		os.Exit(1)
+	}
+	defer log.Close()
+	pins := strings.Split(os.Getenv(fakePINsEnv), ",")
+
+	fmt.Println("OK Pleased to meet you")
+	in := bufio.NewScanner(os.Stdin)
+	repeat := false
+	for in.Scan() {
+		line := in.Text()
+		fmt.Fprintln(log, line)
+		command, _, _ := strings.Cut(line, " ")
+		switch {
+		case command == "BYE":
+			fmt.Println("OK closing connection")
+			return
+		case command == "SETREPEAT" && mode != "repeat":
+			fmt.Println("ERR 536871187 Unknown IPC command <User defined source 1>")
+		case command == "SETREPEAT":
+			repeat = true
+			fmt.Println("OK")
+		case command == "GETPIN" && mode == "cancel":
+			fmt.Println("ERR 83886179 Operation cancelled <Pinentry>")
+		case command == "GETPIN":
+			if repeat {
+				fmt.Println("S PIN_REPEATED")
+			}
+			if pins[0] != "" {
+				fmt.Println("D " + pins[0])
+			}
+			pins = pins[1:]
+			fmt.Println("OK")
+		default:
+			fmt.Println("OK")
+		}
+	}
+}
+
+// fake makes GetPIN run the test binary as a pinentry in mode, answering
+// with pins, and returns the program to pass and the file logging commands.
+func fake(t *testing.T, mode string, pins ...string) (string, string) {
+	t.Helper()
+	logPath := t.TempDir() + "/log"
+	t.Setenv(fakeModeEnv, mode)
+	t.Setenv(fakePINsEnv, strings.Join(pins, ","))
+	t.Setenv(fakeLogEnv, logPath)
+	program, err := os.Executable()
+	if err != nil {
+		t.Fatalf("executable: %v", err)
+	}
+	return program, logPath
+}
+
+func readLog(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	return string(b)
+}
+
+func TestGetPINDecodesAndDescribes(t *testing.T) {
+	program, logPath := fake(t, "repeat", "p%25ss%0Aword")
+	pin, err := GetPIN(program, Prompt{
+		Title:       "Dotward",
+		Description: "Decrypting .env\n100% sure",
+		Prompt:      "Password:",
+		TTYName:     "/dev/pts/3",
+	})
+	if err != nil {
+		t.Fatalf("GetPIN: %v", err)
+	}
+	if string(pin) != "p%ss\nword" {
+		t.Fatalf("pin = %q", pin)
+	}
+	want := "OPTION ttyname=/dev/pts/3\nSETTITLE Dotward\nSETDESC Decrypting .env%0A100%25 sure\nSETPROMPT Password:\nGETPIN\nBYE\n"
+	if got := readLog(t, logPath); got != want {
+		t.Fatalf("commands:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGetPINConfirms(t *testing.T) {
+	program, logPath := fake(t, "repeat", "secret")
+	pin, err := GetPIN(program, Prompt{Prompt: "Password:", Repeat: "Confirm:"})
+	if err != nil || string(pin) != "secret" {
+		t.Fatalf("GetPIN = %q, %v", pin, err)
+	}
+	if got := readLog(t, logPath); !strings.Contains(got, "SETREPEAT Confirm:\nGETPIN\n") {
+		t.Fatalf("commands:\n%s", got)
+	}
+}
+
+func TestGetPINAsksTwiceWithoutRepeatSupport(t *testing.T) {
+	program, logPath := fake(t, "old", "secret", "secret")
+	pin, err := GetPIN(program, Prompt{Prompt: "Password:", Repeat: "Confirm:"})
+	if err != nil || string(pin) != "secret" {
+		t.Fatalf("GetPIN = %q, %v", pin, err)
+	}
+	if got := readLog(t, logPath); !strings.Contains(got, "GETPIN\nSETPROMPT Confirm:\nGETPIN\n") {
+		t.Fatalf("commands:\n%s", got)
+	}
+
+	program, _ = fake(t, "old", "secret", "other")
+	if _, err := GetPIN(program, Prompt{Prompt: "Password:", Repeat: "Confirm:"}); err == nil || !strings.Contains(err.Error(), "do not match") {
+		t.Fatalf("mismatch: %v", err)
+	}
+}
+
+func TestGetPINCancelled(t *testing.T) {
+	program, _ := fake(t, "cancel")
+	if _, err := GetPIN(program, Prompt{Prompt: "Password:"}); !errors.Is(err, ErrCancelled) {
+		t.Fatalf("GetPIN err = %v, want ErrCancelled", err)
+	}
+}
+
+func TestGetPINMissingProgram(t *testing.T) {
+	if _, err := GetPIN(t.TempDir()+"/no-pinentry", Prompt{}); err == nil {
+		t.Fatal("expected an error for a missing program")
+	}
+}
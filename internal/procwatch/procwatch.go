@@ -0,0 +1,60 @@
+// Package procwatch waits for arbitrary processes to exit.
+//
+// It uses the most precise mechanism available on each platform: pidfd on
+// Linux, kqueue on macOS and periodic liveness probes elsewhere.
+package procwatch
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// pollInterval bounds how long a single wait syscall or liveness probe blocks
+// before the context is checked again.
+const pollInterval = 500 * time.Millisecond
+
+// ErrInvalidPID is returned for PIDs that can never identify a process.
+var ErrInvalidPID = errors.New("invalid process id")
+
+// Wait blocks until the process identified by pid exits or ctx is done.
+// A process that no longer exists is reported as exited immediately.
+func Wait(ctx context.Context, pid int) error {
+	if pid <= 0 {
+		return ErrInvalidPID
+	}
+	return wait(ctx, pid)
+}
+
+// Alive reports whether a process with the given pid currently exists.
+func Alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	return alive(pid)
+}
+
+// StartTime returns when the process identified by pid started. A pid is
+// reused once its process exits; the pid and its start time together
+// identify one process.
+func StartTime(pid int) (time.Time, error) {
+	if pid <= 0 {
+		return time.Time{}, ErrInvalidPID
+	}
+	return startTime(pid)
+}
+
+func waitPoll(ctx context.Context, pid int) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if !alive(pid) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,60 @@
+//go:build darwin
+
+package procwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func wait(ctx context.Context, pid int) error {
+	kq, err := unix.Kqueue()
+	if err != nil {
+		return waitPoll(ctx, pid)
+	}
+	defer unix.Close(kq)
+
+	var change unix.Kevent_t
+	unix.SetKevent(&change, pid, unix.EVFILT_PROC, unix.EV_ADD|unix.EV_ONESHOT)
+	change.Fflags = unix.NOTE_EXIT
+	if _, err := unix.Kevent(kq, []unix.Kevent_t{change}, nil, nil); err != nil {
+		if errors.Is(err, unix.ESRCH) {
+			return nil
+		}
+		return waitPoll(ctx, pid)
+	}
+
+	timeout := unix.NsecToTimespec(pollInterval.Nanoseconds())
+	events := make([]unix.Kevent_t, 1)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := unix.Kevent(kq, nil, events, &timeout)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return waitPoll(ctx, pid)
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+}
+
+func startTime(pid int) (time.Time, error) {
+	kp, err := unix.SysctlKinfoProc("kern.proc.pid", pid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if int(kp.Proc.P_pid) != pid {
+		return time.Time{}, fmt.Errorf("process %d not found", pid)
+	}
+	start := kp.Proc.P_starttime
+	return time.Unix(int64(start.Sec), int64(start.Usec)*int64(time.Microsecond)), nil
+}
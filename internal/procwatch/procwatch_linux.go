@@ -0,0 +1,100 @@
+//go:build linux
+
+package procwatch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// userHZ is the unit of the clock ticks in /proc, fixed by the kernel ABI.
+const userHZ = 100
+
+func wait(ctx context.Context, pid int) error {
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		if errors.Is(err, unix.ESRCH) {
+			return nil
+		}
+		// Kernels older than 5.3 (or sandboxes filtering the syscall) lack pidfd.
+		return waitPoll(ctx, pid)
+	}
+	defer unix.Close(fd)
+
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := unix.Poll(fds, int(pollInterval.Milliseconds()))
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return waitPoll(ctx, pid)
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+}
+
+// startTime reads the start time of pid, in clock ticks since boot, from
+// /proc/<pid>/stat and adds the boot time from /proc/stat.
+func startTime(pid int) (time.Time, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, err
+	}
+	// The command name in parentheses may contain spaces; the fields
+	// after it start with the state, field 3 of proc(5).
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return time.Time{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	const startField = 22 - 3
+	if len(fields) <= startField {
+		return time.Time{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	ticks, err := strconv.ParseInt(fields[startField], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed start time of process %d: %w", pid, err)
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return boot.Add(time.Duration(ticks) * time.Second / userHZ), nil
+}
+
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "btime "); ok {
+			sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("malformed boot time: %w", err)
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, errors.New("no boot time in /proc/stat")
+}
@@ -0,0 +1,17 @@
+//go:build unix && !linux && !darwin
+
+package procwatch
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func wait(ctx context.Context, pid int) error {
+	return waitPoll(ctx, pid)
+}
+
+func startTime(_ int) (time.Time, error) {
+	return time.Time{}, errors.ErrUnsupported
+}
@@ -0,0 +1,94 @@
+//go:build unix
+
+package procwatch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWaitReturnsWhenProcessExits(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start sleep: %v", err)
+	}
+	reaped := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(reaped)
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Wait(context.Background(), cmd.Process.Pid)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("wait returned before the process exited: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatalf("kill sleep: %v", err)
+	}
+	<-reaped
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait did not return after the process exited")
+	}
+}
+
+func TestWaitHonorsContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := Wait(ctx, os.Getpid())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait error got=%v want=%v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestWaitRejectsInvalidPID(t *testing.T) {
+	if err := Wait(context.Background(), 0); !errors.Is(err, ErrInvalidPID) {
+		t.Fatalf("wait error got=%v want=%v", err, ErrInvalidPID)
+	}
+}
+
+func TestAlive(t *testing.T) {
+	if !Alive(os.Getpid()) {
+		t.Fatal("current process should be alive")
+	}
+	if Alive(-1) {
+		t.Fatal("negative pid should not be alive")
+	}
+}
+
+func TestStartTimeIdentifiesProcess(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("start times are only read on Linux and macOS")
+	}
+	self, err := StartTime(os.Getpid())
+	if err != nil {
+		t.Fatalf("start time: %v", err)
+	}
+	if again, err := StartTime(os.Getpid()); err != nil || !again.Equal(self) {
+		t.Fatalf("start time changed: %v then %v (%v)", self, again, err)
+	}
+	if d := time.Since(self); d < 0 || d > time.Hour {
+		t.Fatalf("start time %v is not when the test started", self)
+	}
+	if _, err := StartTime(0); !errors.Is(err, ErrInvalidPID) {
+		t.Fatalf("start time of pid 0: %v", err)
+	}
+}
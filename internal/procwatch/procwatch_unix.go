@@ -0,0 +1,14 @@
+//go:build unix
+
+package procwatch
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+func alive(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || errors.Is(err, unix.EPERM)
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package procwatch
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func wait(_ context.Context, _ int) error {
+	return errors.New("process binding is not supported on this platform")
+}
+
+func alive(_ int) bool {
+	return false
+}
+
+func startTime(_ int) (time.Time, error) {
+	return time.Time{}, errors.ErrUnsupported
+}
@@ -0,0 +1,370 @@
+// Package scan looks through a directory tree for secret files that are not
+// protected the way Dotward expects.
+package scan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+	"github.com/stefanos/dotward/internal/manifest"
+)
+
+// Kind identifies a type of problem. The values are stable for JSON output.
+type Kind string
+
+const (
+	// KindUnencrypted is a plaintext secret with no .enc sidecar.
+	KindUnencrypted Kind = "unencrypted"
+	// KindNotIgnored is a plaintext secret that git would commit.
+	KindNotIgnored Kind = "not_ignored"
+	// KindUnregistered is plaintext next to its sidecar that the daemon is
+	// not tracking, so it never expires.
+	KindUnregistered Kind = "unregistered"
+	// KindMissingSidecar is a file that should have a sidecar but does not:
+	// it is unlocked by the daemon or listed in the manifest.
+	KindMissingSidecar Kind = "missing_sidecar"
+	// KindCorruptSidecar is a .enc file that is not a valid encrypted file.
+	KindCorruptSidecar Kind = "corrupt_sidecar"
+)
+
+// Finding is a single problem found by Scan.
+type Finding struct {
+	Kind Kind `json:"kind"`
+	// Path is the plaintext path relative to the scanned directory, or the
+	// sidecar path for KindCorruptSidecar.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Report is the result of a scan.
+type Report struct {
+	Root     string    `json:"root"`
+	Findings []Finding `json:"findings"`
+	// Skipped explains checks that could not run.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// Options control which checks Scan runs.
+type Options struct {
+	// IsWatched reports whether the daemon tracks a plaintext path. When nil
+	// the unregistered and daemon-side missing sidecar checks are skipped,
+	// and SkipReason explains why.
+	IsWatched  func(absPath string) (bool, error)
+	SkipReason string
+	// Ignored returns the subset of absolute paths that git ignores. When
+	// nil, git check-ignore is run in the scanned directory.
+	Ignored func(root string, paths []string) (map[string]bool, error)
+	// Filtered returns the subset of absolute paths that git stores through
+	// the dotward filter. When nil, GitFiltered is used.
+	Filtered func(root string, paths []string) (map[string]bool, error)
+}
+
+// ErrGitUnavailable is returned by GitIgnored outside a git work tree or when
+// git is not installed.
+var ErrGitUnavailable = errors.New("git is unavailable")
+
+// templateSuffixes mark .env files that hold examples rather than secrets.
+var templateSuffixes = []string{".example", ".sample", ".template", ".dist"}
+
+// IsSecretName reports whether a file name looks like a dotenv secret file:
+// ".env" or ".env.<name>", but not templates such as ".env.example".
+func IsSecretName(name string) bool {
+	if name == ".env" {
+		return true
+	}
+	if !strings.HasPrefix(name, ".env.") || strings.HasSuffix(name, ".enc") {
+		return false
+	}
+	for _, suffix := range templateSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// SecretFile is a secret file found below a directory.
+type SecretFile struct {
+	// Rel is the plaintext path relative to the directory, with forward
+	// slashes.
+	Rel      string
+	HasPlain bool
+	HasEnc   bool
+}
+
+// candidate is a secret file to check.
+type candidate struct {
+	SecretFile
+	listed bool
+}
+
+// Scan checks every secret file below root. Files listed in the manifest
+// that governs root are checked too, whatever their name.
+func Scan(root string, opts Options) (Report, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to resolve directory: %w", err)
+	}
+	report := Report{Root: root, Findings: []Finding{}}
+
+	files, err := FindSecretFiles(root)
+	if err != nil {
+		return Report{}, err
+	}
+	byRel := make(map[string]*candidate, len(files))
+	for _, f := range files {
+		byRel[f.Rel] = &candidate{SecretFile: f}
+	}
+	if err := addManifestFiles(root, byRel); err != nil {
+		return Report{}, err
+	}
+	cands := make([]*candidate, 0, len(byRel))
+	for _, c := range byRel {
+		cands = append(cands, c)
+	}
+	sort.Slice(cands, func(i, j int) bool { return cands[i].Rel < cands[j].Rel })
+
+	abs := func(rel string) string { return filepath.Join(root, filepath.FromSlash(rel)) }
+	add := func(kind Kind, rel, format string, args ...any) {
+		report.Findings = append(report.Findings, Finding{Kind: kind, Path: rel, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if opts.IsWatched == nil && opts.SkipReason != "" {
+		report.Skipped = append(report.Skipped, "daemon checks: "+opts.SkipReason)
+	}
+	var plain []string
+	for _, c := range cands {
+		if c.HasPlain {
+			plain = append(plain, abs(c.Rel))
+		}
+	}
+	ignored := map[string]bool{}
+	if len(plain) > 0 {
+		ignoredFn := opts.Ignored
+		if ignoredFn == nil {
+			ignoredFn = GitIgnored
+		}
+		ignored, err = ignoredFn(root, plain)
+		if errors.Is(err, ErrGitUnavailable) {
+			report.Skipped = append(report.Skipped, "git-ignore check: "+err.Error())
+			ignored = nil
+		} else if err != nil {
+			return Report{}, err
+		}
+	}
+	filtered := map[string]bool{}
+	if len(plain) > 0 && ignored != nil {
+		filteredFn := opts.Filtered
+		if filteredFn == nil {
+			filteredFn = GitFiltered
+		}
+		filtered, err = filteredFn(root, plain)
+		if errors.Is(err, ErrGitUnavailable) {
+			filtered = map[string]bool{}
+		} else if err != nil {
+			return Report{}, err
+		}
+	}
+
+	for _, c := range cands {
+		absPlain := abs(c.Rel)
+		if c.HasEnc {
+			if _, err := cryptopkg.Inspect(absPlain + ".enc"); err != nil {
+				add(KindCorruptSidecar, c.Rel+".enc", "%v", err)
+			}
+		}
+
+		// Filtered files are stored encrypted by git instead of a sidecar.
+		isFiltered := filtered[absPlain]
+		watched := false
+		if c.HasPlain && opts.IsWatched != nil {
+			if watched, err = opts.IsWatched(absPlain); err != nil {
+				return Report{}, fmt.Errorf("failed to ask the daemon about %q: %w", absPlain, err)
+			}
+		}
+		switch {
+		case c.HasPlain && isFiltered:
+			if opts.IsWatched != nil && !watched {
+				add(KindUnregistered, c.Rel, "plaintext is not registered with Dotward and will not expire; delete it and run git checkout -- %s while Dotward.app is running", c.Rel)
+			}
+		case c.HasPlain && !c.HasEnc && watched:
+			add(KindMissingSidecar, c.Rel, "unlocked by Dotward but %s.enc is missing; run dotward update --create before it expires", c.Rel)
+		case c.HasPlain && !c.HasEnc:
+			add(KindUnencrypted, c.Rel, "plaintext secret has no .enc sidecar; run dotward init or dotward update --create")
+		case c.HasPlain && opts.IsWatched != nil && !watched:
+			add(KindUnregistered, c.Rel, "plaintext is not registered with Dotward and will not expire; run dotward lock")
+		case !c.HasPlain && !c.HasEnc && c.listed:
+			add(KindMissingSidecar, c.Rel, "listed in the manifest but neither the file nor %s.enc exists", c.Rel)
+		}
+		if c.HasPlain && ignored != nil && !ignored[absPlain] && !isFiltered {
+			add(KindNotIgnored, c.Rel, "plaintext secret is not ignored by git")
+		}
+	}
+	return report, nil
+}
+
+// FindSecretFiles returns the dotenv secret files below root, sorted by path,
+// whether they are present as plaintext, as a .enc sidecar, or both.
+// Directories matched by manifest.SkipDir are not searched.
+func FindSecretFiles(root string) ([]SecretFile, error) {
+	byRel := make(map[string]*SecretFile)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && manifest.SkipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		name := d.Name()
+		enc := strings.HasSuffix(name, ".enc")
+		if !IsSecretName(strings.TrimSuffix(name, ".enc")) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = strings.TrimSuffix(filepath.ToSlash(rel), ".enc")
+		f := byRel[rel]
+		if f == nil {
+			f = &SecretFile{Rel: rel}
+			byRel[rel] = f
+		}
+		if enc {
+			f.HasEnc = true
+		} else {
+			f.HasPlain = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %q: %w", root, err)
+	}
+
+	out := make([]SecretFile, 0, len(byRel))
+	for _, f := range byRel {
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Rel < out[j].Rel })
+	return out, nil
+}
+
+// addManifestFiles adds the files of the manifest governing root that lie
+// below root.
+func addManifestFiles(root string, byRel map[string]*candidate) error {
+	path, err := manifest.Find(root)
+	if errors.Is(err, manifest.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m, err := manifest.Load(path)
+	if err != nil {
+		return err
+	}
+	entries, err := m.Entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		rel, err := filepath.Rel(root, e.Path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		c := byRel[rel]
+		if c == nil {
+			c = &candidate{SecretFile: SecretFile{Rel: rel, HasPlain: exists(e.Path), HasEnc: exists(e.Path + ".enc")}}
+			byRel[rel] = c
+		}
+		c.listed = true
+	}
+	return nil
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// GitIgnored runs git check-ignore in root and returns the paths git
+// ignores. Tracked files are never reported as ignored.
+func GitIgnored(root string, paths []string) (map[string]bool, error) {
+	fields, err := gitPaths(root, paths, "check-ignore", "-z", "--stdin")
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool)
+	for _, p := range fields {
+		out[filepath.Clean(p)] = true
+	}
+	return out, nil
+}
+
+// GitFiltered returns the paths that .gitattributes routes through the
+// dotward clean/smudge filter. Their plaintext is meant to be in the work
+// tree and their blobs are stored encrypted.
+func GitFiltered(root string, paths []string) (map[string]bool, error) {
+	fields, err := gitPaths(root, paths, "check-attr", "-z", "--stdin", "filter")
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool)
+	// Output is a sequence of path, attribute, value triples.
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+2] == "dotward" {
+			out[filepath.Clean(fields[i])] = true
+		}
+	}
+	return out, nil
+}
+
+// gitPaths feeds paths to a git command reading NUL-separated paths on
+// stdin, and returns its NUL-separated output fields.
+func gitPaths(root string, paths []string, args ...string) ([]string, error) {
+	var stdin bytes.Buffer
+	for _, p := range paths {
+		stdin.WriteString(p)
+		stdin.WriteByte(0)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", root}, args...)...)
+	cmd.Stdin = &stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && args[0] == "check-ignore":
+		// No path is ignored.
+	case errors.As(err, &exitErr) && strings.Contains(stderr.String(), "not a git repository"):
+		return nil, fmt.Errorf("%w: %s is not inside a git work tree", ErrGitUnavailable, root)
+	case errors.Is(err, exec.ErrNotFound):
+		return nil, fmt.Errorf("%w: git is not installed", ErrGitUnavailable)
+	default:
+		return nil, fmt.Errorf("failed to run git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	var out []string
+	for _, f := range strings.Split(stdout.String(), "\x00") {
+		if f != "" {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,233 @@
+package scan
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	cryptopkg "github.com/stefanos/dotward/internal/crypto"
+)
+
+func writeFile(t *testing.T, root, rel, content string) string {
+	t.Helper()
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", rel, err)
+	}
+	return p
+}
+
+func writeSidecar(t *testing.T, root, rel string) {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "plain")
+	if err := os.WriteFile(src, []byte("X=1\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	dst := filepath.Join(root, filepath.FromSlash(rel)+".enc")
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := cryptopkg.EncryptFile(src, dst, []byte("pw")); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+}
+
+func TestIsSecretName(t *testing.T) {
+	for name, want := range map[string]bool{
+		".env":            true,
+		".env.local":      true,
+		".env.production": true,
+		".env.example":    false,
+		".env.sample":     false,
+		".env.enc":        false,
+		"config.env":      false,
+		".envrc":          false,
+	} {
+		if got := IsSecretName(name); got != want {
+			t.Fatalf("IsSecretName(%q) got=%v want=%v", name, got, want)
+		}
+	}
+}
+
+func TestFindSecretFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, rel := range []string{
+		".env",
+		".env.example",
+		".env.production.enc",
+		"api/.env",
+		"api/.env.enc",
+		"api/.env.local",
+		"api/config.env",
+		"node_modules/pkg/.env",
+		".git/.env",
+	} {
+		writeFile(t, root, rel, "X=1\n")
+	}
+
+	files, err := FindSecretFiles(root)
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	want := []SecretFile{
+		{Rel: ".env", HasPlain: true},
+		{Rel: ".env.production", HasEnc: true},
+		{Rel: "api/.env", HasPlain: true, HasEnc: true},
+		{Rel: "api/.env.local", HasPlain: true},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("files got=%+v want=%+v", files, want)
+	}
+	for i := range want {
+		if files[i] != want[i] {
+			t.Fatalf("file %d got=%+v want=%+v", i, files[i], want[i])
+		}
+	}
+}
+
+func findingKeys(r Report) []string {
+	var out []string
+	for _, f := range r.Findings {
+		out = append(out, string(f.Kind)+":"+f.Path)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestScanReportsEveryKind(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, ".env", "A=1\n")     // unencrypted, not ignored
+	writeFile(t, root, "api/.env", "B=2\n") // unregistered
+	writeSidecar(t, root, "api/.env")
+	writeFile(t, root, "web/.env", "C=3\n")      // watched, sidecar missing
+	writeFile(t, root, "db/.env.enc", "garbage") // corrupt sidecar
+	writeSidecar(t, root, "ok/.env")             // locked and fine
+	writeFile(t, root, ".dotward.yaml", "files: [.env, gone/.env, ok/.env]\n")
+
+	watched := map[string]bool{filepath.Join(root, "web", ".env"): true}
+	report, err := Scan(root, Options{
+		IsWatched: func(p string) (bool, error) { return watched[p], nil },
+		Ignored: func(_ string, paths []string) (map[string]bool, error) {
+			return map[string]bool{filepath.Join(root, "api", ".env"): true, filepath.Join(root, "web", ".env"): true}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	want := []string{
+		"corrupt_sidecar:db/.env.enc",
+		"missing_sidecar:gone/.env",
+		"missing_sidecar:web/.env",
+		"not_ignored:.env",
+		"unencrypted:.env",
+		"unregistered:api/.env",
+	}
+	if got := findingKeys(report); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("findings got=%q want=%q", got, want)
+	}
+	if len(report.Skipped) != 0 {
+		t.Fatalf("unexpected skipped checks: %q", report.Skipped)
+	}
+}
+
+func TestScanSkipsUnavailableChecks(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "api/.env", "B=2\n")
+	writeSidecar(t, root, "api/.env")
+
+	report, err := Scan(root, Options{
+		SkipReason: "Dotward.app is not running",
+		Ignored: func(string, []string) (map[string]bool, error) {
+			return nil, ErrGitUnavailable
+		},
+	})
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(report.Findings) != 0 {
+		t.Fatalf("expected no findings without daemon and git, got %+v", report.Findings)
+	}
+	if len(report.Skipped) != 2 {
+		t.Fatalf("expected both checks reported as skipped, got %q", report.Skipped)
+	}
+}
+
+func TestGitIgnored(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	if out, err := exec.Command("git", "-C", root, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	writeFile(t, root, ".gitignore", "/.env\n")
+	ignored := writeFile(t, root, ".env", "A=1\n")
+	visible := writeFile(t, root, "api/.env", "B=2\n")
+
+	got, err := GitIgnored(root, []string{ignored, visible})
+	if err != nil {
+		t.Fatalf("git ignored: %v", err)
+	}
+	if !got[ignored] || got[visible] {
+		t.Fatalf("ignored set mismatch: %v", got)
+	}
+
+	if _, err := GitIgnored(t.TempDir(), []string{visible}); !errors.Is(err, ErrGitUnavailable) {
+		t.Fatal("expected error outside a git work tree")
+	}
+}
+
+func TestScanTreatsFilteredFilesAsStoredEncrypted(t *testing.T) {
+	root := t.TempDir()
+	watchedPath := writeFile(t, root, ".env", "A=1\n")
+	writeFile(t, root, "api/.env", "B=2\n")
+
+	report, err := Scan(root, Options{
+		IsWatched: func(p string) (bool, error) { return p == watchedPath, nil },
+		Ignored: func(string, []string) (map[string]bool, error) {
+			return map[string]bool{}, nil
+		},
+		Filtered: func(_ string, paths []string) (map[string]bool, error) {
+			out := make(map[string]bool)
+			for _, p := range paths {
+				out[p] = true
+			}
+			return out, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	want := []string{"unregistered:api/.env"}
+	if got := findingKeys(report); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("findings got=%q want=%q", got, want)
+	}
+}
+
+func TestGitFiltered(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	if out, err := exec.Command("git", "-C", root, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	writeFile(t, root, ".gitattributes", ".env filter=dotward\n")
+	filtered := writeFile(t, root, ".env", "A=1\n")
+	plain := writeFile(t, root, ".env.local", "B=2\n")
+
+	got, err := GitFiltered(root, []string{filtered, plain})
+	if err != nil {
+		t.Fatalf("git filtered: %v", err)
+	}
+	if !got[filtered] || got[plain] {
+		t.Fatalf("filtered set mismatch: %v", got)
+	}
+}
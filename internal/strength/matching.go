@@ -0,0 +1,561 @@
+package strength
+
+import (
+	"bytes"
+	"math"
+	"regexp"
+)
+
+// Match patterns.
+const (
+	patternDictionary = "dictionary"
+	patternSpatial    = "spatial"
+	patternRepeat     = "repeat"
+	patternSequence   = "sequence"
+	patternYear       = "year"
+	patternDate       = "date"
+	patternBruteforce = "bruteforce"
+)
+
+// Dictionary names.
+const (
+	dictPasswords  = "passwords"
+	dictEnglish    = "english"
+	dictUserInputs = "user_inputs"
+)
+
+// match is a guessable part of a password, from byte i to byte j inclusive.
+// It records positions and properties only, never the matched bytes.
+type match struct {
+	pattern string
+	i, j    int
+	guesses float64
+
+	// Dictionary matches.
+	dictionary string
+	rank       int
+	reversed   bool
+	l33t       bool
+	// Spatial matches.
+	turns int
+	// Repeat matches.
+	unitLen int
+}
+
+type dictionary struct {
+	name  string
+	ranks map[string]int
+}
+
+var builtinDictionaries = []dictionary{
+	{dictPasswords, words(commonPasswords)},
+	{dictEnglish, words(englishWords)},
+}
+
+// l33tTable lists the letters a substituted character may stand for.
+var l33tTable = map[byte][]byte{
+	'4': {'a'}, '@': {'a'},
+	'8': {'b'},
+	'3': {'e'},
+	'6': {'g'}, '9': {'g'},
+	'1': {'i', 'l'}, '!': {'i'}, '|': {'i', 'l'},
+	'0': {'o'},
+	'$': {'s'}, '5': {'s'},
+	'7': {'t'}, '+': {'t'},
+	'2': {'z'},
+}
+
+// matcher finds matches in one password.
+type matcher struct {
+	pw []byte
+	// lower is pw with ASCII letters lowercased.
+	lower []byte
+	dicts []dictionary
+	year  int
+	// scratch holds copies of the password to zero when done.
+	scratch [][]byte
+}
+
+func (mt *matcher) buffer(b []byte) []byte {
+	buf := append([]byte(nil), b...)
+	mt.scratch = append(mt.scratch, buf)
+	return buf
+}
+
+func (mt *matcher) zero() {
+	for _, b := range mt.scratch {
+		zeroBytes(b)
+	}
+}
+
+func (mt *matcher) matches() []*match {
+	var out []*match
+	out = append(out, mt.dictionaryMatches(mt.lower, false)...)
+	out = append(out, mt.reversedMatches()...)
+	out = append(out, mt.l33tMatches()...)
+	out = append(out, mt.spatialMatches()...)
+	out = append(out, mt.sequenceMatches()...)
+	out = append(out, mt.repeatMatches()...)
+	out = append(out, mt.yearMatches()...)
+	out = append(out, mt.dateMatches()...)
+	return out
+}
+
+func (mt *matcher) dictionaryMatches(b []byte, reversed bool) []*match {
+	var out []*match
+	for _, d := range mt.dicts {
+		for i := range b {
+			for j := i; j < len(b); j++ {
+				// The conversion does not allocate in a map index.
+				rank, ok := d.ranks[string(b[i:j+1])]
+				if !ok {
+					continue
+				}
+				m := &match{pattern: patternDictionary, i: i, j: j, dictionary: d.name, rank: rank, reversed: reversed}
+				m.guesses = float64(rank) * uppercaseVariations(mt.pw[i:j+1])
+				out = append(out, m)
+			}
+		}
+	}
+	return out
+}
+
+func (mt *matcher) reversedMatches() []*match {
+	rev := mt.buffer(mt.lower)
+	for i, j := 0, len(rev)-1; i < j; i, j = i+1, j-1 {
+		rev[i], rev[j] = rev[j], rev[i]
+	}
+	n := len(rev)
+	var out []*match
+	for _, m := range mt.dictionaryMatches(rev, true) {
+		// A palindrome is found forwards already.
+		if m.j == m.i {
+			continue
+		}
+		m.i, m.j = n-1-m.j, n-1-m.i
+		m.guesses = float64(m.rank) * uppercaseVariations(mt.pw[m.i:m.j+1]) * 2
+		out = append(out, m)
+	}
+	return out
+}
+
+// l33tMatches finds dictionary words with characters substituted, trying
+// the first and then the second letter each character may stand for.
+func (mt *matcher) l33tMatches() []*match {
+	var out []*match
+	for choice := 0; choice < 2; choice++ {
+		sub := mt.buffer(mt.lower)
+		subbed := make([]bool, len(sub))
+		found := false
+		for k, c := range sub {
+			letters, ok := l33tTable[c]
+			if !ok {
+				continue
+			}
+			sub[k] = letters[min(choice, len(letters)-1)]
+			subbed[k] = true
+			found = true
+		}
+		if !found {
+			return out
+		}
+		for _, m := range mt.dictionaryMatches(sub, false) {
+			if m.i == m.j || !containsTrue(subbed[m.i:m.j+1]) {
+				continue
+			}
+			m.l33t = true
+			m.guesses *= l33tVariations(mt.lower[m.i:m.j+1], sub[m.i:m.j+1])
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func (mt *matcher) spatialMatches() []*match {
+	var out []*match
+	n := len(mt.pw)
+	for i := 0; i < n-2; {
+		j := i
+		turns, lastDir := 0, -1
+		shifted := 0
+		if keyOf(mt.pw[i]).shifted {
+			shifted++
+		}
+		for j+1 < n {
+			dir := direction(mt.pw[j], mt.pw[j+1])
+			if dir < 0 {
+				break
+			}
+			if dir != lastDir {
+				turns++
+				lastDir = dir
+			}
+			if keyOf(mt.pw[j+1]).shifted {
+				shifted++
+			}
+			j++
+		}
+		if j-i+1 >= 3 {
+			out = append(out, &match{
+				pattern: patternSpatial, i: i, j: j, turns: turns,
+				guesses: spatialGuesses(j-i+1, turns, shifted),
+			})
+		}
+		i = j + 1
+	}
+	return out
+}
+
+func (mt *matcher) sequenceMatches() []*match {
+	var out []*match
+	n := len(mt.pw)
+	for i := 0; i < n-2; {
+		delta := int(mt.pw[i+1]) - int(mt.pw[i])
+		class := charClass(mt.pw[i])
+		j := i
+		for j+1 < n && int(mt.pw[j+1])-int(mt.pw[j]) == delta && charClass(mt.pw[j+1]) == class {
+			j++
+		}
+		if length := j - i + 1; length >= 3 && class >= 0 && delta != 0 && abs(delta) <= 5 {
+			out = append(out, &match{pattern: patternSequence, i: i, j: j, guesses: sequenceGuesses(mt.pw[i], length, delta > 0)})
+		}
+		i = max(j, i+1)
+	}
+	return out
+}
+
+func (mt *matcher) repeatMatches() []*match {
+	var out []*match
+	n := len(mt.pw)
+	for i := 0; i < n-1; {
+		unit, count := 0, 0
+		for u := 1; u <= (n-i)/2; u++ {
+			c := 1
+			for i+(c+1)*u <= n && bytes.Equal(mt.pw[i+c*u:i+(c+1)*u], mt.pw[i:i+u]) {
+				c++
+			}
+			if c >= 2 && c*u > count*unit {
+				unit, count = u, c
+			}
+		}
+		if count == 0 {
+			i++
+			continue
+		}
+		unitGuesses, _ := mostGuessable(mt.pw[i:i+unit], mt.dicts, mt.year)
+		out = append(out, &match{pattern: patternRepeat, i: i, j: i + unit*count - 1, unitLen: unit, guesses: unitGuesses * float64(count)})
+		i += unit * count
+	}
+	return out
+}
+
+func (mt *matcher) yearMatches() []*match {
+	var out []*match
+	for i := 0; i+4 <= len(mt.pw); i++ {
+		year, ok := digits(mt.pw[i : i+4])
+		if !ok || year < 1900 || year > 2050 {
+			continue
+		}
+		out = append(out, &match{pattern: patternYear, i: i, j: i + 3, guesses: yearSpace(year, mt.year)})
+	}
+	return out
+}
+
+// separatedDate matches dates such as 13.5.1991 or 1991-05-13.
+var separatedDate = regexp.MustCompile(`^(\d{1,4})([\s/\\_.-])(\d{1,2})([\s/\\_.-])(\d{1,4})$`)
+
+func (mt *matcher) dateMatches() []*match {
+	var out []*match
+	n := len(mt.pw)
+	for i := 0; i < n; i++ {
+		for j := i + 3; j < min(n, i+10); j++ {
+			tok := mt.pw[i : j+1]
+			if len(tok) <= 8 {
+				if year, ok := digitDate(tok, mt.year); ok {
+					out = append(out, &match{pattern: patternDate, i: i, j: j, guesses: 365 * yearSpace(year, mt.year)})
+					continue
+				}
+			}
+			if len(tok) < 6 {
+				continue
+			}
+			parts := separatedDate.FindSubmatchIndex(tok)
+			if parts == nil || tok[parts[4]] != tok[parts[8]] {
+				continue
+			}
+			if year, ok := dateYear([3][]byte{tok[parts[2]:parts[3]], tok[parts[6]:parts[7]], tok[parts[10]:parts[11]]}, mt.year); ok {
+				out = append(out, &match{pattern: patternDate, i: i, j: j, guesses: 365 * yearSpace(year, mt.year) * 4})
+			}
+		}
+	}
+	return out
+}
+
+// digitDate reports whether tok, of digits only, splits into a valid day,
+// month and year, and returns the year closest to now.
+func digitDate(tok []byte, now int) (int, bool) {
+	if _, ok := digits(tok); !ok {
+		return 0, false
+	}
+	best, found := 0, false
+	for k1 := 1; k1 < len(tok)-1; k1++ {
+		for k2 := k1 + 1; k2 < len(tok); k2++ {
+			year, ok := dateYear([3][]byte{tok[:k1], tok[k1:k2], tok[k2:]}, now)
+			if ok && (!found || abs(year-now) < abs(best-now)) {
+				best, found = year, true
+			}
+		}
+	}
+	return best, found
+}
+
+// dateYear reports whether parts are a day, month and year in one of the
+// usual orders, and returns the year.
+func dateYear(parts [3][]byte, now int) (int, bool) {
+	for _, order := range [][3]int{{0, 1, 2}, {1, 0, 2}, {2, 1, 0}} {
+		day, month, year := parts[order[0]], parts[order[1]], parts[order[2]]
+		if len(day) > 2 || len(month) > 2 || (len(year) != 2 && len(year) != 4) {
+			continue
+		}
+		d, ok1 := digits(day)
+		m, ok2 := digits(month)
+		y, ok3 := digits(year)
+		if !ok1 || !ok2 || !ok3 || d < 1 || d > 31 || m < 1 || m > 12 {
+			continue
+		}
+		switch {
+		case len(year) == 2 && y > 50:
+			y += 1900
+		case len(year) == 2:
+			y += 2000
+		case y < 1000 || y > 2050:
+			continue
+		}
+		return y, true
+	}
+	return 0, false
+}
+
+// bruteforceMatch covers bytes i to j that no pattern explains.
+func bruteforceMatch(i, j int) *match {
+	length := j - i + 1
+	guesses := math.Pow(10, float64(length))
+	if math.IsInf(guesses, 1) {
+		guesses = math.MaxFloat64
+	}
+	minimum := float64(minSubmatchGuessesMultiChar + 1)
+	if length == 1 {
+		minimum = minSubmatchGuessesSingleChar + 1
+	}
+	return &match{pattern: patternBruteforce, i: i, j: j, guesses: math.Max(guesses, minimum)}
+}
+
+// key is a position on a QWERTY keyboard.
+type key struct {
+	x, y    int
+	ok      bool
+	shifted bool
+}
+
+// qwertyRows list each key as its unshifted and shifted character.
+var qwertyRows = [...]string{
+	"`~1!2@3#4$5%6^7&8*9(0)-_=+",
+	"qQwWeErRtTyYuUiIoOpP[{]}\\|",
+	"aAsSdDfFgGhHjJkKlL;:'\"",
+	"zZxXcCvVbBnNmM,<.>/?",
+}
+
+var keyboard = func() (keys [256]key) {
+	for y, row := range qwertyRows {
+		// Rows below the digits start half a key to the right, which puts
+		// q above a and between 1 and 2.
+		offset := min(y, 1)
+		for k := 0; k+1 < len(row); k += 2 {
+			x := k/2 + offset
+			keys[row[k]] = key{x: x, y: y, ok: true}
+			keys[row[k+1]] = key{x: x, y: y, ok: true, shifted: true}
+		}
+	}
+	return keys
+}()
+
+// keyboardStartingPositions and keyboardAverageDegree describe the QWERTY
+// graph: every key, shifted or not, and how many neighbours a key has.
+const (
+	keyboardStartingPositions = 94
+	keyboardAverageDegree     = 4.595
+)
+
+// neighbours are the six directions to an adjacent key.
+var neighbours = [...][2]int{{-1, 0}, {0, -1}, {1, -1}, {1, 0}, {0, 1}, {-1, 1}}
+
+func keyOf(c byte) key {
+	return keyboard[c]
+}
+
+// direction returns the direction from the key of a to the key of b, or -1
+// when they are not adjacent.
+func direction(a, b byte) int {
+	ka, kb := keyOf(a), keyOf(b)
+	if !ka.ok || !kb.ok {
+		return -1
+	}
+	for d, n := range neighbours {
+		if kb.x-ka.x == n[0] && kb.y-ka.y == n[1] {
+			return d
+		}
+	}
+	return -1
+}
+
+func spatialGuesses(length, turns, shifted int) float64 {
+	var guesses float64
+	for i := 2; i <= length; i++ {
+		for j := 1; j <= min(turns, i-1); j++ {
+			guesses += binomial(i-1, j-1) * keyboardStartingPositions * math.Pow(keyboardAverageDegree, float64(j))
+		}
+	}
+	if shifted > 0 {
+		guesses *= variations(shifted, length-shifted)
+	}
+	return guesses
+}
+
+func sequenceGuesses(first byte, length int, ascending bool) float64 {
+	var base float64
+	switch {
+	case bytes.IndexByte([]byte("aAzZ019"), first) >= 0:
+		base = 4
+	case '0' <= first && first <= '9':
+		base = 10
+	default:
+		base = 26
+	}
+	if !ascending {
+		base *= 2
+	}
+	return base * float64(length)
+}
+
+// yearSpace is how many years an attacker tries to reach year.
+func yearSpace(year, now int) float64 {
+	return float64(max(abs(year-now), minYearSpace))
+}
+
+// uppercaseVariations counts the capitalizations of a word an attacker
+// tries before tok.
+func uppercaseVariations(tok []byte) float64 {
+	upper, lower := 0, 0
+	for _, c := range tok {
+		switch {
+		case 'A' <= c && c <= 'Z':
+			upper++
+		case 'a' <= c && c <= 'z':
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	first, last := tok[0], tok[len(tok)-1]
+	if lower == 0 || (upper == 1 && ('A' <= first && first <= 'Z' || 'A' <= last && last <= 'Z')) {
+		return 2
+	}
+	return variations(upper, lower)
+}
+
+// l33tVariations counts the substitutions of a word an attacker tries
+// before orig, whose substituted form is sub.
+func l33tVariations(orig, sub []byte) float64 {
+	total := 1.0
+	seen := make(map[byte]bool)
+	for k, c := range orig {
+		if c == sub[k] || seen[c] {
+			continue
+		}
+		seen[c] = true
+		subbed, unsubbed := 0, 0
+		for m := range orig {
+			switch {
+			case orig[m] == c && sub[m] == sub[k]:
+				subbed++
+			case orig[m] == sub[k]:
+				unsubbed++
+			}
+		}
+		total *= variations(subbed, unsubbed)
+	}
+	return total
+}
+
+// variations counts the ways to mark up to min(a, b) of a+b positions, or
+// 2 when one of a and b is zero.
+func variations(a, b int) float64 {
+	if a == 0 || b == 0 {
+		return 2
+	}
+	var total float64
+	for i := 1; i <= min(a, b); i++ {
+		total += binomial(a+b, i)
+	}
+	return total
+}
+
+func binomial(n, k int) float64 {
+	if k > n {
+		return 0
+	}
+	r := 1.0
+	for d := 1; d <= k; d++ {
+		r = r * float64(n-k+d) / float64(d)
+	}
+	return r
+}
+
+// charClass returns 0 for lowercase letters, 1 for uppercase letters, 2 for
+// digits and -1 otherwise.
+func charClass(c byte) int {
+	switch {
+	case 'a' <= c && c <= 'z':
+		return 0
+	case 'A' <= c && c <= 'Z':
+		return 1
+	case '0' <= c && c <= '9':
+		return 2
+	}
+	return -1
+}
+
+// digits parses b as a decimal number when it holds only digits.
+func digits(b []byte) (int, bool) {
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, len(b) > 0
+}
+
+func containsTrue(b []bool) bool {
+	for _, v := range b {
+		if v {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,286 @@
+// Package strength estimates how many guesses an attacker needs to find a
+// password, in the manner of zxcvbn: it finds dictionary words, keyboard
+// walks, sequences, repeats and dates in the password, and counts the
+// guesses for the least guessable way to combine them.
+//
+// The password is only read from the caller's slice and the copies the
+// package makes, which it zeroes; it never becomes a string.
+package strength
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// maxLength is how many bytes of a password are analysed. Longer passwords
+// are rated on their first maxLength bytes, which only underestimates them.
+const maxLength = 100
+
+const (
+	// minGuessesBeforeGrowingSequence penalises splitting a password into
+	// more parts, so that a whole word wins over the letters it spells.
+	minGuessesBeforeGrowingSequence = 10000
+	minSubmatchGuessesSingleChar    = 10
+	minSubmatchGuessesMultiChar     = 50
+	// minYearSpace is how many years an attacker tries around the present.
+	minYearSpace = 20
+)
+
+// scoreThresholds are the guesses below which a password scores 0 to 3.
+var scoreThresholds = [...]float64{1e3 + 5, 1e6 + 5, 1e8 + 5, 1e10 + 5}
+
+// MaxScore is the score of the strongest passwords.
+const MaxScore = len(scoreThresholds)
+
+// Result rates a password.
+type Result struct {
+	// Guesses estimates how many guesses find the password.
+	Guesses float64
+	// Score is 0 for passwords guessed within about a thousand tries, 1,
+	// 2 and 3 for a million, 10^8 and 10^10 tries, and 4 beyond that.
+	Score int
+	// Warning explains what makes a weak password easy to guess. It is
+	// empty for strong passwords and when nothing in particular does.
+	Warning string
+	// Suggestions say how to choose a stronger password.
+	Suggestions []string
+}
+
+// Estimate rates password. userInputs are words an attacker would try
+// first, such as the names of what the password protects.
+func Estimate(password []byte, userInputs ...string) Result {
+	if len(password) > maxLength {
+		password = password[:maxLength]
+	}
+	dicts := append([]dictionary(nil), builtinDictionaries...)
+	if len(userInputs) > 0 {
+		ranks := make(map[string]int, len(userInputs))
+		for _, in := range userInputs {
+			if in = strings.ToLower(in); in != "" {
+				if _, ok := ranks[in]; !ok {
+					ranks[in] = len(ranks) + 1
+				}
+			}
+		}
+		dicts = append(dicts, dictionary{dictUserInputs, ranks})
+	}
+
+	guesses, sequence := mostGuessable(password, dicts, time.Now().Year())
+	score := 0
+	for score < MaxScore && guesses >= scoreThresholds[score] {
+		score++
+	}
+	r := Result{Guesses: guesses, Score: score}
+	r.Warning, r.Suggestions = feedback(password, score, sequence)
+	return r
+}
+
+// mostGuessable returns the guesses needed for password and the matches
+// that need the fewest.
+func mostGuessable(password []byte, dicts []dictionary, year int) (float64, []*match) {
+	n := len(password)
+	if n == 0 {
+		return 1, nil
+	}
+	mt := &matcher{pw: password, dicts: dicts, year: year}
+	defer mt.zero()
+	mt.lower = mt.buffer(password)
+	for k, c := range mt.lower {
+		if 'A' <= c && c <= 'Z' {
+			mt.lower[k] = c + 'a' - 'A'
+		}
+	}
+
+	byEnd := make([][]*match, n)
+	for _, m := range mt.matches() {
+		// Parts of a password are at least as hard to guess as a short
+		// random string.
+		if m.j-m.i+1 < n {
+			minimum := float64(minSubmatchGuessesMultiChar)
+			if m.i == m.j {
+				minimum = minSubmatchGuessesSingleChar
+			}
+			m.guesses = math.Max(m.guesses, minimum)
+		}
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	s := &search{best: make([]map[int]step, n)}
+	for k := range s.best {
+		s.best[k] = make(map[int]step)
+	}
+	for k := 0; k < n; k++ {
+		for _, m := range byEnd[k] {
+			if m.i == 0 {
+				s.update(m, 1)
+				continue
+			}
+			for l := range s.best[m.i-1] {
+				s.update(m, l+1)
+			}
+		}
+		s.update(bruteforceMatch(0, k), 1)
+		for i := 1; i <= k; i++ {
+			// Two bruteforce matches in a row are one longer match.
+			for l, prev := range s.best[i-1] {
+				if prev.m.pattern != patternBruteforce {
+					s.update(bruteforceMatch(i, k), l+1)
+				}
+			}
+		}
+	}
+	return s.unwind(n)
+}
+
+// search finds the sequence of matches covering a password with the fewest
+// guesses, by dynamic programming over where sequences end.
+type search struct {
+	// best[k][l] is the best sequence of l matches ending at byte k.
+	best []map[int]step
+}
+
+type step struct {
+	// m is the last match of the sequence.
+	m *match
+	// pi is the product of the guesses of the matches.
+	pi float64
+	// g is the guesses for the sequence: an attacker tries sequences of
+	// fewer matches first, and the matches in any order.
+	g float64
+}
+
+func (s *search) update(m *match, l int) {
+	pi := m.guesses
+	if l > 1 {
+		pi *= s.best[m.i-1][l-1].pi
+	}
+	g := factorial(l)*pi + math.Pow(minGuessesBeforeGrowingSequence, float64(l-1))
+	for other, st := range s.best[m.j] {
+		if other <= l && st.g <= g {
+			return
+		}
+	}
+	s.best[m.j][l] = step{m: m, pi: pi, g: g}
+}
+
+func (s *search) unwind(n int) (float64, []*match) {
+	k := n - 1
+	l, guesses := 0, math.Inf(1)
+	for length, st := range s.best[k] {
+		if st.g < guesses || (st.g == guesses && length < l) {
+			l, guesses = length, st.g
+		}
+	}
+	sequence := make([]*match, l)
+	for ; l > 0; l-- {
+		st := s.best[k][l]
+		sequence[l-1] = st.m
+		k = st.m.i - 1
+	}
+	return guesses, sequence
+}
+
+func factorial(n int) float64 {
+	f := 1.0
+	for i := 2; i <= n; i++ {
+		f *= float64(i)
+	}
+	return f
+}
+
+const suggestionMoreWords = "Add another word or two. Uncommon words are better."
+
+// feedback explains a score of at most 2 by its longest match.
+func feedback(password []byte, score int, sequence []*match) (string, []string) {
+	if len(sequence) == 0 {
+		return "", []string{"Use a few words, avoid common phrases.", "No need for symbols, digits, or uppercase letters."}
+	}
+	if score > 2 {
+		return "", nil
+	}
+	longest := sequence[0]
+	for _, m := range sequence[1:] {
+		if m.j-m.i > longest.j-longest.i {
+			longest = m
+		}
+	}
+	warning, suggestions := matchFeedback(password, longest, len(sequence) == 1)
+	return warning, append([]string{suggestionMoreWords}, suggestions...)
+}
+
+func matchFeedback(password []byte, m *match, sole bool) (string, []string) {
+	switch m.pattern {
+	case patternDictionary:
+		return dictionaryFeedback(password[m.i:m.j+1], m, sole)
+	case patternSpatial:
+		if m.turns == 1 {
+			return "Straight rows of keys are easy to guess", []string{"Use a longer keyboard pattern with more turns."}
+		}
+		return "Short keyboard patterns are easy to guess", []string{"Use a longer keyboard pattern with more turns."}
+	case patternRepeat:
+		if m.unitLen == 1 {
+			return `Repeats like "aaa" are easy to guess`, []string{"Avoid repeated words and characters."}
+		}
+		return `Repeats like "abcabcabc" are only slightly harder to guess than "abc"`, []string{"Avoid repeated words and characters."}
+	case patternSequence:
+		return "Sequences like abc or 6543 are easy to guess", []string{"Avoid sequences."}
+	case patternYear:
+		return "Recent years are easy to guess", []string{"Avoid recent years.", "Avoid years that are associated with you."}
+	case patternDate:
+		return "Dates are often easy to guess", []string{"Avoid dates and years that are associated with you."}
+	}
+	return "", nil
+}
+
+func dictionaryFeedback(tok []byte, m *match, sole bool) (string, []string) {
+	var warning string
+	switch m.dictionary {
+	case dictPasswords:
+		switch {
+		case !sole || m.l33t || m.reversed:
+			warning = "This is similar to a commonly used password"
+		case m.rank <= 10:
+			warning = "This is a top-10 common password"
+		case m.rank <= 100:
+			warning = "This is a top-100 common password"
+		default:
+			warning = "This is a very common password"
+		}
+	case dictEnglish:
+		if sole {
+			warning = "A word by itself is easy to guess"
+		}
+	case dictUserInputs:
+		warning = "Names of what the password protects are easy to guess"
+	}
+
+	var suggestions []string
+	switch {
+	case 'A' <= tok[0] && tok[0] <= 'Z':
+		suggestions = append(suggestions, "Capitalization doesn't help very much.")
+	case allUpper(tok):
+		suggestions = append(suggestions, "All-uppercase is almost as easy to guess as all-lowercase.")
+	}
+	if m.reversed && len(tok) >= 4 {
+		suggestions = append(suggestions, "Reversed words aren't much harder to guess.")
+	}
+	if m.l33t {
+		suggestions = append(suggestions, "Predictable substitutions like '@' instead of 'a' don't help very much.")
+	}
+	return warning, suggestions
+}
+
+// allUpper reports whether tok has letters and all of them are uppercase.
+func allUpper(tok []byte) bool {
+	letters := false
+	for _, c := range tok {
+		switch {
+		case 'a' <= c && c <= 'z':
+			return false
+		case 'A' <= c && c <= 'Z':
+			letters = true
+		}
+	}
+	return letters
+}
@@ -0,0 +1,103 @@
+package strength
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEstimateRejectsGuessablePasswords(t *testing.T) {
+	tests := []struct {
+		password string
+		maxScore int
+		warning  string
+	}{
+		{"1234", 0, "top-10 common password"},
+		{"password", 0, "top-10 common password"},
+		{"P@ssw0rd", 0, "similar to a commonly used password"},
+		{"drowssap", 0, "similar to a commonly used password"},
+		{"asdfghjkl", 1, "Straight rows of keys"},
+		{"qazxswedc", 2, "keyboard patterns"},
+		{"abcdefgh", 0, "Sequences like abc"},
+		{"98765", 0, "Sequences like abc"},
+		{"aaaaaaaaaa", 0, `Repeats like "aaa"`},
+		{"1987", 0, "Recent years"},
+		{"13.05.1991", 1, "Dates are often"},
+		{"monkey", 0, "common password"},
+		{"sunshine", 0, "common password"},
+		{"Summer2024!", 2, "similar to a commonly used password"},
+	}
+	for _, tt := range tests {
+		r := Estimate([]byte(tt.password))
+		if r.Score > tt.maxScore {
+			t.Errorf("%q: score %d, want at most %d (%.3g guesses)", tt.password, r.Score, tt.maxScore, r.Guesses)
+		}
+		if !strings.Contains(r.Warning, tt.warning) {
+			t.Errorf("%q: warning %q, want it to contain %q", tt.password, r.Warning, tt.warning)
+		}
+		if len(r.Suggestions) == 0 || r.Suggestions[0] != suggestionMoreWords {
+			t.Errorf("%q: suggestions %q", tt.password, r.Suggestions)
+		}
+	}
+}
+
+func TestEstimateAcceptsStrongPasswords(t *testing.T) {
+	for _, pw := range []string{
+		"correct horse battery staple",
+		"kX9#mQ2$vL7p",
+		"glacier-tinsel-omnibus-quarrel",
+	} {
+		r := Estimate([]byte(pw))
+		if r.Score < 3 {
+			t.Errorf("%q: score %d (%.3g guesses), want at least 3", pw, r.Score, r.Guesses)
+		}
+		if r.Warning != "" || len(r.Suggestions) != 0 {
+			t.Errorf("%q: unexpected feedback %q %q", pw, r.Warning, r.Suggestions)
+		}
+	}
+}
+
+func TestEstimateSuggestsAgainstCapitalsAndSubstitutions(t *testing.T) {
+	r := Estimate([]byte("P@ssw0rd"))
+	joined := strings.Join(r.Suggestions, "\n")
+	for _, want := range []string{"Capitalization", "Predictable substitutions"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("suggestions %q lack %q", r.Suggestions, want)
+		}
+	}
+}
+
+func TestEstimateUsesUserInputs(t *testing.T) {
+	pw := []byte("zephyrinepayments")
+	without := Estimate(pw)
+	with := Estimate(pw, "Payments", "zephyrine")
+	if with.Guesses >= without.Guesses {
+		t.Fatalf("user inputs did not lower the estimate: %.3g >= %.3g", with.Guesses, without.Guesses)
+	}
+	if with.Score > 2 || !strings.Contains(with.Warning, "Names of what the password protects") {
+		t.Fatalf("score %d warning %q", with.Score, with.Warning)
+	}
+}
+
+func TestEstimateKeepsPasswordIntact(t *testing.T) {
+	pw := []byte("Tr0ub4dor&3 qwerty 1991")
+	Estimate(pw)
+	if string(pw) != "Tr0ub4dor&3 qwerty 1991" {
+		t.Fatalf("password changed to %q", pw)
+	}
+	if r := Estimate([]byte(strings.Repeat("x7", 500))); r.Score > 1 {
+		t.Fatalf("long repeat scored %d", r.Score)
+	}
+}
+
+func TestSpatialAdjacency(t *testing.T) {
+	for _, pair := range []string{"qw", "qa", "q1", "q2", "sz", "sx", "se", "t6", "az", "/'", "P{"} {
+		if direction(pair[0], pair[1]) < 0 {
+			t.Errorf("%q are neighbours", pair)
+		}
+	}
+	for _, pair := range []string{"qe", "qs", "ad", "g6", "1a"} {
+		if direction(pair[0], pair[1]) >= 0 {
+			t.Errorf("%q are not neighbours", pair)
+		}
+	}
+}
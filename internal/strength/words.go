@@ -0,0 +1,79 @@
+package strength
+
+import "strings"
+
+// commonPasswords are frequently leaked passwords, most common first.
+const commonPasswords = `
+123456 password 12345678 qwerty 123456789 12345 1234 111111 1234567 dragon
+123123 baseball abc123 football monkey letmein 696969 shadow master 666666
+qwertyuiop 123321 mustang 1234567890 michael 654321 superman 1qaz2wsx 7777777
+121212 000000 qazwsx 123qwe killer trustno1 jordan jennifer zxcvbnm asdfgh
+hunter buster soccer harley batman andrew tigger sunshine iloveyou 2000
+charlie robert thomas hockey ranger daniel starwars klaster 112233 george
+computer michelle jessica pepper 1111 zxcvbn 555555 11111111 131313 freedom
+777777 pass maggie 159753 aaaaaa ginger princess joshua cheese amanda summer
+love ashley nicole chelsea biteme matthew access yankees 987654321 dallas
+austin thunder taylor matrix minecraft william corvette hello martin heather
+secret merlin diamond 1234qwer hammer silver 222222 88888888 anthony justin
+test bailey q1w2e3r4t5 patrick internet scooter orange 11111 golfer cookie
+richard samantha bigdog guitar jackson whatever mickey chicken sparky snoopy
+maverick phoenix camaro peanut morgan welcome falcon cowboy ferrari samsung
+andrea smokey steelers joseph mercedes dakota arsenal eagles melissa boomer
+booboo spider nascar monster tigers yellow xxxxxx 123123123 gateway marina
+diablo bulldog qwer1234 compaq purple banana junior hannah 123654 porsche
+lakers iceman money cowboys 987654 london tennis 999999 ncc1701 coffee scooby
+0000 miller boston q1w2e3r4 brandon yamaha chester mother forever johnny
+edward 333333 oliver redsox player nikita knight fender barney midnight please
+brandy chicago badboy slayer rangers charles angel flower bigdaddy rabbit
+wizard jasper enter rachel chris steven winner adidas victoria natasha
+1q2w3e4r jasmine winter prince marine fishing cocacola casper james 232323
+raiders 888888 marlboro gandalf asdfasdf crystal 87654321 12344321 golden
+8675309 welcome1 password1 password123 admin root changeme qwerty123 letmein1
+abcdef abcd1234 aa123456 passw0rd p@ssw0rd secret123 admin123 test123 qwe123
+`
+
+// englishWords are common English words, most frequent first.
+const englishWords = `
+you the that this with have what your for not are just but was all there
+know like get here can out about right now well come want yeah how think
+good they one look back going time then when see tell who would only really
+from why okay let take will something could some more over never little
+because thing said went way man make sure need did love very much mean
+were where nothing thank gonna talk help give people yes before find
+life after first father even little call again work mother always still
+night down hey home give thought last house kind room new day better long
+everything maybe feel money told around leave name mind world great friend
+sorry happy believe heart water girl school family boy woman baby dead kill
+stop real keep morning stay hear place live hope wait hard another while
+life game word fire light party black white power dream story music city
+state country money health mother brother sister summer winter spring autumn
+monday friday sunday january march april june july august october november
+december green blue red yellow orange purple silver gold golden happy lucky
+sweet pretty angel heaven secret magic forest river ocean island mountain
+garden flower apple banana cherry lemon coffee chocolate cookie pizza cheese
+butter honey sugar candy tiger lion eagle falcon wolf bear horse dragon
+monkey rabbit turtle shark dolphin kitten puppy doggy kitty battery staple
+correct table chair window door street road bridge castle tower king queen
+prince princess knight soldier doctor teacher student office business market
+computer internet phone letter paper pencil picture camera movie video radio
+guitar piano drum song dance sport soccer football tennis hockey baseball
+runner rocket planet star moon sun sky cloud rain snow storm thunder shadow
+ghost monster zombie robot ninja pirate wizard hunter master player winner
+freedom justice peace truth beauty future past present nature science
+history energy system server database secure private public access admin
+login account user guest service cloud network company project product
+welcome hello goodbye please thanks friend enemy family spring station
+silence thunder diamond crystal purple summer october spirit wonder simple
+`
+
+// words splits a word list into a rank map; repeated words keep their
+// first rank.
+func words(list string) map[string]int {
+	ranks := make(map[string]int)
+	for _, w := range strings.Fields(list) {
+		if _, ok := ranks[w]; !ok {
+			ranks[w] = len(ranks) + 1
+		}
+	}
+	return ranks
+}
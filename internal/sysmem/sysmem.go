@@ -0,0 +1,9 @@
+// Package sysmem reports how much memory the system can spare.
+package sysmem
+
+// Available returns an estimate in bytes of the memory that can be allocated
+// without pushing the system into swap. ok is false when the platform gives
+// no estimate.
+func Available() (bytes uint64, ok bool) {
+	return available()
+}
@@ -0,0 +1,15 @@
+//go:build darwin
+
+package sysmem
+
+import "golang.org/x/sys/unix"
+
+// macOS keeps most idle memory in caches it reclaims on demand, so free page
+// counts say little. Half of physical memory is a safe estimate.
+func available() (uint64, bool) {
+	total, err := unix.SysctlUint64("hw.memsize")
+	if err != nil || total == 0 {
+		return 0, false
+	}
+	return total / 2, true
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package sysmem
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func available() (uint64, bool) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	return parseMeminfo(bufio.NewScanner(f))
+}
+
+// parseMeminfo returns MemAvailable from /proc/meminfo.
+func parseMeminfo(s *bufio.Scanner) (uint64, bool) {
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return kb * 1024, true
+	}
+	return 0, false
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package sysmem
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestParseMeminfo(t *testing.T) {
+	in := "MemTotal:       16318508 kB\nMemFree:         1204880 kB\nMemAvailable:    9051164 kB\n"
+	got, ok := parseMeminfo(bufio.NewScanner(strings.NewReader(in)))
+	if !ok || got != 9051164*1024 {
+		t.Fatalf("got=%d ok=%v", got, ok)
+	}
+	if _, ok := parseMeminfo(bufio.NewScanner(strings.NewReader("MemTotal: 1 kB\n"))); ok {
+		t.Fatal("expected no estimate without MemAvailable")
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	if n, ok := Available(); !ok || n == 0 {
+		t.Fatalf("Available() = %d, %v", n, ok)
+	}
+}
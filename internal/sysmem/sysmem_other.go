@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package sysmem
+
+func available() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,109 @@
+// Package totp implements the time-based one-time passwords of RFC 6238 as
+// authenticator apps show them: HMAC-SHA1 over 30-second steps, truncated
+// to six digits.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the length of a code.
+	Digits = 6
+	// Period is how long a code is current.
+	Period = 30 * time.Second
+	// SecretSize is the length of secrets from NewSecret, the 160 bits
+	// RFC 4226 recommends for HMAC-SHA1.
+	SecretSize = 20
+	// DefaultSkew is how many steps before and after the current one
+	// Verify accepts by default, for clocks that are slightly off.
+	DefaultSkew = 1
+)
+
+// NewSecret returns a random secret.
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return secret, nil
+}
+
+// Code returns the code for secret at t.
+func Code(secret []byte, t time.Time) string {
+	return hotp(secret, step(t))
+}
+
+// Verify reports whether code is the code for secret at t, or at up to skew
+// steps before or after it. Spaces in code are ignored.
+func Verify(secret []byte, code string, t time.Time, skew int) bool {
+	_, ok := Match(secret, code, t, skew)
+	return ok
+}
+
+// Match is Verify that also returns the time step code belongs to, so that
+// callers can refuse a code that was already used.
+func Match(secret []byte, code string, t time.Time, skew int) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+	now := step(t)
+	ok, matched := 0, 0
+	// Every step in the window is compared, so that the time taken does
+	// not tell which one matched.
+	for d := -int64(skew); d <= int64(skew); d++ {
+		if now+d < 0 {
+			continue
+		}
+		eq := subtle.ConstantTimeCompare([]byte(hotp(secret, now+d)), []byte(code))
+		matched = subtle.ConstantTimeSelect(eq, int(now+d), matched)
+		ok |= eq
+	}
+	return int64(matched), ok == 1
+}
+
+// EncodeSecret returns secret in the unpadded base32 that authenticator apps
+// accept for manual entry.
+func EncodeSecret(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// URI returns the otpauth URI that enrols secret in an authenticator app,
+// usually shown as a QR code. account names the secret within issuer.
+func URI(secret []byte, issuer, account string) string {
+	q := url.Values{}
+	q.Set("secret", EncodeSecret(secret))
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period/time.Second)))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+func step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// hotp is the HOTP value of RFC 4226 for counter.
+func hotp(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000)
+}
@@ -0,0 +1,85 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the test vectors in RFC 6238.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := Code(rfcSecret, time.Unix(tt.unix, 0)); got != tt.code {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestVerifyAcceptsSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	previous := Code(rfcSecret, now.Add(-Period))
+	next := Code(rfcSecret, now.Add(Period))
+	tooOld := Code(rfcSecret, now.Add(-2*Period))
+
+	if !Verify(rfcSecret, Code(rfcSecret, now), now, 0) {
+		t.Fatal("current code rejected")
+	}
+	if !Verify(rfcSecret, "050 471", now, 0) {
+		t.Fatal("code with a space rejected")
+	}
+	if Verify(rfcSecret, previous, now, 0) {
+		t.Fatal("previous code accepted without skew")
+	}
+	if !Verify(rfcSecret, previous, now, DefaultSkew) || !Verify(rfcSecret, next, now, DefaultSkew) {
+		t.Fatal("adjacent code rejected within skew")
+	}
+	if Verify(rfcSecret, tooOld, now, DefaultSkew) {
+		t.Fatal("code two steps old accepted")
+	}
+	if step, ok := Match(rfcSecret, previous, now, DefaultSkew); !ok || step != now.Unix()/30-1 {
+		t.Fatalf("Match(previous) = %d %t", step, ok)
+	}
+	for _, bad := range []string{"", "05047", "0504711", "abcdef"} {
+		if Verify(rfcSecret, bad, now, DefaultSkew) {
+			t.Fatalf("%q accepted", bad)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != SecretSize {
+		t.Fatalf("secret of %d bytes", len(secret))
+	}
+	u, err := url.Parse(URI(secret, "Dotward", "api/.env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Dotward:api/.env" {
+		t.Fatalf("uri %s", u)
+	}
+	q := u.Query()
+	if q.Get("secret") != EncodeSecret(secret) || strings.Contains(q.Get("secret"), "=") {
+		t.Fatalf("secret %q", q.Get("secret"))
+	}
+	if q.Get("issuer") != "Dotward" || q.Get("digits") != "6" || q.Get("period") != "30" || q.Get("algorithm") != "SHA1" {
+		t.Fatalf("query %v", q)
+	}
+}
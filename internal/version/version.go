@@ -21,11 +21,16 @@ func String() string {
 	return fmt.Sprintf("%s (%s)", Version, Commit)
 }
 
+// Built returns the build timestamp, falling back to the deprecated
+// BuildDate.
+func Built() string {
+	if BuildTime == "unknown" {
+		return BuildDate
+	}
+	return BuildTime
+}
+
 // Detailed returns extended build metadata for a component.
 func Detailed(component string) string {
-	built := BuildTime
-	if built == "unknown" {
-		built = BuildDate
-	}
-	return fmt.Sprintf("%s %s\ncommit: %s\nbuilt: %s\nbuilder: %s", component, Version, Commit, built, BuiltBy)
+	return fmt.Sprintf("%s %s\ncommit: %s\nbuilt: %s\nbuilder: %s", component, Version, Commit, Built(), BuiltBy)
 }